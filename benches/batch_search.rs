@@ -0,0 +1,85 @@
+//! Time to run a few hundred symbol-name queries through one
+//! `CachedSearcher`, calling `search` in a loop versus one `search_batch`.
+//! The cache is off so both run every query against the index.
+//!
+//! Run with `cargo bench --bench batch_search`. `NS_BENCH_FILES` sets the
+//! corpus size (default 5000 files), `NS_BENCH_QUERIES` the batch size
+//! (default 300 queries).
+
+use std::fs;
+use std::time::{Duration, Instant};
+
+use ns::searcher::cached::{CachedSearcher, SearcherOptions};
+use ns::searcher::query::SearchOptions;
+
+const RUNS: usize = 5;
+
+fn env_or(name: &str, default: usize) -> usize {
+    std::env::var(name)
+        .ok()
+        .and_then(|v| v.parse().ok())
+        .unwrap_or(default)
+}
+
+fn main() {
+    let files = env_or("NS_BENCH_FILES", 5_000);
+    let query_count = env_or("NS_BENCH_QUERIES", 300);
+    let tmp = tempfile::tempdir().expect("tempdir");
+    let root = tmp.path();
+    for i in 0..files {
+        let dir = root.join(format!("pkg{:03}", i % 200));
+        fs::create_dir_all(&dir).unwrap();
+        let body = format!(
+            "fn handler_{i}() {{\n    let config = load_{}();\n    apply_{}(config);\n}}\n",
+            i % 97,
+            i % 31,
+        );
+        fs::write(dir.join(format!("file{}.rs", i)), body).unwrap();
+    }
+    let start = Instant::now();
+    ns::indexer::run_full_index(root, 1_048_576).expect("indexing should succeed");
+    println!("indexed {} files in {:?}", files, start.elapsed());
+
+    let queries: Vec<String> = (0..query_count)
+        .map(|i| match i % 3 {
+            0 => format!("handler_{}", i * 7 % files.max(1)),
+            1 => format!("load_{}", i % 97),
+            _ => format!("apply_{} config", i % 31),
+        })
+        .collect();
+    let queries: Vec<&str> = queries.iter().map(String::as_str).collect();
+    let opts = SearchOptions {
+        max_results: 10,
+        ..Default::default()
+    };
+    let searcher = CachedSearcher::open(root, SearcherOptions::default()).unwrap();
+
+    let time = |run: &dyn Fn()| {
+        let mut times: Vec<Duration> = (0..RUNS)
+            .map(|_| {
+                let start = Instant::now();
+                run();
+                start.elapsed()
+            })
+            .collect();
+        times.sort();
+        times[RUNS / 2]
+    };
+    let looped = time(&|| {
+        for query in &queries {
+            searcher.search(query, &opts).unwrap();
+        }
+    });
+    let batched = time(&|| {
+        for page in searcher.search_batch(&queries, &opts).unwrap() {
+            page.unwrap();
+        }
+    });
+    println!(
+        "{} queries  loop median {:>9.2?}  batch median {:>9.2?}  ({:.1}x)",
+        queries.len(),
+        looped,
+        batched,
+        looped.as_secs_f64() / batched.as_secs_f64(),
+    );
+}
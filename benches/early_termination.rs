@@ -0,0 +1,61 @@
+//! Latency of a ranked search for a term found in every file, with and
+//! without `SearchOptions::early_termination`.
+//!
+//! Run with `cargo bench --bench early_termination`. `NS_BENCH_FILES` sets
+//! the corpus size (default 20000 files).
+
+use std::fs;
+use std::time::{Duration, Instant};
+
+use ns::searcher::query::{execute_search, SearchOptions};
+
+const RUNS: usize = 30;
+
+fn main() {
+    let files: usize = std::env::var("NS_BENCH_FILES")
+        .ok()
+        .and_then(|v| v.parse().ok())
+        .unwrap_or(20_000);
+    let tmp = tempfile::tempdir().expect("tempdir");
+    let root = tmp.path();
+    for i in 0..files {
+        let dir = root.join(format!("pkg{:03}", i % 500));
+        fs::create_dir_all(&dir).unwrap();
+        // `config` is in every file, at varying frequency and file length.
+        let body = format!(
+            "fn handler_{i}() {{\n    let config = load();\n{}{}}}\n",
+            "    config.apply();\n".repeat(i % 5),
+            "    step();\n".repeat(i % 40),
+        );
+        fs::write(dir.join(format!("file{}.rs", i)), body).unwrap();
+    }
+    let start = Instant::now();
+    ns::indexer::run_full_index(root, 1_048_576).expect("indexing should succeed");
+    println!("indexed {} files in {:?}", files, start.elapsed());
+
+    for early_termination in [false, true] {
+        let opts = SearchOptions {
+            max_results: 10,
+            early_termination,
+            ..Default::default()
+        };
+        let (results, stats) = execute_search(root, "config", &opts).unwrap();
+        assert_eq!(results.len(), 10);
+        let mut times: Vec<Duration> = (0..RUNS)
+            .map(|_| {
+                let start = Instant::now();
+                execute_search(root, "config", &opts).unwrap();
+                start.elapsed()
+            })
+            .collect();
+        times.sort();
+        println!(
+            "early_termination={:<5}  median {:>9.2?}  p90 {:>9.2?}  total_matches {}{}",
+            early_termination,
+            times[RUNS / 2],
+            times[RUNS * 9 / 10],
+            stats.total_matches,
+            if stats.total_is_lower_bound { "+" } else { "" },
+        );
+    }
+}
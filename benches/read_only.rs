@@ -0,0 +1,95 @@
+//! Search throughput as threads are added, searching one `ReadOnlyIndex`
+//! versus one `CachedSearcher` (cache off), which reads the index
+//! generation and takes its lock on every search.
+//!
+//! Run with `cargo bench --bench read_only`. `NS_BENCH_FILES` sets the
+//! corpus size (default 5000 files), `NS_BENCH_QUERIES` the searches per
+//! thread (default 200).
+
+use std::fs;
+use std::time::{Duration, Instant};
+
+use ns::searcher::cached::{CachedSearcher, SearcherOptions};
+use ns::searcher::query::SearchOptions;
+use ns::searcher::read_only::ReadOnlyIndex;
+
+const RUNS: usize = 3;
+
+fn env_or(name: &str, default: usize) -> usize {
+    std::env::var(name)
+        .ok()
+        .and_then(|v| v.parse().ok())
+        .unwrap_or(default)
+}
+
+/// The median time for `threads` threads to each run `search` on every
+/// one of `queries`.
+fn time(threads: usize, queries: &[String], search: &(dyn Fn(&str) + Sync)) -> Duration {
+    let mut times: Vec<Duration> = (0..RUNS)
+        .map(|_| {
+            let start = Instant::now();
+            std::thread::scope(|scope| {
+                for _ in 0..threads {
+                    scope.spawn(|| queries.iter().for_each(|q| search(q)));
+                }
+            });
+            start.elapsed()
+        })
+        .collect();
+    times.sort();
+    times[RUNS / 2]
+}
+
+fn main() {
+    let files = env_or("NS_BENCH_FILES", 5_000);
+    let query_count = env_or("NS_BENCH_QUERIES", 200);
+    let tmp = tempfile::tempdir().expect("tempdir");
+    let root = tmp.path();
+    for i in 0..files {
+        let dir = root.join(format!("pkg{:03}", i % 200));
+        fs::create_dir_all(&dir).unwrap();
+        let body = format!(
+            "fn handler_{i}() {{\n    let config = load_{}();\n    apply_{}(config);\n}}\n",
+            i % 97,
+            i % 31,
+        );
+        fs::write(dir.join(format!("file{}.rs", i)), body).unwrap();
+    }
+    let start = Instant::now();
+    ns::indexer::run_full_index(root, 1_048_576).expect("indexing should succeed");
+    println!("indexed {} files in {:?}", files, start.elapsed());
+
+    let queries: Vec<String> = (0..query_count)
+        .map(|i| match i % 3 {
+            0 => format!("handler_{}", i * 7 % files.max(1)),
+            1 => format!("load_{}", i % 97),
+            _ => format!("apply_{} config", i % 31),
+        })
+        .collect();
+    let opts = SearchOptions {
+        max_results: 10,
+        ..Default::default()
+    };
+    let cached = CachedSearcher::open(root, SearcherOptions::default()).unwrap();
+    let read_only = ReadOnlyIndex::open(root).unwrap();
+
+    let cpus = std::thread::available_parallelism().map_or(1, |n| n.get());
+    let mut threads = 1;
+    while threads <= cpus {
+        let searches = (threads * queries.len()) as f64;
+        let mutable = time(threads, &queries, &|q| {
+            cached.search(q, &opts).unwrap();
+        });
+        let frozen = time(threads, &queries, &|q| {
+            read_only.search(q, &opts).unwrap();
+        });
+        println!(
+            "{:>3} threads  cached {:>8.0} searches/s  read-only {:>8.0} searches/s  ({:.2}x)",
+            threads,
+            searches / mutable.as_secs_f64(),
+            searches / frozen.as_secs_f64(),
+            mutable.as_secs_f64() / frozen.as_secs_f64(),
+        );
+        threads *= 2;
+    }
+}
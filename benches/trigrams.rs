@@ -0,0 +1,101 @@
+//! Latency of `--regex` searches over a `--trigrams` index, which only
+//! scans the files holding a match's trigrams, versus the same search
+//! over a default index, which for these patterns scans every file.
+//!
+//! Run with `cargo bench --bench trigrams`. `NS_BENCH_FILES` sets the
+//! corpus size (default 5000 files).
+
+use std::fs;
+use std::path::Path;
+use std::time::{Duration, Instant};
+
+use ns::indexer::IndexOptions;
+use ns::searcher::query::SearchOptions;
+use ns::searcher::{search, OutputMode};
+
+const RUNS: usize = 10;
+
+/// Patterns the word-fragment lookups can't narrow: an alternation, a
+/// case-insensitive pattern, and a rare sequence of words every file has.
+const PATTERNS: [&str; 3] = [
+    "ReadDeadline|WriteDeadline",
+    "(?i)retry.*backoff",
+    r"load\(opts\)",
+];
+
+fn main() {
+    let files: usize = std::env::var("NS_BENCH_FILES")
+        .ok()
+        .and_then(|v| v.parse().ok())
+        .unwrap_or(5_000);
+    let tmp = tempfile::tempdir().expect("tempdir");
+    let plain = tmp.path().join("plain");
+    let trigram = tmp.path().join("trigram");
+    for root in [&plain, &trigram] {
+        write_corpus(root, files);
+        let opts = IndexOptions {
+            trigrams: root == &trigram,
+            ..Default::default()
+        };
+        let start = Instant::now();
+        ns::indexer::run_full_index_with_options(root, &opts).expect("indexing should succeed");
+        println!(
+            "indexed {} files with trigrams={:<5} in {:?} ({} bytes)",
+            files,
+            opts.trigrams,
+            start.elapsed(),
+            ns::indexer::writer::read_meta(root).unwrap().index_size_bytes,
+        );
+    }
+
+    let opts = SearchOptions {
+        regex: true,
+        max_results: 10,
+        context_window: 0,
+        ..Default::default()
+    };
+    for pattern in PATTERNS {
+        let scan = time(&plain, pattern, &opts);
+        let narrowed = time(&trigram, pattern, &opts);
+        println!(
+            "{:<28}  scan all {:>9.2?}  trigrams {:>9.2?}  ({:.1}x)",
+            pattern,
+            scan,
+            narrowed,
+            scan.as_secs_f64() / narrowed.as_secs_f64(),
+        );
+    }
+}
+
+/// Writes `files` Go files under `root`, one in 100 with the lines the
+/// patterns match; most of the rest call `load` and `opts` apart.
+fn write_corpus(root: &Path, files: usize) {
+    for i in 0..files {
+        let dir = root.join(format!("pkg{:03}", i % 200));
+        fs::create_dir_all(&dir).unwrap();
+        let rare = if i % 100 == 0 {
+            "    conn.SetReadDeadline(t)\n    retryWithBackoff(op)\n    cfg := load(opts)\n"
+        } else {
+            ""
+        };
+        let body = format!(
+            "package pkg\n\nfunc handler{i}(w http.ResponseWriter) {{\n{}{}}}\n",
+            "    w.Write(render(load(key), opts))\n".repeat(i % 50),
+            rare,
+        );
+        fs::write(dir.join(format!("file{}.go", i)), body).unwrap();
+    }
+}
+
+/// The median time of a FilesOnly search for `pattern` at `root`.
+fn time(root: &Path, pattern: &str, opts: &SearchOptions) -> Duration {
+    let mut times: Vec<Duration> = (0..RUNS)
+        .map(|_| {
+            let start = Instant::now();
+            search(root, pattern, OutputMode::FilesOnly, opts).unwrap();
+            start.elapsed()
+        })
+        .collect();
+    times.sort();
+    times[RUNS / 2]
+}
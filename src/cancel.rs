@@ -0,0 +1,89 @@
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+
+use crate::error::NsError;
+
+/// Cancellation and deadline for a search or full index build, passed in
+/// `SearchOptions::cancel` and `IndexOptions::cancel`.
+///
+/// Clones share one flag, so a caller keeps a clone and calls
+/// [`CancelToken::cancel`] from any thread — a server whose client went
+/// away, a Ctrl-C handler — to stop work running with another. Work checks
+/// the token at loop boundaries (every few documents) and returns
+/// [`NsError::Cancelled`] or [`NsError::DeadlineExceeded`] soon after.
+///
+/// The default token is never cancelled and has no deadline.
+#[derive(Debug, Clone, Default)]
+pub struct CancelToken {
+    cancelled: Arc<AtomicBool>,
+    deadline: Option<Instant>,
+}
+
+impl CancelToken {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// A token sharing this one's flag that also expires `timeout` from
+    /// now, or at this token's own deadline if that comes first.
+    pub fn with_timeout(&self, timeout: Duration) -> Self {
+        self.with_deadline(Instant::now() + timeout)
+    }
+
+    /// A token sharing this one's flag that also expires at `deadline`,
+    /// or at this token's own deadline if that comes first.
+    pub fn with_deadline(&self, deadline: Instant) -> Self {
+        Self {
+            cancelled: Arc::clone(&self.cancelled),
+            deadline: Some(self.deadline.map_or(deadline, |d| d.min(deadline))),
+        }
+    }
+
+    /// Cancels this token and every clone of it.
+    pub fn cancel(&self) {
+        self.cancelled.store(true, Ordering::Relaxed);
+    }
+
+    /// True once the token is cancelled or past its deadline.
+    pub fn is_cancelled(&self) -> bool {
+        self.check().is_err()
+    }
+
+    /// Fails with the reason work should stop, if it should.
+    pub fn check(&self) -> Result<(), NsError> {
+        if self.cancelled.load(Ordering::Relaxed) {
+            return Err(NsError::Cancelled);
+        }
+        if self.deadline.is_some_and(|d| Instant::now() >= d) {
+            return Err(NsError::DeadlineExceeded);
+        }
+        Ok(())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn clones_share_cancellation() {
+        let token = CancelToken::new();
+        let worker = token.with_timeout(Duration::from_secs(60));
+        assert!(worker.check().is_ok());
+
+        token.cancel();
+        assert!(matches!(worker.check(), Err(NsError::Cancelled)));
+    }
+
+    #[test]
+    fn earliest_deadline_wins() {
+        let past = Instant::now() - Duration::from_secs(1);
+        let expired = CancelToken::new().with_deadline(past);
+        assert!(matches!(expired.check(), Err(NsError::DeadlineExceeded)));
+
+        let extended = expired.with_timeout(Duration::from_secs(60));
+        assert!(extended.is_cancelled(), "a child can't outlive its parent's deadline");
+        assert!(CancelToken::new().check().is_ok());
+    }
+}
@@ -0,0 +1,57 @@
+use std::path::PathBuf;
+
+use ns::error::NsError;
+use ns::indexer::definitions::SymbolKind;
+use ns::searcher::definitions::search_symbols;
+
+use crate::cmd::DefArgs;
+
+pub fn run(args: &DefArgs) {
+    let root = match PathBuf::from(".").canonicalize() {
+        Ok(p) => p,
+        Err(err) => {
+            eprintln!("error: cannot resolve current directory: {}", err);
+            std::process::exit(1);
+        }
+    };
+
+    // Names were validated by clap against `KIND_NAMES`.
+    let kinds: Vec<SymbolKind> = args.kinds.iter().filter_map(|k| SymbolKind::from_name(k)).collect();
+
+    let hits = match search_symbols(&root, &args.name, &kinds) {
+        Ok(h) => h,
+        Err(NsError::Io(e)) if e.kind() == std::io::ErrorKind::NotFound => {
+            eprintln!("error: no index found. Run 'ns index --definitions' to create one.");
+            std::process::exit(1);
+        }
+        Err(err) => {
+            eprintln!("error: {}", err);
+            std::process::exit(1);
+        }
+    };
+
+    if args.json {
+        let items: Vec<serde_json::Value> = hits
+            .iter()
+            .map(|h| {
+                serde_json::json!({
+                    "path": h.path,
+                    "name": h.definition.name,
+                    "kind": h.definition.kind.name(),
+                    "line": h.definition.line,
+                    "column": h.definition.column,
+                })
+            })
+            .collect();
+        println!("{}", serde_json::Value::Array(items));
+    } else {
+        for h in &hits {
+            let d = &h.definition;
+            println!("{}:{}:{}: {} {}", h.path, d.line, d.column, d.kind.name(), d.name);
+        }
+    }
+
+    if hits.is_empty() {
+        std::process::exit(1);
+    }
+}
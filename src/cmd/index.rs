@@ -1,9 +1,18 @@
 use std::path::PathBuf;
+use std::sync::atomic::AtomicBool;
+use std::time::Duration;
+
+use ns::error::NsError;
+use ns::indexer;
+use ns::indexer::analyzer::Analyzer;
+use ns::indexer::incremental::IncrementalStats;
+use ns::indexer::stopwords;
+use ns::indexer::tokenizer::Stemming;
+use ns::indexer::watch::{watch, WatchOptions};
+use ns::indexer::writer::{check_gitignore_warning, FullIndexStats};
+use ns::indexer::IndexOptions;
 
 use crate::cmd::IndexArgs;
-use crate::error::NsError;
-use crate::indexer;
-use crate::indexer::writer::check_gitignore_warning;
 
 pub fn run(args: &IndexArgs) {
     let root = args
@@ -20,19 +29,123 @@ pub fn run(args: &IndexArgs) {
     };
 
     if args.incremental {
+        if !args.ignore.is_empty() {
+            eprintln!(
+                "warning: --ignore is ignored with --incremental; patterns from the last full `ns index` apply."
+            );
+        }
+        if !args.stop_words.is_empty() || args.stop_words_file.is_some() {
+            eprintln!(
+                "warning: stop word options are ignored with --incremental; the last full `ns index` list applies."
+            );
+        }
+        if args.stem
+            || args.analyzer.is_some()
+            || args.min_token_length.is_some()
+            || args.max_token_length.is_some()
+            || args.definitions
+            || args.case_sensitive
+            || args.include_binary
+            || args.track_lines
+            || args.track_offsets
+            || args.match_paths
+            || args.track_mtime
+            || args.token_classes
+            || args.trigrams
+            || !args.field.is_empty()
+        {
+            eprintln!(
+                "warning: --stem, --analyzer, --min/max-token-length, --definitions, --case-sensitive, --include-binary, --track-lines, --track-offsets, --match-paths, --track-mtime, --token-classes, --trigrams and --field are ignored with --incremental; the last full `ns index` settings apply."
+            );
+        }
         run_incremental(&root, args.max_file_size);
     } else {
-        run_full(&root, args.max_file_size);
+        let opts = IndexOptions {
+            max_file_size: args.max_file_size,
+            ignore_patterns: args.ignore.clone(),
+            threads: args.threads,
+            stop_words: resolve_stop_words(args),
+            stemming: if args.stem { Stemming::English } else { Stemming::Noop },
+            analyzer: resolve_analyzer(args),
+            definitions: args.definitions,
+            case_sensitive: args.case_sensitive,
+            skip_binary: !args.include_binary,
+            track_lines: args.track_lines,
+            track_offsets: args.track_offsets,
+            match_paths: args.match_paths,
+            track_mtime: args.track_mtime,
+            token_classes: args.token_classes,
+            trigrams: args.trigrams,
+            fields: args.field.clone(),
+            strict: args.strict,
+            ..Default::default()
+        };
+        run_full(&root, &opts);
+    }
+
+    if args.watch {
+        run_watch(&root, args);
+    }
+}
+
+/// Resolves `--analyzer` and `--stem` with `--min-token-length` and
+/// `--max-token-length` applied, or `None` to build the default pipeline.
+/// Exits if the bounds are the wrong way round.
+fn resolve_analyzer(args: &IndexArgs) -> Option<Analyzer> {
+    if args.min_token_length.is_none() && args.max_token_length.is_none() {
+        return args.analyzer.clone();
+    }
+    let stemming = if args.stem { Stemming::English } else { Stemming::Noop };
+    let analyzer = args
+        .analyzer
+        .clone()
+        .unwrap_or_else(|| Analyzer::with_stemming(stemming))
+        .with_length(args.min_token_length, args.max_token_length);
+    if Analyzer::parse(&analyzer.spec()).is_err() {
+        eprintln!("error: the minimum token length is over the maximum ({})", analyzer.spec());
+        std::process::exit(1);
     }
+    Some(analyzer)
+}
+
+/// Resolves `--stop-words` and `--stop-words-file` into a word list,
+/// defaulting to the english list. Exits on an unreadable file.
+fn resolve_stop_words(args: &IndexArgs) -> Vec<String> {
+    let extra = match args.stop_words_file {
+        Some(ref path) => match stopwords::read_stop_words_file(path) {
+            Ok(words) => words,
+            Err(err) => {
+                eprintln!("error: cannot read stop words file '{}': {}", path.display(), err);
+                std::process::exit(1);
+            }
+        },
+        None => Vec::new(),
+    };
+    let lists = if args.stop_words.is_empty() {
+        vec!["english".to_string()]
+    } else {
+        args.stop_words.clone()
+    };
+    stopwords::resolve(&lists, &extra)
 }
 
-fn run_full(root: &std::path::Path, max_file_size: u64) {
-    match indexer::run_full_index(root, max_file_size) {
+fn run_full(root: &std::path::Path, opts: &IndexOptions) {
+    match indexer::run_full_index_with_options(root, opts) {
         Ok(None) => {
             eprintln!("No indexable files found.");
         }
         Ok(Some(stats)) => {
             eprintln!("Indexed {} files in {}ms", stats.file_count, stats.elapsed_ms);
+            for file in &stats.unreadable.files {
+                eprintln!("warning: cannot read {}", file);
+            }
+            if let Some(skipped) = skipped_summary(&stats, opts.max_file_size) {
+                eprintln!("{}", skipped);
+            }
+            if stats.filtered_terms > 0 {
+                let noun = if stats.filtered_terms == 1 { "term" } else { "terms" };
+                eprintln!("Left out {} distinct {} by length", stats.filtered_terms, noun);
+            }
             check_gitignore_warning(root);
         }
         Err(err) => {
@@ -58,16 +171,40 @@ fn run_full(root: &std::path::Path, max_file_size: u64) {
     }
 }
 
+/// Describes the files a full build left out, or `None` if there were none,
+/// e.g. "Skipped 2 binary files, 1 unreadable file and 1 file over 1048576 bytes".
+fn skipped_summary(stats: &FullIndexStats, max_file_size: u64) -> Option<String> {
+    let files = |n: usize| if n == 1 { "file" } else { "files" };
+    let mut parts = Vec::new();
+    if stats.skipped_binary > 0 {
+        parts.push(format!("{} binary {}", stats.skipped_binary, files(stats.skipped_binary)));
+    }
+    if !stats.unreadable.is_empty() {
+        let n = stats.unreadable.len();
+        parts.push(format!("{} unreadable {}", n, files(n)));
+    }
+    if stats.skipped_too_large > 0 {
+        parts.push(format!(
+            "{} {} over {} bytes",
+            stats.skipped_too_large,
+            files(stats.skipped_too_large),
+            max_file_size
+        ));
+    }
+    let last = parts.pop()?;
+    Some(if parts.is_empty() {
+        format!("Skipped {}", last)
+    } else {
+        format!("Skipped {} and {}", parts.join(", "), last)
+    })
+}
+
 fn run_incremental(root: &std::path::Path, max_file_size: u64) {
     match indexer::run_incremental_index(root, max_file_size) {
         Ok(stats) => {
-            if stats.added == 0 && stats.modified == 0 && stats.deleted == 0 {
-                eprintln!("Index is up to date.");
-            } else {
-                eprintln!(
-                    "Incremental update: {} added, {} modified, {} deleted in {}ms",
-                    stats.added, stats.modified, stats.deleted, stats.elapsed_ms
-                );
+            match update_summary(&stats) {
+                Some(summary) => eprintln!("{}", summary),
+                None => eprintln!("Index is up to date."),
             }
             check_gitignore_warning(root);
         }
@@ -101,3 +238,44 @@ fn run_incremental(root: &std::path::Path, max_file_size: u64) {
         }
     }
 }
+
+/// Describes an incremental update, or `None` if nothing changed.
+fn update_summary(stats: &IncrementalStats) -> Option<String> {
+    if stats.added == 0 && stats.modified == 0 && stats.deleted == 0 && stats.renamed == 0 {
+        return None;
+    }
+    Some(format!(
+        "Incremental update: {} added, {} modified, {} deleted, {} renamed in {}ms",
+        stats.added, stats.modified, stats.deleted, stats.renamed, stats.elapsed_ms
+    ))
+}
+
+/// Keeps the index current until the process is interrupted. Failed
+/// updates are reported and watching continues.
+fn run_watch(root: &std::path::Path, args: &IndexArgs) {
+    let opts = WatchOptions {
+        debounce: Duration::from_millis(args.debounce),
+        max_file_size: args.max_file_size,
+    };
+    eprintln!("Watching {} for changes (Ctrl-C to stop)", root.display());
+
+    // Never set: the watch ends when the process does, which releases it.
+    let stop = AtomicBool::new(false);
+    let result = watch(root, &opts, &stop, |update| match update {
+        Ok(stats) => {
+            if let Some(summary) = update_summary(&stats) {
+                eprintln!("{}", summary);
+            }
+        }
+        Err(err) if err.is_lock_error() => {
+            eprintln!("warning: index is locked by another process; retrying.");
+        }
+        Err(err) => {
+            eprintln!("error: incremental indexing failed: {}", err);
+        }
+    });
+    if let Err(err) = result {
+        eprintln!("error: cannot watch '{}': {}", root.display(), err);
+        std::process::exit(1);
+    }
+}
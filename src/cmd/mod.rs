@@ -1,12 +1,66 @@
+pub mod def;
 pub mod hooks;
 pub mod index;
 pub mod search;
 pub mod status;
+pub mod suggest;
 
 use std::path::PathBuf;
 
-use crate::stats::SearchLogFlags;
 use clap::{Parser, Subcommand};
+use ns::indexer::analyzer::Analyzer;
+use ns::indexer::classes::{TokenClass, TokenClasses};
+use ns::indexer::fields::{check_fields, DocField};
+use ns::searcher::query::{SortMode, WeightRule};
+use ns::stats::SearchLogFlags;
+
+/// Values of `--format`.
+pub const OUTPUT_FORMATS: &[&str] = &["text", "json", "jsonl", "grep"];
+
+/// Parses `--filename-boost`: a finite, non-negative number.
+fn parse_boost(s: &str) -> Result<f32, String> {
+    match s.parse::<f32>() {
+        Ok(boost) if boost.is_finite() && boost >= 0.0 => Ok(boost),
+        _ => Err(format!("'{}' is not a non-negative number", s)),
+    }
+}
+
+/// Parses `ns index --analyzer`: a tokenizer, then filters, comma-separated.
+fn parse_analyzer(s: &str) -> Result<Analyzer, String> {
+    Analyzer::parse(s).map_err(|_| {
+        format!(
+            "'{}' is not an analyzer: expected a tokenizer (code, simple), then filters (lowercase, ascii_fold, stop_words, stem, length:MIN:MAX)",
+            s
+        )
+    })
+}
+
+/// Parses `--weight GLOB=N`: a glob, then a multiplier as for `--filename-boost`.
+fn parse_weight(s: &str) -> Result<WeightRule, String> {
+    let (glob, multiplier) = s
+        .rsplit_once('=')
+        .ok_or_else(|| format!("'{}' is not GLOB=N", s))?;
+    glob::Pattern::new(glob).map_err(|e| format!("invalid glob '{}': {}", glob, e))?;
+    Ok(WeightRule {
+        glob: glob.to_string(),
+        multiplier: parse_boost(multiplier)?,
+    })
+}
+
+/// Parses `ns index --field NAME[=BOOST]`: a field name, then a boost as
+/// for `--filename-boost`, 1 if left out.
+fn parse_field(s: &str) -> Result<DocField, String> {
+    let (name, boost) = match s.split_once('=') {
+        Some((name, boost)) => (name, parse_boost(boost)?),
+        None => (s, 1.0),
+    };
+    let field = DocField {
+        name: name.to_string(),
+        boost,
+    };
+    check_fields(std::slice::from_ref(&field)).map_err(|e| e.to_string())?;
+    Ok(field)
+}
 
 #[derive(Parser)]
 #[command(
@@ -21,9 +75,9 @@ pub struct Cli {
     /// Search query (default when no subcommand is given)
     pub query: Option<String>,
 
-    /// Language filter (e.g. rust, python, go)
-    #[arg(short = 't', long = "type", global = true)]
-    pub file_type: Option<String>,
+    /// Language filter, repeatable or comma-separated (e.g. rust, go,markdown)
+    #[arg(short = 't', long = "type", global = true, value_delimiter = ',')]
+    pub file_type: Vec<String>,
 
     /// Path glob filter
     #[arg(short = 'g', long = "glob", global = true)]
@@ -33,22 +87,63 @@ pub struct Cli {
     #[arg(short = 'l', long = "files")]
     pub files_only: bool,
 
-    /// Case-insensitive search (accepted for rg compatibility; always on)
+    /// Case-insensitive search (the default for ranked search; applies to --regex)
     #[arg(short = 'i', long = "ignore-case")]
     pub ignore_case: bool,
 
+    /// Case-sensitive ranked search (needs `ns index --case-sensitive`)
+    #[arg(
+        short = 's',
+        long = "case-sensitive",
+        conflicts_with_all = ["ignore_case", "fuzzy", "fuzzy_distance", "sym"]
+    )]
+    pub case_sensitive: bool,
+
     /// Maximum number of results
     #[arg(short = 'm', long = "max-count", default_value_t = 10)]
     pub max_count: usize,
 
+    /// Skip this many top-ranked results (page with -m)
+    #[arg(long = "offset", default_value_t = 0)]
+    pub offset: usize,
+
     /// Context lines around matches
     #[arg(short = 'C', long = "context", default_value_t = 1)]
     pub context: usize,
 
+    /// Context lines before matches (overrides -C on that side)
+    #[arg(short = 'B', long = "before-context")]
+    pub before_context: Option<usize>,
+
+    /// Context lines after matches (overrides -C on that side)
+    #[arg(short = 'A', long = "after-context")]
+    pub after_context: Option<usize>,
+
+    /// Max snippets (separate runs of context lines) per file (0 = unlimited)
+    #[arg(long = "max-snippets", default_value_t = 0)]
+    pub max_snippets: usize,
+
+    /// Max matching lines shown per file, noting how many more there are (0 = unlimited)
+    #[arg(long = "max-per-file", value_name = "N", default_value_t = 0)]
+    pub max_per_file: usize,
+
+    /// Shorten lines longer than N characters around the match (0 = unlimited)
+    #[arg(long = "max-columns", default_value_t = 300)]
+    pub max_columns: usize,
+
     /// Output results as JSON
     #[arg(long = "json")]
     pub json: bool,
 
+    /// Output format: text, json, jsonl (one JSON result per line), or grep (path:line:text)
+    #[arg(
+        long = "format",
+        value_name = "FORMAT",
+        conflicts_with_all = ["json", "files_only"],
+        value_parser = clap::builder::PossibleValuesParser::new(OUTPUT_FORMATS)
+    )]
+    pub format: Option<String>,
+
     /// Symbol-only search
     #[arg(long = "sym")]
     pub sym: bool,
@@ -57,6 +152,74 @@ pub struct Cli {
     #[arg(long = "fuzzy")]
     pub fuzzy: bool,
 
+    /// Max edit distance for fuzzy search (1-2, default 1; implies --fuzzy)
+    #[arg(long = "fuzzy-distance", value_parser = clap::value_parser!(u8).range(1..=2))]
+    pub fuzzy_distance: Option<u8>,
+
+    /// Score multiplier for query terms found in file paths (0 = off, default 1.5)
+    #[arg(long = "filename-boost", value_name = "N", value_parser = parse_boost)]
+    pub filename_boost: Option<f32>,
+
+    /// Multiply the score of files matching GLOB by N, repeatable (e.g. '*_test.go=0.3')
+    #[arg(long = "weight", value_name = "GLOB=N", value_parser = parse_weight)]
+    pub weight: Vec<WeightRule>,
+
+    /// Score bump for query terms found close together (0 = off, the default)
+    #[arg(long = "proximity-boost", value_name = "N", value_parser = parse_boost)]
+    pub proximity_boost: Option<f32>,
+
+    /// Max tokens between terms for --proximity-boost (default 10)
+    #[arg(long = "proximity-window", value_name = "N", requires = "proximity_boost")]
+    pub proximity_window: Option<usize>,
+
+    /// Stop ranking once the page is settled; faster on common terms, total becomes a lower bound
+    #[arg(long = "early-termination")]
+    pub early_termination: bool,
+
+    /// Fold near-identical files (vendored copies, generated code) into one result
+    #[arg(long = "dedup")]
+    pub dedup: bool,
+
+    /// Max fingerprint bits near-duplicates may differ by for --dedup (0-64, default 6)
+    #[arg(
+        long = "dedup-distance",
+        value_name = "N",
+        requires = "dedup",
+        value_parser = clap::value_parser!(u32).range(0..=64)
+    )]
+    pub dedup_distance: Option<u32>,
+
+    /// Show each matched term's tf, idf and score contribution per result
+    #[arg(long = "explain")]
+    pub explain: bool,
+
+    /// List the byte offsets of each matched term per result in JSON output (needs `ns index --track-offsets`)
+    #[arg(long = "positions", conflicts_with = "regex")]
+    pub positions: bool,
+
+    /// Order results by relevance (default), path, or mtime (newest first; needs `ns index --track-mtime`)
+    #[arg(
+        long = "sort",
+        value_name = "MODE",
+        conflicts_with = "regex",
+        value_parser = clap::builder::PossibleValuesParser::new(SortMode::NAMES)
+    )]
+    pub sort: Option<String>,
+
+    /// Only match in these parts of Go files: code, comment, string (comma-separated; needs `ns index --token-classes`)
+    #[arg(
+        long = "in",
+        value_name = "CLASSES",
+        value_delimiter = ',',
+        conflicts_with = "regex",
+        value_parser = clap::builder::PossibleValuesParser::new(TokenClass::NAMES)
+    )]
+    pub in_classes: Vec<String>,
+
+    /// Don't match in Go comments, like --in code,string
+    #[arg(long = "exclude-comments", conflicts_with_all = ["regex", "in_classes"])]
+    pub exclude_comments: bool,
+
     /// Max context lines per file (0 = unlimited)
     #[arg(long = "max-context-lines", default_value_t = 30)]
     pub max_context_lines: usize,
@@ -68,6 +231,14 @@ pub struct Cli {
     /// Use AST-guided span extraction (replaces grep-and-expand context)
     #[arg(long = "spans")]
     pub spans: bool,
+
+    /// Treat the query as a regex matched against raw file lines
+    #[arg(long = "regex")]
+    pub regex: bool,
+
+    /// Stop a --regex search after this many milliseconds (partial results)
+    #[arg(long = "regex-timeout", value_name = "MS")]
+    pub regex_timeout: Option<u64>,
 }
 
 #[derive(Subcommand)]
@@ -77,7 +248,11 @@ pub enum Command {
     /// Build or update the search index
     Index(IndexArgs),
     /// Show index status
-    Status,
+    Status(StatusArgs),
+    /// Suggest indexed terms that complete a prefix
+    Suggest(SuggestArgs),
+    /// Find where a symbol is defined (needs `ns index --definitions`)
+    Def(DefArgs),
     /// Manage git hooks
     Hooks {
         #[command(subcommand)]
@@ -94,22 +269,63 @@ pub struct SearchSubArgs {
     #[arg(short = 'l', long = "files")]
     pub files_only: bool,
 
-    /// Case-insensitive search (accepted for rg compatibility; always on)
+    /// Case-insensitive search (the default for ranked search; applies to --regex)
     #[arg(short = 'i', long = "ignore-case")]
     pub ignore_case: bool,
 
+    /// Case-sensitive ranked search (needs `ns index --case-sensitive`)
+    #[arg(
+        short = 's',
+        long = "case-sensitive",
+        conflicts_with_all = ["ignore_case", "fuzzy", "fuzzy_distance", "sym"]
+    )]
+    pub case_sensitive: bool,
+
     /// Maximum number of results
     #[arg(short = 'm', long = "max-count", default_value_t = 10)]
     pub max_count: usize,
 
+    /// Skip this many top-ranked results (page with -m)
+    #[arg(long = "offset", default_value_t = 0)]
+    pub offset: usize,
+
     /// Context lines around matches
     #[arg(short = 'C', long = "context", default_value_t = 1)]
     pub context: usize,
 
+    /// Context lines before matches (overrides -C on that side)
+    #[arg(short = 'B', long = "before-context")]
+    pub before_context: Option<usize>,
+
+    /// Context lines after matches (overrides -C on that side)
+    #[arg(short = 'A', long = "after-context")]
+    pub after_context: Option<usize>,
+
+    /// Max snippets (separate runs of context lines) per file (0 = unlimited)
+    #[arg(long = "max-snippets", default_value_t = 0)]
+    pub max_snippets: usize,
+
+    /// Max matching lines shown per file, noting how many more there are (0 = unlimited)
+    #[arg(long = "max-per-file", value_name = "N", default_value_t = 0)]
+    pub max_per_file: usize,
+
+    /// Shorten lines longer than N characters around the match (0 = unlimited)
+    #[arg(long = "max-columns", default_value_t = 300)]
+    pub max_columns: usize,
+
     /// Output results as JSON
     #[arg(long = "json")]
     pub json: bool,
 
+    /// Output format: text, json, jsonl (one JSON result per line), or grep (path:line:text)
+    #[arg(
+        long = "format",
+        value_name = "FORMAT",
+        conflicts_with_all = ["json", "files_only"],
+        value_parser = clap::builder::PossibleValuesParser::new(OUTPUT_FORMATS)
+    )]
+    pub format: Option<String>,
+
     /// Symbol-only search
     #[arg(long = "sym")]
     pub sym: bool,
@@ -118,6 +334,74 @@ pub struct SearchSubArgs {
     #[arg(long = "fuzzy")]
     pub fuzzy: bool,
 
+    /// Max edit distance for fuzzy search (1-2, default 1; implies --fuzzy)
+    #[arg(long = "fuzzy-distance", value_parser = clap::value_parser!(u8).range(1..=2))]
+    pub fuzzy_distance: Option<u8>,
+
+    /// Score multiplier for query terms found in file paths (0 = off, default 1.5)
+    #[arg(long = "filename-boost", value_name = "N", value_parser = parse_boost)]
+    pub filename_boost: Option<f32>,
+
+    /// Multiply the score of files matching GLOB by N, repeatable (e.g. '*_test.go=0.3')
+    #[arg(long = "weight", value_name = "GLOB=N", value_parser = parse_weight)]
+    pub weight: Vec<WeightRule>,
+
+    /// Score bump for query terms found close together (0 = off, the default)
+    #[arg(long = "proximity-boost", value_name = "N", value_parser = parse_boost)]
+    pub proximity_boost: Option<f32>,
+
+    /// Max tokens between terms for --proximity-boost (default 10)
+    #[arg(long = "proximity-window", value_name = "N", requires = "proximity_boost")]
+    pub proximity_window: Option<usize>,
+
+    /// Stop ranking once the page is settled; faster on common terms, total becomes a lower bound
+    #[arg(long = "early-termination")]
+    pub early_termination: bool,
+
+    /// Fold near-identical files (vendored copies, generated code) into one result
+    #[arg(long = "dedup")]
+    pub dedup: bool,
+
+    /// Max fingerprint bits near-duplicates may differ by for --dedup (0-64, default 6)
+    #[arg(
+        long = "dedup-distance",
+        value_name = "N",
+        requires = "dedup",
+        value_parser = clap::value_parser!(u32).range(0..=64)
+    )]
+    pub dedup_distance: Option<u32>,
+
+    /// Show each matched term's tf, idf and score contribution per result
+    #[arg(long = "explain")]
+    pub explain: bool,
+
+    /// List the byte offsets of each matched term per result in JSON output (needs `ns index --track-offsets`)
+    #[arg(long = "positions", conflicts_with = "regex")]
+    pub positions: bool,
+
+    /// Order results by relevance (default), path, or mtime (newest first; needs `ns index --track-mtime`)
+    #[arg(
+        long = "sort",
+        value_name = "MODE",
+        conflicts_with = "regex",
+        value_parser = clap::builder::PossibleValuesParser::new(SortMode::NAMES)
+    )]
+    pub sort: Option<String>,
+
+    /// Only match in these parts of Go files: code, comment, string (comma-separated; needs `ns index --token-classes`)
+    #[arg(
+        long = "in",
+        value_name = "CLASSES",
+        value_delimiter = ',',
+        conflicts_with = "regex",
+        value_parser = clap::builder::PossibleValuesParser::new(TokenClass::NAMES)
+    )]
+    pub in_classes: Vec<String>,
+
+    /// Don't match in Go comments, like --in code,string
+    #[arg(long = "exclude-comments", conflicts_with_all = ["regex", "in_classes"])]
+    pub exclude_comments: bool,
+
     /// Max context lines per file (0 = unlimited)
     #[arg(long = "max-context-lines", default_value_t = 30)]
     pub max_context_lines: usize,
@@ -129,6 +413,14 @@ pub struct SearchSubArgs {
     /// Use AST-guided span extraction (replaces grep-and-expand context)
     #[arg(long = "spans")]
     pub spans: bool,
+
+    /// Treat the query as a regex matched against raw file lines
+    #[arg(long = "regex")]
+    pub regex: bool,
+
+    /// Stop a --regex search after this many milliseconds (partial results)
+    #[arg(long = "regex-timeout", value_name = "MS")]
+    pub regex_timeout: Option<u64>,
 }
 
 #[derive(Parser)]
@@ -144,6 +436,151 @@ pub struct IndexArgs {
     /// Maximum file size in bytes (default: 1 MB)
     #[arg(long = "max-file-size", default_value_t = 1_048_576)]
     pub max_file_size: u64,
+
+    /// Extra gitignore-style pattern to skip (repeatable, e.g. --ignore '*.tmp')
+    #[arg(long = "ignore", value_name = "PATTERN")]
+    pub ignore: Vec<String>,
+
+    /// Worker threads for reading and parsing files (0 = one per CPU)
+    #[arg(short = 'j', long = "threads", default_value_t = 0)]
+    pub threads: usize,
+
+    /// Built-in stop word lists to drop, comma-separated (default: english)
+    #[arg(
+        long = "stop-words",
+        value_name = "LIST",
+        value_delimiter = ',',
+        value_parser = clap::builder::PossibleValuesParser::new(ns::indexer::stopwords::LIST_NAMES)
+    )]
+    pub stop_words: Vec<String>,
+
+    /// File of extra stop words, one per line ('#' starts a comment)
+    #[arg(long = "stop-words-file", value_name = "PATH")]
+    pub stop_words_file: Option<PathBuf>,
+
+    /// Stem content with the English stemmer, so plural and verb forms match
+    #[arg(long)]
+    pub stem: bool,
+
+    /// Content analyzer: tokenizer then filters (default: code,length:1:39,lowercase,stop_words)
+    #[arg(long, value_name = "SPEC", value_parser = parse_analyzer, conflicts_with = "stem")]
+    pub analyzer: Option<Analyzer>,
+
+    /// Leave out words shorter than N characters (default: 1)
+    #[arg(
+        long = "min-token-length",
+        value_name = "N",
+        value_parser = clap::builder::RangedU64ValueParser::<usize>::new().range(1..)
+    )]
+    pub min_token_length: Option<usize>,
+
+    /// Leave out words longer than N characters (default: 39)
+    #[arg(
+        long = "max-token-length",
+        value_name = "N",
+        value_parser = clap::builder::RangedU64ValueParser::<usize>::new().range(1..)
+    )]
+    pub max_token_length: Option<usize>,
+
+    /// Also record where symbols are defined, for `ns def` (Go only)
+    #[arg(long)]
+    pub definitions: bool,
+
+    /// Also index content with case preserved, for `ns -s` (case-sensitive search)
+    #[arg(long = "case-sensitive")]
+    pub case_sensitive: bool,
+
+    /// Index files that look binary (NUL bytes, many control characters) if they are UTF-8
+    #[arg(long = "include-binary")]
+    pub include_binary: bool,
+
+    /// Record the line of every indexed word, so results list matched lines without scanning files
+    #[arg(long = "track-lines")]
+    pub track_lines: bool,
+
+    /// Record where every indexed word starts, so `ns --positions` gives each matched term's byte offsets
+    #[arg(long = "track-offsets")]
+    pub track_offsets: bool,
+
+    /// Let query words match directory and file names, so `internal` finds files under internal/
+    #[arg(long = "match-paths")]
+    pub match_paths: bool,
+
+    /// Record each file's modification time, for `ns --sort mtime`
+    #[arg(long = "track-mtime")]
+    pub track_mtime: bool,
+
+    /// Also index Go code, comments and strings apart, for `ns --in` and `ns --exclude-comments`
+    #[arg(long = "token-classes")]
+    pub token_classes: bool,
+
+    /// Also index each file's trigrams, so `ns --regex` only scans files that could match
+    #[arg(long = "trigrams")]
+    pub trigrams: bool,
+
+    /// Declare a text field set per file with the library's `index_fields`, searched by plain
+    /// queries at BOOST times the weight of content (default 1) and alone by `NAME:term`;
+    /// repeatable
+    #[arg(long = "field", value_name = "NAME[=BOOST]", value_parser = parse_field)]
+    pub field: Vec<DocField>,
+
+    /// Fail on the first file that can't be read, instead of skipping it with a warning
+    #[arg(long, conflicts_with = "incremental")]
+    pub strict: bool,
+
+    /// After indexing, keep running and update the index as files change
+    #[arg(long)]
+    pub watch: bool,
+
+    /// With --watch, milliseconds without changes before updating the index
+    #[arg(long = "debounce", value_name = "MS", default_value_t = 300, requires = "watch")]
+    pub debounce: u64,
+}
+
+#[derive(Parser)]
+pub struct StatusArgs {
+    /// Print index statistics as JSON
+    #[arg(long = "json")]
+    pub json: bool,
+}
+
+#[derive(Parser)]
+pub struct SuggestArgs {
+    /// Prefix to complete (omit for the most frequent terms)
+    #[arg(default_value = "")]
+    pub prefix: String,
+
+    /// Maximum number of suggestions
+    #[arg(short = 'n', long = "limit", default_value_t = 10)]
+    pub limit: usize,
+
+    /// Case-insensitive prefix matching
+    #[arg(short = 'i', long = "ignore-case")]
+    pub ignore_case: bool,
+
+    /// Output as JSON
+    #[arg(long = "json")]
+    pub json: bool,
+}
+
+#[derive(Parser)]
+pub struct DefArgs {
+    /// Symbol name (exact, case-sensitive)
+    pub name: String,
+
+    /// Only these kinds, comma-separated (func, method, type, const, var)
+    #[arg(
+        short = 'k',
+        long = "kind",
+        value_name = "KIND",
+        value_delimiter = ',',
+        value_parser = clap::builder::PossibleValuesParser::new(ns::indexer::definitions::KIND_NAMES)
+    )]
+    pub kinds: Vec<String>,
+
+    /// Output as JSON
+    #[arg(long = "json")]
+    pub json: bool,
 }
 
 #[derive(Subcommand)]
@@ -157,18 +594,41 @@ pub enum HooksAction {
 /// Extracts search args from the top-level Cli struct.
 pub struct SearchArgs {
     pub query: String,
-    pub file_type: Option<String>,
+    pub file_type: Vec<String>,
     pub file_glob: Option<String>,
     pub files_only: bool,
     pub ignore_case: bool,
+    pub case_sensitive: bool,
     pub max_count: usize,
+    pub offset: usize,
     pub context: usize,
+    pub before_context: Option<usize>,
+    pub after_context: Option<usize>,
+    pub max_snippets: usize,
+    pub max_per_file: usize,
+    pub max_columns: usize,
     pub json: bool,
+    pub format: Option<String>,
     pub sym: bool,
     pub fuzzy: bool,
+    pub fuzzy_distance: Option<u8>,
+    pub filename_boost: Option<f32>,
+    pub weight: Vec<WeightRule>,
+    pub proximity_boost: Option<f32>,
+    pub proximity_window: Option<usize>,
+    pub early_termination: bool,
+    pub dedup: bool,
+    pub dedup_distance: Option<u32>,
+    pub explain: bool,
+    pub positions: bool,
+    pub sort: Option<String>,
+    pub in_classes: Vec<String>,
+    pub exclude_comments: bool,
     pub max_context_lines: usize,
     pub budget: Option<usize>,
     pub spans: bool,
+    pub regex: bool,
+    pub regex_timeout: Option<u64>,
 }
 
 impl SearchArgs {
@@ -179,14 +639,37 @@ impl SearchArgs {
             file_glob: cli.file_glob.clone(),
             files_only: cli.files_only,
             ignore_case: cli.ignore_case,
+            case_sensitive: cli.case_sensitive,
             max_count: cli.max_count,
+            offset: cli.offset,
             context: cli.context,
+            before_context: cli.before_context,
+            after_context: cli.after_context,
+            max_snippets: cli.max_snippets,
+            max_per_file: cli.max_per_file,
+            max_columns: cli.max_columns,
             json: cli.json,
+            format: cli.format.clone(),
             sym: cli.sym,
             fuzzy: cli.fuzzy,
+            fuzzy_distance: cli.fuzzy_distance,
+            filename_boost: cli.filename_boost,
+            weight: cli.weight.clone(),
+            proximity_boost: cli.proximity_boost,
+            proximity_window: cli.proximity_window,
+            early_termination: cli.early_termination,
+            dedup: cli.dedup,
+            dedup_distance: cli.dedup_distance,
+            explain: cli.explain,
+            positions: cli.positions,
+            sort: cli.sort.clone(),
+            in_classes: cli.in_classes.clone(),
+            exclude_comments: cli.exclude_comments,
             max_context_lines: cli.max_context_lines,
             budget: cli.budget,
             spans: cli.spans,
+            regex: cli.regex,
+            regex_timeout: cli.regex_timeout,
         }
     }
 
@@ -197,31 +680,97 @@ impl SearchArgs {
             file_glob: cli.file_glob.clone(),
             files_only: sub.files_only,
             ignore_case: sub.ignore_case,
+            case_sensitive: sub.case_sensitive,
             max_count: sub.max_count,
+            offset: sub.offset,
             context: sub.context,
+            before_context: sub.before_context,
+            after_context: sub.after_context,
+            max_snippets: sub.max_snippets,
+            max_per_file: sub.max_per_file,
+            max_columns: sub.max_columns,
             json: sub.json,
+            format: sub.format.clone(),
             sym: sub.sym,
             fuzzy: sub.fuzzy,
+            fuzzy_distance: sub.fuzzy_distance,
+            filename_boost: sub.filename_boost,
+            weight: sub.weight.clone(),
+            proximity_boost: sub.proximity_boost,
+            proximity_window: sub.proximity_window,
+            early_termination: sub.early_termination,
+            dedup: sub.dedup,
+            dedup_distance: sub.dedup_distance,
+            explain: sub.explain,
+            positions: sub.positions,
+            sort: sub.sort.clone(),
+            in_classes: sub.in_classes.clone(),
+            exclude_comments: sub.exclude_comments,
             max_context_lines: sub.max_context_lines,
             budget: sub.budget,
             spans: sub.spans,
+            regex: sub.regex,
+            regex_timeout: sub.regex_timeout,
+        }
+    }
+
+    /// The token classes `--in` or `--exclude-comments` limit the search
+    /// to; all of them without either.
+    pub fn token_classes(&self) -> TokenClasses {
+        if self.exclude_comments {
+            return TokenClasses::default().without(TokenClass::Comment);
+        }
+        if self.in_classes.is_empty() {
+            return TokenClasses::default();
         }
+        let classes: Vec<TokenClass> = self
+            .in_classes
+            .iter()
+            .filter_map(|name| TokenClass::from_name(name))
+            .collect();
+        TokenClasses::only(&classes)
     }
 
     pub fn to_log_flags(&self) -> SearchLogFlags {
         SearchLogFlags {
-            file_type: self.file_type.clone(),
+            file_type: (!self.file_type.is_empty()).then(|| self.file_type.join(",")),
             file_glob: self.file_glob.clone(),
             files_only: self.files_only,
             ignore_case: self.ignore_case,
-            json: self.json,
+            case_sensitive: self.case_sensitive,
+            json: self.json || self.format.as_deref() == Some("json"),
             sym: self.sym,
             fuzzy: self.fuzzy,
+            fuzzy_distance: self.fuzzy_distance,
+            filename_boost: self.filename_boost,
+            weight: self
+                .weight
+                .iter()
+                .map(|r| format!("{}={}", r.glob, r.multiplier))
+                .collect(),
+            proximity_boost: self.proximity_boost,
+            proximity_window: self.proximity_window,
+            early_termination: self.early_termination,
+            dedup: self.dedup,
+            dedup_distance: self.dedup_distance,
+            explain: self.explain,
+            positions: self.positions,
+            sort: self.sort.clone(),
+            token_classes: Some(self.token_classes())
+                .filter(|classes| !classes.is_all())
+                .map(TokenClasses::names),
             max_count: self.max_count,
+            offset: self.offset,
             context: self.context,
+            before_context: self.before_context,
+            after_context: self.after_context,
+            max_snippets: self.max_snippets,
+            max_per_file: self.max_per_file,
+            max_columns: self.max_columns,
             max_context_lines: self.max_context_lines,
             budget: self.budget,
             spans: self.spans,
+            regex: self.regex,
         }
     }
 }
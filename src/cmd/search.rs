@@ -1,13 +1,19 @@
+use std::io::IsTerminal;
 use std::path::PathBuf;
+use std::time::Duration;
+
+use ns::error::NsError;
+use ns::indexer::writer::utc_timestamp_iso8601;
+use ns::searcher;
+use ns::searcher::format::format_summary;
+use ns::searcher::query::{
+    SearchOptions, SortMode, DEFAULT_DEDUP_DISTANCE, DEFAULT_FILENAME_BOOST,
+    DEFAULT_PROXIMITY_WINDOW,
+};
+use ns::searcher::OutputMode;
+use ns::stats;
 
 use crate::cmd::SearchArgs;
-use crate::error::NsError;
-use crate::indexer::writer::utc_timestamp_iso8601;
-use crate::searcher;
-use crate::searcher::format::format_summary;
-use crate::searcher::query::SearchOptions;
-use crate::searcher::OutputMode;
-use crate::stats;
 
 pub fn run(args: &SearchArgs, argv: &[String]) {
     let root = match PathBuf::from(".").canonicalize() {
@@ -18,14 +24,18 @@ pub fn run(args: &SearchArgs, argv: &[String]) {
         }
     };
 
-    let is_json = args.json;
-    let (output_mode, mode_str) = if args.files_only {
-        (OutputMode::FilesOnly, "files")
-    } else if args.json {
-        (OutputMode::Json, "json")
-    } else {
-        (OutputMode::Text, "text")
+    let (output_mode, mode_str) = match args.format.as_deref() {
+        _ if args.files_only => (OutputMode::FilesOnly, "files"),
+        _ if args.json => (OutputMode::Json, "json"),
+        Some("json") => (OutputMode::Json, "json"),
+        Some("jsonl") => (OutputMode::JsonLines, "jsonl"),
+        Some("grep") => (OutputMode::Grep, "grep"),
+        _ => (OutputMode::Text, "text"),
     };
+    let is_json = matches!(output_mode, OutputMode::Json | OutputMode::JsonLines);
+    let color = matches!(output_mode, OutputMode::Text)
+        && std::io::stdout().is_terminal()
+        && std::env::var_os("NO_COLOR").is_none();
 
     let max_context_lines = if args.max_context_lines == 0 {
         Some(0) // 0 means unlimited
@@ -41,20 +51,59 @@ pub fn run(args: &SearchArgs, argv: &[String]) {
 
     let opts = SearchOptions {
         max_results: args.max_count,
+        offset: args.offset,
         context_window: args.context,
-        file_type: args.file_type.clone(),
+        context_before: args.before_context,
+        context_after: args.after_context,
+        max_snippets: args.max_snippets,
+        max_per_file: args.max_per_file,
+        max_columns: args.max_columns,
+        languages: args.file_type.clone(),
         file_glob: args.file_glob.clone(),
         sym_only: args.sym,
-        fuzzy: args.fuzzy,
+        fuzzy: args.fuzzy || args.fuzzy_distance.is_some(),
+        fuzzy_distance: args.fuzzy_distance.unwrap_or(1),
         max_context_lines,
         budget,
         spans: args.spans,
+        regex: args.regex,
+        ignore_case: args.ignore_case,
+        case_sensitive: args.case_sensitive,
+        regex_timeout: args.regex_timeout.map(Duration::from_millis),
+        color,
+        filename_boost: args.filename_boost.unwrap_or(DEFAULT_FILENAME_BOOST),
+        weights: args.weight.clone(),
+        proximity_boost: args.proximity_boost.unwrap_or(0.0),
+        proximity_window: args.proximity_window.unwrap_or(DEFAULT_PROXIMITY_WINDOW),
+        early_termination: args.early_termination,
+        dedup: args.dedup,
+        dedup_distance: args.dedup_distance.unwrap_or(DEFAULT_DEDUP_DISTANCE),
+        explain: args.explain,
+        include_positions: args.positions,
+        sort_by: args
+            .sort
+            .as_deref()
+            .and_then(SortMode::from_name)
+            .unwrap_or_default(),
+        token_classes: args.token_classes(),
+        field_boosts: Default::default(),
+        synonyms: Default::default(),
+        prefix_last_term: false,
+        cancel: Default::default(),
     };
 
     match searcher::search(&root, &args.query, output_mode, &opts) {
         Ok(search_output) => {
             let output = &search_output.formatted;
             let stats = &search_output.stats;
+            // A timed-out regex search still prints what it found, then fails.
+            let timeout_error = search_output.timed_out.then(|| stats::SearchLogError {
+                code: "regex_timeout",
+                message: format!(
+                    "error: regex search timed out after {}ms; results are partial",
+                    stats.elapsed_ms
+                ),
+            });
             if stats.total_results == 0 {
                 // JSON mode: print the body to stdout (structured data for consumers)
                 if is_json {
@@ -62,6 +111,14 @@ pub fn run(args: &SearchArgs, argv: &[String]) {
                 }
                 // Summary to stderr — consistent with exit 1 (rg convention)
                 eprintln!("{}", format_summary(stats));
+                if !search_output.suggestions.is_empty() {
+                    let quoted: Vec<String> =
+                        search_output.suggestions.iter().map(|q| format!("'{}'", q)).collect();
+                    eprintln!("did you mean: {}?", quoted.join(", "));
+                }
+                if let Some(ref e) = timeout_error {
+                    eprintln!("{}", e.message);
+                }
                 stats::record_search_log(
                     &root,
                     stats::SearchLogEntry {
@@ -73,11 +130,15 @@ pub fn run(args: &SearchArgs, argv: &[String]) {
                         files: stats.total_results,
                         mode: mode_str.to_string(),
                         budget,
-                        outcome: stats::SearchOutcome::NoResults,
+                        outcome: if timeout_error.is_some() {
+                            stats::SearchOutcome::Error
+                        } else {
+                            stats::SearchOutcome::NoResults
+                        },
                         zero_results: true,
                         flags: args.to_log_flags(),
                         argv: argv.to_vec(),
-                        error: None,
+                        error: timeout_error,
                     },
                 );
                 std::process::exit(1);
@@ -90,6 +151,9 @@ pub fn run(args: &SearchArgs, argv: &[String]) {
                     );
                 }
                 eprintln!("{}", format_summary(stats));
+                if let Some(ref e) = timeout_error {
+                    eprintln!("{}", e.message);
+                }
                 stats::record_search(&root, output.len());
                 stats::record_search_log(
                     &root,
@@ -102,13 +166,20 @@ pub fn run(args: &SearchArgs, argv: &[String]) {
                         files: stats.total_results,
                         mode: mode_str.to_string(),
                         budget,
-                        outcome: stats::SearchOutcome::Success,
+                        outcome: if timeout_error.is_some() {
+                            stats::SearchOutcome::Error
+                        } else {
+                            stats::SearchOutcome::Success
+                        },
                         zero_results: false,
                         flags: args.to_log_flags(),
                         argv: argv.to_vec(),
-                        error: None,
+                        error: timeout_error,
                     },
                 );
+                if search_output.timed_out {
+                    std::process::exit(1);
+                }
             }
         }
         Err(err) => {
@@ -132,6 +203,27 @@ pub fn run(args: &SearchArgs, argv: &[String]) {
                 NsError::Glob(e) => {
                     ("invalid_glob", format!("error: invalid glob pattern: {}", e))
                 }
+                NsError::Regex(e) => {
+                    ("invalid_regex", format!("error: invalid regex: {}", e))
+                }
+                NsError::UnsupportedStemmer(_) => {
+                    ("unsupported_stemmer", format!("error: {}", err))
+                }
+                NsError::UnsupportedAnalyzer(_) => {
+                    ("unsupported_analyzer", format!("error: {}", err))
+                }
+                NsError::CaseFoldedIndex => {
+                    ("case_folded_index", format!("error: {}", err))
+                }
+                NsError::NoMtimes => {
+                    ("no_mtimes", format!("error: {}", err))
+                }
+                NsError::NoOffsets => {
+                    ("no_offsets", format!("error: {}", err))
+                }
+                NsError::NoTokenClasses => {
+                    ("no_token_classes", format!("error: {}", err))
+                }
                 NsError::Json(_) => {
                     (
                         "corrupt_meta",
@@ -1,10 +1,14 @@
 use std::path::PathBuf;
 
-use crate::error::NsError;
-use crate::indexer::writer::{read_meta, SCHEMA_VERSION};
-use crate::stats;
+use ns::error::NsError;
+use ns::indexer::analyzer::Analyzer;
+use ns::indexer::writer::{read_meta, SCHEMA_VERSION};
+use ns::searcher::query::{index_stats, indexed_languages};
+use ns::stats;
 
-pub fn run() {
+use crate::cmd::StatusArgs;
+
+pub fn run(args: &StatusArgs) {
     let root = match PathBuf::from(".").canonicalize() {
         Ok(p) => p,
         Err(err) => {
@@ -36,14 +40,66 @@ pub fn run() {
         );
     }
 
+    // Best-effort like the language list: needs a readable, current index.
+    let index_stats = index_stats(&root).ok();
+    if args.json {
+        let Some(index_stats) = index_stats else {
+            eprintln!("error: failed to read index statistics. Run 'ns index' to rebuild.");
+            std::process::exit(1);
+        };
+        let mut value = serde_json::json!(index_stats);
+        value["schema_version"] = serde_json::json!(meta.schema_version);
+        value["git_commit"] = serde_json::json!(meta.git_commit);
+        let langs = indexed_languages(&root).unwrap_or_default();
+        value["languages"] = langs
+            .iter()
+            .map(|(name, files)| serde_json::json!({"name": name, "files": files}))
+            .collect();
+        println!("{}", value);
+        return;
+    }
+
     println!("ns index status");
     println!("  schema version : {}", meta.schema_version);
     println!("  files indexed  : {}", meta.file_count);
-    println!("  index size     : {}", format_bytes(meta.index_size_bytes));
+    if let Some(ref st) = index_stats {
+        println!("  total terms    : {}", st.total_terms);
+        println!("  avg. file size : {:.0} terms", st.avg_doc_length);
+    }
+    let index_size = index_stats.as_ref().map_or(meta.index_size_bytes, |st| st.index_size_bytes);
+    println!("  index size     : {}", format_bytes(index_size));
     println!("  indexed at     : {}", meta.indexed_at);
     if let Some(commit) = &meta.git_commit {
         println!("  git commit     : {}", &commit[..commit.len().min(12)]);
     }
+    if !meta.stop_words.is_empty() {
+        println!("  stop words     : {}", meta.stop_words.len());
+    }
+    if let Some(stemmer) = &meta.stemmer {
+        println!("  stemming       : {}", stemmer);
+    }
+    // Only a custom pipeline: the default one is implied by the lines above.
+    if let Ok(analyzer) = meta.content_analyzer() {
+        if analyzer != Analyzer::with_stemming(analyzer.stemming()) {
+            println!("  analyzer       : {}", analyzer.spec());
+        }
+    }
+    if meta.definitions {
+        println!("  definitions    : yes");
+    }
+    if meta.case_sensitive {
+        println!("  case-sensitive : yes");
+    }
+    if !meta.skip_binary {
+        println!("  binary files   : included");
+    }
+    // Best-effort: an outdated schema was already warned about above.
+    if let Ok(langs) = indexed_languages(&root) {
+        if !langs.is_empty() {
+            let list: Vec<String> = langs.iter().map(|(name, n)| format!("{} ({})", name, n)).collect();
+            println!("  languages      : {}", list.join(", "));
+        }
+    }
 
     let st = stats::read_stats(&root);
     if st.total_searches > 0 {
@@ -0,0 +1,44 @@
+use std::path::PathBuf;
+
+use ns::error::NsError;
+use ns::searcher::suggest::suggest;
+
+use crate::cmd::SuggestArgs;
+
+pub fn run(args: &SuggestArgs) {
+    let root = match PathBuf::from(".").canonicalize() {
+        Ok(p) => p,
+        Err(err) => {
+            eprintln!("error: cannot resolve current directory: {}", err);
+            std::process::exit(1);
+        }
+    };
+
+    let suggestions = match suggest(&root, &args.prefix, args.limit, args.ignore_case) {
+        Ok(s) => s,
+        Err(NsError::Io(e)) if e.kind() == std::io::ErrorKind::NotFound => {
+            eprintln!("error: no index found. Run 'ns index' to create one.");
+            std::process::exit(1);
+        }
+        Err(NsError::SchemaVersionMismatch { .. }) => {
+            eprintln!("error: index was built with an older version of ns. Run 'ns index' to rebuild.");
+            std::process::exit(1);
+        }
+        Err(err) => {
+            eprintln!("error: suggest failed: {}", err);
+            std::process::exit(1);
+        }
+    };
+
+    if args.json {
+        let items: Vec<serde_json::Value> = suggestions
+            .iter()
+            .map(|s| serde_json::json!({ "term": s.term, "doc_freq": s.doc_freq }))
+            .collect();
+        println!("{}", serde_json::Value::Array(items));
+    } else {
+        for s in &suggestions {
+            println!("{}\t{}", s.term, s.doc_freq);
+        }
+    }
+}
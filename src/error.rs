@@ -19,6 +19,39 @@ pub enum NsError {
     SchemaVersionMismatch { found: u32, expected: u32 },
     /// Invalid glob pattern passed via `-g`/`--glob`.
     Glob(glob::PatternError),
+    /// Invalid regex pattern passed with `--regex`.
+    Regex(regex::Error),
+    /// The index was built with a stemmer this binary does not support.
+    UnsupportedStemmer(String),
+    /// The index was built with an analyzer this binary can't build, or
+    /// `Analyzer::parse` was given a malformed spec.
+    UnsupportedAnalyzer(String),
+    /// Definition search on an index built without `--definitions`.
+    NoDefinitions,
+    /// Case-sensitive search on an index built without `--case-sensitive`.
+    CaseFoldedIndex,
+    /// Sorting by mtime on an index built without `--track-mtime`.
+    NoMtimes,
+    /// Asking for term positions on an index built without `--track-offsets`.
+    NoOffsets,
+    /// Searching by token class on an index built without `--token-classes`.
+    NoTokenClasses,
+    /// A field name `IndexOptions::fields` can't declare, and why.
+    InvalidField { name: String, reason: &'static str },
+    /// Field values for a field the index wasn't built with.
+    UnknownField(String),
+    /// File watcher failure (`ns index --watch`).
+    Watch(notify::Error),
+    /// The operation's `CancelToken` was cancelled.
+    Cancelled,
+    /// The operation's `CancelToken` deadline passed.
+    DeadlineExceeded,
+    /// Merging shard indexes built with different index options.
+    IncompatibleIndexes { option: &'static str },
+    /// Merging shard indexes that both hold this file.
+    DuplicateShardPath(String),
+    /// A `strict` full build hit a file it couldn't read.
+    Unreadable(FileError),
 }
 
 impl fmt::Display for NsError {
@@ -34,6 +67,57 @@ impl fmt::Display for NsError {
                 found, expected
             ),
             NsError::Glob(e) => write!(f, "invalid glob pattern: {}", e),
+            NsError::Regex(e) => write!(f, "invalid regex: {}", e),
+            NsError::UnsupportedStemmer(name) => write!(
+                f,
+                "index was built with the '{}' stemmer, which this version of ns does not support — run `ns index` to rebuild",
+                name
+            ),
+            NsError::UnsupportedAnalyzer(spec) => write!(
+                f,
+                "index was built with the '{}' analyzer, which this version of ns does not support — run `ns index` to rebuild",
+                spec
+            ),
+            NsError::NoDefinitions => write!(
+                f,
+                "index has no symbol definitions — run `ns index --definitions` to record them"
+            ),
+            NsError::CaseFoldedIndex => write!(
+                f,
+                "index only holds lowercased text — run `ns index --case-sensitive` to search case-sensitively"
+            ),
+            NsError::NoMtimes => write!(
+                f,
+                "index has no file modification times — run `ns index --track-mtime` to sort by mtime"
+            ),
+            NsError::NoOffsets => write!(
+                f,
+                "index has no token offsets — run `ns index --track-offsets` to report term positions"
+            ),
+            NsError::NoTokenClasses => write!(
+                f,
+                "index doesn't tell code from comments and strings — run `ns index --token-classes` to search them apart"
+            ),
+            NsError::InvalidField { name, reason } => {
+                write!(f, "cannot declare field '{}': {}", name, reason)
+            }
+            NsError::UnknownField(name) => write!(
+                f,
+                "index has no field '{}' — declare it with `ns index --field {}`",
+                name, name
+            ),
+            NsError::Watch(e) => write!(f, "file watcher error: {}", e),
+            NsError::Cancelled => write!(f, "operation cancelled"),
+            NsError::DeadlineExceeded => write!(f, "operation timed out"),
+            NsError::IncompatibleIndexes { option } => write!(
+                f,
+                "cannot merge indexes built with different {} — rebuild the shards with the same `ns index` options",
+                option
+            ),
+            NsError::DuplicateShardPath(path) => {
+                write!(f, "cannot merge indexes: '{}' is indexed in more than one shard", path)
+            }
+            NsError::Unreadable(e) => write!(f, "cannot read {}", e),
         }
     }
 }
@@ -47,10 +131,78 @@ impl std::error::Error for NsError {
             NsError::Json(e) => Some(e),
             NsError::SchemaVersionMismatch { .. } => None,
             NsError::Glob(e) => Some(e),
+            NsError::Regex(e) => Some(e),
+            NsError::UnsupportedStemmer(_) => None,
+            NsError::UnsupportedAnalyzer(_) => None,
+            NsError::NoDefinitions => None,
+            NsError::CaseFoldedIndex => None,
+            NsError::NoMtimes => None,
+            NsError::NoOffsets => None,
+            NsError::NoTokenClasses => None,
+            NsError::InvalidField { .. } => None,
+            NsError::UnknownField(_) => None,
+            NsError::Watch(e) => Some(e),
+            NsError::Cancelled => None,
+            NsError::DeadlineExceeded => None,
+            NsError::IncompatibleIndexes { .. } => None,
+            NsError::DuplicateShardPath(_) => None,
+            NsError::Unreadable(e) => Some(&e.error),
+        }
+    }
+}
+
+/// A file a full build couldn't read: permissions, a file deleted or
+/// replaced after the walk listed it.
+#[derive(Debug)]
+pub struct FileError {
+    /// Path relative to the repo root.
+    pub path: String,
+    pub error: std::io::Error,
+}
+
+impl fmt::Display for FileError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}: {}", self.path, self.error)
+    }
+}
+
+impl std::error::Error for FileError {
+    fn source(&self) -> Option<&(dyn std::error::Error + 'static)> {
+        Some(&self.error)
+    }
+}
+
+/// The files a full build skipped because it couldn't read them, in walk
+/// order (`FullIndexStats::unreadable`). The build itself succeeds; as an
+/// error, this lets callers fail on any skipped file after the fact.
+#[derive(Debug, Default)]
+pub struct IndexErrors {
+    pub files: Vec<FileError>,
+}
+
+impl IndexErrors {
+    pub fn is_empty(&self) -> bool {
+        self.files.is_empty()
+    }
+
+    pub fn len(&self) -> usize {
+        self.files.len()
+    }
+}
+
+impl fmt::Display for IndexErrors {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let noun = if self.files.len() == 1 { "file" } else { "files" };
+        write!(f, "cannot read {} {}", self.files.len(), noun)?;
+        for (i, file) in self.files.iter().enumerate() {
+            write!(f, "{} {}", if i == 0 { ":" } else { ";" }, file)?;
         }
+        Ok(())
     }
 }
 
+impl std::error::Error for IndexErrors {}
+
 impl From<std::io::Error> for NsError {
     fn from(e: std::io::Error) -> Self {
         NsError::Io(e)
@@ -81,6 +233,18 @@ impl From<glob::PatternError> for NsError {
     }
 }
 
+impl From<regex::Error> for NsError {
+    fn from(e: regex::Error) -> Self {
+        NsError::Regex(e)
+    }
+}
+
+impl From<notify::Error> for NsError {
+    fn from(e: notify::Error) -> Self {
+        NsError::Watch(e)
+    }
+}
+
 impl NsError {
     /// Returns `true` if this error is a tantivy lock-acquisition failure.
     ///
@@ -93,4 +257,10 @@ impl NsError {
                 | NsError::Tantivy(tantivy::TantivyError::Poisoned)
         )
     }
+
+    /// Returns `true` if the operation stopped because its `CancelToken`
+    /// was cancelled or expired, rather than failing.
+    pub fn is_cancellation(&self) -> bool {
+        matches!(self, NsError::Cancelled | NsError::DeadlineExceeded)
+    }
 }
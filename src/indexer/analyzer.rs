@@ -0,0 +1,613 @@
+use std::collections::HashSet;
+use std::sync::{Arc, Mutex};
+
+use tantivy::tokenizer::{
+    AsciiFoldingFilter, LowerCaser, RawTokenizer, Stemmer, StopWordFilter, TextAnalyzer,
+    TextAnalyzerBuilder, Token, TokenStream, Tokenizer,
+};
+use unicode_normalization::char::is_combining_mark;
+use unicode_normalization::UnicodeNormalization;
+
+use crate::error::NsError;
+
+use super::tokenizer::{is_word_char, split_identifier, CodeTokenizer, Stemming, WordTokenizer};
+
+/// The pipeline that turns file content into `content` terms: a tokenizer,
+/// then filters applied to each token in order. The same pipeline analyzes
+/// query text, since the index's "code" tokenizer is registered from it.
+///
+/// Built from `IndexOptions::analyzer` (or `ns index --analyzer`) and
+/// recorded in `meta.json` as its [`Analyzer::spec`], e.g.
+/// `code,length:1:39,lowercase,stop_words,stem`. Opening an index whose
+/// spec this binary can't parse fails with [`NsError::UnsupportedAnalyzer`]
+/// rather than querying it with a different pipeline.
+///
+/// `symbols` and `content_cased` keep their own fixed analyzers.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Analyzer {
+    pub tokenizer: BaseTokenizer,
+    pub filters: Vec<Filter>,
+}
+
+/// How an [`Analyzer`] splits text into tokens.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum BaseTokenizer {
+    /// [`CodeTokenizer`]: runs of letters, digits and `_`, with each
+    /// identifier also split on `camelCase` and `snake_case` boundaries.
+    Code,
+    /// [`WordTokenizer`]: runs of letters and digits, so identifiers stay
+    /// whole and `_` separates words.
+    Simple,
+}
+
+/// One step of an [`Analyzer`] pipeline. Filters that drop a token leave
+/// the positions of the others as they were, so phrases and tracked lines
+/// agree whatever the filters.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum Filter {
+    /// Lowercases each token.
+    Lowercase,
+    /// Folds accented Latin letters to ASCII (`café` → `cafe`).
+    AsciiFold,
+    /// Puts each token in Unicode normalization form C, so a precomposed
+    /// `é` and `e` followed by a combining acute are the same term.
+    Nfc,
+    /// Strips combining marks after canonical decomposition, then
+    /// recomposes (`café` → `cafe`, `Ελλάδα` → `Ελλαδα`). Lossy, and wrong
+    /// for languages where the marks tell words apart, so never a default.
+    FoldDiacritics,
+    /// Drops the index's stop words (`IndexOptions::stop_words`).
+    StopWords,
+    /// Reduces each token to its stem.
+    Stem(Stemming),
+    /// Drops tokens shorter than `min` or longer than `max` characters —
+    /// Unicode scalar values, so `日本` is two long, not six.
+    Length { min: usize, max: usize },
+}
+
+impl Default for Analyzer {
+    /// The pipeline `ns index` uses unless told otherwise: code tokens of
+    /// at most 39 characters (after tantivy's own 40-byte cutoff),
+    /// lowercased, stop words dropped. Stop words are matched as written,
+    /// so before any stemming.
+    fn default() -> Self {
+        Self {
+            tokenizer: BaseTokenizer::Code,
+            filters: vec![
+                Filter::Length { min: 1, max: 39 },
+                Filter::Lowercase,
+                Filter::StopWords,
+            ],
+        }
+    }
+}
+
+impl Analyzer {
+    /// The default pipeline, stemmed with `stemming` after stop words are
+    /// dropped — what `ns index --stem` builds.
+    pub fn with_stemming(stemming: Stemming) -> Self {
+        let mut analyzer = Self::default();
+        if stemming != Stemming::Noop {
+            analyzer.filters.push(Filter::Stem(stemming));
+        }
+        analyzer
+    }
+
+    /// This pipeline with its first [`Filter::Length`] bounds replaced by
+    /// `min` and `max` where given — what `ns index --min-token-length` and
+    /// `--max-token-length` build. A pipeline without one gets one first,
+    /// the missing bound taken from the default `length:1:39`. The result
+    /// only round-trips through [`Analyzer::parse`] if `min <= max`.
+    pub fn with_length(mut self, min: Option<usize>, max: Option<usize>) -> Self {
+        let position = self
+            .filters
+            .iter()
+            .position(|f| matches!(f, Filter::Length { .. }));
+        let index = position.unwrap_or_else(|| {
+            self.filters.insert(0, Filter::Length { min: 1, max: 39 });
+            0
+        });
+        if let Filter::Length {
+            min: ref mut lo,
+            max: ref mut hi,
+        } = self.filters[index]
+        {
+            *lo = min.unwrap_or(*lo);
+            *hi = max.unwrap_or(*hi);
+        }
+        self
+    }
+
+    /// Parses a spec as written by [`Analyzer::spec`]: the tokenizer
+    /// (`code` or `simple`), then the filters, comma-separated —
+    /// `lowercase`, `ascii_fold`, `nfc`, `fold_diacritics`, `stop_words`,
+    /// `stem` (`stem:english`) and `length:MIN:MAX`.
+    pub fn parse(spec: &str) -> Result<Self, NsError> {
+        let unsupported = || NsError::UnsupportedAnalyzer(spec.to_string());
+        let mut steps = spec.split(',').map(str::trim);
+        let tokenizer = match steps.next() {
+            Some("code") => BaseTokenizer::Code,
+            Some("simple") => BaseTokenizer::Simple,
+            _ => return Err(unsupported()),
+        };
+        let mut filters = Vec::new();
+        for step in steps {
+            let (name, arg) = match step.split_once(':') {
+                Some((name, arg)) => (name, Some(arg)),
+                None => (step, None),
+            };
+            let filter = match (name, arg) {
+                ("lowercase", None) => Filter::Lowercase,
+                ("ascii_fold", None) => Filter::AsciiFold,
+                ("nfc", None) => Filter::Nfc,
+                ("fold_diacritics", None) => Filter::FoldDiacritics,
+                ("stop_words", None) => Filter::StopWords,
+                ("stem", None) => Filter::Stem(Stemming::English),
+                ("stem", Some(language)) => match Stemming::from_name(Some(language)) {
+                    Ok(Stemming::Noop) | Err(_) => return Err(unsupported()),
+                    Ok(stemming) => Filter::Stem(stemming),
+                },
+                ("length", Some(range)) => {
+                    let (min, max) = range.split_once(':').ok_or_else(unsupported)?;
+                    let min = min.parse().map_err(|_| unsupported())?;
+                    let max = max.parse().map_err(|_| unsupported())?;
+                    if min > max {
+                        return Err(unsupported());
+                    }
+                    Filter::Length { min, max }
+                }
+                _ => return Err(unsupported()),
+            };
+            filters.push(filter);
+        }
+        Ok(Self { tokenizer, filters })
+    }
+
+    /// The spec recorded in `meta.json`; [`Analyzer::parse`] reads it back.
+    pub fn spec(&self) -> String {
+        let mut steps = vec![match self.tokenizer {
+            BaseTokenizer::Code => "code".to_string(),
+            BaseTokenizer::Simple => "simple".to_string(),
+        }];
+        for filter in &self.filters {
+            steps.push(match filter {
+                Filter::Lowercase => "lowercase".to_string(),
+                Filter::AsciiFold => "ascii_fold".to_string(),
+                Filter::Nfc => "nfc".to_string(),
+                Filter::FoldDiacritics => "fold_diacritics".to_string(),
+                Filter::StopWords => "stop_words".to_string(),
+                Filter::Stem(stemming) => match stemming.name() {
+                    Some("english") | None => "stem".to_string(),
+                    Some(language) => format!("stem:{}", language),
+                },
+                Filter::Length { min, max } => format!("length:{}:{}", min, max),
+            });
+        }
+        steps.join(",")
+    }
+
+    /// The stemmer applied by the pipeline, `Noop` if none.
+    pub fn stemming(&self) -> Stemming {
+        self.filters
+            .iter()
+            .find_map(|f| match f {
+                Filter::Stem(stemming) => Some(*stemming),
+                _ => None,
+            })
+            .unwrap_or_default()
+    }
+
+    /// Whether indexed terms are the lowercased words of the text, as
+    /// written: true unless the pipeline stems, folds or normalizes, keeps
+    /// case, splits
+    /// differently from the default or drops more words by length. Regex
+    /// search pre-filters candidate files by indexed terms only then.
+    pub fn indexes_lowercased_words(&self) -> bool {
+        self.tokenizer == BaseTokenizer::Code
+            && self.filters.contains(&Filter::Lowercase)
+            && self.filters.iter().all(|f| match *f {
+                Filter::Lowercase | Filter::StopWords => true,
+                Filter::Length { min, max } => min <= 1 && max >= 39,
+                _ => false,
+            })
+    }
+
+    /// The tantivy analyzer for `content`, dropping `stop_words` at
+    /// [`Filter::StopWords`].
+    pub fn text_analyzer(&self, stop_words: &[String]) -> TextAnalyzer {
+        self.build_text_analyzer(stop_words, None)
+    }
+
+    /// Like [`Analyzer::text_analyzer`], also collecting the tokens its
+    /// [`Filter::Length`] drops into `dropped`. Full builds index with it
+    /// to report `FullIndexStats::filtered_terms`.
+    pub fn counting_text_analyzer(
+        &self,
+        stop_words: &[String],
+        dropped: &DroppedTerms,
+    ) -> TextAnalyzer {
+        self.build_text_analyzer(stop_words, Some(dropped))
+    }
+
+    fn build_text_analyzer(
+        &self,
+        stop_words: &[String],
+        dropped: Option<&DroppedTerms>,
+    ) -> TextAnalyzer {
+        let builder = match self.tokenizer {
+            BaseTokenizer::Code => TextAnalyzer::builder(CodeTokenizer::default()).dynamic(),
+            BaseTokenizer::Simple => TextAnalyzer::builder(WordTokenizer::default()).dynamic(),
+        };
+        self.with_filters(builder, stop_words, dropped).build()
+    }
+
+    /// The tokenizer alone, for token positions (see `lines::LineIndex`).
+    pub fn position_analyzer(&self) -> TextAnalyzer {
+        match self.tokenizer {
+            BaseTokenizer::Code => CodeTokenizer::default().into(),
+            BaseTokenizer::Simple => WordTokenizer::default().into(),
+        }
+    }
+
+    /// A [`Normalizer`] that puts single words through the filters.
+    pub fn normalizer(&self, stop_words: &[String]) -> Normalizer {
+        let builder = TextAnalyzer::builder(RawTokenizer::default()).dynamic();
+        Normalizer {
+            analyzer: self.with_filters(builder, stop_words, None).build(),
+        }
+    }
+
+    /// The words of `text` the tokenizer indexes at consecutive positions:
+    /// identifier parts for `code` (`readTimeout` → `read`, `Timeout`),
+    /// whole words for `simple`. Not filtered.
+    pub fn split_words<'a>(&self, text: &'a str) -> Vec<&'a str> {
+        match self.tokenizer {
+            BaseTokenizer::Code => text
+                .split(|c: char| !is_word_char(c) && c != '_')
+                .flat_map(|word| split_identifier(word).into_iter().map(|(s, e)| &word[s..e]))
+                .collect(),
+            BaseTokenizer::Simple => text
+                .split(|c: char| !is_word_char(c))
+                .filter(|word| !word.is_empty())
+                .collect(),
+        }
+    }
+
+    fn with_filters(
+        &self,
+        mut builder: TextAnalyzerBuilder,
+        stop_words: &[String],
+        dropped: Option<&DroppedTerms>,
+    ) -> TextAnalyzerBuilder {
+        for filter in &self.filters {
+            builder = match filter {
+                Filter::Lowercase => builder.filter_dynamic(LowerCaser),
+                Filter::AsciiFold => builder.filter_dynamic(AsciiFoldingFilter),
+                Filter::Nfc => builder.filter_dynamic(MapFilter(nfc)),
+                Filter::FoldDiacritics => builder.filter_dynamic(MapFilter(fold_diacritics)),
+                Filter::StopWords => {
+                    builder.filter_dynamic(StopWordFilter::remove(stop_words.to_vec()))
+                }
+                Filter::Stem(stemming) => match stemming.language() {
+                    Some(language) => builder.filter_dynamic(Stemmer::new(language)),
+                    None => builder,
+                },
+                &Filter::Length { min, max } => builder.filter_dynamic(LengthFilter {
+                    min,
+                    max,
+                    dropped: dropped.cloned(),
+                }),
+            };
+        }
+        builder
+    }
+}
+
+/// Applies an [`Analyzer`]'s filters to words the caller has already split
+/// (query terms for fuzzy matching, proximity and suggestions), so they
+/// come out as the indexed terms of those words would.
+pub struct Normalizer {
+    analyzer: TextAnalyzer,
+}
+
+impl Normalizer {
+    /// The indexed form of `word`, or `None` if the filters drop it.
+    pub fn normalize(&mut self, word: &str) -> Option<String> {
+        let mut stream = self.analyzer.token_stream(word);
+        stream.next().map(|token| token.text.clone())
+    }
+}
+
+/// The distinct tokens a [`Filter::Length`] dropped, shared by the clones
+/// of a [`Analyzer::counting_text_analyzer`] on every indexing thread.
+#[derive(Debug, Clone, Default)]
+pub struct DroppedTerms(Arc<Mutex<HashSet<String>>>);
+
+impl DroppedTerms {
+    pub fn count(&self) -> usize {
+        self.0.lock().map_or(0, |terms| terms.len())
+    }
+}
+
+/// [`Filter::Length`] as a tantivy filter. Like tantivy's
+/// `RemoveLongFilter`, but counting characters, with a lower bound too.
+#[derive(Clone)]
+struct LengthFilter {
+    min: usize,
+    max: usize,
+    dropped: Option<DroppedTerms>,
+}
+
+impl tantivy::tokenizer::TokenFilter for LengthFilter {
+    type Tokenizer<T: Tokenizer> = LengthFilterWrapper<T>;
+
+    fn transform<T: Tokenizer>(self, tokenizer: T) -> LengthFilterWrapper<T> {
+        LengthFilterWrapper {
+            filter: self,
+            inner: tokenizer,
+        }
+    }
+}
+
+#[derive(Clone)]
+struct LengthFilterWrapper<T> {
+    filter: LengthFilter,
+    inner: T,
+}
+
+impl<T: Tokenizer> Tokenizer for LengthFilterWrapper<T> {
+    type TokenStream<'a> = LengthFilterStream<T::TokenStream<'a>>;
+
+    fn token_stream<'a>(&'a mut self, text: &'a str) -> Self::TokenStream<'a> {
+        LengthFilterStream {
+            filter: self.filter.clone(),
+            tail: self.inner.token_stream(text),
+        }
+    }
+}
+
+struct LengthFilterStream<T> {
+    filter: LengthFilter,
+    tail: T,
+}
+
+impl<T: TokenStream> TokenStream for LengthFilterStream<T> {
+    fn advance(&mut self) -> bool {
+        while self.tail.advance() {
+            let text = &self.tail.token().text;
+            if (self.filter.min..=self.filter.max).contains(&text.chars().count()) {
+                return true;
+            }
+            if let Some(DroppedTerms(ref terms)) = self.filter.dropped {
+                if let Ok(mut terms) = terms.lock() {
+                    if !terms.contains(text) {
+                        terms.insert(text.clone());
+                    }
+                }
+            }
+        }
+        false
+    }
+
+    fn token(&self) -> &Token {
+        self.tail.token()
+    }
+
+    fn token_mut(&mut self) -> &mut Token {
+        self.tail.token_mut()
+    }
+}
+
+fn nfc(text: &str) -> String {
+    text.nfc().collect()
+}
+
+fn fold_diacritics(text: &str) -> String {
+    text.nfd()
+        .filter(|&c| !is_combining_mark(c))
+        .nfc()
+        .collect()
+}
+
+/// A filter that replaces each token's text with `self.0` of it, for
+/// [`Filter::Nfc`] and [`Filter::FoldDiacritics`]. Offsets still point at
+/// the text as written.
+#[derive(Clone, Copy)]
+struct MapFilter(fn(&str) -> String);
+
+impl tantivy::tokenizer::TokenFilter for MapFilter {
+    type Tokenizer<T: Tokenizer> = MapFilterWrapper<T>;
+
+    fn transform<T: Tokenizer>(self, tokenizer: T) -> MapFilterWrapper<T> {
+        MapFilterWrapper {
+            map: self.0,
+            inner: tokenizer,
+        }
+    }
+}
+
+#[derive(Clone)]
+struct MapFilterWrapper<T> {
+    map: fn(&str) -> String,
+    inner: T,
+}
+
+impl<T: Tokenizer> Tokenizer for MapFilterWrapper<T> {
+    type TokenStream<'a> = MapFilterStream<T::TokenStream<'a>>;
+
+    fn token_stream<'a>(&'a mut self, text: &'a str) -> Self::TokenStream<'a> {
+        MapFilterStream {
+            map: self.map,
+            tail: self.inner.token_stream(text),
+        }
+    }
+}
+
+struct MapFilterStream<T> {
+    map: fn(&str) -> String,
+    tail: T,
+}
+
+impl<T: TokenStream> TokenStream for MapFilterStream<T> {
+    fn advance(&mut self) -> bool {
+        if !self.tail.advance() {
+            return false;
+        }
+        let token = self.tail.token_mut();
+        token.text = (self.map)(&token.text);
+        true
+    }
+
+    fn token(&self) -> &Token {
+        self.tail.token()
+    }
+
+    fn token_mut(&mut self) -> &mut Token {
+        self.tail.token_mut()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn terms(analyzer: &Analyzer, text: &str) -> Vec<(String, usize)> {
+        let mut analyzer = analyzer.text_analyzer(&["the".to_string()]);
+        let mut stream = analyzer.token_stream(text);
+        let mut out = Vec::new();
+        while let Some(token) = stream.next() {
+            out.push((token.text.clone(), token.position));
+        }
+        out
+    }
+
+    #[test]
+    fn specs_round_trip() {
+        let default = Analyzer::default();
+        assert_eq!(default.spec(), "code,length:1:39,lowercase,stop_words");
+        let stemmed = Analyzer::with_stemming(Stemming::English);
+        assert_eq!(stemmed.spec(), "code,length:1:39,lowercase,stop_words,stem");
+        assert_eq!(
+            Analyzer::parse("code, lowercase , stem:english")
+                .unwrap()
+                .stemming(),
+            Stemming::English
+        );
+        for analyzer in [
+            default,
+            stemmed,
+            Analyzer::parse("simple,ascii_fold,length:2:20").unwrap(),
+            Analyzer::parse("code,nfc,lowercase,fold_diacritics").unwrap(),
+        ] {
+            assert_eq!(Analyzer::parse(&analyzer.spec()).unwrap(), analyzer);
+        }
+        for spec in [
+            "",
+            "code,soundex",
+            "ngram,lowercase",
+            "code,length:5:2",
+            "code,stem:klingon",
+        ] {
+            match Analyzer::parse(spec) {
+                Err(NsError::UnsupportedAnalyzer(found)) => assert_eq!(found, spec),
+                other => panic!("expected {:?} to be rejected, got {:?}", spec, other),
+            }
+        }
+    }
+
+    #[test]
+    fn filters_run_in_order_and_keep_positions() {
+        let custom = Analyzer::parse("simple,length:3:10").unwrap();
+        assert_eq!(
+            terms(&custom, "The read_Timeout of a café"),
+            vec![
+                ("The".to_string(), 0),
+                ("read".to_string(), 1),
+                ("Timeout".to_string(), 2),
+                ("café".to_string(), 5),
+            ]
+        );
+        // Filters run as listed: stop words only catch `The` once lowercased.
+        let folded = Analyzer::parse("code,lowercase,stop_words,ascii_fold").unwrap();
+        assert_eq!(terms(&folded, "The Café"), vec![("cafe".to_string(), 1)]);
+        let early = Analyzer::parse("code,stop_words,lowercase").unwrap();
+        assert_eq!(terms(&early, "The")[0].0, "the");
+    }
+
+    #[test]
+    fn normalization_makes_composed_and_decomposed_forms_one_term() {
+        let precomposed = "caf\u{e9} Cr\u{e8}me";
+        let decomposed = "cafe\u{301} Cre\u{300}me";
+        for base in ["code", "simple"] {
+            let plain = Analyzer::parse(&format!("{},lowercase", base)).unwrap();
+            assert_ne!(terms(&plain, precomposed), terms(&plain, decomposed));
+
+            let nfc = Analyzer::parse(&format!("{},nfc,lowercase", base)).unwrap();
+            let expected = vec![("caf\u{e9}".to_string(), 0), ("cr\u{e8}me".to_string(), 1)];
+            assert_eq!(terms(&nfc, precomposed), expected);
+            assert_eq!(terms(&nfc, decomposed), expected);
+            assert_eq!(
+                nfc.normalizer(&[]).normalize("cafe\u{301}").unwrap(),
+                "caf\u{e9}"
+            );
+        }
+
+        let folded = Analyzer::parse("code,lowercase,fold_diacritics").unwrap();
+        let expected = vec![("cafe".to_string(), 0), ("creme".to_string(), 1)];
+        assert_eq!(terms(&folded, precomposed), expected);
+        assert_eq!(terms(&folded, decomposed), expected);
+        // Beyond Latin, unlike `ascii_fold`; letters without a decomposition stay.
+        assert_eq!(terms(&folded, "Ελλάδα øre")[0].0, "ελλαδα");
+        assert_eq!(terms(&folded, "Ελλάδα øre")[1].0, "øre");
+        assert!(!folded.indexes_lowercased_words());
+    }
+
+    #[test]
+    fn length_counts_characters_and_reports_drops() {
+        let short = Analyzer::default().with_length(Some(2), None);
+        assert_eq!(short.spec(), "code,length:2:39,lowercase,stop_words");
+        let dropped = DroppedTerms::default();
+        let mut analyzer = short.counting_text_analyzer(&[], &dropped);
+        let mut stream = analyzer.token_stream("for i in x: 日本 語 i");
+        let mut kept = Vec::new();
+        while let Some(token) = stream.next() {
+            kept.push(token.text.clone());
+        }
+        // `日本` is six bytes but two characters; `語` is one.
+        assert_eq!(kept, vec!["for", "in", "日本"]);
+        assert_eq!(dropped.count(), 3, "i, x and 語, each counted once");
+
+        let capped = Analyzer::parse("simple")
+            .unwrap()
+            .with_length(None, Some(4));
+        assert_eq!(capped.spec(), "simple,length:1:4");
+    }
+
+    #[test]
+    fn normalizer_matches_indexed_terms() {
+        let stemmed = Analyzer::with_stemming(Stemming::English);
+        let mut normalizer = stemmed.normalizer(&["the".to_string()]);
+        assert_eq!(
+            normalizer.normalize("Connections").as_deref(),
+            Some("connect")
+        );
+        assert_eq!(normalizer.normalize("the"), None);
+        assert_eq!(normalizer.normalize(&"x".repeat(40)), None);
+
+        assert_eq!(
+            stemmed.split_words("readTimeout(x_y)"),
+            vec!["read", "Timeout", "x", "y"]
+        );
+        assert_eq!(
+            Analyzer::parse("simple")
+                .unwrap()
+                .split_words("readTimeout(x_y)"),
+            vec!["readTimeout", "x", "y"]
+        );
+        assert!(Analyzer::default().indexes_lowercased_words());
+        assert!(!stemmed.indexes_lowercased_words());
+        assert!(!Analyzer::parse("code,length:1:39")
+            .unwrap()
+            .indexes_lowercased_words());
+    }
+}
@@ -0,0 +1,203 @@
+use tree_sitter::{Node, Parser};
+
+/// What part of a source file a token came from. Only Go is lexed; every
+/// token of any other file counts as `Code`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum TokenClass {
+    Code,
+    /// `// line` and `/* block */` comments.
+    Comment,
+    /// Interpreted, raw and rune literals, quotes included.
+    String,
+}
+
+impl TokenClass {
+    pub const ALL: [TokenClass; 3] = [TokenClass::Code, TokenClass::Comment, TokenClass::String];
+
+    /// The names `ns --in` takes, as [`TokenClass::name`] returns them.
+    pub const NAMES: &'static [&'static str] = &["code", "comment", "string"];
+
+    pub fn name(self) -> &'static str {
+        match self {
+            TokenClass::Code => "code",
+            TokenClass::Comment => "comment",
+            TokenClass::String => "string",
+        }
+    }
+
+    pub fn from_name(name: &str) -> Option<Self> {
+        TokenClass::ALL
+            .into_iter()
+            .find(|class| class.name() == name)
+    }
+
+    fn bit(self) -> u8 {
+        1 << self as u8
+    }
+}
+
+/// A set of [`TokenClass`]es, for `SearchOptions::token_classes`. The
+/// default holds all three, which filters nothing.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct TokenClasses(u8);
+
+impl Default for TokenClasses {
+    fn default() -> Self {
+        Self::only(&TokenClass::ALL)
+    }
+}
+
+impl TokenClasses {
+    pub fn only(classes: &[TokenClass]) -> Self {
+        Self(classes.iter().fold(0, |bits, class| bits | class.bit()))
+    }
+
+    /// This set without `class` — `without(Comment)` is `--exclude-comments`.
+    pub fn without(self, class: TokenClass) -> Self {
+        Self(self.0 & !class.bit())
+    }
+
+    pub fn contains(self, class: TokenClass) -> bool {
+        self.0 & class.bit() != 0
+    }
+
+    pub fn is_all(self) -> bool {
+        self == Self::default()
+    }
+
+    /// The classes in the set, in [`TokenClass::ALL`] order.
+    pub fn iter(self) -> impl Iterator<Item = TokenClass> {
+        TokenClass::ALL
+            .into_iter()
+            .filter(move |&class| self.contains(class))
+    }
+
+    /// The names of the classes in the set, comma-separated.
+    pub fn names(self) -> String {
+        self.iter()
+            .map(TokenClass::name)
+            .collect::<Vec<_>>()
+            .join(",")
+    }
+}
+
+/// A file's text split by [`TokenClass`], indexed into `content_code`,
+/// `content_comment` and `content_string` beside the whole text in
+/// `content`. Each span of one class is followed by a space or newline,
+/// so words of neighbouring spans don't run together.
+#[derive(Debug, Default, PartialEq, Eq)]
+pub struct ClassTexts {
+    pub code: String,
+    pub comment: String,
+    pub string: String,
+}
+
+impl ClassTexts {
+    pub fn get(&self, class: TokenClass) -> &str {
+        match class {
+            TokenClass::Code => &self.code,
+            TokenClass::Comment => &self.comment,
+            TokenClass::String => &self.string,
+        }
+    }
+}
+
+/// Splits Go `source` into its code, comments and string literals with
+/// tree-sitter, which knows their spans exactly: `"// not a comment"` is
+/// a string, and `/* "not a string" */` a comment.
+///
+/// Returns `None` for other languages, whose tokens are all code.
+pub fn split_classes(lang: &str, source: &str) -> Option<ClassTexts> {
+    if lang != "go" {
+        return None;
+    }
+    let mut parser = Parser::new();
+    parser
+        .set_language(&tree_sitter_go::LANGUAGE.into())
+        .expect("failed to load Go grammar");
+    let tree = parser.parse(source, None)?;
+
+    let mut spans = Vec::new();
+    collect_spans(tree.root_node(), &mut spans);
+    let mut texts = ClassTexts::default();
+    let mut code_from = 0;
+    for (class, start, end) in spans {
+        texts.code.push_str(&source[code_from..start]);
+        texts.code.push(' ');
+        let text = match class {
+            TokenClass::Comment => &mut texts.comment,
+            _ => &mut texts.string,
+        };
+        text.push_str(&source[start..end]);
+        text.push('\n');
+        code_from = end;
+    }
+    texts.code.push_str(&source[code_from..]);
+    Some(texts)
+}
+
+/// Appends the comment and string literal spans under `node`, in source
+/// order. Literals aren't descended into, so spans never overlap.
+fn collect_spans(node: Node, spans: &mut Vec<(TokenClass, usize, usize)>) {
+    let class = match node.kind() {
+        "comment" => Some(TokenClass::Comment),
+        "interpreted_string_literal" | "raw_string_literal" | "rune_literal" => {
+            Some(TokenClass::String)
+        }
+        _ => None,
+    };
+    if let Some(class) = class {
+        spans.push((class, node.start_byte(), node.end_byte()));
+        return;
+    }
+    for i in 0..node.child_count() {
+        if let Some(child) = node.child(i) {
+            collect_spans(child, spans);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn go_source_splits_into_code_comments_and_strings() {
+        let source = r#"package main
+
+// graceful shutdown
+func stop(s *Server) {
+	s.log("// not a comment")
+	/* "not a string" */
+	s.Close(`raw`, 'x')
+}
+"#;
+        let texts = split_classes("go", source).unwrap();
+        assert_eq!(
+            texts.comment,
+            "// graceful shutdown\n/* \"not a string\" */\n"
+        );
+        assert_eq!(texts.string, "\"// not a comment\"\n`raw`\n'x'\n");
+        assert!(texts.code.contains("func stop(s *Server)"));
+        assert!(!texts.code.contains("graceful"));
+        assert!(!texts.code.contains("raw"));
+
+        assert_eq!(split_classes("rust", "// comment"), None);
+    }
+
+    #[test]
+    fn class_sets() {
+        let all = TokenClasses::default();
+        assert!(all.is_all());
+        let no_comments = all.without(TokenClass::Comment);
+        assert!(!no_comments.is_all());
+        assert_eq!(no_comments.names(), "code,string");
+        let comments = TokenClasses::only(&[TokenClass::Comment]);
+        assert_eq!(
+            comments.iter().collect::<Vec<_>>(),
+            vec![TokenClass::Comment]
+        );
+        assert_eq!(TokenClass::from_name("string"), Some(TokenClass::String));
+        assert_eq!(TokenClass::from_name("strings"), None);
+    }
+}
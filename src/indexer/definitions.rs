@@ -0,0 +1,159 @@
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::Path;
+
+use serde::{Deserialize, Serialize};
+
+use crate::error::NsError;
+
+use super::language::detect_language;
+use super::symbols::extract_definitions;
+
+/// What a [`Definition`] defines.
+#[derive(Serialize, Deserialize, Debug, Clone, Copy, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+pub enum SymbolKind {
+    Func,
+    Method,
+    Type,
+    Const,
+    Var,
+}
+
+/// Names accepted by `ns def --kind`, in [`SymbolKind::name`] form.
+pub const KIND_NAMES: &[&str] = &["func", "method", "type", "const", "var"];
+
+impl SymbolKind {
+    /// The lowercase name used in `definitions.json` and on the command line.
+    pub fn name(self) -> &'static str {
+        match self {
+            SymbolKind::Func => "func",
+            SymbolKind::Method => "method",
+            SymbolKind::Type => "type",
+            SymbolKind::Const => "const",
+            SymbolKind::Var => "var",
+        }
+    }
+
+    /// Parses a name from [`KIND_NAMES`].
+    pub fn from_name(name: &str) -> Option<Self> {
+        match name {
+            "func" => Some(SymbolKind::Func),
+            "method" => Some(SymbolKind::Method),
+            "type" => Some(SymbolKind::Type),
+            "const" => Some(SymbolKind::Const),
+            "var" => Some(SymbolKind::Var),
+            _ => None,
+        }
+    }
+}
+
+/// A symbol definition site within one file.
+#[derive(Serialize, Deserialize, Debug, Clone, PartialEq, Eq)]
+pub struct Definition {
+    /// Symbol name, as written.
+    pub name: String,
+    pub kind: SymbolKind,
+    /// 1-based line of the name.
+    pub line: usize,
+    /// 1-based byte column of the name.
+    pub column: usize,
+}
+
+/// Contents of `.ns/definitions.json` (`ns index --definitions`): the
+/// definitions in each file, keyed by path relative to the repo root.
+/// Files without definitions are omitted.
+#[derive(Serialize, Deserialize, Debug, Default)]
+pub struct Definitions {
+    pub files: BTreeMap<String, Vec<Definition>>,
+}
+
+impl Definitions {
+    /// Extracts definitions from each of `rel_paths` under `root`, reading
+    /// only files of supported languages (Go). Full builds use
+    /// [`Definitions::insert`] on the content they already read instead.
+    pub fn build<'a>(root: &Path, rel_paths: impl IntoIterator<Item = &'a String>) -> Self {
+        let mut defs = Self::default();
+        for rel_path in rel_paths {
+            defs.refresh(root, rel_path);
+        }
+        defs
+    }
+
+    /// Re-extracts the definitions of `rel_path` from disk, dropping its
+    /// entry if the file is gone, unsupported or defines nothing.
+    pub fn refresh(&mut self, root: &Path, rel_path: &str) {
+        let abs_path = root.join(rel_path);
+        match detect_language(&abs_path).and_then(|_| fs::read(&abs_path).ok()) {
+            Some(source) => self.insert(rel_path, &source),
+            None => {
+                self.files.remove(rel_path);
+            }
+        }
+    }
+
+    /// Records the definitions in `source`, the content of `rel_path`,
+    /// replacing any earlier entry; drops the entry if its language is
+    /// unsupported or it defines nothing.
+    pub fn insert(&mut self, rel_path: &str, source: &[u8]) {
+        let found = detect_language(Path::new(rel_path))
+            .map(|lang| extract_definitions(lang, source))
+            .unwrap_or_default();
+        if found.is_empty() {
+            self.files.remove(rel_path);
+        } else {
+            self.files.insert(rel_path.to_string(), found);
+        }
+    }
+}
+
+/// Reads `.ns/definitions.json`.
+pub fn read_definitions(root: &Path) -> Result<Definitions, NsError> {
+    let path = root.join(".ns").join("definitions.json");
+    let content = fs::read_to_string(path)?;
+    Ok(serde_json::from_str(&content)?)
+}
+
+/// Writes `.ns/definitions.json`.
+pub fn write_definitions(root: &Path, defs: &Definitions) -> Result<(), NsError> {
+    let path = root.join(".ns").join("definitions.json");
+    let json = serde_json::to_string(defs)?;
+    fs::write(path, json)?;
+    Ok(())
+}
+
+/// Removes `.ns/definitions.json` left by an earlier `--definitions` build.
+pub fn remove_definitions(root: &Path) -> Result<(), NsError> {
+    match fs::remove_file(root.join(".ns").join("definitions.json")) {
+        Err(e) if e.kind() != std::io::ErrorKind::NotFound => Err(e.into()),
+        _ => Ok(()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn kind_names_round_trip() {
+        for name in KIND_NAMES {
+            assert_eq!(SymbolKind::from_name(name).map(SymbolKind::name), Some(*name));
+        }
+        assert_eq!(SymbolKind::from_name("struct"), None);
+    }
+
+    #[test]
+    fn refresh_skips_non_go_files_and_drops_stale_entries() {
+        let dir = tempfile::tempdir().unwrap();
+        fs::write(dir.path().join("main.go"), "package main\n\nfunc Run() {}\n").unwrap();
+        fs::write(dir.path().join("lib.rs"), "fn run() {}\n").unwrap();
+
+        let paths = vec!["main.go".to_string(), "lib.rs".to_string()];
+        let mut defs = Definitions::build(dir.path(), &paths);
+        assert_eq!(defs.files.keys().collect::<Vec<_>>(), vec!["main.go"]);
+
+        fs::remove_file(dir.path().join("main.go")).unwrap();
+        defs.refresh(dir.path(), "main.go");
+        assert!(defs.files.is_empty());
+    }
+}
@@ -0,0 +1,166 @@
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::Path;
+
+use serde::{Deserialize, Serialize};
+
+use crate::error::NsError;
+use crate::schema::build_schema;
+
+/// A named text field declared with `IndexOptions::fields` (`ns index
+/// --field title=3`), beside the file's own content: a document title, a
+/// summary, tags. Values are set per file with [`super::index_fields`] and
+/// tokenized like `content`.
+///
+/// Plain queries search every declared field, each scored at `boost`
+/// times the weight of `content` (1), so a word in a title can outrank the
+/// same word deep in the body; `title:term` searches that field alone.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct DocField {
+    pub name: String,
+    pub boost: f32,
+}
+
+/// Field names inline filters take (see `query_ast::split_field_filters`),
+/// besides those of schema fields.
+const FILTER_NAMES: &[&str] = &["path", "lang", "ext"];
+
+/// Fails with [`NsError::InvalidField`] unless every name in `fields` is
+/// lowercase ASCII letters, digits and `_`, starting with a letter, not
+/// taken by a schema field or inline filter, and declared once; and every
+/// boost is a non-negative number.
+pub fn check_fields(fields: &[DocField]) -> Result<(), NsError> {
+    let schema = build_schema();
+    for (i, field) in fields.iter().enumerate() {
+        let name = field.name.as_str();
+        let well_formed = name.starts_with(|c: char| c.is_ascii_lowercase())
+            && name
+                .bytes()
+                .all(|b| b.is_ascii_lowercase() || b.is_ascii_digit() || b == b'_');
+        let reason = if !well_formed {
+            "use lowercase letters, digits and `_`, starting with a letter"
+        } else if schema.get_field(name).is_ok() || FILTER_NAMES.contains(&name) {
+            "the name is taken by a built-in field"
+        } else if fields[..i].iter().any(|f| f.name == name) {
+            "declared twice"
+        } else if !(field.boost.is_finite() && field.boost >= 0.0) {
+            "the boost must be a non-negative number"
+        } else {
+            continue;
+        };
+        return Err(NsError::InvalidField {
+            name: name.to_string(),
+            reason,
+        });
+    }
+    Ok(())
+}
+
+/// Contents of `.ns/fields.json`: the values of declared fields for
+/// indexed files, keyed by path relative to the repo root, then field
+/// name. Like metadata, they outlive rebuilds and follow renames.
+#[derive(Serialize, Deserialize, Debug, Default)]
+pub struct FieldValues {
+    files: BTreeMap<String, BTreeMap<String, String>>,
+}
+
+impl FieldValues {
+    /// The field values of `rel_path`, if it has any.
+    pub fn get(&self, rel_path: &str) -> Option<&BTreeMap<String, String>> {
+        self.files.get(rel_path)
+    }
+
+    /// Replaces the field values of `rel_path` with `values`; an empty map
+    /// removes them.
+    pub fn set(&mut self, rel_path: &str, values: &BTreeMap<String, String>) {
+        if values.is_empty() {
+            self.files.remove(rel_path);
+        } else {
+            self.files.insert(rel_path.to_string(), values.clone());
+        }
+    }
+
+    /// Moves the values of `old_path` to `new_path`, for a renamed file.
+    /// Returns whether `old_path` had any.
+    pub fn rename(&mut self, old_path: &str, new_path: &str) -> bool {
+        match self.files.remove(old_path) {
+            Some(values) => {
+                self.files.insert(new_path.to_string(), values);
+                true
+            }
+            None => false,
+        }
+    }
+
+    /// Keeps only the files for which `keep` returns true. Returns whether
+    /// any were dropped.
+    pub fn retain(&mut self, mut keep: impl FnMut(&str) -> bool) -> bool {
+        let before = self.files.len();
+        self.files.retain(|path, _| keep(path));
+        self.files.len() != before
+    }
+}
+
+/// Reads `.ns/fields.json`, or no values if there is none.
+pub fn read_field_values(root: &Path) -> Result<FieldValues, NsError> {
+    let path = root.join(".ns").join("fields.json");
+    match fs::read_to_string(path) {
+        Ok(content) => Ok(serde_json::from_str(&content)?),
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(FieldValues::default()),
+        Err(e) => Err(e.into()),
+    }
+}
+
+/// Writes `.ns/fields.json`.
+pub fn write_field_values(root: &Path, values: &FieldValues) -> Result<(), NsError> {
+    let path = root.join(".ns").join("fields.json");
+    fs::write(path, serde_json::to_string(values)?)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn field(name: &str, boost: f32) -> DocField {
+        DocField {
+            name: name.to_string(),
+            boost,
+        }
+    }
+
+    #[test]
+    fn field_names_must_be_new_and_well_formed() {
+        assert!(check_fields(&[field("title", 3.0), field("summary_2", 1.5)]).is_ok());
+        for fields in [
+            vec![field("Title", 1.0)],
+            vec![field("2nd", 1.0)],
+            vec![field("content", 1.0)],
+            vec![field("ext", 1.0)],
+            vec![field("title", 1.0), field("title", 2.0)],
+            vec![field("title", -1.0)],
+        ] {
+            assert!(
+                matches!(check_fields(&fields), Err(NsError::InvalidField { .. })),
+                "{:?}",
+                fields
+            );
+        }
+    }
+
+    #[test]
+    fn values_follow_renames_and_round_trip() {
+        let dir = tempfile::tempdir().unwrap();
+        fs::create_dir(dir.path().join(".ns")).unwrap();
+        let mut values = FieldValues::default();
+        let title = BTreeMap::from([("title".to_string(), "Getting started".to_string())]);
+        values.set("docs/start.md", &title);
+        assert!(values.rename("docs/start.md", "docs/intro.md"));
+        assert!(!values.retain(|path| path == "docs/intro.md"));
+        write_field_values(dir.path(), &values).unwrap();
+
+        let read = read_field_values(dir.path()).unwrap();
+        assert_eq!(read.get("docs/intro.md"), Some(&title));
+        assert_eq!(read.get("docs/start.md"), None);
+    }
+}
@@ -0,0 +1,150 @@
+use std::borrow::Cow;
+use std::fs;
+use std::io::{self, Read};
+use std::path::Path;
+
+use flate2::read::MultiGzDecoder;
+
+/// The first two bytes of every gzip stream.
+const GZIP_MAGIC: [u8; 2] = [0x1f, 0x8b];
+
+/// Most bytes [`read_to_string`] decompresses a file to. Search only reads
+/// files a build accepted under its `max_file_size`, so this just guards
+/// against one having been replaced by a zip bomb since.
+const MAX_READ_SIZE: u64 = 256 * 1_048_576;
+
+/// Why [`decompress`] returned no content.
+#[derive(Debug)]
+pub enum GzipError {
+    /// The file decompresses to more than the size limit.
+    TooLarge,
+    /// The file is named `.gz` or starts like gzip but isn't a valid gzip
+    /// stream: corrupt or truncated.
+    Corrupt(io::Error),
+}
+
+/// Whether `bytes`, read from `path`, should be decompressed: they start
+/// with gzip's magic number, or the name ends in `.gz`.
+pub fn is_gzip(path: &Path, bytes: &[u8]) -> bool {
+    bytes.starts_with(&GZIP_MAGIC) || has_gz_extension(path)
+}
+
+/// `path` without a `.gz` extension — the name of the file a gzip file
+/// holds, which language detection goes by (`server.go.gz` is Go).
+pub fn logical_path(path: &Path) -> Cow<'_, Path> {
+    if has_gz_extension(path) {
+        Cow::Owned(path.with_extension(""))
+    } else {
+        Cow::Borrowed(path)
+    }
+}
+
+fn has_gz_extension(path: &Path) -> bool {
+    path.extension()
+        .is_some_and(|ext| ext.eq_ignore_ascii_case("gz"))
+}
+
+/// The content of the file at `path`, given its `bytes` as read:
+/// decompressed if it is gzip (see [`is_gzip`]), as is otherwise.
+/// Concatenated gzip members, as `cat a.gz b.gz` or log rotation leave
+/// them, decompress to the files joined.
+///
+/// At most `max_size` decompressed bytes are produced, so a small file
+/// that inflates hugely fails with [`GzipError::TooLarge`] before it can
+/// fill memory.
+pub fn decompress(path: &Path, bytes: Vec<u8>, max_size: u64) -> Result<Vec<u8>, GzipError> {
+    if !is_gzip(path, &bytes) {
+        return Ok(bytes);
+    }
+    let mut content = Vec::new();
+    MultiGzDecoder::new(bytes.as_slice())
+        .take(max_size.saturating_add(1))
+        .read_to_end(&mut content)
+        .map_err(GzipError::Corrupt)?;
+    if content.len() as u64 > max_size {
+        return Err(GzipError::TooLarge);
+    }
+    Ok(content)
+}
+
+/// Reads the bytes of the file at `path` like `fs::read`, decompressing
+/// gzip files: the content a build indexed, for search-time scans.
+pub fn read(path: impl AsRef<Path>) -> io::Result<Vec<u8>> {
+    let path = path.as_ref();
+    match decompress(path, fs::read(path)?, MAX_READ_SIZE) {
+        Ok(content) => Ok(content),
+        Err(GzipError::Corrupt(err)) => Err(err),
+        Err(GzipError::TooLarge) => Err(io::Error::new(
+            io::ErrorKind::InvalidData,
+            "decompresses too large",
+        )),
+    }
+}
+
+/// Reads the file at `path` as UTF-8 text like `fs::read_to_string`,
+/// decompressing gzip files, for displaying files at search time.
+pub fn read_to_string(path: impl AsRef<Path>) -> io::Result<String> {
+    String::from_utf8(read(path)?).map_err(|err| io::Error::new(io::ErrorKind::InvalidData, err))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use flate2::write::GzEncoder;
+    use flate2::Compression;
+    use std::io::Write;
+
+    fn gzip(content: &[u8]) -> Vec<u8> {
+        let mut encoder = GzEncoder::new(Vec::new(), Compression::default());
+        encoder.write_all(content).unwrap();
+        encoder.finish().unwrap()
+    }
+
+    #[test]
+    fn gzip_is_decompressed_by_magic_or_name() {
+        let packed = gzip(b"package main\n");
+        for name in ["main.go.gz", "main.go"] {
+            let content = decompress(Path::new(name), packed.clone(), 1024).unwrap();
+            assert_eq!(content, b"package main\n", "{}", name);
+        }
+        let plain = decompress(Path::new("main.go"), b"plain".to_vec(), 1024).unwrap();
+        assert_eq!(plain, b"plain");
+
+        let mut joined = gzip(b"one\n");
+        joined.extend(gzip(b"two\n"));
+        let content = decompress(Path::new("app.log.gz"), joined, 1024).unwrap();
+        assert_eq!(content, b"one\ntwo\n");
+    }
+
+    #[test]
+    fn oversized_and_corrupt_gzip_fail() {
+        let bomb = gzip(&vec![b'a'; 1_000_000]);
+        assert!(bomb.len() < 10_000);
+        let result = decompress(Path::new("bomb.gz"), bomb.clone(), 999_999);
+        assert!(matches!(result, Err(GzipError::TooLarge)));
+        assert!(decompress(Path::new("bomb.gz"), bomb, 1_000_000).is_ok());
+
+        let mut truncated = gzip(b"package main\nfunc main() {}\n");
+        truncated.truncate(truncated.len() / 2);
+        for bytes in [truncated, b"not gzip".to_vec()] {
+            let result = decompress(Path::new("main.go.gz"), bytes, 1024);
+            assert!(matches!(result, Err(GzipError::Corrupt(_))));
+        }
+    }
+
+    #[test]
+    fn logical_path_drops_the_gz_extension() {
+        assert_eq!(
+            logical_path(Path::new("src/server.go.gz")),
+            Path::new("src/server.go")
+        );
+        assert_eq!(
+            logical_path(Path::new("logs/app.GZ")),
+            Path::new("logs/app")
+        );
+        assert_eq!(
+            logical_path(Path::new("src/server.go")),
+            Path::new("src/server.go")
+        );
+    }
+}
@@ -1,21 +1,36 @@
-use std::collections::HashSet;
+use std::collections::{BTreeMap, HashMap, HashSet};
 use std::fs;
 use std::path::Path;
 use std::time::Instant;
 
-use tantivy::schema::Value;
+use tantivy::collector::Count;
+use tantivy::query::TermQuery;
+use tantivy::schema::{Field, IndexRecordOption, Schema, Value};
 use tantivy::{IndexWriter, ReloadPolicy, TantivyDocument, Term};
 
 use crate::error::NsError;
 use crate::schema::{
-    content_field, lang_field, path_field, symbols_field, symbols_raw_field,
+    content_cased_field, content_field, doc_fields, lang_field, line_index_field, mtime_field,
+    path_field, path_text_field, simhash_field, symbols_field, symbols_raw_field,
+    token_class_field, token_offsets_field, trigrams_field,
 };
 
-use super::language::detect_language;
+use super::language::detect_language_with_content;
+use super::analyzer::Analyzer;
+use super::classes::{split_classes, TokenClass};
+use super::lines::LineIndex;
+use super::definitions::{read_definitions, write_definitions, Definitions};
+use super::fields::{read_field_values, write_field_values, DocField};
+use super::gzip::{decompress, logical_path};
+use super::manifest::{mtime_to_ns, read_manifest, write_manifest, Manifest, ManifestEntry};
+use super::metadata::{read_metadata, write_metadata};
+use super::offsets::OffsetIndex;
+use super::simhash::simhash;
 use super::symbols::extract_symbols;
-use super::walker::walk_repo;
+use super::trigrams::add_trigrams;
+use super::walker::{is_text, read_file, walk_paths_with_ignores, IgnoreRules, WalkedFile};
 use super::writer::{
-    dir_size, get_git_commit, open_index, utc_timestamp_iso8601, IndexMeta,
+    add_field_values, dir_size, get_git_commit, open_index, utc_timestamp_iso8601, IndexMeta,
     SCHEMA_VERSION,
 };
 
@@ -24,6 +39,8 @@ pub struct IncrementalStats {
     pub added: usize,
     pub modified: usize,
     pub deleted: usize,
+    /// Files that moved to a new path with identical content.
+    pub renamed: usize,
     pub elapsed_ms: u64,
 }
 
@@ -36,35 +53,60 @@ struct ChangeSet {
 
 /// Runs an incremental index update on the repository at `root`.
 ///
-/// 1. Opens the existing index and reads meta.json
+/// 1. Opens the existing index and reads meta.json + manifest.json
 /// 2. Detects changes (git-based or mtime-based fallback)
-/// 3. Deletes documents for deleted/modified files
-/// 4. Re-indexes modified and added files
-/// 5. Commits and updates meta.json
+/// 3. Drops "modified" files whose content hash is unchanged, and pairs
+///    deleted/added files with identical content as renames
+/// 4. Deletes documents for deleted/modified/renamed files
+/// 5. Re-indexes modified, renamed and added files
+/// 6. Commits and updates meta.json + manifest.json (and definitions.json
+///    for a `--definitions` index); metadata.json and fields.json entries
+///    follow deleted and renamed files
 pub fn run_incremental(
     root: &Path,
     max_file_size: u64,
 ) -> Result<IncrementalStats, NsError> {
     let (index, meta) = open_index(root)?;
+    let mut manifest = read_manifest(root).unwrap_or_default();
 
-    let changes = detect_changes(root, &meta, &index, max_file_size)?;
+    let mut changes = detect_changes(root, &meta, &index, &manifest, max_file_size)?;
+    // Only include files a full build would index (exist, not binary, etc.)
+    filter_changeset(root, &mut changes, max_file_size, meta.skip_binary);
+    apply_ignore_rules(root, &mut changes, &meta.ignore_patterns);
 
-    let total_changes = changes.added.len() + changes.modified.len() + changes.deleted.len();
+    // Touched-but-identical files: refresh their stat in the manifest so the
+    // next run doesn't hash them again, but don't re-tokenize.
+    let unchanged = drop_unchanged_content(root, &mut changes.modified, &manifest);
+    let renamed = pair_renames(root, &mut changes, &manifest);
+
+    let total_changes =
+        changes.added.len() + changes.modified.len() + changes.deleted.len() + renamed.len();
     if total_changes == 0 {
+        if !unchanged.is_empty() {
+            manifest.files.extend(unchanged);
+            write_manifest(root, &manifest)?;
+        }
         return Ok(IncrementalStats {
             added: 0,
             modified: 0,
             deleted: 0,
+            renamed: 0,
             elapsed_ms: 0,
         });
     }
 
     let schema = index.schema();
-    let content_f = content_field(&schema);
-    let symbols_f = symbols_field(&schema);
-    let symbols_raw_f = symbols_raw_field(&schema);
     let path_f = path_field(&schema);
-    let lang_f = lang_field(&schema);
+    let analyzer = meta.content_analyzer()?;
+    let builder = DocBuilder::new(&schema, &meta, &analyzer, max_file_size);
+
+    // Field values follow their files, and are read back for re-indexed ones.
+    let mut field_values = read_field_values(root)?;
+    let deleted: HashSet<&str> = changes.deleted.iter().map(String::as_str).collect();
+    let mut values_changed = field_values.retain(|rel_path| !deleted.contains(rel_path));
+    for (old_path, new_path) in &renamed {
+        values_changed |= field_values.rename(old_path, new_path);
+    }
 
     let mut writer: IndexWriter = index.writer(50_000_000)?;
 
@@ -78,14 +120,22 @@ pub fn run_incremental(
     // Delete then re-index modified files
     for rel_path in &changes.modified {
         writer.delete_term(Term::from_field_text(path_f, rel_path));
-        if let Some(doc) = build_document(root, rel_path, content_f, symbols_f, symbols_raw_f, path_f, lang_f) {
+        if let Some(doc) = builder.build(root, rel_path, field_values.get(rel_path)) {
+            writer.add_document(doc)?;
+        }
+    }
+
+    // Renamed files: drop the old path, index under the new one
+    for (old_path, new_path) in &renamed {
+        writer.delete_term(Term::from_field_text(path_f, old_path));
+        if let Some(doc) = builder.build(root, new_path, field_values.get(new_path)) {
             writer.add_document(doc)?;
         }
     }
 
     // Index added files
     for rel_path in &changes.added {
-        if let Some(doc) = build_document(root, rel_path, content_f, symbols_f, symbols_raw_f, path_f, lang_f) {
+        if let Some(doc) = builder.build(root, rel_path, field_values.get(rel_path)) {
             writer.add_document(doc)?;
         }
     }
@@ -120,16 +170,81 @@ pub fn run_incremental(
         git_commit,
         file_count,
         index_size_bytes: index_size,
+        ignore_patterns: meta.ignore_patterns.clone(),
+        stop_words: meta.stop_words.clone(),
+        stemmer: meta.stemmer.clone(),
+        analyzer: meta.analyzer.clone(),
+        definitions: meta.definitions,
+        case_sensitive: meta.case_sensitive,
+        skip_binary: meta.skip_binary,
+        track_lines: meta.track_lines,
+        track_offsets: meta.track_offsets,
+        match_paths: meta.match_paths,
+        track_mtime: meta.track_mtime,
+        token_classes: meta.token_classes,
+        trigrams: meta.trigrams,
+        fields: meta.fields.clone(),
+        generation: meta.generation + 1,
     };
 
     let meta_path = root.join(".ns").join("meta.json");
     let meta_json = serde_json::to_string(&new_meta)?;
     fs::write(&meta_path, &meta_json)?;
 
+    // Update manifest.json to mirror what was just written to the index
+    manifest.files.extend(unchanged);
+    for rel_path in &changes.deleted {
+        manifest.files.remove(rel_path);
+    }
+    for (old_path, _) in &renamed {
+        manifest.files.remove(old_path);
+    }
+    let reindexed: Vec<&String> = changes
+        .added
+        .iter()
+        .chain(changes.modified.iter())
+        .chain(renamed.iter().map(|(_, new_path)| new_path))
+        .collect();
+    for rel_path in &reindexed {
+        if let Some(entry) = ManifestEntry::from_path(&root.join(rel_path)) {
+            manifest.files.insert((*rel_path).clone(), entry);
+        }
+    }
+    write_manifest(root, &manifest)?;
+
+    if meta.definitions {
+        // A missing file (deleted by hand) is rebuilt from scratch.
+        let mut defs = match read_definitions(root) {
+            Ok(defs) => defs,
+            Err(_) => Definitions::build(root, manifest.files.keys()),
+        };
+        let stale = changes.deleted.iter().chain(renamed.iter().map(|(old_path, _)| old_path));
+        for rel_path in stale {
+            defs.files.remove(rel_path);
+        }
+        for rel_path in &reindexed {
+            defs.refresh(root, rel_path);
+        }
+        write_definitions(root, &defs)?;
+    }
+
+    let mut metadata = read_metadata(root)?;
+    let mut metadata_changed = metadata.retain(|rel_path| !deleted.contains(rel_path));
+    for (old_path, new_path) in &renamed {
+        metadata_changed |= metadata.rename(old_path, new_path);
+    }
+    if metadata_changed {
+        write_metadata(root, &metadata)?;
+    }
+    if values_changed {
+        write_field_values(root, &field_values)?;
+    }
+
     let stats = IncrementalStats {
         added: changes.added.len(),
         modified: changes.modified.len(),
         deleted: changes.deleted.len(),
+        renamed: renamed.len(),
         elapsed_ms,
     };
 
@@ -167,6 +282,7 @@ fn detect_changes(
     root: &Path,
     meta: &IndexMeta,
     index: &tantivy::Index,
+    manifest: &Manifest,
     max_file_size: u64,
 ) -> Result<ChangeSet, NsError> {
     // Try git-based detection first
@@ -175,18 +291,16 @@ fn detect_changes(
             let indexed_paths = get_indexed_paths(index)?;
             if *old_commit == current_commit {
                 // Same commit — check for uncommitted changes via working tree diff
-                return detect_changes_git_uncommitted(
-                    root, max_file_size, &indexed_paths, &meta.indexed_at,
-                );
+                return detect_changes_git_uncommitted(root, &indexed_paths, &meta.indexed_at);
             }
             return detect_changes_git(
-                root, old_commit, &current_commit, max_file_size, &indexed_paths, &meta.indexed_at,
+                root, old_commit, &current_commit, &indexed_paths, &meta.indexed_at,
             );
         }
     }
 
     // Fallback: mtime-based detection
-    detect_changes_mtime(root, meta, index, max_file_size)
+    detect_changes_mtime(root, meta, index, manifest, max_file_size)
 }
 
 /// Detects changes using `git diff --name-status` between two commits,
@@ -195,7 +309,6 @@ fn detect_changes_git(
     root: &Path,
     old_commit: &str,
     current_commit: &str,
-    max_file_size: u64,
     indexed_paths: &HashSet<String>,
     indexed_at: &str,
 ) -> Result<ChangeSet, NsError> {
@@ -203,15 +316,11 @@ fn detect_changes_git(
     let mut changes = parse_git_diff(root, old_commit, current_commit)?;
 
     // Also check for uncommitted working tree changes (staged + unstaged)
-    let working_changes =
-        detect_changes_git_uncommitted(root, max_file_size, indexed_paths, indexed_at)?;
+    let working_changes = detect_changes_git_uncommitted(root, indexed_paths, indexed_at)?;
 
     // Merge working tree changes into committed changes
     merge_changesets(&mut changes, working_changes);
 
-    // Filter: only include files that would actually be walked (exist, not binary, etc.)
-    filter_changeset(root, &mut changes, max_file_size);
-
     Ok(changes)
 }
 
@@ -223,7 +332,6 @@ fn detect_changes_git(
 /// insertion on repeated incremental runs.
 fn detect_changes_git_uncommitted(
     root: &Path,
-    max_file_size: u64,
     indexed_paths: &HashSet<String>,
     indexed_at: &str,
 ) -> Result<ChangeSet, NsError> {
@@ -280,8 +388,6 @@ fn detect_changes_git_uncommitted(
         }
     }
 
-    filter_changeset(root, &mut changes, max_file_size);
-
     Ok(changes)
 }
 
@@ -380,8 +486,9 @@ fn merge_changesets(base: &mut ChangeSet, other: ChangeSet) {
 }
 
 /// Filters a changeset to remove paths that shouldn't be indexed
-/// (e.g., .ns/ directory, .git/, files that no longer exist for added/modified).
-fn filter_changeset(root: &Path, changes: &mut ChangeSet, max_file_size: u64) {
+/// (e.g., .ns/ directory, .git/, files that no longer exist for added/modified,
+/// and binary files unless the index was built with `skip_binary` off).
+fn filter_changeset(root: &Path, changes: &mut ChangeSet, max_file_size: u64, skip_binary: bool) {
     let should_skip = |path: &str| -> bool {
         path.starts_with(".ns/")
             || path.starts_with(".ns\\")
@@ -406,20 +513,11 @@ fn filter_changeset(root: &Path, changes: &mut ChangeSet, max_file_size: u64) {
                 return false;
             }
         }
-        // Check for binary content (null bytes in first 512 bytes)
-        if let Ok(raw) = fs::read(&abs_path) {
-            let check_len = raw.len().min(512);
-            if raw[..check_len].contains(&0) {
-                return false;
-            }
-            // UTF-8 check
-            if String::from_utf8(raw).is_err() {
-                return false;
-            }
-        } else {
-            return false;
-        }
-        true
+        // Same content checks as the full build's reader
+        fs::read(&abs_path)
+            .ok()
+            .and_then(|raw| decompress(&abs_path, raw, max_file_size).ok())
+            .is_some_and(|content| is_text(&content, skip_binary))
     };
 
     changes.added.retain(|p| is_indexable(p));
@@ -427,19 +525,41 @@ fn filter_changeset(root: &Path, changes: &mut ChangeSet, max_file_size: u64) {
     changes.deleted.retain(|p| !should_skip(p));
 }
 
-/// Detects changes using file mtime comparison against `meta.indexed_at`.
+/// Drops ignored paths from `changes`. A modified file that is now ignored
+/// (e.g. a pattern was added to `.nsignore`) becomes a deletion.
+fn apply_ignore_rules(root: &Path, changes: &mut ChangeSet, ignore_patterns: &[String]) {
+    let mut rules = IgnoreRules::new(root, ignore_patterns);
+    changes.added.retain(|p| !rules.is_ignored(p));
+
+    let (ignored, kept): (Vec<String>, Vec<String>) =
+        changes.modified.drain(..).partition(|p| rules.is_ignored(p));
+    changes.modified = kept;
+    for path in ignored {
+        if !changes.deleted.contains(&path) {
+            changes.deleted.push(path);
+        }
+    }
+}
+
+/// Detects changes by comparing each file's mtime and size against its
+/// manifest entry, falling back to `meta.indexed_at` for files the manifest
+/// doesn't know about (e.g. an index built before manifests existed).
 ///
 /// Used when git is not available or git_commit is not set.
 fn detect_changes_mtime(
     root: &Path,
     meta: &IndexMeta,
     index: &tantivy::Index,
+    manifest: &Manifest,
     max_file_size: u64,
 ) -> Result<ChangeSet, NsError> {
     let indexed_at = parse_iso8601_to_system_time(&meta.indexed_at);
 
     // Walk all current files
-    let current_files = walk_repo(root, max_file_size);
+    let current_files: Vec<WalkedFile> = walk_paths_with_ignores(root, max_file_size, &[])
+        .iter()
+        .filter_map(|walked| read_file(walked, meta.skip_binary, max_file_size).ok())
+        .collect();
     let current_paths: HashSet<String> = current_files
         .iter()
         .map(|f| f.rel_path.clone())
@@ -456,6 +576,13 @@ fn detect_changes_mtime(
     for file in &current_files {
         if !indexed_paths.contains(&file.rel_path) {
             added.push(file.rel_path.clone());
+        } else if let Some(entry) = manifest.files.get(&file.rel_path) {
+            let abs_path = root.join(&file.rel_path);
+            if let Ok(file_meta) = abs_path.metadata() {
+                if !entry.matches_stat(&file_meta) {
+                    modified.push(file.rel_path.clone());
+                }
+            }
         } else if let Some(ref indexed_time) = indexed_at {
             let abs_path = root.join(&file.rel_path);
             if let Ok(file_meta) = abs_path.metadata() {
@@ -478,37 +605,294 @@ fn detect_changes_mtime(
     Ok(ChangeSet { added, modified, deleted })
 }
 
-/// Builds a tantivy document for a single file.
+/// Removes paths from `modified` whose current content hash matches the
+/// manifest — the file was touched (mtime bump, checkout) but not changed.
 ///
-/// Returns `None` if the file cannot be read or is not indexable.
-fn build_document(
+/// Returns fresh manifest entries for the removed paths.
+fn drop_unchanged_content(
+    root: &Path,
+    modified: &mut Vec<String>,
+    manifest: &Manifest,
+) -> Vec<(String, ManifestEntry)> {
+    let mut unchanged = Vec::new();
+    modified.retain(|rel_path| {
+        let Some(old) = manifest.files.get(rel_path) else {
+            return true;
+        };
+        match ManifestEntry::from_path(&root.join(rel_path)) {
+            Some(current) if current.hash == old.hash && current.size == old.size => {
+                unchanged.push((rel_path.clone(), current));
+                false
+            }
+            _ => true,
+        }
+    });
+    unchanged
+}
+
+/// Pairs deleted and added paths whose content is identical (same hash and
+/// size) and removes them from `changes`.
+///
+/// Returns `(old_path, new_path)` pairs. A deleted path must be in the
+/// manifest to be paired; each path pairs at most once.
+fn pair_renames(
+    root: &Path,
+    changes: &mut ChangeSet,
+    manifest: &Manifest,
+) -> Vec<(String, String)> {
+    if changes.deleted.is_empty() || changes.added.is_empty() {
+        return Vec::new();
+    }
+
+    let mut added_by_content: HashMap<(u64, u64), Vec<String>> = HashMap::new();
+    for rel_path in &changes.added {
+        if let Some(entry) = ManifestEntry::from_path(&root.join(rel_path)) {
+            added_by_content
+                .entry((entry.hash, entry.size))
+                .or_default()
+                .push(rel_path.clone());
+        }
+    }
+
+    let mut renamed = Vec::new();
+    changes.deleted.retain(|old_path| {
+        let Some(entry) = manifest.files.get(old_path) else {
+            return true;
+        };
+        match added_by_content.get_mut(&(entry.hash, entry.size)).and_then(|v| v.pop()) {
+            Some(new_path) => {
+                renamed.push((old_path.clone(), new_path));
+                false
+            }
+            None => true,
+        }
+    });
+
+    let renamed_to: HashSet<&String> = renamed.iter().map(|(_, new_path)| new_path).collect();
+    changes.added.retain(|p| !renamed_to.contains(p));
+    renamed
+}
+
+/// The field handles of an index's documents, and which of its optional
+/// fields to fill: `content_cased` in case-sensitive indexes, `line_index`
+/// and `token_offsets` (positions as the `content` analyzer numbers them)
+/// when it tracks lines or offsets, `mtime` when it tracks mtimes, the token
+/// class fields and `trigrams` when it has them, and its declared fields.
+/// Gzip files are decompressed up to `max_file_size`, as full builds do.
+struct DocBuilder<'a> {
+    content_fs: Vec<Field>,
+    symbols_f: Field,
+    symbols_raw_f: Field,
+    path_fs: [Field; 2],
+    lang_f: Field,
+    simhash_f: Field,
+    lines: Option<(Field, &'a Analyzer)>,
+    offsets: Option<(Field, &'a Analyzer)>,
+    mtime_f: Option<Field>,
+    class_fs: Option<[Field; 3]>,
+    trigrams_f: Option<Field>,
+    field_fs: Vec<Field>,
+    fields: &'a [DocField],
+    max_file_size: u64,
+}
+
+impl<'a> DocBuilder<'a> {
+    fn new(
+        schema: &Schema,
+        meta: &'a IndexMeta,
+        analyzer: &'a Analyzer,
+        max_file_size: u64,
+    ) -> Self {
+        let mut content_fs = vec![content_field(schema)];
+        if meta.case_sensitive {
+            content_fs.push(content_cased_field(schema));
+        }
+        Self {
+            content_fs,
+            symbols_f: symbols_field(schema),
+            symbols_raw_f: symbols_raw_field(schema),
+            path_fs: [path_field(schema), path_text_field(schema)],
+            lang_f: lang_field(schema),
+            simhash_f: simhash_field(schema),
+            lines: meta.track_lines.then(|| (line_index_field(schema), analyzer)),
+            offsets: meta.track_offsets.then(|| (token_offsets_field(schema), analyzer)),
+            mtime_f: meta.track_mtime.then(|| mtime_field(schema)),
+            class_fs: meta
+                .token_classes
+                .then(|| TokenClass::ALL.map(|class| token_class_field(schema, class))),
+            trigrams_f: meta.trigrams.then(|| trigrams_field(schema)),
+            field_fs: doc_fields(schema, &meta.fields),
+            fields: &meta.fields,
+            max_file_size,
+        }
+    }
+
+    /// Builds the document of the file at `rel_path`, with `values` for
+    /// its declared fields.
+    ///
+    /// Returns `None` if the file cannot be read or is not indexable.
+    fn build(
+        &self,
+        root: &Path,
+        rel_path: &str,
+        values: Option<&BTreeMap<String, String>>,
+    ) -> Option<TantivyDocument> {
+        let abs_path = root.join(rel_path);
+        let raw = fs::read(&abs_path).ok()?;
+        let content = decompress(&abs_path, raw, self.max_file_size).ok()?;
+        let content = String::from_utf8(content).ok()?;
+        let lang = detect_language_with_content(&logical_path(&abs_path), &content)
+            .map(|s| s.to_string());
+
+        let symbol_names = lang
+            .as_deref()
+            .map(|l| extract_symbols(l, content.as_bytes()))
+            .unwrap_or_default();
+
+        let mut doc = TantivyDocument::new();
+        for &content_f in &self.content_fs {
+            doc.add_text(content_f, &content);
+        }
+        doc.add_text(self.symbols_f, &symbol_names.join(" "));
+        doc.add_text(self.symbols_raw_f, &symbol_names.join("|"));
+        for &path_f in &self.path_fs {
+            doc.add_text(path_f, rel_path);
+        }
+        if let Some(ref lang_str) = lang {
+            doc.add_text(self.lang_f, lang_str);
+        }
+        doc.add_u64(self.simhash_f, simhash(&content));
+        if let Some((line_index_f, analyzer)) = self.lines {
+            let line_index = LineIndex::build(&content, &mut analyzer.position_analyzer());
+            doc.add_bytes(line_index_f, line_index.encode().as_slice());
+        }
+        if let Some((offsets_f, analyzer)) = self.offsets {
+            let offsets = OffsetIndex::build(&content, &mut analyzer.position_analyzer());
+            doc.add_bytes(offsets_f, offsets.encode().as_slice());
+        }
+        if let Some(mtime_f) = self.mtime_f {
+            let mtime = abs_path.metadata().ok().and_then(|m| m.modified().ok());
+            doc.add_u64(mtime_f, mtime_to_ns(mtime));
+        }
+        let classes = self
+            .class_fs
+            .zip(lang.as_deref().and_then(|l| split_classes(l, &content)));
+        if let Some((class_fs, classes)) = classes {
+            for (class_f, class) in class_fs.into_iter().zip(TokenClass::ALL) {
+                doc.add_text(class_f, classes.get(class));
+            }
+        }
+        if let Some(trigrams_f) = self.trigrams_f {
+            add_trigrams(&mut doc, trigrams_f, &content);
+        }
+        if let Some(values) = values {
+            add_field_values(&mut doc, &self.field_fs, self.fields, values);
+        }
+
+        Some(doc)
+    }
+}
+
+/// Re-indexes the file at `rel_path`, which the index at `root` must
+/// already hold, with the field `values` it is about to be given. Bumps
+/// the index generation.
+pub(crate) fn reindex_file(
     root: &Path,
     rel_path: &str,
-    content_f: tantivy::schema::Field,
-    symbols_f: tantivy::schema::Field,
-    symbols_raw_f: tantivy::schema::Field,
-    path_f: tantivy::schema::Field,
-    lang_f: tantivy::schema::Field,
-) -> Option<TantivyDocument> {
-    let abs_path = root.join(rel_path);
-    let content = fs::read_to_string(&abs_path).ok()?;
-    let lang = detect_language(&abs_path).map(|s| s.to_string());
-
-    let symbol_names = lang
-        .as_deref()
-        .map(|l| extract_symbols(l, content.as_bytes()))
-        .unwrap_or_default();
-
-    let mut doc = TantivyDocument::new();
-    doc.add_text(content_f, &content);
-    doc.add_text(symbols_f, &symbol_names.join(" "));
-    doc.add_text(symbols_raw_f, &symbol_names.join("|"));
-    doc.add_text(path_f, rel_path);
-    if let Some(ref lang_str) = lang {
-        doc.add_text(lang_f, lang_str);
-    }
-
-    Some(doc)
+    values: &BTreeMap<String, String>,
+    max_file_size: u64,
+) -> Result<(), NsError> {
+    let (index, mut meta) = open_index(root)?;
+    let analyzer = meta.content_analyzer()?;
+    let schema = index.schema();
+    let doc = DocBuilder::new(&schema, &meta, &analyzer, max_file_size)
+        .build(root, rel_path, Some(values))
+        .ok_or_else(|| not_indexed(rel_path))?;
+
+    let mut writer: IndexWriter = index.writer(50_000_000)?;
+    writer.delete_term(Term::from_field_text(path_field(&schema), rel_path));
+    writer.add_document(doc)?;
+    writer.commit()?;
+    writer.wait_merging_threads()?;
+
+    meta.generation += 1;
+    fs::write(root.join(".ns").join("meta.json"), serde_json::to_string(&meta)?)?;
+    Ok(())
+}
+
+/// Removes the document of `rel_path` from the index at `root`, along
+/// with its manifest, metadata, field value and definition entries, then
+/// merges every segment holding deleted documents so the removed file's
+/// terms and lengths leave the BM25 statistics too. Updates `file_count`
+/// and bumps the generation. Fails with [`not_indexed`] if the index
+/// doesn't hold the file, changing nothing.
+pub(crate) fn remove_file(root: &Path, rel_path: &str) -> Result<(), NsError> {
+    let (index, meta) = open_index(root)?;
+    let term = Term::from_field_text(path_field(&index.schema()), rel_path);
+    let reader = index
+        .reader_builder()
+        .reload_policy(ReloadPolicy::Manual)
+        .try_into()?;
+    let query = TermQuery::new(term.clone(), IndexRecordOption::Basic);
+    if reader.searcher().search(&query, &Count)? == 0 {
+        return Err(not_indexed(rel_path));
+    }
+
+    let mut writer: IndexWriter = index.writer(50_000_000)?;
+    writer.delete_term(term);
+    writer.commit()?;
+    // Deleted documents keep counting in document frequencies and average
+    // field lengths until their segment is rewritten without them.
+    reader.reload()?;
+    let searcher = reader.searcher();
+    let with_deletes: Vec<_> = searcher
+        .segment_readers()
+        .iter()
+        .filter(|segment| segment.num_deleted_docs() > 0)
+        .map(|segment| segment.segment_id())
+        .collect();
+    if !with_deletes.is_empty() {
+        writer.merge(&with_deletes).wait()?;
+    }
+    writer.wait_merging_threads()?;
+
+    let mut manifest = read_manifest(root).unwrap_or_default();
+    if manifest.files.remove(rel_path).is_some() {
+        write_manifest(root, &manifest)?;
+    }
+    let mut metadata = read_metadata(root)?;
+    if metadata.retain(|path| path != rel_path) {
+        write_metadata(root, &metadata)?;
+    }
+    let mut field_values = read_field_values(root)?;
+    if field_values.retain(|path| path != rel_path) {
+        write_field_values(root, &field_values)?;
+    }
+    if meta.definitions {
+        if let Ok(mut defs) = read_definitions(root) {
+            if defs.files.remove(rel_path).is_some() {
+                write_definitions(root, &defs)?;
+            }
+        }
+    }
+
+    reader.reload()?;
+    let new_meta = IndexMeta {
+        file_count: reader.searcher().num_docs() as usize,
+        index_size_bytes: dir_size(&root.join(".ns").join("index")),
+        generation: meta.generation + 1,
+        ..meta
+    };
+    fs::write(root.join(".ns").join("meta.json"), serde_json::to_string(&new_meta)?)?;
+    Ok(())
+}
+
+/// The error for a path the index doesn't hold.
+pub(crate) fn not_indexed(rel_path: &str) -> NsError {
+    NsError::Io(std::io::Error::new(
+        std::io::ErrorKind::NotFound,
+        format!("{} is not indexed", rel_path),
+    ))
 }
 
 /// Parses an ISO 8601 timestamp string to SystemTime.
@@ -1,7 +1,9 @@
 use std::path::Path;
 
 /// Maps a file extension to a language identifier.
-/// Returns `None` for unsupported languages (content-only indexing, no symbols).
+/// Returns `None` for unknown extensions. Only some languages get symbol
+/// extraction (see `symbols.rs`); the rest are content-only but can still be
+/// filtered with `-t`.
 pub fn detect_language(path: &Path) -> Option<&'static str> {
     match path.extension()?.to_str()? {
         "rs" => Some("rust"),
@@ -10,6 +12,37 @@ pub fn detect_language(path: &Path) -> Option<&'static str> {
         "js" | "jsx" | "mjs" | "cjs" => Some("javascript"),
         "ts" | "tsx" | "mts" | "cts" => Some("typescript"),
         "ex" | "exs" => Some("elixir"),
+        "md" | "markdown" => Some("markdown"),
+        "sh" | "bash" | "zsh" => Some("shell"),
+        _ => None,
+    }
+}
+
+/// Like [`detect_language`], falling back to the `#!` line of `content` for
+/// files without a known extension (`bin/deploy` starting `#!/bin/bash`).
+pub fn detect_language_with_content(path: &Path, content: &str) -> Option<&'static str> {
+    detect_language(path).or_else(|| detect_shebang(content))
+}
+
+/// Maps a shebang interpreter to a language identifier.
+///
+/// Handles both `#!/bin/bash` and `#!/usr/bin/env bash` forms, including
+/// versioned interpreters (`python3.12`) and `env -S` flags.
+fn detect_shebang(content: &str) -> Option<&'static str> {
+    let line = content.lines().next()?.strip_prefix("#!")?;
+    let mut words = line.split_whitespace();
+    let mut program = words.next()?.rsplit('/').next()?;
+    if program == "env" {
+        program = words.find(|w| !w.starts_with('-'))?;
+    }
+
+    let name = program.trim_end_matches(|c: char| c.is_ascii_digit() || c == '.');
+    match name {
+        "sh" | "bash" | "zsh" | "dash" | "ksh" => Some("shell"),
+        "python" => Some("python"),
+        "node" | "nodejs" => Some("javascript"),
+        "deno" | "ts-node" => Some("typescript"),
+        "elixir" => Some("elixir"),
         _ => None,
     }
 }
@@ -30,11 +63,12 @@ mod tests {
         assert_eq!(detect_language(Path::new("qux.jsx")), Some("javascript"));
         assert_eq!(detect_language(Path::new("app.ex")), Some("elixir"));
         assert_eq!(detect_language(Path::new("test_helper.exs")), Some("elixir"));
+        assert_eq!(detect_language(Path::new("readme.md")), Some("markdown"));
+        assert_eq!(detect_language(Path::new("install.sh")), Some("shell"));
     }
 
     #[test]
     fn unknown_extensions() {
-        assert_eq!(detect_language(Path::new("readme.md")), None);
         assert_eq!(detect_language(Path::new("config.json")), None);
         assert_eq!(detect_language(Path::new("Makefile")), None);
         assert_eq!(detect_language(Path::new(".gitignore")), None);
@@ -45,4 +79,24 @@ mod tests {
         assert_eq!(detect_language(&PathBuf::from("Makefile")), None);
         assert_eq!(detect_language(&PathBuf::from("LICENSE")), None);
     }
+
+    #[test]
+    fn shebang_fallback() {
+        let detect = |content| detect_language_with_content(Path::new("bin/tool"), content);
+        assert_eq!(detect("#!/bin/bash\necho hi\n"), Some("shell"));
+        assert_eq!(detect("#!/usr/bin/env bash\n"), Some("shell"));
+        assert_eq!(detect("#!/bin/sh -e\n"), Some("shell"));
+        assert_eq!(detect("#!/usr/bin/env python3\n"), Some("python"));
+        assert_eq!(detect("#!/usr/bin/python3.12\n"), Some("python"));
+        assert_eq!(detect("#!/usr/bin/env -S node --no-warnings\n"), Some("javascript"));
+        assert_eq!(detect("#!/usr/bin/perl\n"), None);
+        assert_eq!(detect("echo no shebang\n"), None);
+        assert_eq!(detect(""), None);
+    }
+
+    #[test]
+    fn extension_wins_over_shebang() {
+        let content = "#!/usr/bin/env python3\n";
+        assert_eq!(detect_language_with_content(Path::new("run.sh"), content), Some("shell"));
+    }
 }
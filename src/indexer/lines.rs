@@ -0,0 +1,131 @@
+use tantivy::tokenizer::{TextAnalyzer, TokenStream};
+
+/// Maps the token positions of a file's `content` postings to 1-based line
+/// numbers, stored per document in `line_index` by `--track-lines` builds
+/// so matched lines come straight from the index.
+///
+/// Lines end at `\n`, as in `str::lines` (which context extraction uses):
+/// a `\r\n` ending is one break, so files with mixed endings number lines
+/// the same way, and a last line without a newline is still a line.
+///
+/// Holds one `(position, line)` pair per line that has tokens — the
+/// position of its first token — since identifiers never span lines.
+#[derive(Debug, Default, PartialEq, Eq)]
+pub struct LineIndex {
+    starts: Vec<(u32, u32)>,
+}
+
+impl LineIndex {
+    /// Indexes `content` as `tokenizer` — the `content` analyzer's
+    /// tokenizer, `Analyzer::position_analyzer` — numbers its tokens.
+    /// Filters drop tokens without renumbering the rest, so positions agree
+    /// with the analyzer's whatever its filters.
+    pub fn build(content: &str, tokenizer: &mut TextAnalyzer) -> Self {
+        let mut stream = tokenizer.token_stream(content);
+        let mut starts: Vec<(u32, u32)> = Vec::new();
+        let (mut line, mut scanned) = (1u32, 0);
+        while stream.advance() {
+            let token = stream.token();
+            line += content.as_bytes()[scanned..token.offset_from]
+                .iter()
+                .filter(|&&b| b == b'\n')
+                .count() as u32;
+            scanned = token.offset_from;
+            if starts.last().is_none_or(|&(_, last)| last != line) {
+                starts.push((token.position as u32, line));
+            }
+        }
+        Self { starts }
+    }
+
+    /// The 1-based line of the token at `position`.
+    pub fn line(&self, position: u32) -> usize {
+        let i = self.starts.partition_point(|&(start, _)| start <= position);
+        i.checked_sub(1).map_or(1, |i| self.starts[i].1 as usize)
+    }
+
+    /// The stored form: each pair as varint deltas from the previous one.
+    pub fn encode(&self) -> Vec<u8> {
+        let mut out = Vec::with_capacity(self.starts.len() * 2);
+        let mut prev = (0, 0);
+        for &(position, line) in &self.starts {
+            write_varint(&mut out, position - prev.0);
+            write_varint(&mut out, line - prev.1);
+            prev = (position, line);
+        }
+        out
+    }
+
+    /// Reads back [`LineIndex::encode`]; `None` if `bytes` is truncated.
+    pub fn decode(bytes: &[u8]) -> Option<Self> {
+        let mut starts = Vec::new();
+        let mut prev = (0u32, 0u32);
+        let mut rest = bytes;
+        while !rest.is_empty() {
+            let position = prev.0.checked_add(read_varint(&mut rest)?)?;
+            let line = prev.1.checked_add(read_varint(&mut rest)?)?;
+            prev = (position, line);
+            starts.push(prev);
+        }
+        Some(Self { starts })
+    }
+}
+
+pub(super) fn write_varint(out: &mut Vec<u8>, mut value: u32) {
+    while value >= 0x80 {
+        out.push(value as u8 | 0x80);
+        value >>= 7;
+    }
+    out.push(value as u8);
+}
+
+pub(super) fn read_varint(bytes: &mut &[u8]) -> Option<u32> {
+    let mut value = 0u32;
+    for shift in (0..35).step_by(7) {
+        let (&byte, rest) = bytes.split_first()?;
+        *bytes = rest;
+        value |= u32::from(byte & 0x7f).checked_shl(shift)?;
+        if byte < 0x80 {
+            return Some(value);
+        }
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::indexer::analyzer::Analyzer;
+
+    /// The line of every token of `content`, in position order.
+    fn token_lines(content: &str) -> Vec<usize> {
+        let mut tokenizer = Analyzer::default().position_analyzer();
+        let index = LineIndex::build(content, &mut tokenizer);
+        let index = LineIndex::decode(&index.encode()).unwrap();
+        let mut stream = tokenizer.token_stream(content);
+        let mut lines = Vec::new();
+        while stream.advance() {
+            lines.push(index.line(stream.token().position as u32));
+        }
+        lines
+    }
+
+    #[test]
+    fn counts_lines_like_str_lines() {
+        assert_eq!(token_lines("one\ntwo\n\nfour"), vec![1, 2, 4]);
+        assert_eq!(token_lines("one\r\ntwo\r\n\r\nfour\r\n"), vec![1, 2, 4]);
+        assert_eq!(token_lines("one\r\ntwo\nthree\r\n\nfive"), vec![1, 2, 3, 5]);
+        // Every part of an identifier is on the identifier's line.
+        assert_eq!(token_lines("\n  readTimeout()\nok"), vec![2, 2, 2, 3]);
+    }
+
+    #[test]
+    fn encoding_round_trips() {
+        let content = (0..500).map(|i| format!("word{} x\n", i)).collect::<String>();
+        let index = LineIndex::build(&content, &mut Analyzer::default().position_analyzer());
+        assert_eq!(LineIndex::decode(&index.encode()), Some(index));
+        assert_eq!(LineIndex::decode(&[]), Some(LineIndex::default()));
+        assert_eq!(LineIndex::decode(&[0x80]), None);
+        assert_eq!(LineIndex::default().line(7), 1);
+    }
+}
@@ -0,0 +1,145 @@
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::Path;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use serde::{Deserialize, Serialize};
+
+use crate::error::NsError;
+
+/// Fingerprint of a single indexed file, recorded at index time.
+///
+/// `mtime_ns` + `size` are a cheap "maybe changed" check; `hash` is the
+/// authoritative "content changed" check, so a touched-but-identical file
+/// is not re-tokenized.
+#[derive(Serialize, Deserialize, Debug, Clone, PartialEq)]
+pub struct ManifestEntry {
+    /// Modification time in nanoseconds since the Unix epoch (0 if unavailable).
+    pub mtime_ns: u64,
+    /// File size in bytes.
+    pub size: u64,
+    /// FNV-1a 64-bit hash of the file content.
+    pub hash: u64,
+}
+
+/// Contents of `.ns/manifest.json`: one entry per indexed file, keyed by
+/// path relative to the repo root.
+///
+/// A `BTreeMap` keeps the on-disk file sorted, so two builds of the same
+/// tree produce the same manifest.
+#[derive(Serialize, Deserialize, Debug, Default)]
+pub struct Manifest {
+    pub files: BTreeMap<String, ManifestEntry>,
+}
+
+impl ManifestEntry {
+    /// Builds an entry from already-read file content.
+    pub fn new(content: &[u8], mtime: Option<SystemTime>) -> Self {
+        Self {
+            mtime_ns: mtime_to_ns(mtime),
+            size: content.len() as u64,
+            hash: content_hash(content),
+        }
+    }
+
+    /// Reads the file at `abs_path` and builds an entry for it.
+    ///
+    /// Returns `None` if the file cannot be read.
+    pub fn from_path(abs_path: &Path) -> Option<Self> {
+        let raw = fs::read(abs_path).ok()?;
+        let mtime = abs_path.metadata().ok().and_then(|m| m.modified().ok());
+        Some(Self::new(&raw, mtime))
+    }
+
+    /// Returns `true` if `meta` has the same size and mtime as this entry.
+    pub fn matches_stat(&self, meta: &fs::Metadata) -> bool {
+        self.size == meta.len() && self.mtime_ns == mtime_to_ns(meta.modified().ok())
+    }
+}
+
+/// Reads `.ns/manifest.json`.
+///
+/// Returns `None` if the manifest is missing or unreadable — e.g. an index
+/// built before manifests existed. Callers fall back to `indexed_at`.
+pub fn read_manifest(root: &Path) -> Option<Manifest> {
+    let path = root.join(".ns").join("manifest.json");
+    fs::read_to_string(path)
+        .ok()
+        .and_then(|content| serde_json::from_str(&content).ok())
+}
+
+/// Writes `.ns/manifest.json`.
+pub fn write_manifest(root: &Path, manifest: &Manifest) -> Result<(), NsError> {
+    let path = root.join(".ns").join("manifest.json");
+    let json = serde_json::to_string(manifest)?;
+    fs::write(path, json)?;
+    Ok(())
+}
+
+/// FNV-1a 64-bit hash — stable across Rust releases (unlike `DefaultHasher`)
+/// and fast enough that hashing does not dominate indexing time.
+pub fn content_hash(bytes: &[u8]) -> u64 {
+    let mut hash: u64 = 0xcbf2_9ce4_8422_2325;
+    for &b in bytes {
+        hash ^= b as u64;
+        hash = hash.wrapping_mul(0x0000_0100_0000_01b3);
+    }
+    hash
+}
+
+pub(crate) fn mtime_to_ns(mtime: Option<SystemTime>) -> u64 {
+    mtime
+        .and_then(|t| t.duration_since(UNIX_EPOCH).ok())
+        .map(|d| d.as_nanos() as u64)
+        .unwrap_or(0)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn content_hash_is_stable() {
+        // Reference values for FNV-1a 64.
+        assert_eq!(content_hash(b""), 0xcbf2_9ce4_8422_2325);
+        assert_eq!(content_hash(b"a"), 0xaf63_dc4c_8601_ec8c);
+        assert_ne!(content_hash(b"foo"), content_hash(b"bar"));
+    }
+
+    #[test]
+    fn manifest_round_trip() {
+        let dir = tempfile::tempdir().unwrap();
+        let root = dir.path();
+        fs::create_dir_all(root.join(".ns")).unwrap();
+
+        let mut manifest = Manifest::default();
+        manifest
+            .files
+            .insert("src/a.rs".to_string(), ManifestEntry::new(b"fn a() {}", None));
+        write_manifest(root, &manifest).unwrap();
+
+        let read = read_manifest(root).expect("manifest should be readable");
+        assert_eq!(read.files.len(), 1);
+        assert_eq!(read.files["src/a.rs"], manifest.files["src/a.rs"]);
+    }
+
+    #[test]
+    fn missing_manifest_is_none() {
+        let dir = tempfile::tempdir().unwrap();
+        assert!(read_manifest(dir.path()).is_none());
+    }
+
+    #[test]
+    fn entry_matches_own_stat() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("f.txt");
+        fs::write(&path, "hello").unwrap();
+
+        let entry = ManifestEntry::from_path(&path).unwrap();
+        assert_eq!(entry.size, 5);
+        assert!(entry.matches_stat(&path.metadata().unwrap()));
+
+        fs::write(&path, "hello world").unwrap();
+        assert!(!entry.matches_stat(&path.metadata().unwrap()));
+    }
+}
@@ -0,0 +1,303 @@
+use std::collections::HashSet;
+use std::fs;
+use std::path::Path;
+
+use tantivy::directory::MmapDirectory;
+use tantivy::schema::Value;
+use tantivy::{Index, ReloadPolicy, TantivyDocument};
+
+use crate::error::NsError;
+use crate::schema::path_field;
+
+use super::definitions::{read_definitions, remove_definitions, write_definitions, Definitions};
+use super::fields::{read_field_values, write_field_values, FieldValues};
+use super::manifest::{read_manifest, write_manifest, Manifest};
+use super::metadata::{read_metadata, write_metadata, Metadata};
+use super::writer::{
+    dir_size, get_git_commit, open_index, read_meta, utc_timestamp_iso8601, IndexMeta,
+    SCHEMA_VERSION,
+};
+
+/// Stats returned by [`merge_indexes`].
+#[derive(Debug)]
+pub struct MergeStats {
+    /// Files in the merged index, summed over the shards.
+    pub file_count: usize,
+    /// Shards merged.
+    pub shard_count: usize,
+}
+
+/// Merges the indexes built at each of `shards` (repo roots with a `.ns/`
+/// directory) into one index at `dst/.ns/`, replacing any index there.
+///
+/// Shards are built separately — in parallel, one per top-level directory
+/// — with paths relative to the same repo root: `index_source` over a
+/// source listing part of the repo, or `build_index` with part of a walk.
+/// Their segments are merged by tantivy: term dictionaries are unioned,
+/// documents renumbered after those of earlier shards and postings
+/// rewritten in the new numbering. BM25 statistics (document frequencies,
+/// average field lengths) are read from the merged segment at search time,
+/// so searching the result ranks exactly like one index built over every
+/// shard's files.
+///
+/// `manifest.json`, `metadata.json`, `fields.json` and `definitions.json`
+/// are combined too, so incremental updates at `dst` pick up from the
+/// merge. All shards must be built with the same options that shape the
+/// index — stop words, stemmer, analyzer, `--case-sensitive`,
+/// `--track-lines`, `--track-offsets`, `--definitions`, `--include-binary`,
+/// `--match-paths`, `--track-mtime`, `--token-classes`, `--trigrams`,
+/// `--field` — or this
+/// fails with [`NsError::IncompatibleIndexes`];
+/// a path indexed in two shards fails with [`NsError::DuplicateShardPath`].
+/// Ignore patterns of all shards are kept.
+///
+/// As with full builds, the index is merged in `.ns/index.new/` and only
+/// replaces `.ns/index/` once complete. Returns `None`, leaving `dst`
+/// alone, if `shards` is empty.
+pub fn merge_indexes(dst: &Path, shards: &[&Path]) -> Result<Option<MergeStats>, NsError> {
+    let Some((first_root, _)) = shards.split_first() else {
+        return Ok(None);
+    };
+    let first = read_meta(first_root)?;
+
+    let mut indexes = Vec::with_capacity(shards.len());
+    let mut shard_paths = Vec::with_capacity(shards.len());
+    let mut all_paths: HashSet<String> = HashSet::new();
+    let mut ignore_patterns: Vec<String> = Vec::new();
+    for root in shards {
+        let (index, meta) = open_index(root)?;
+        check_compatible(&first, &meta)?;
+        let paths = live_paths(&index)?;
+        for path in &paths {
+            if !all_paths.insert(path.clone()) {
+                return Err(NsError::DuplicateShardPath(path.clone()));
+            }
+        }
+        shard_paths.push(paths);
+        for pattern in meta.ignore_patterns {
+            if !ignore_patterns.contains(&pattern) {
+                ignore_patterns.push(pattern);
+            }
+        }
+        indexes.push(index);
+    }
+
+    // Per-file records, each taken from the shard that indexed the file.
+    let mut manifest = Manifest::default();
+    let mut metadata = Metadata::default();
+    let mut field_values = FieldValues::default();
+    let mut definitions = Definitions::default();
+    for (root, paths) in shards.iter().zip(&shard_paths) {
+        let owned = |path: &String| paths.contains(path);
+        if let Some(shard_manifest) = read_manifest(root) {
+            manifest
+                .files
+                .extend(shard_manifest.files.into_iter().filter(|(p, _)| owned(p)));
+        }
+        let shard_metadata = read_metadata(root)?;
+        let shard_values = read_field_values(root)?;
+        for path in paths {
+            metadata.set(path, &shard_metadata.get(path));
+            if let Some(values) = shard_values.get(path) {
+                field_values.set(path, values);
+            }
+        }
+        if first.definitions {
+            let shard_definitions = read_definitions(root)?;
+            definitions.files.extend(
+                shard_definitions
+                    .files
+                    .into_iter()
+                    .filter(|(p, _)| owned(p)),
+            );
+        }
+    }
+
+    let ns_dir = dst.join(".ns");
+    let index_dir = ns_dir.join("index");
+    let staging_dir = ns_dir.join("index.new");
+    if staging_dir.exists() {
+        fs::remove_dir_all(&staging_dir)?;
+    }
+    fs::create_dir_all(&staging_dir)?;
+    let merged = MmapDirectory::open(&staging_dir)
+        .map_err(tantivy::TantivyError::from)
+        .and_then(|dir| tantivy::merge_indices(&indexes, dir));
+    if let Err(e) = merged {
+        let _ = fs::remove_dir_all(&staging_dir);
+        return Err(e.into());
+    }
+    drop(indexes);
+    if index_dir.exists() {
+        fs::remove_dir_all(&index_dir)?;
+    }
+    fs::rename(&staging_dir, &index_dir)?;
+
+    let meta = IndexMeta {
+        schema_version: SCHEMA_VERSION,
+        indexed_at: utc_timestamp_iso8601(),
+        git_commit: get_git_commit(dst),
+        file_count: all_paths.len(),
+        index_size_bytes: dir_size(&index_dir),
+        ignore_patterns,
+        stop_words: first.stop_words,
+        stemmer: first.stemmer,
+        analyzer: first.analyzer,
+        definitions: first.definitions,
+        case_sensitive: first.case_sensitive,
+        skip_binary: first.skip_binary,
+        track_lines: first.track_lines,
+        track_offsets: first.track_offsets,
+        match_paths: first.match_paths,
+        track_mtime: first.track_mtime,
+        token_classes: first.token_classes,
+        trigrams: first.trigrams,
+        fields: first.fields.clone(),
+        generation: read_meta(dst).map_or(0, |m| m.generation) + 1,
+    };
+    let meta_json = serde_json::to_string(&meta)?;
+    fs::write(ns_dir.join("meta.json"), &meta_json)?;
+
+    write_manifest(dst, &manifest)?;
+    write_metadata(dst, &metadata)?;
+    write_field_values(dst, &field_values)?;
+    if meta.definitions {
+        write_definitions(dst, &definitions)?;
+    } else {
+        remove_definitions(dst)?;
+    }
+
+    Ok(Some(MergeStats {
+        file_count: meta.file_count,
+        shard_count: shards.len(),
+    }))
+}
+
+/// Fails if `other` was built with options that make its documents
+/// tokenized or filled differently from those of `first`.
+fn check_compatible(first: &IndexMeta, other: &IndexMeta) -> Result<(), NsError> {
+    let mismatch = |option| Err(NsError::IncompatibleIndexes { option });
+    let sorted = |words: &[String]| {
+        let mut words = words.to_vec();
+        words.sort();
+        words
+    };
+    if sorted(&first.stop_words) != sorted(&other.stop_words) {
+        return mismatch("stop words");
+    }
+    if first.stemmer != other.stemmer {
+        return mismatch("stemmers");
+    }
+    if first.content_analyzer()? != other.content_analyzer()? {
+        return mismatch("analyzers");
+    }
+    if first.case_sensitive != other.case_sensitive {
+        return mismatch("--case-sensitive");
+    }
+    if first.track_lines != other.track_lines {
+        return mismatch("--track-lines");
+    }
+    if first.track_offsets != other.track_offsets {
+        return mismatch("--track-offsets");
+    }
+    if first.definitions != other.definitions {
+        return mismatch("--definitions");
+    }
+    if first.skip_binary != other.skip_binary {
+        return mismatch("--include-binary");
+    }
+    if first.match_paths != other.match_paths {
+        return mismatch("--match-paths");
+    }
+    if first.track_mtime != other.track_mtime {
+        return mismatch("--track-mtime");
+    }
+    if first.token_classes != other.token_classes {
+        return mismatch("--token-classes");
+    }
+    if first.trigrams != other.trigrams {
+        return mismatch("--trigrams");
+    }
+    if first.fields != other.fields {
+        return mismatch("fields");
+    }
+    Ok(())
+}
+
+/// The paths of the documents in `index` that aren't deleted.
+fn live_paths(index: &Index) -> Result<HashSet<String>, NsError> {
+    let reader = index
+        .reader_builder()
+        .reload_policy(ReloadPolicy::Manual)
+        .try_into()?;
+    let searcher = reader.searcher();
+    let path_f = path_field(&index.schema());
+
+    let mut paths = HashSet::new();
+    for segment_reader in searcher.segment_readers() {
+        let store_reader = segment_reader.get_store_reader(1)?;
+        for doc_id in segment_reader.doc_ids_alive() {
+            let doc = store_reader.get::<TantivyDocument>(doc_id)?;
+            if let Some(path) = doc.get_first(path_f).and_then(|v| v.as_str()) {
+                paths.insert(path.to_string());
+            }
+        }
+    }
+    Ok(paths)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn meta() -> IndexMeta {
+        IndexMeta {
+            schema_version: SCHEMA_VERSION,
+            indexed_at: String::new(),
+            git_commit: None,
+            file_count: 0,
+            index_size_bytes: 0,
+            ignore_patterns: Vec::new(),
+            stop_words: vec!["the".to_string(), "a".to_string()],
+            stemmer: None,
+            analyzer: None,
+            definitions: false,
+            case_sensitive: false,
+            skip_binary: true,
+            track_lines: false,
+            track_offsets: false,
+            match_paths: false,
+            track_mtime: false,
+            token_classes: false,
+            trigrams: false,
+            fields: Vec::new(),
+            generation: 0,
+        }
+    }
+
+    #[test]
+    fn options_that_shape_the_index_must_match() {
+        let reordered = IndexMeta {
+            stop_words: vec!["a".to_string(), "the".to_string()],
+            ignore_patterns: vec!["vendor/".to_string()],
+            file_count: 12,
+            ..meta()
+        };
+        assert!(check_compatible(&meta(), &reordered).is_ok());
+
+        let stemmed = IndexMeta {
+            stemmer: Some("english".to_string()),
+            ..meta()
+        };
+        let cased = IndexMeta {
+            case_sensitive: true,
+            ..meta()
+        };
+        for (other, option) in [(stemmed, "stemmers"), (cased, "--case-sensitive")] {
+            match check_compatible(&meta(), &other) {
+                Err(NsError::IncompatibleIndexes { option: found }) => assert_eq!(found, option),
+                other => panic!("expected a mismatch on {}, got {:?}", option, other),
+            }
+        }
+    }
+}
@@ -0,0 +1,151 @@
+use std::collections::{BTreeMap, HashMap};
+use std::fs;
+use std::path::Path;
+
+use serde::{Deserialize, Serialize};
+
+use crate::error::NsError;
+
+/// Contents of `.ns/metadata.json`: caller-supplied key/value pairs for
+/// indexed files (see [`super::index_with_meta`]), keyed by path relative
+/// to the repo root. Files without metadata are omitted.
+///
+/// Values are interned: each distinct value (an owning team, a commit) is
+/// stored once in `values`, and files refer to it by position.
+#[derive(Serialize, Deserialize, Debug, Default)]
+pub struct Metadata {
+    values: Vec<String>,
+    files: BTreeMap<String, BTreeMap<String, usize>>,
+    /// Position of each value in `values`, rebuilt on read.
+    #[serde(skip)]
+    positions: HashMap<String, usize>,
+}
+
+impl Metadata {
+    /// The metadata recorded for `rel_path`, empty if there is none.
+    pub fn get(&self, rel_path: &str) -> BTreeMap<String, String> {
+        self.files
+            .get(rel_path)
+            .map(|entry| {
+                entry
+                    .iter()
+                    .map(|(key, &value)| (key.clone(), self.values[value].clone()))
+                    .collect()
+            })
+            .unwrap_or_default()
+    }
+
+    /// Replaces the metadata of `rel_path` with `meta`; an empty map
+    /// removes it.
+    pub fn set(&mut self, rel_path: &str, meta: &BTreeMap<String, String>) {
+        if meta.is_empty() {
+            self.files.remove(rel_path);
+            return;
+        }
+        let entry = meta
+            .iter()
+            .map(|(key, value)| (key.clone(), self.intern(value)))
+            .collect();
+        self.files.insert(rel_path.to_string(), entry);
+    }
+
+    /// Moves the metadata of `old_path` to `new_path`, for a renamed file.
+    /// Returns whether `old_path` had any.
+    pub fn rename(&mut self, old_path: &str, new_path: &str) -> bool {
+        match self.files.remove(old_path) {
+            Some(entry) => {
+                self.files.insert(new_path.to_string(), entry);
+                true
+            }
+            None => false,
+        }
+    }
+
+    /// Keeps only the files for which `keep` returns true. Returns whether
+    /// any were dropped.
+    pub fn retain(&mut self, mut keep: impl FnMut(&str) -> bool) -> bool {
+        let before = self.files.len();
+        self.files.retain(|path, _| keep(path));
+        self.files.len() != before
+    }
+
+    fn intern(&mut self, value: &str) -> usize {
+        if let Some(&pos) = self.positions.get(value) {
+            return pos;
+        }
+        self.values.push(value.to_string());
+        self.positions.insert(value.to_string(), self.values.len() - 1);
+        self.values.len() - 1
+    }
+
+    /// A copy holding only the values still referenced by some file.
+    fn compacted(&self) -> Self {
+        let mut out = Self::default();
+        for (path, entry) in &self.files {
+            let entry = entry
+                .iter()
+                .map(|(key, &value)| (key.clone(), out.intern(&self.values[value])))
+                .collect();
+            out.files.insert(path.clone(), entry);
+        }
+        out
+    }
+}
+
+/// Reads `.ns/metadata.json`, or empty metadata if there is none.
+pub fn read_metadata(root: &Path) -> Result<Metadata, NsError> {
+    let path = root.join(".ns").join("metadata.json");
+    let content = match fs::read_to_string(path) {
+        Ok(content) => content,
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => return Ok(Metadata::default()),
+        Err(e) => return Err(e.into()),
+    };
+    let mut metadata: Metadata = serde_json::from_str(&content)?;
+    metadata.positions = metadata
+        .values
+        .iter()
+        .enumerate()
+        .map(|(pos, value)| (value.clone(), pos))
+        .collect();
+    Ok(metadata)
+}
+
+/// Writes `.ns/metadata.json`, dropping values no file refers to any more.
+pub fn write_metadata(root: &Path, metadata: &Metadata) -> Result<(), NsError> {
+    let path = root.join(".ns").join("metadata.json");
+    let json = serde_json::to_string(&metadata.compacted())?;
+    fs::write(path, json)?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn owner(team: &str) -> BTreeMap<String, String> {
+        BTreeMap::from([("owner".to_string(), team.to_string())])
+    }
+
+    #[test]
+    fn values_are_interned_and_compacted_on_write() {
+        let dir = tempfile::tempdir().unwrap();
+        fs::create_dir(dir.path().join(".ns")).unwrap();
+
+        let mut metadata = Metadata::default();
+        metadata.set("a.rs", &owner("payments"));
+        metadata.set("b.rs", &owner("payments"));
+        metadata.set("c.rs", &owner("search"));
+        assert_eq!(metadata.values, vec!["payments", "search"]);
+
+        metadata.set("c.rs", &BTreeMap::new());
+        metadata.rename("b.rs", "d.rs");
+        write_metadata(dir.path(), &metadata).unwrap();
+
+        let read = read_metadata(dir.path()).unwrap();
+        assert_eq!(read.values, vec!["payments"]);
+        assert_eq!(read.get("a.rs"), owner("payments"));
+        assert_eq!(read.get("d.rs"), owner("payments"));
+        assert!(read.get("b.rs").is_empty());
+        assert!(read.get("c.rs").is_empty());
+    }
+}
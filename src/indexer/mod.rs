@@ -1,26 +1,193 @@
+pub mod analyzer;
+pub mod classes;
+pub mod definitions;
+pub mod fields;
+pub mod gzip;
 pub mod incremental;
 pub mod language;
+pub mod lines;
+pub mod manifest;
+pub mod merge;
+pub mod metadata;
+pub mod offsets;
+pub mod pipeline;
+pub mod progress;
+pub mod simhash;
+pub mod source;
+pub mod stopwords;
 pub mod symbols;
+pub mod tokenizer;
+pub mod trigrams;
 pub mod walker;
+pub mod watch;
 pub mod writer;
 
+use std::collections::BTreeMap;
+use std::fs;
 use std::path::Path;
 
+use crate::cancel::CancelToken;
 use crate::error::NsError;
 use incremental::{run_incremental, IncrementalStats};
-use walker::walk_repo;
+use metadata::{read_metadata, write_metadata};
+use source::{DiskSource, FileSource};
 use writer::{build_index, FullIndexStats};
 
+/// Options for a full index build — maps 1:1 to `ns index` flags.
+#[derive(Debug)]
+pub struct IndexOptions {
+    /// Files larger than this (in bytes) are skipped.
+    pub max_file_size: u64,
+    /// Extra gitignore-style patterns, on top of `.gitignore` / `.nsignore`.
+    /// Recorded in `meta.json` so incremental updates apply them too.
+    pub ignore_patterns: Vec<String>,
+    /// Worker threads that read and parse files. 0 means one per CPU.
+    pub threads: usize,
+    /// Upper bound on bytes of file content read but not yet indexed.
+    pub max_in_flight_bytes: u64,
+    /// Lowercased words dropped from `content` and `symbols` at index and
+    /// query time. Recorded in `meta.json`. Empty disables stop words.
+    pub stop_words: Vec<String>,
+    /// Stemming for `content` at index and query time, added to the default
+    /// pipeline. Recorded in `meta.json`. Ignored if `analyzer` is set.
+    pub stemming: tokenizer::Stemming,
+    /// The `content` pipeline, in place of the default one stemmed with
+    /// `stemming`. Recorded in `meta.json` and used for queries too.
+    pub analyzer: Option<analyzer::Analyzer>,
+    /// Also record symbol definition sites in `.ns/definitions.json`.
+    /// Recorded in `meta.json` so incremental updates maintain them.
+    pub definitions: bool,
+    /// Also index content with case preserved, for case-sensitive search.
+    /// Recorded in `meta.json` so incremental updates keep it.
+    pub case_sensitive: bool,
+    /// Skip files that look binary (see `walker::looks_binary`). Files that
+    /// aren't valid UTF-8 are always skipped. Recorded in `meta.json`.
+    pub skip_binary: bool,
+    /// Also record which line each `content` token is on (see
+    /// `lines::LineIndex`), filling `SearchResult::lines`. Recorded in
+    /// `meta.json` so incremental updates keep it.
+    pub track_lines: bool,
+    /// Also record the byte offset of each `content` token (see
+    /// `offsets::OffsetIndex`), for `SearchOptions::include_positions`.
+    /// Recorded in `meta.json` so incremental updates keep it.
+    pub track_offsets: bool,
+    /// Let plain query terms match the words of file paths (`path_text`),
+    /// not just rank by them, so `internal` finds every file under
+    /// `internal/`. Recorded in `meta.json`, since it changes what matches.
+    pub match_paths: bool,
+    /// Also store each file's mtime, so searches can sort by it
+    /// (`SortMode::ModTime`). Recorded in `meta.json` so incremental
+    /// updates keep it.
+    pub track_mtime: bool,
+    /// Also index the code, comments and string literals of Go files
+    /// apart (see `classes::split_classes`), so searches can be limited to
+    /// some of them (`SearchOptions::token_classes`). Recorded in
+    /// `meta.json` so incremental updates keep it.
+    pub token_classes: bool,
+    /// Also index the set of trigrams of each file's content (see
+    /// `trigrams::trigrams`), so `--regex` and substring searches only
+    /// scan files holding the trigrams a match needs. Recorded in
+    /// `meta.json` so incremental updates keep it.
+    pub trigrams: bool,
+    /// Named text fields beside the content, with their boosts, whose
+    /// values files get with [`index_fields`]. Recorded in `meta.json`.
+    pub fields: Vec<fields::DocField>,
+    /// Fail with `NsError::Unreadable` on the first file that can't be
+    /// read, instead of skipping it and listing it in
+    /// `FullIndexStats::unreadable`.
+    pub strict: bool,
+    /// Stops the build with `NsError::Cancelled` or `DeadlineExceeded`,
+    /// checked before each document; the previous index stays in place.
+    pub cancel: CancelToken,
+    /// Called with the files and bytes read so far as the build goes (see
+    /// [`progress::Progress`]). `None`, the default, costs nothing.
+    pub progress: Option<progress::Progress>,
+}
+
+impl Default for IndexOptions {
+    fn default() -> Self {
+        Self {
+            max_file_size: 1_048_576,
+            ignore_patterns: Vec::new(),
+            threads: 0,
+            max_in_flight_bytes: 64 * 1_048_576,
+            stop_words: stopwords::default_stop_words(),
+            stemming: tokenizer::Stemming::Noop,
+            analyzer: None,
+            definitions: false,
+            case_sensitive: false,
+            skip_binary: true,
+            track_lines: false,
+            track_offsets: false,
+            match_paths: false,
+            track_mtime: false,
+            token_classes: false,
+            trigrams: false,
+            fields: Vec::new(),
+            strict: false,
+            cancel: CancelToken::default(),
+            progress: None,
+        }
+    }
+}
+
+impl IndexOptions {
+    /// `threads`, with 0 resolved to the available parallelism.
+    pub fn worker_threads(&self) -> usize {
+        match self.threads {
+            0 => std::thread::available_parallelism().map_or(1, |n| n.get()),
+            n => n,
+        }
+    }
+
+    /// `analyzer`, or the default pipeline with `stemming`.
+    pub fn content_analyzer(&self) -> analyzer::Analyzer {
+        match self.analyzer {
+            Some(ref analyzer) => analyzer.clone(),
+            None => analyzer::Analyzer::with_stemming(self.stemming),
+        }
+    }
+}
+
 /// Runs a full (non-incremental) index of the repository at `root`.
 ///
 /// Returns `None` if no indexable files were found, or `Some(stats)` on success.
 /// Does not print to stderr — the CLI layer handles all output.
 pub fn run_full_index(root: &Path, max_file_size: u64) -> Result<Option<FullIndexStats>, NsError> {
-    let files = walk_repo(root, max_file_size);
-    if files.is_empty() {
-        return Ok(None);
-    }
-    build_index(root, &files).map(Some)
+    run_full_index_with_options(
+        root,
+        &IndexOptions {
+            max_file_size,
+            ..Default::default()
+        },
+    )
+}
+
+/// Like [`run_full_index`], with all build options.
+pub fn run_full_index_with_options(
+    root: &Path,
+    opts: &IndexOptions,
+) -> Result<Option<FullIndexStats>, NsError> {
+    index_source(root, &DiskSource::new(root), opts)
+}
+
+/// Runs a full index of the files in `source` — which need not be on disk
+/// (see [`source::MemorySource`]) — writing the index to `root/.ns/`.
+///
+/// Incremental updates, `--watch` and search context read files from
+/// disk at `root`, so for other sources, rebuild with this instead and
+/// expect no context lines unless the files also exist there.
+pub fn index_source(
+    root: &Path,
+    source: &dyn FileSource,
+    opts: &IndexOptions,
+) -> Result<Option<FullIndexStats>, NsError> {
+    let (paths, too_large) = source.walk(opts.max_file_size, &opts.ignore_patterns);
+    let stats = build_index(root, source, &paths, opts)?;
+    Ok(stats.map(|stats| FullIndexStats {
+        skipped_too_large: too_large + stats.skipped_too_large,
+        ..stats
+    }))
 }
 
 /// Runs an incremental index update on the repository at `root`.
@@ -28,9 +195,88 @@ pub fn run_full_index(root: &Path, max_file_size: u64) -> Result<Option<FullInde
 /// Requires an existing index (created by `run_full_index`).
 /// Detects changes via git diff (preferred) or mtime fallback,
 /// then applies adds/modifies/deletes to the existing index.
+/// Ignore patterns recorded at the last full build still apply.
 pub fn run_incremental_index(
     root: &Path,
     max_file_size: u64,
 ) -> Result<IncrementalStats, NsError> {
     run_incremental(root, max_file_size)
 }
+
+/// Removes the file at `rel_path` from the index at `root` without a
+/// rebuild — for a file deleted from disk, or one that should no longer be
+/// searched — dropping its metadata and field values with it.
+///
+/// Searches after this never return the file, and rank as if it had never
+/// been indexed: segments holding deleted documents are merged so their
+/// term counts and lengths leave the BM25 statistics. On a large index
+/// that rewrites most of it, so batch many removals into one
+/// [`run_incremental_index`] instead. Fails with a `NotFound` I/O error if
+/// the file isn't indexed.
+///
+/// Nothing records the removal, so a file left on disk comes back with the
+/// next full build. [`run_incremental_index`] adds it back too: without git,
+/// on the very next run, changed or not, since it is on disk but neither
+/// indexed nor in the manifest; in a git repo, once git reports it changed
+/// since the indexed commit. Remove it from disk, or ignore it (`.nsignore`
+/// or `--ignore`), to keep it out.
+pub fn remove_file(root: &Path, rel_path: &str) -> Result<(), NsError> {
+    incremental::remove_file(root, rel_path)
+}
+
+/// Brings the index at `root` up to date, as [`run_incremental_index`]
+/// does, then records `meta` for the file at `rel_path` in
+/// `.ns/metadata.json`, replacing any it had (an empty map clears it).
+///
+/// Search results for the file carry the metadata in
+/// `SearchResult::meta`. It follows the file through renames, is dropped
+/// when the file is deleted, and survives full rebuilds.
+pub fn index_with_meta(
+    root: &Path,
+    rel_path: &str,
+    meta: &BTreeMap<String, String>,
+    max_file_size: u64,
+) -> Result<IncrementalStats, NsError> {
+    let stats = run_incremental(root, max_file_size)?;
+    let mut metadata = read_metadata(root)?;
+    metadata.set(rel_path, meta);
+    write_metadata(root, &metadata)?;
+    // Results carry metadata, so searchers caching them must see a change.
+    let mut index_meta = writer::read_meta(root)?;
+    index_meta.generation += 1;
+    fs::write(root.join(".ns").join("meta.json"), serde_json::to_string(&index_meta)?)?;
+    Ok(stats)
+}
+
+/// Brings the index at `root` up to date, as [`run_incremental_index`]
+/// does, then sets the values of declared fields (`IndexOptions::fields`)
+/// for the file at `rel_path` — a title, a summary — replacing any it had
+/// (an empty map clears them), and re-indexes the file so searches match
+/// and rank by them.
+///
+/// Fails with [`NsError::UnknownField`] on a field the index wasn't built
+/// with, and with a `NotFound` I/O error if the file isn't indexed, either
+/// way before setting anything. Like metadata, the values are kept in
+/// `.ns/fields.json`, follow the file through renames, are dropped when
+/// it is deleted, and survive full rebuilds.
+pub fn index_fields(
+    root: &Path,
+    rel_path: &str,
+    values: &BTreeMap<String, String>,
+    max_file_size: u64,
+) -> Result<IncrementalStats, NsError> {
+    let declared = writer::read_meta(root)?.fields;
+    if let Some(name) = values.keys().find(|name| !declared.iter().any(|f| &&f.name == name)) {
+        return Err(NsError::UnknownField(name.clone()));
+    }
+    let stats = run_incremental(root, max_file_size)?;
+    if !manifest::read_manifest(root).is_some_and(|m| m.files.contains_key(rel_path)) {
+        return Err(incremental::not_indexed(rel_path));
+    }
+    // Re-index first, so a locked index is left with its old values.
+    incremental::reindex_file(root, rel_path, values, max_file_size)?;
+    let mut field_values = fields::read_field_values(root)?;
+    field_values.set(rel_path, values);
+    fields::write_field_values(root, &field_values)?;
+    Ok(stats)
+}
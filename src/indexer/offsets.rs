@@ -0,0 +1,110 @@
+use tantivy::tokenizer::{TextAnalyzer, TokenStream};
+
+use super::lines::{read_varint, write_varint};
+
+/// Maps the token positions of a file's `content` postings to the byte
+/// offsets where the tokens start, stored per document in `token_offsets`
+/// by `--track-offsets` builds so results can say where each query term
+/// occurs without reading the file again.
+///
+/// Offsets count bytes of the UTF-8 content, not characters, so an editor
+/// can seek straight to them whatever the text before. Holds the start of
+/// every position: positions are dense, and an identifier and its first
+/// part share both position and offset.
+#[derive(Debug, Default, PartialEq, Eq)]
+pub struct OffsetIndex {
+    starts: Vec<u32>,
+}
+
+impl OffsetIndex {
+    /// Indexes `content` as `tokenizer` — the `content` analyzer's
+    /// tokenizer, `Analyzer::position_analyzer` — numbers its tokens, as
+    /// with `LineIndex::build`.
+    pub fn build(content: &str, tokenizer: &mut TextAnalyzer) -> Self {
+        let mut stream = tokenizer.token_stream(content);
+        let mut starts: Vec<u32> = Vec::new();
+        while stream.advance() {
+            let token = stream.token();
+            let offset = token.offset_from as u32;
+            // A skipped position would have started where the next one does.
+            while starts.len() <= token.position {
+                starts.push(offset);
+            }
+        }
+        Self { starts }
+    }
+
+    /// The byte offset of the token at `position`, or `None` past the end.
+    pub fn offset(&self, position: u32) -> Option<usize> {
+        self.starts
+            .get(position as usize)
+            .map(|&start| start as usize)
+    }
+
+    /// The stored form: each offset as a varint delta from the previous one.
+    pub fn encode(&self) -> Vec<u8> {
+        let mut out = Vec::with_capacity(self.starts.len() * 2);
+        let mut prev = 0;
+        for &start in &self.starts {
+            write_varint(&mut out, start - prev);
+            prev = start;
+        }
+        out
+    }
+
+    /// Reads back [`OffsetIndex::encode`]; `None` if `bytes` is truncated.
+    pub fn decode(bytes: &[u8]) -> Option<Self> {
+        let mut starts = Vec::new();
+        let mut prev = 0u32;
+        let mut rest = bytes;
+        while !rest.is_empty() {
+            prev = prev.checked_add(read_varint(&mut rest)?)?;
+            starts.push(prev);
+        }
+        Some(Self { starts })
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::indexer::analyzer::Analyzer;
+
+    /// The text at the offset of every token of `content`, up to the
+    /// token's length, in position order.
+    fn token_texts(content: &str) -> Vec<String> {
+        let mut tokenizer = Analyzer::default().position_analyzer();
+        let index = OffsetIndex::build(content, &mut tokenizer);
+        let index = OffsetIndex::decode(&index.encode()).unwrap();
+        let mut stream = tokenizer.token_stream(content);
+        let mut texts = Vec::new();
+        while stream.advance() {
+            let token = stream.token();
+            let start = index.offset(token.position as u32).unwrap();
+            let len = token.offset_to - token.offset_from;
+            texts.push(content[start..start + len].to_string());
+        }
+        texts
+    }
+
+    #[test]
+    fn offsets_are_bytes_into_multi_byte_text() {
+        assert_eq!(token_texts("héllo wörld"), vec!["héllo", "wörld"]);
+        assert_eq!(
+            token_texts("// ключ: readTimeout"),
+            vec!["ключ", "readTimeout", "read", "Timeout"]
+        );
+    }
+
+    #[test]
+    fn encoding_round_trips() {
+        let content = (0..500)
+            .map(|i| format!("word{} ü\n", i))
+            .collect::<String>();
+        let index = OffsetIndex::build(&content, &mut Analyzer::default().position_analyzer());
+        assert_eq!(OffsetIndex::decode(&index.encode()), Some(index));
+        assert_eq!(OffsetIndex::decode(&[]), Some(OffsetIndex::default()));
+        assert_eq!(OffsetIndex::decode(&[0x80]), None);
+        assert_eq!(OffsetIndex::default().offset(0), None);
+    }
+}
@@ -0,0 +1,418 @@
+use std::collections::BTreeMap;
+use std::sync::mpsc;
+use std::sync::{Condvar, Mutex};
+
+use crate::error::{FileError, IndexErrors, NsError};
+
+use super::classes::{split_classes, ClassTexts};
+use super::gzip::{decompress, GzipError};
+use super::manifest::{mtime_to_ns, ManifestEntry};
+use super::progress::ProgressTracker;
+use super::source::FileSource;
+use super::symbols::extract_symbols;
+use super::walker::{decode_file, Skipped, WalkedFile, WalkedPath};
+use super::IndexOptions;
+
+/// A file read and parsed by a worker, ready to be added to the index.
+pub struct PreparedFile {
+    pub file: WalkedFile,
+    /// Symbol names extracted via tree-sitter (empty for unsupported languages).
+    pub symbols: Vec<String>,
+    /// The content split by token class, with `token_classes` (Go files only).
+    pub classes: Option<ClassTexts>,
+    pub manifest_entry: ManifestEntry,
+}
+
+/// Files [`prepare_files`] didn't pass to its sink.
+#[derive(Debug, Default)]
+pub struct Skips {
+    /// Binary-looking (with `skip_binary`) or non-UTF-8 files.
+    pub binary: usize,
+    /// Gzip files that decompress to more than `max_file_size`.
+    pub too_large: usize,
+    /// Files `source` failed to read.
+    pub unreadable: IndexErrors,
+}
+
+/// Why [`prepare`] returned no file.
+enum Unprepared {
+    Skipped(Skipped),
+    Unreadable(std::io::Error),
+}
+
+/// Reads `paths` from `source` and parses them on `opts.threads` workers,
+/// passing each result to `sink` on the calling thread **in `paths`
+/// order** — so a parallel build adds documents exactly as a serial one
+/// would.
+///
+/// Reads are bounded by `opts.max_in_flight_bytes`: a worker waits before
+/// reading a file that would push the bytes being read, parsed or waiting
+/// for `sink` over the limit. A single file larger than the limit is still
+/// read, alone.
+///
+/// Gzip files are decompressed first (see `gzip::decompress`), and
+/// skipped if that takes them over `opts.max_file_size`. Non-UTF-8 files
+/// are skipped, as are binary-looking ones with `opts.skip_binary` (see
+/// `walker::decode_file`), and so are unreadable and corrupt gzip files,
+/// each listed with its error — unless `opts.strict`, where the
+/// first one fails like a `sink` error, with [`NsError::Unreadable`]. The
+/// first error stops workers from starting new files; files already in
+/// flight are drained (not passed to `sink`) before the error is returned.
+///
+/// With `opts.token_classes`, workers also split Go files into their code,
+/// comments and strings (see `classes::split_classes`). `opts.progress` is
+/// told of each file once `sink` had it or it was skipped.
+pub fn prepare_files<F>(
+    source: &dyn FileSource,
+    paths: &[WalkedPath],
+    opts: &IndexOptions,
+    mut sink: F,
+) -> Result<Skips, NsError>
+where
+    F: FnMut(PreparedFile) -> Result<(), NsError>,
+{
+    let mut progress = opts.progress.as_ref().map(|progress| {
+        let bytes_total = paths.iter().map(|p| p.size).sum();
+        ProgressTracker::new(progress, paths.len(), bytes_total)
+    });
+    let budget = ByteBudget::new(opts.max_in_flight_bytes);
+    let (tx, rx) = mpsc::channel::<(usize, Result<PreparedFile, Unprepared>)>();
+    let mut first_err = None;
+    let mut skips = Skips::default();
+
+    std::thread::scope(|scope| {
+        for _ in 0..opts.worker_threads() {
+            let tx = tx.clone();
+            let budget = &budget;
+            scope.spawn(move || {
+                let _guard = CancelOnPanic(budget);
+                while let Some(i) = budget.claim(paths) {
+                    if tx.send((i, prepare(source, &paths[i], opts))).is_err() {
+                        break;
+                    }
+                }
+            });
+        }
+        drop(tx);
+
+        // Workers claim files in order, so the next file in sequence is
+        // always being worked on; out-of-order results wait here.
+        let mut pending = BTreeMap::new();
+        let mut next = 0;
+        for (i, prepared) in rx {
+            pending.insert(i, prepared);
+            while let Some(prepared) = pending.remove(&next) {
+                match (prepared, &first_err) {
+                    (Ok(prepared), None) => {
+                        if let Err(err) = sink(prepared) {
+                            first_err = Some(err);
+                            budget.cancel();
+                        }
+                    }
+                    (Err(Unprepared::Skipped(Skipped::Binary)), _) => skips.binary += 1,
+                    (Err(Unprepared::Skipped(Skipped::TooLarge)), _) => skips.too_large += 1,
+                    (Err(Unprepared::Unreadable(error)), None) => {
+                        let file = FileError {
+                            path: paths[next].rel_path.clone(),
+                            error,
+                        };
+                        if opts.strict {
+                            first_err = Some(NsError::Unreadable(file));
+                            budget.cancel();
+                        } else {
+                            skips.unreadable.files.push(file);
+                        }
+                    }
+                    _ => {}
+                }
+                budget.release(paths[next].size);
+                if let (Some(progress), None) = (&mut progress, &first_err) {
+                    progress.file_done(&paths[next].rel_path, paths[next].size);
+                }
+                next += 1;
+            }
+        }
+    });
+
+    match first_err {
+        Some(err) => Err(err),
+        None => {
+            if let Some(ref mut progress) = progress {
+                progress.finish();
+            }
+            Ok(skips)
+        }
+    }
+}
+
+/// Reads one file and extracts its symbols, and its token classes with
+/// `opts.token_classes`. The manifest entry is of the file as stored, so
+/// incremental runs compare gzip files by their compressed bytes.
+fn prepare(
+    source: &dyn FileSource,
+    walked: &WalkedPath,
+    opts: &IndexOptions,
+) -> Result<PreparedFile, Unprepared> {
+    let raw = source.read(walked).map_err(Unprepared::Unreadable)?;
+    let stored = ManifestEntry::new(&raw, None);
+    let raw = decompress(&walked.path, raw, opts.max_file_size).map_err(|err| match err {
+        GzipError::TooLarge => Unprepared::Skipped(Skipped::TooLarge),
+        GzipError::Corrupt(err) => Unprepared::Unreadable(err),
+    })?;
+    let file = decode_file(walked, raw, opts.skip_binary).map_err(Unprepared::Skipped)?;
+    let symbols = file
+        .lang
+        .as_deref()
+        .map(|l| extract_symbols(l, file.content.as_bytes()))
+        .unwrap_or_default();
+    let classes = match file.lang.as_deref() {
+        Some(lang) if opts.token_classes => split_classes(lang, &file.content),
+        _ => None,
+    };
+    let manifest_entry = ManifestEntry {
+        mtime_ns: mtime_to_ns(file.mtime),
+        ..stored
+    };
+    Ok(PreparedFile {
+        file,
+        symbols,
+        classes,
+        manifest_entry,
+    })
+}
+
+/// Hands out file indices in order, limiting the total size of files that
+/// have been claimed but not yet released.
+struct ByteBudget {
+    state: Mutex<BudgetState>,
+    released: Condvar,
+    limit: u64,
+}
+
+struct BudgetState {
+    next: usize,
+    in_flight: u64,
+    cancelled: bool,
+}
+
+impl ByteBudget {
+    fn new(limit: u64) -> Self {
+        Self {
+            state: Mutex::new(BudgetState {
+                next: 0,
+                in_flight: 0,
+                cancelled: false,
+            }),
+            released: Condvar::new(),
+            limit,
+        }
+    }
+
+    /// Claims the next file, waiting until its size fits in the budget.
+    /// Returns `None` once all files are claimed or the run is cancelled.
+    fn claim(&self, paths: &[WalkedPath]) -> Option<usize> {
+        let mut state = self.state.lock().unwrap();
+        loop {
+            if state.cancelled || state.next >= paths.len() {
+                return None;
+            }
+            let size = paths[state.next].size;
+            if state.in_flight == 0 || state.in_flight + size <= self.limit {
+                state.in_flight += size;
+                state.next += 1;
+                return Some(state.next - 1);
+            }
+            state = self.released.wait(state).unwrap();
+        }
+    }
+
+    fn release(&self, size: u64) {
+        let mut state = self.state.lock().unwrap();
+        state.in_flight -= size;
+        self.released.notify_all();
+    }
+
+    fn cancel(&self) {
+        let mut state = self.state.lock().unwrap();
+        state.cancelled = true;
+        self.released.notify_all();
+    }
+}
+
+/// Cancels the budget if a worker panics, so the other workers stop
+/// waiting for a release that will never come.
+struct CancelOnPanic<'a>(&'a ByteBudget);
+
+impl Drop for CancelOnPanic<'_> {
+    fn drop(&mut self) {
+        if std::thread::panicking() {
+            if let Ok(mut state) = self.0.state.lock() {
+                state.cancelled = true;
+            }
+            self.0.released.notify_all();
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::indexer::progress::Progress;
+    use crate::indexer::source::DiskSource;
+    use std::path::Path;
+    use std::sync::Arc;
+    use std::time::Duration;
+
+    fn options(threads: usize, max_in_flight_bytes: u64, strict: bool) -> IndexOptions {
+        IndexOptions {
+            threads,
+            max_in_flight_bytes,
+            strict,
+            ..Default::default()
+        }
+    }
+
+    fn write_files(root: &Path, count: usize) -> Vec<WalkedPath> {
+        (0..count)
+            .map(|i| {
+                let rel_path = format!("f{:03}.rs", i);
+                let path = root.join(&rel_path);
+                std::fs::write(&path, format!("fn func_{}() {{}}\n", i)).unwrap();
+                WalkedPath {
+                    size: std::fs::metadata(&path).unwrap().len(),
+                    path,
+                    rel_path,
+                    mtime: None,
+                }
+            })
+            .collect()
+    }
+
+    #[test]
+    fn results_arrive_in_path_order() {
+        let dir = tempfile::tempdir().unwrap();
+        let paths = write_files(dir.path(), 50);
+
+        for threads in [1, 4, 16] {
+            let mut seen = Vec::new();
+            prepare_files(&DiskSource::new(dir.path()), &paths, &options(threads, 64, false), |p| {
+                seen.push(p.file.rel_path);
+                Ok(())
+            })
+            .unwrap();
+            let expected: Vec<String> = paths.iter().map(|p| p.rel_path.clone()).collect();
+            assert_eq!(seen, expected, "threads = {}", threads);
+        }
+    }
+
+    #[test]
+    fn symbols_are_extracted_by_workers() {
+        let dir = tempfile::tempdir().unwrap();
+        let paths = write_files(dir.path(), 3);
+
+        let mut symbols = Vec::new();
+        prepare_files(&DiskSource::new(dir.path()), &paths, &options(2, u64::MAX, false), |p| {
+            symbols.extend(p.symbols);
+            Ok(())
+        })
+        .unwrap();
+        assert_eq!(symbols, vec!["func_0", "func_1", "func_2"]);
+    }
+
+    #[test]
+    fn unreadable_and_binary_files_are_skipped() {
+        let dir = tempfile::tempdir().unwrap();
+        let mut paths = write_files(dir.path(), 2);
+        let bin = dir.path().join("blob.bin");
+        std::fs::write(&bin, b"\x00\x01\x02").unwrap();
+        paths.insert(1, WalkedPath { path: bin, rel_path: "blob.bin".into(), size: 3, mtime: None });
+        paths.push(WalkedPath {
+            path: dir.path().join("gone.rs"),
+            rel_path: "gone.rs".into(),
+            size: 10,
+            mtime: None,
+        });
+
+        let mut seen = Vec::new();
+        let skips = prepare_files(&DiskSource::new(dir.path()), &paths, &options(3, 1024, false), |p| {
+            seen.push(p.file.rel_path);
+            Ok(())
+        })
+        .unwrap();
+        assert_eq!(seen, vec!["f000.rs", "f001.rs"]);
+        assert_eq!(skips.binary, 1, "the unreadable file is not counted as binary");
+        assert_eq!(skips.unreadable.len(), 1);
+        assert_eq!(skips.unreadable.files[0].path, "gone.rs");
+        assert_eq!(skips.unreadable.files[0].error.kind(), std::io::ErrorKind::NotFound);
+
+        let mut calls = 0;
+        let result = prepare_files(&DiskSource::new(dir.path()), &paths, &options(3, 1024, true), |_| {
+            calls += 1;
+            Ok(())
+        });
+        match result {
+            Err(NsError::Unreadable(file)) => assert_eq!(file.path, "gone.rs"),
+            other => panic!("expected the unreadable file, got {:?}", other),
+        }
+        assert_eq!(calls, 2, "files before the unreadable one are still passed on");
+    }
+
+    #[test]
+    fn first_sink_error_is_returned_and_workers_drain() {
+        let dir = tempfile::tempdir().unwrap();
+        let paths = write_files(dir.path(), 40);
+
+        let mut calls = 0;
+        let result = prepare_files(&DiskSource::new(dir.path()), &paths, &options(8, 64, false), |p| {
+            calls += 1;
+            if p.file.rel_path == "f005.rs" {
+                return Err(NsError::Io(std::io::Error::other("disk full")));
+            }
+            Ok(())
+        });
+        match result {
+            Err(NsError::Io(e)) => assert_eq!(e.to_string(), "disk full"),
+            other => panic!("expected the sink error, got {:?}", other.err()),
+        }
+        assert_eq!(calls, 6, "no files are passed to the sink after the error");
+    }
+
+    #[test]
+    fn progress_counts_skipped_files_in_order() {
+        let dir = tempfile::tempdir().unwrap();
+        let mut paths = write_files(dir.path(), 2);
+        let bin = dir.path().join("blob.bin");
+        std::fs::write(&bin, b"\x00\x01\x02").unwrap();
+        paths.insert(1, WalkedPath { path: bin, rel_path: "blob.bin".into(), size: 3, mtime: None });
+
+        let events = Arc::new(Mutex::new(Vec::new()));
+        let seen = Arc::clone(&events);
+        let progress = Progress::new(move |e| seen.lock().unwrap().push(e.clone()))
+            .with_interval(Duration::ZERO);
+        let opts = IndexOptions {
+            progress: Some(progress),
+            ..options(3, 1024, false)
+        };
+        prepare_files(&DiskSource::new(dir.path()), &paths, &opts, |_| Ok(())).unwrap();
+        let events = events.lock().unwrap();
+        let done: Vec<(usize, &str)> = events.iter().map(|e| (e.files_done, e.path.as_str())).collect();
+        assert_eq!(done, vec![(1, "f000.rs"), (2, "blob.bin"), (3, "f001.rs")]);
+        let total: u64 = paths.iter().map(|p| p.size).sum();
+        assert_eq!((events[2].bytes_done, events[2].bytes_total), (total, total));
+    }
+
+    #[test]
+    fn byte_budget_limits_in_flight_claims() {
+        let dir = tempfile::tempdir().unwrap();
+        let paths = write_files(dir.path(), 3);
+        let size = paths[0].size;
+        let budget = ByteBudget::new(size * 2);
+
+        assert_eq!(budget.claim(&paths), Some(0));
+        assert_eq!(budget.claim(&paths), Some(1));
+        assert_eq!(budget.state.lock().unwrap().in_flight, size * 2);
+        budget.release(size);
+        assert_eq!(budget.claim(&paths), Some(2));
+        assert_eq!(budget.claim(&paths), None);
+    }
+}
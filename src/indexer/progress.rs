@@ -0,0 +1,133 @@
+use std::fmt;
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+
+/// How far a full build has got, passed to a [`Progress`] callback.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct ProgressEvent {
+    /// Files read so far, indexed or skipped (binary, unreadable).
+    pub files_done: usize,
+    /// Files the build will read in all.
+    pub files_total: usize,
+    /// Bytes of the files read so far, as walked.
+    pub bytes_done: u64,
+    /// Bytes of every file the build will read, as walked.
+    pub bytes_total: u64,
+    /// The file read last, relative to the repo root.
+    pub path: String,
+}
+
+/// A progress callback for full builds, passed in `IndexOptions::progress`.
+///
+/// Called at most once per interval (100ms by default) as files are
+/// indexed, in walk order, and once more after the last file, so a
+/// progress bar reaches its end. The callback runs on the thread adding
+/// documents while workers read ahead, so a slow one stalls the build;
+/// it must be `Send + Sync` since builds may run on any thread. To stop a
+/// build from it, cancel a clone of `IndexOptions::cancel`: the build
+/// checks it before the next document.
+#[derive(Clone)]
+pub struct Progress {
+    callback: Arc<dyn Fn(&ProgressEvent) + Send + Sync>,
+    interval: Duration,
+}
+
+impl Progress {
+    pub fn new(callback: impl Fn(&ProgressEvent) + Send + Sync + 'static) -> Self {
+        Self {
+            callback: Arc::new(callback),
+            interval: Duration::from_millis(100),
+        }
+    }
+
+    /// This callback, called at most once per `interval` instead. Zero
+    /// calls it for every file.
+    pub fn with_interval(self, interval: Duration) -> Self {
+        Self { interval, ..self }
+    }
+}
+
+impl fmt::Debug for Progress {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.debug_struct("Progress")
+            .field("interval", &self.interval)
+            .finish_non_exhaustive()
+    }
+}
+
+/// Counts a build's files and calls its [`Progress`] when the interval
+/// since the last call has passed.
+pub(crate) struct ProgressTracker<'a> {
+    progress: &'a Progress,
+    event: ProgressEvent,
+    last_report: Option<Instant>,
+    reported_files: usize,
+}
+
+impl<'a> ProgressTracker<'a> {
+    pub(crate) fn new(progress: &'a Progress, files_total: usize, bytes_total: u64) -> Self {
+        Self {
+            progress,
+            event: ProgressEvent {
+                files_done: 0,
+                files_total,
+                bytes_done: 0,
+                bytes_total,
+                path: String::new(),
+            },
+            last_report: None,
+            reported_files: 0,
+        }
+    }
+
+    /// Records that the file at `path`, of `size` bytes, was read.
+    pub(crate) fn file_done(&mut self, path: &str, size: u64) {
+        self.event.files_done += 1;
+        self.event.bytes_done += size;
+        self.event.path.clear();
+        self.event.path.push_str(path);
+        let now = Instant::now();
+        let due = self
+            .last_report
+            .is_none_or(|last| now.duration_since(last) >= self.progress.interval);
+        if due {
+            (self.progress.callback)(&self.event);
+            self.last_report = Some(now);
+            self.reported_files = self.event.files_done;
+        }
+    }
+
+    /// Calls the callback with the final counts, unless the last file
+    /// already reported them.
+    pub(crate) fn finish(&mut self) {
+        if self.last_report.is_none() || self.reported_files != self.event.files_done {
+            (self.progress.callback)(&self.event);
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::Mutex;
+
+    #[test]
+    fn reports_are_rate_limited_and_end_with_the_totals() {
+        let events = Arc::new(Mutex::new(Vec::new()));
+        let seen = Arc::clone(&events);
+        let progress = Progress::new(move |e| seen.lock().unwrap().push(e.clone()))
+            .with_interval(Duration::from_secs(3600));
+
+        let mut tracker = ProgressTracker::new(&progress, 3, 30);
+        for path in ["a.rs", "b.rs", "c.rs"] {
+            tracker.file_done(path, 10);
+        }
+        tracker.finish();
+        let events = events.lock().unwrap();
+        let reported: Vec<(usize, u64, &str)> = events
+            .iter()
+            .map(|e| (e.files_done, e.bytes_done, e.path.as_str()))
+            .collect();
+        assert_eq!(reported, vec![(1, 10, "a.rs"), (3, 30, "c.rs")]);
+    }
+}
@@ -0,0 +1,85 @@
+use super::manifest::content_hash;
+
+/// Words per shingle: runs of three words are hashed together, so that
+/// two files share most features only if they share most word sequences,
+/// not just vocabulary.
+const SHINGLE: usize = 3;
+
+/// 64-bit SimHash of `content`, the near-duplicate fingerprint stored per
+/// document. Identical content gives equal fingerprints, and content that
+/// differs in a few lines gives fingerprints a few bits apart (see
+/// [`distance`]); unrelated files land about 32 bits apart.
+///
+/// Features are overlapping three-word shingles, where a word is a run of
+/// letters, digits and `_`, so whitespace and punctuation changes don't
+/// count. Content without words hashes to 0.
+pub fn simhash(content: &str) -> u64 {
+    let words: Vec<u64> = content
+        .split(|c: char| !c.is_alphanumeric() && c != '_')
+        .filter(|w| !w.is_empty())
+        .map(|w| content_hash(w.as_bytes()))
+        .collect();
+    if words.is_empty() {
+        return 0;
+    }
+
+    let mut votes = [0i64; 64];
+    for shingle in words.windows(SHINGLE.min(words.len())) {
+        let feature = mix(shingle
+            .iter()
+            .enumerate()
+            .fold(0, |acc, (i, &h)| acc ^ h.rotate_left(21 * i as u32)));
+        for (bit, vote) in votes.iter_mut().enumerate() {
+            *vote += if feature >> bit & 1 == 1 { 1 } else { -1 };
+        }
+    }
+    votes
+        .iter()
+        .enumerate()
+        .filter(|(_, &vote)| vote > 0)
+        .fold(0, |acc, (bit, _)| acc | 1 << bit)
+}
+
+/// Number of bits in which two fingerprints differ.
+pub fn distance(a: u64, b: u64) -> u32 {
+    (a ^ b).count_ones()
+}
+
+/// The splitmix64 finalizer: spreads every input bit over the output, so
+/// each fingerprint bit gets an independent vote from each shingle.
+fn mix(mut x: u64) -> u64 {
+    x = (x ^ (x >> 30)).wrapping_mul(0xbf58_476d_1ce4_e5b9);
+    x = (x ^ (x >> 27)).wrapping_mul(0x94d0_49bb_1331_11eb);
+    x ^ (x >> 31)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn source(lines: usize) -> String {
+        (0..lines)
+            .map(|i| format!("let value_{} = compute(input_{}, {});\n", i, i % 7, i * 3))
+            .collect()
+    }
+
+    #[test]
+    fn near_duplicates_are_close_and_unrelated_files_far() {
+        let original = source(200);
+        let edited = original.replacen("compute(input_3, 9)", "compute(input_3, 10)", 1);
+        let reformatted = original.replace(" = ", "=");
+        let unrelated = "fn main() { println!(\"hello world from a different file\"); }".repeat(20);
+
+        assert_eq!(simhash(&original), simhash(&reformatted));
+        assert!(distance(simhash(&original), simhash(&edited)) <= 3);
+        assert!(distance(simhash(&original), simhash(&unrelated)) > 10);
+    }
+
+    #[test]
+    fn short_and_empty_content() {
+        assert_eq!(simhash(""), 0);
+        assert_eq!(simhash("  {}\n"), 0);
+        assert_ne!(simhash("config"), 0);
+        assert_eq!(simhash("a b"), simhash("a  b"));
+    }
+}
@@ -0,0 +1,135 @@
+use std::collections::BTreeMap;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+
+use super::walker::{build_pattern_matcher, walk_paths_with_skips, WalkedPath};
+
+/// Where a full build reads files from: the repo on disk ([`DiskSource`]),
+/// or anything else that can list files and read them by path — an
+/// archive, an embedded tree, an in-memory test fixture ([`MemorySource`]).
+///
+/// Files are read from several worker threads at once, hence `Sync`.
+///
+/// Symlinks are the source's business. [`DiskSource`] skips them, both to
+/// files and to directories, so a link can't pull in files from outside
+/// the repo or make a file appear twice. A source that follows links
+/// should list each linked file once, under the path it is reached by;
+/// one that has no links (an in-memory tree) has nothing to decide.
+pub trait FileSource: Sync {
+    /// The files to index, in a stable order (it becomes the document
+    /// order), and how many were left out for being larger than
+    /// `max_file_size`. Files matching `ignore_patterns` — gitignore-style
+    /// lines relative to the source root — are left out and not counted.
+    fn walk(&self, max_file_size: u64, ignore_patterns: &[String]) -> (Vec<WalkedPath>, usize);
+
+    /// Reads a file returned by [`FileSource::walk`].
+    fn read(&self, walked: &WalkedPath) -> io::Result<Vec<u8>>;
+}
+
+/// The repository at a directory on disk, walked with `.gitignore` and
+/// `.nsignore` rules (see `walker::walk_paths_with_skips`).
+#[derive(Debug, Clone)]
+pub struct DiskSource {
+    root: PathBuf,
+}
+
+impl DiskSource {
+    pub fn new(root: &Path) -> Self {
+        Self {
+            root: root.to_path_buf(),
+        }
+    }
+}
+
+impl FileSource for DiskSource {
+    fn walk(&self, max_file_size: u64, ignore_patterns: &[String]) -> (Vec<WalkedPath>, usize) {
+        walk_paths_with_skips(&self.root, max_file_size, ignore_patterns)
+    }
+
+    fn read(&self, walked: &WalkedPath) -> io::Result<Vec<u8>> {
+        fs::read(&walked.path)
+    }
+}
+
+/// Files held in memory, keyed by `/`-separated path relative to the
+/// source root, for indexing content that isn't on disk without writing
+/// it out first.
+///
+/// Walked in path order. Only the build's `ignore_patterns` apply:
+/// `.gitignore` and `.nsignore` entries are indexed like any other file.
+#[derive(Debug, Clone, Default)]
+pub struct MemorySource {
+    files: BTreeMap<String, Vec<u8>>,
+}
+
+impl MemorySource {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Adds or replaces the file at `rel_path`.
+    pub fn insert(&mut self, rel_path: &str, content: impl Into<Vec<u8>>) {
+        self.files.insert(rel_path.to_string(), content.into());
+    }
+}
+
+impl FileSource for MemorySource {
+    fn walk(&self, max_file_size: u64, ignore_patterns: &[String]) -> (Vec<WalkedPath>, usize) {
+        // Patterns are matched against paths under a stand-in root.
+        let root = Path::new("/");
+        let ignored = build_pattern_matcher(root, ignore_patterns);
+        let mut paths = Vec::new();
+        let mut too_large = 0;
+        for (rel_path, content) in &self.files {
+            let path = root.join(rel_path);
+            if ignored
+                .matched_path_or_any_parents(&path, false)
+                .is_ignore()
+            {
+                continue;
+            }
+            let size = content.len() as u64;
+            if size > max_file_size {
+                too_large += 1;
+                continue;
+            }
+            paths.push(WalkedPath {
+                path: PathBuf::from(rel_path),
+                rel_path: rel_path.clone(),
+                size,
+                mtime: None,
+            });
+        }
+        (paths, too_large)
+    }
+
+    fn read(&self, walked: &WalkedPath) -> io::Result<Vec<u8>> {
+        self.files
+            .get(&walked.rel_path)
+            .cloned()
+            .ok_or_else(|| io::Error::new(io::ErrorKind::NotFound, "not in source"))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn memory_source_applies_patterns_and_size_limit() {
+        let mut source = MemorySource::new();
+        source.insert("src/main.rs", "fn main() {}\n");
+        source.insert("build/out.rs", "fn generated() {}\n");
+        source.insert("notes.tmp", "scratch\n");
+        source.insert("big.txt", "x".repeat(100));
+        source.insert("README.md", "# demo\n");
+
+        let patterns = vec!["build/".to_string(), "*.tmp".to_string()];
+        let (paths, too_large) = source.walk(50, &patterns);
+        let rel_paths: Vec<&str> = paths.iter().map(|p| p.rel_path.as_str()).collect();
+        assert_eq!(rel_paths, vec!["README.md", "src/main.rs"]);
+        assert_eq!(too_large, 1);
+        assert_eq!(source.read(&paths[1]).unwrap(), b"fn main() {}\n");
+    }
+}
@@ -0,0 +1,94 @@
+use std::path::Path;
+
+use crate::error::NsError;
+
+/// Common English words (Lucene's list, also used by tantivy). Mostly
+/// matters for comments, docs and prose files.
+pub const ENGLISH: &[&str] = &[
+    "a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in", "into", "is",
+    "it", "no", "not", "of", "on", "or", "such", "that", "the", "their", "then", "there",
+    "these", "they", "this", "to", "was", "will", "with",
+];
+
+/// Keywords that appear in nearly every file of their language and rarely
+/// help rank one file above another. Only reserved words are listed, so no
+/// identifier a symbol could be named after is dropped.
+pub const CODE: &[&str] = &[
+    "const", "def", "defp", "do", "elif", "else", "end", "false", "fn", "func", "impl",
+    "import", "let", "mod", "nil", "null", "package", "private", "protected", "pub", "public",
+    "return", "static", "true", "var", "void", "while",
+];
+
+/// Names accepted by `ns index --stop-words`.
+pub const LIST_NAMES: &[&str] = &["english", "code", "none"];
+
+/// Resolves built-in list names (see [`LIST_NAMES`]) plus `extra` words into
+/// a sorted, deduplicated, lowercased stop word list. `none` contributes
+/// nothing, so `--stop-words none` disables stop words.
+pub fn resolve(lists: &[String], extra: &[String]) -> Vec<String> {
+    let mut words: Vec<String> = Vec::new();
+    for name in lists {
+        let builtin: &[&str] = match name.as_str() {
+            "english" => ENGLISH,
+            "code" => CODE,
+            _ => &[],
+        };
+        words.extend(builtin.iter().map(|w| w.to_string()));
+    }
+    words.extend(extra.iter().map(|w| w.to_lowercase()));
+    words.sort();
+    words.dedup();
+    words
+}
+
+/// The stop words used when none are configured: [`ENGLISH`].
+pub fn default_stop_words() -> Vec<String> {
+    resolve(&["english".to_string()], &[])
+}
+
+/// Reads a stop word file: one word per line, blank lines and `#` comments
+/// ignored.
+pub fn read_stop_words_file(path: &Path) -> Result<Vec<String>, NsError> {
+    let content = std::fs::read_to_string(path)?;
+    Ok(content
+        .lines()
+        .map(|line| line.split('#').next().unwrap_or("").trim())
+        .filter(|word| !word.is_empty())
+        .map(|word| word.to_string())
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn names(list: &[&str]) -> Vec<String> {
+        list.iter().map(|s| s.to_string()).collect()
+    }
+
+    #[test]
+    fn resolve_merges_lists_sorted_and_deduplicated() {
+        let words = resolve(&names(&["code", "english"]), &names(&["The", "Widget"]));
+        assert!(words.contains(&"the".to_string()));
+        assert!(words.contains(&"func".to_string()));
+        assert!(words.contains(&"widget".to_string()), "extra words are lowercased");
+        let mut sorted = words.clone();
+        sorted.sort();
+        sorted.dedup();
+        assert_eq!(words, sorted);
+    }
+
+    #[test]
+    fn none_disables_stop_words() {
+        assert!(resolve(&names(&["none"]), &[]).is_empty());
+        assert_eq!(default_stop_words().len(), ENGLISH.len());
+    }
+
+    #[test]
+    fn stop_words_file_skips_comments_and_blanks() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("stop.txt");
+        std::fs::write(&path, "# project noise\nlorem\n\n  ipsum  # trailing\n").unwrap();
+        assert_eq!(read_stop_words_file(&path).unwrap(), vec!["lorem", "ipsum"]);
+    }
+}
@@ -1,5 +1,7 @@
 use tree_sitter::{Node, Parser};
 
+use super::definitions::{Definition, SymbolKind};
+
 /// Extracts symbol names (functions, structs, classes, etc.) from source code.
 ///
 /// Returns an empty vec for unsupported languages or parse failures.
@@ -204,6 +206,76 @@ fn walk_go(node: Node, source: &[u8], symbols: &mut Vec<String>) {
     }
 }
 
+/// Extracts package-level definitions (funcs, methods, types, consts and
+/// vars) with the position of each name, for `.ns/definitions.json`.
+///
+/// Only Go is supported; other languages return an empty vec, as do parse
+/// failures. Definitions are in source order.
+pub fn extract_definitions(lang: &str, source: &[u8]) -> Vec<Definition> {
+    if lang != "go" {
+        return Vec::new();
+    }
+    let mut parser = Parser::new();
+    parser
+        .set_language(&tree_sitter_go::LANGUAGE.into())
+        .expect("failed to load Go grammar");
+    let tree = match parser.parse(source, None) {
+        Some(t) => t,
+        None => return Vec::new(),
+    };
+
+    let mut defs = Vec::new();
+    walk_go_definitions(tree.root_node(), source, &mut defs);
+    defs
+}
+
+fn walk_go_definitions(node: Node, source: &[u8], defs: &mut Vec<Definition>) {
+    let kind = match node.kind() {
+        "function_declaration" => SymbolKind::Func,
+        "method_declaration" => SymbolKind::Method,
+        "type_spec" | "type_alias" => SymbolKind::Type,
+        "const_spec" => SymbolKind::Const,
+        "var_spec" => SymbolKind::Var,
+        // Declarations inside function bodies are local, not definitions.
+        "block" => return,
+        _ => {
+            for i in 0..node.child_count() {
+                if let Some(child) = node.child(i) {
+                    walk_go_definitions(child, source, defs);
+                }
+            }
+            return;
+        }
+    };
+
+    let mut push = |name_node: Node| {
+        if let Ok(name) = name_node.utf8_text(source) {
+            let pos = name_node.start_position();
+            defs.push(Definition {
+                name: name.to_string(),
+                kind,
+                line: pos.row + 1,
+                column: pos.column + 1,
+            });
+        }
+    };
+    match kind {
+        // `const a, b = 1, 2` names every identifier before the values.
+        SymbolKind::Const | SymbolKind::Var => {
+            for i in 0..node.named_child_count() {
+                if let Some(child) = node.named_child(i).filter(|c| c.kind() == "identifier") {
+                    push(child);
+                }
+            }
+        }
+        _ => {
+            if let Some(name_node) = node.child_by_field_name("name") {
+                push(name_node);
+            }
+        }
+    }
+}
+
 // ── Elixir ────────────────────────────────────────────────────────────────────
 
 fn extract_elixir(source: &[u8]) -> Vec<String> {
@@ -677,6 +749,52 @@ const MaxRequestSize = 1048576
         assert!(symbols.contains(&"MaxRequestSize".to_string()));
     }
 
+    #[test]
+    fn go_definitions_have_kinds_and_positions() {
+        let source = include_bytes!("../../tests/fixtures/sample_repo/src/server.go");
+        let defs = extract_definitions("go", source);
+        let find = |name: &str| defs.iter().find(|d| d.name == name).cloned();
+
+        let server = find("Server").expect("should find Server");
+        assert_eq!((server.kind, server.line, server.column), (SymbolKind::Type, 19, 6));
+        assert_eq!(find("NewServer").map(|d| (d.kind, d.line)), Some((SymbolKind::Func, 27)));
+        assert_eq!(find("Start").map(|d| d.kind), Some(SymbolKind::Method));
+        assert_eq!(find("DefaultPort").map(|d| d.kind), Some(SymbolKind::Const));
+        assert!(find("addr").is_none(), "locals are not definitions");
+    }
+
+    #[test]
+    fn go_definitions_split_grouped_specs_and_skip_locals() {
+        let source = br#"
+package main
+
+var a, b = 1, 2
+
+const (
+	Low = iota
+	High
+)
+
+func run() {
+	var local = 3
+	type inner struct{}
+}
+"#;
+        let defs = extract_definitions("go", source);
+        let names: Vec<(&str, SymbolKind)> = defs.iter().map(|d| (d.name.as_str(), d.kind)).collect();
+        assert_eq!(
+            names,
+            vec![
+                ("a", SymbolKind::Var),
+                ("b", SymbolKind::Var),
+                ("Low", SymbolKind::Const),
+                ("High", SymbolKind::Const),
+                ("run", SymbolKind::Func),
+            ]
+        );
+        assert!(extract_definitions("rust", b"fn run() {}").is_empty());
+    }
+
     #[test]
     fn elixir_extracts_all_symbol_kinds() {
         let source = br#"
@@ -0,0 +1,388 @@
+use tantivy::tokenizer::{
+    Language, RawTokenizer, Stemmer, TextAnalyzer, Token, TokenStream, Tokenizer,
+};
+use unicode_normalization::char::is_combining_mark;
+
+use crate::error::NsError;
+
+/// Tokenizer for source code: splits on non-identifier characters, then
+/// splits each identifier on `snake_case` and `camelCase` boundaries.
+///
+/// The whole identifier is emitted first (at the position of its first part),
+/// followed by each part at consecutive positions, so `ReadTimeout` yields
+/// `ReadTimeout`, `Read`, `Timeout`. A phrase query for `"read timeout"` and a
+/// term query for `readtimeout` both match. Lowercasing is left to a
+/// `LowerCaser` filter, as with tantivy's `SimpleTokenizer`.
+///
+/// Acronyms stay together (`HTTPServer` → `HTTP`, `Server`) and digit runs
+/// split off from letters (`UTF8` → `UTF`, `8`). Combining marks stay with
+/// the letter before them, so a decomposed `café` (`e` + U+0301) is one
+/// word, as is the precomposed one.
+#[derive(Clone, Default)]
+pub struct CodeTokenizer {
+    tokens: Vec<Token>,
+}
+
+/// Tokenizer for prose: runs of letters, digits and combining marks, each
+/// one token. Like tantivy's `SimpleTokenizer`, except that `café` written
+/// with a combining accent isn't split at the accent.
+#[derive(Clone, Default)]
+pub struct WordTokenizer {
+    tokens: Vec<Token>,
+}
+
+/// Token stream over the tokens produced by [`CodeTokenizer`] and
+/// [`WordTokenizer`].
+pub struct CodeTokenStream<'a> {
+    tokens: &'a mut Vec<Token>,
+    cursor: usize,
+}
+
+impl Tokenizer for CodeTokenizer {
+    type TokenStream<'a> = CodeTokenStream<'a>;
+
+    fn token_stream<'a>(&'a mut self, text: &'a str) -> CodeTokenStream<'a> {
+        self.tokens.clear();
+        code_tokens(text, &mut self.tokens);
+        CodeTokenStream {
+            tokens: &mut self.tokens,
+            cursor: 0,
+        }
+    }
+}
+
+impl Tokenizer for WordTokenizer {
+    type TokenStream<'a> = CodeTokenStream<'a>;
+
+    fn token_stream<'a>(&'a mut self, text: &'a str) -> CodeTokenStream<'a> {
+        self.tokens.clear();
+        let words = text
+            .split(|c: char| !is_word_char(c))
+            .filter(|word| !word.is_empty());
+        for (position, word) in words.enumerate() {
+            let offset = word.as_ptr() as usize - text.as_ptr() as usize;
+            self.tokens.push(Token {
+                offset_from: offset,
+                offset_to: offset + word.len(),
+                position,
+                text: word.to_string(),
+                position_length: 1,
+            });
+        }
+        CodeTokenStream {
+            tokens: &mut self.tokens,
+            cursor: 0,
+        }
+    }
+}
+
+impl TokenStream for CodeTokenStream<'_> {
+    fn advance(&mut self) -> bool {
+        if self.cursor < self.tokens.len() {
+            self.cursor += 1;
+            true
+        } else {
+            false
+        }
+    }
+
+    fn token(&self) -> &Token {
+        &self.tokens[self.cursor - 1]
+    }
+
+    fn token_mut(&mut self) -> &mut Token {
+        &mut self.tokens[self.cursor - 1]
+    }
+}
+
+/// Whether `c` belongs to a word: a letter, a digit or a combining mark.
+/// `_` joins identifiers too, for the `code` tokenizer only.
+pub(crate) fn is_word_char(c: char) -> bool {
+    c.is_alphanumeric() || is_combining_mark(c)
+}
+
+/// Appends the code tokens of `text` to `out`.
+fn code_tokens(text: &str, out: &mut Vec<Token>) {
+    let mut position = 0;
+    let mut run_start: Option<usize> = None;
+
+    // Trailing sentinel so the last identifier run is flushed.
+    let chars = text.char_indices().chain(std::iter::once((text.len(), ' ')));
+    for (offset, c) in chars {
+        if is_word_char(c) || c == '_' {
+            run_start.get_or_insert(offset);
+            continue;
+        }
+        let Some(start) = run_start.take() else {
+            continue;
+        };
+
+        let word = &text[start..offset];
+        let parts = split_identifier(word);
+        let (Some(first), Some(last)) = (parts.first(), parts.last()) else {
+            continue; // all underscores
+        };
+
+        // Whole identifier, minus leading/trailing underscores — only when it
+        // differs from its single part.
+        if parts.len() > 1 {
+            out.push(Token {
+                offset_from: start + first.0,
+                offset_to: start + last.1,
+                position,
+                text: word[first.0..last.1].to_string(),
+                position_length: parts.len(),
+            });
+        }
+        for (i, &(from, to)) in parts.iter().enumerate() {
+            out.push(Token {
+                offset_from: start + from,
+                offset_to: start + to,
+                position: position + i,
+                text: word[from..to].to_string(),
+                position_length: 1,
+            });
+        }
+        position += parts.len();
+    }
+}
+
+/// Splits an identifier into `(start, end)` byte ranges of its parts.
+///
+/// Boundaries: `_`, lower→upper (`readTimeout`), the last capital of an
+/// acronym followed by lowercase (`HTTPServer`), and letter↔digit (`utf8`).
+/// Combining marks are never boundaries and are skipped when looking back
+/// for the previous letter.
+pub(crate) fn split_identifier(word: &str) -> Vec<(usize, usize)> {
+    let chars: Vec<(usize, char)> = word.char_indices().collect();
+    let mut parts = Vec::new();
+    let mut start: Option<usize> = None;
+
+    for (i, &(offset, c)) in chars.iter().enumerate() {
+        if c == '_' {
+            if let Some(s) = start.take() {
+                parts.push((s, offset));
+            }
+            continue;
+        }
+        let Some(s) = start else {
+            start = Some(offset);
+            continue;
+        };
+        if is_combining_mark(c) {
+            continue;
+        }
+
+        let Some(prev) = chars[..i]
+            .iter()
+            .rev()
+            .map(|&(_, p)| p)
+            .find(|&p| !is_combining_mark(p))
+        else {
+            continue;
+        };
+        let next = chars[i + 1..]
+            .iter()
+            .map(|&(_, n)| n)
+            .find(|&n| !is_combining_mark(n));
+        let boundary = (prev.is_lowercase() && c.is_uppercase())
+            || (prev.is_uppercase() && c.is_uppercase() && next.is_some_and(char::is_lowercase))
+            || (prev.is_numeric() != c.is_numeric());
+        if boundary {
+            parts.push((s, offset));
+            start = Some(offset);
+        }
+    }
+    if let Some(s) = start {
+        parts.push((s, word.len()));
+    }
+    parts
+}
+
+/// Stemming applied to `content` tokens at index and query time, chosen
+/// when the index is built and recorded in `meta.json`.
+///
+/// `symbols` always use `Noop`: identifiers are matched as written.
+#[derive(Clone, Copy, Debug, Default, PartialEq, Eq)]
+pub enum Stemming {
+    /// No stemming.
+    #[default]
+    Noop,
+    /// Snowball English (Porter2): `connections` → `connect`, `running` → `run`.
+    English,
+}
+
+impl Stemming {
+    /// The name recorded in `meta.json`; `None` for `Noop`.
+    pub fn name(self) -> Option<&'static str> {
+        match self {
+            Stemming::Noop => None,
+            Stemming::English => Some("english"),
+        }
+    }
+
+    /// Parses a name recorded in `meta.json` (see [`Stemming::name`]).
+    ///
+    /// An index built with a stemmer this binary doesn't know can't be
+    /// queried correctly, so that's an error rather than a fallback to `Noop`.
+    pub fn from_name(name: Option<&str>) -> Result<Self, NsError> {
+        match name {
+            None => Ok(Stemming::Noop),
+            Some("english") => Ok(Stemming::English),
+            Some(other) => Err(NsError::UnsupportedStemmer(other.to_string())),
+        }
+    }
+
+    /// The tantivy stemmer language, or `None` for `Noop`.
+    pub fn language(self) -> Option<Language> {
+        match self {
+            Stemming::Noop => None,
+            Stemming::English => Some(Language::English),
+        }
+    }
+
+    /// Stems one lowercased word the way indexed `content` tokens are.
+    pub fn stem(self, word: &str) -> String {
+        let Some(language) = self.language() else {
+            return word.to_string();
+        };
+        let mut analyzer = TextAnalyzer::builder(RawTokenizer::default())
+            .filter(Stemmer::new(language))
+            .build();
+        let mut stream = analyzer.token_stream(word);
+        match stream.next() {
+            Some(token) => token.text.clone(),
+            None => word.to_string(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tantivy::tokenizer::{LowerCaser, TextAnalyzer};
+
+    fn tokens(text: &str) -> Vec<(String, usize)> {
+        let mut analyzer = TextAnalyzer::builder(CodeTokenizer::default())
+            .filter(LowerCaser)
+            .build();
+        let mut stream = analyzer.token_stream(text);
+        let mut out = Vec::new();
+        while let Some(token) = stream.next() {
+            out.push((token.text.clone(), token.position));
+        }
+        out
+    }
+
+    fn texts(text: &str) -> Vec<String> {
+        tokens(text).into_iter().map(|(t, _)| t).collect()
+    }
+
+    fn parts(word: &str) -> Vec<&str> {
+        split_identifier(word)
+            .into_iter()
+            .map(|(s, e)| &word[s..e])
+            .collect()
+    }
+
+    #[test]
+    fn splits_camel_case_and_keeps_whole() {
+        assert_eq!(texts("ReadTimeout"), vec!["readtimeout", "read", "timeout"]);
+    }
+
+    #[test]
+    fn splits_snake_case_and_keeps_whole() {
+        assert_eq!(texts("read_timeout"), vec!["read_timeout", "read", "timeout"]);
+    }
+
+    #[test]
+    fn acronyms_and_digits() {
+        assert_eq!(parts("HTTPServer"), vec!["HTTP", "Server"]);
+        assert_eq!(parts("getHTTPResponse"), vec!["get", "HTTP", "Response"]);
+        assert_eq!(parts("UTF8"), vec!["UTF", "8"]);
+        assert_eq!(parts("x86_64"), vec!["x", "86", "64"]);
+        assert_eq!(parts("ABC"), vec!["ABC"]);
+    }
+
+    #[test]
+    fn single_part_words_emitted_once() {
+        assert_eq!(texts("hello world"), vec!["hello", "world"]);
+        assert_eq!(texts("__init__"), vec!["init"]);
+        assert!(texts("___ ++ --").is_empty());
+    }
+
+    #[test]
+    fn whole_token_shares_position_with_first_part() {
+        assert_eq!(
+            tokens("foo ReadTimeout bar"),
+            vec![
+                ("foo".to_string(), 0),
+                ("readtimeout".to_string(), 1),
+                ("read".to_string(), 1),
+                ("timeout".to_string(), 2),
+                ("bar".to_string(), 3),
+            ]
+        );
+    }
+
+    #[test]
+    fn go_method_declaration() {
+        let t = texts("func (s *Server) Start() error {");
+        assert_eq!(t, vec!["func", "s", "server", "start", "error"]);
+    }
+
+    #[test]
+    fn go_struct_fields() {
+        let t = texts("ReadTimeout: s.config.ReadTimeout,\n\tWriteTimeout time.Duration");
+        for expected in ["readtimeout", "read", "timeout", "writetimeout", "write", "duration"] {
+            assert!(t.contains(&expected.to_string()), "missing {expected}: {t:?}");
+        }
+    }
+
+    #[test]
+    fn offsets_point_into_source() {
+        let text = "let srvConfig = 1;";
+        let mut tokenizer = CodeTokenizer::default();
+        let mut stream = tokenizer.token_stream(text);
+        let mut spans = Vec::new();
+        while stream.advance() {
+            let t = stream.token();
+            spans.push(&text[t.offset_from..t.offset_to]);
+        }
+        assert_eq!(spans, vec!["let", "srvConfig", "srv", "Config", "1"]);
+    }
+
+    #[test]
+    fn non_ascii_identifiers() {
+        assert_eq!(parts("größeWert"), vec!["größe", "Wert"]);
+        assert_eq!(texts("naïve_café"), vec!["naïve_café", "naïve", "café"]);
+    }
+
+    #[test]
+    fn combining_marks_stay_in_their_word() {
+        // `e` + U+0301 and `E` + U+0301: decomposed `é` and `É`.
+        assert_eq!(texts("cafe\u{301} x"), vec!["cafe\u{301}", "x"]);
+        assert_eq!(parts("cafe\u{301}Bar"), vec!["cafe\u{301}", "Bar"]);
+        assert_eq!(parts("HTTPE\u{301}te"), vec!["HTTP", "E\u{301}te"]);
+        assert_eq!(parts("v2\u{301}x"), vec!["v", "2\u{301}", "x"]);
+    }
+
+    #[test]
+    fn english_stemming_folds_plurals_and_verb_forms() {
+        let stem = |w| Stemming::English.stem(w);
+        assert_eq!(stem("connections"), stem("connection"));
+        assert_eq!(stem("running"), "run");
+        assert_eq!(Stemming::Noop.stem("running"), "running");
+    }
+
+    #[test]
+    fn stemming_names_round_trip() {
+        for stemming in [Stemming::Noop, Stemming::English] {
+            assert_eq!(Stemming::from_name(stemming.name()).unwrap(), stemming);
+        }
+        match Stemming::from_name(Some("klingon")) {
+            Err(NsError::UnsupportedStemmer(name)) => assert_eq!(name, "klingon"),
+            other => panic!("expected UnsupportedStemmer, got {:?}", other),
+        }
+    }
+}
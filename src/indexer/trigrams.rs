@@ -0,0 +1,263 @@
+use std::collections::{BTreeSet, HashMap};
+
+use regex_syntax::hir::{Class, ClassUnicode, ClassUnicodeRange, Hir, HirKind};
+use tantivy::query::{BooleanQuery, Occur, Query, TermQuery};
+use tantivy::schema::{Field, IndexRecordOption};
+use tantivy::{TantivyDocument, Term};
+
+/// Which trigrams a file must hold to possibly match a pattern: a
+/// file matching the pattern always matches the query, though a file
+/// matching the query may not match the pattern.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum TrigramQuery {
+    /// Any file may match: the pattern requires no three characters in a row.
+    Any,
+    /// Files holding this trigram, case folded (see [`fold`]).
+    Trigram(String),
+    /// Files matching every one of the queries.
+    And(Vec<TrigramQuery>),
+    /// Files matching at least one of the queries.
+    Or(Vec<TrigramQuery>),
+}
+
+impl TrigramQuery {
+    fn and(parts: Vec<TrigramQuery>) -> Self {
+        let mut all: Vec<TrigramQuery> = Vec::new();
+        for part in parts {
+            let flattened = match part {
+                TrigramQuery::Any => Vec::new(),
+                TrigramQuery::And(inner) => inner,
+                other => vec![other],
+            };
+            for query in flattened {
+                if !all.contains(&query) {
+                    all.push(query);
+                }
+            }
+        }
+        match all.len() {
+            0 => TrigramQuery::Any,
+            1 => all.pop().unwrap(),
+            _ => TrigramQuery::And(all),
+        }
+    }
+
+    fn or(mut branches: Vec<TrigramQuery>) -> Self {
+        if branches.is_empty() || branches.contains(&TrigramQuery::Any) {
+            return TrigramQuery::Any;
+        }
+        if branches.len() == 1 {
+            return branches.pop().unwrap();
+        }
+        TrigramQuery::Or(branches)
+    }
+
+    /// The query over the `trigrams` field `field`, or `None` for
+    /// [`TrigramQuery::Any`].
+    pub fn to_query(&self, field: Field) -> Option<Box<dyn Query>> {
+        match self {
+            TrigramQuery::Any => None,
+            TrigramQuery::Trigram(trigram) => Some(Box::new(TermQuery::new(
+                Term::from_field_text(field, trigram),
+                IndexRecordOption::Basic,
+            ))),
+            TrigramQuery::And(parts) | TrigramQuery::Or(parts) => {
+                let occur = match self {
+                    TrigramQuery::And(_) => Occur::Must,
+                    _ => Occur::Should,
+                };
+                let clauses = parts
+                    .iter()
+                    .filter_map(|part| part.to_query(field))
+                    .map(|query| (occur, query))
+                    .collect();
+                Some(Box::new(BooleanQuery::new(clauses)))
+            }
+        }
+    }
+}
+
+/// `c` with its case folded away: every character that simple case
+/// folding says is the same letter (`K`, `k` and the Kelvin sign `K`)
+/// folds to one, the ASCII one lowercased where there is one.
+///
+/// Folding character by character, rather than lowercasing the text, means
+/// folding a substring gives the substring of the folded text, and the
+/// characters a case-insensitive regex lets a letter match all fold alike.
+pub fn fold(c: char) -> char {
+    if c.is_ascii() {
+        return c.to_ascii_lowercase();
+    }
+    let mut class = ClassUnicode::new([ClassUnicodeRange::new(c, c)]);
+    class.case_fold_simple();
+    let mut equivalents = class
+        .ranges()
+        .iter()
+        .flat_map(|range| range.start()..=range.end());
+    let first = equivalents.next().unwrap_or(c);
+    if first.is_ascii() {
+        first.to_ascii_lowercase()
+    } else {
+        first
+    }
+}
+
+/// The distinct trigrams of `content`, case folded: every three
+/// characters in a row, line breaks included.
+pub fn trigrams(content: &str) -> BTreeSet<String> {
+    // Content is mostly ASCII; folding the rest takes a table lookup.
+    let mut folded: HashMap<char, char> = HashMap::new();
+    let chars: Vec<char> = content
+        .chars()
+        .map(|c| *folded.entry(c).or_insert_with(|| fold(c)))
+        .collect();
+    let windows: BTreeSet<[char; 3]> = chars.windows(3).map(|w| [w[0], w[1], w[2]]).collect();
+    windows.into_iter().map(|w| w.iter().collect()).collect()
+}
+
+/// Adds the trigrams of `content` to `doc`'s `trigrams` field `field`.
+pub fn add_trigrams(doc: &mut TantivyDocument, field: Field, content: &str) {
+    for trigram in trigrams(content) {
+        doc.add_text(field, &trigram);
+    }
+}
+
+/// The trigrams a file must hold for `pattern` (a Rust regex, matched
+/// case-insensitively with `case_insensitive`) to match in it.
+///
+/// Conservative: only runs of characters every match contains in a row
+/// count — literals, and classes that only differ by case — through
+/// concatenations, groups and repetitions of at least one; an
+/// alternation needs the trigrams of one of its branches. Everything
+/// else (`.`, wider classes, optional parts) breaks the run, and a
+/// pattern that doesn't parse may match anything.
+pub fn required_trigrams(pattern: &str, case_insensitive: bool) -> TrigramQuery {
+    let parsed = regex_syntax::ParserBuilder::new()
+        .case_insensitive(case_insensitive)
+        .build()
+        .parse(pattern);
+    match parsed {
+        Ok(hir) => required(&hir),
+        Err(_) => TrigramQuery::Any,
+    }
+}
+
+fn required(hir: &Hir) -> TrigramQuery {
+    match hir.kind() {
+        HirKind::Literal(_) | HirKind::Class(_) => match folded_chars(hir) {
+            Some(chars) => run_trigrams(&chars),
+            None => TrigramQuery::Any,
+        },
+        HirKind::Concat(subs) => {
+            // Adjacent characters form one run; anything else ends the run.
+            let mut parts = Vec::new();
+            let mut run: Vec<char> = Vec::new();
+            for sub in subs {
+                if let Some(chars) = folded_chars(sub) {
+                    run.extend(chars);
+                    continue;
+                }
+                parts.push(run_trigrams(&std::mem::take(&mut run)));
+                parts.push(required(sub));
+            }
+            parts.push(run_trigrams(&run));
+            TrigramQuery::and(parts)
+        }
+        HirKind::Capture(cap) => required(&cap.sub),
+        HirKind::Repetition(rep) if rep.min > 0 => required(&rep.sub),
+        HirKind::Alternation(branches) => TrigramQuery::or(branches.iter().map(required).collect()),
+        _ => TrigramQuery::Any,
+    }
+}
+
+/// The folded characters `hir` matches, if it matches exactly those: a
+/// literal, or a class of one letter in its cases.
+fn folded_chars(hir: &Hir) -> Option<Vec<char>> {
+    match hir.kind() {
+        HirKind::Literal(lit) => {
+            let text = std::str::from_utf8(&lit.0).ok()?;
+            Some(text.chars().map(fold).collect())
+        }
+        HirKind::Class(Class::Unicode(class)) => {
+            let mut chars = class
+                .ranges()
+                .iter()
+                .flat_map(|range| range.start()..=range.end())
+                .map(fold);
+            let first = chars.next()?;
+            // Case variants come a few at a time; a wide range never folds
+            // to one character, so stop at the first that differs.
+            chars.all(|c| c == first).then(|| vec![first])
+        }
+        _ => None,
+    }
+}
+
+fn run_trigrams(run: &[char]) -> TrigramQuery {
+    let trigrams: BTreeSet<String> = run.windows(3).map(|w| w.iter().collect()).collect();
+    TrigramQuery::and(trigrams.into_iter().map(TrigramQuery::Trigram).collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn all(trigrams: &[&str]) -> TrigramQuery {
+        TrigramQuery::And(
+            trigrams
+                .iter()
+                .map(|t| TrigramQuery::Trigram(t.to_string()))
+                .collect(),
+        )
+    }
+
+    #[test]
+    fn content_trigrams_are_folded_and_distinct() {
+        let found: Vec<String> = trigrams("AbAbA\nÉ").into_iter().collect();
+        assert_eq!(found, vec!["a\nÉ", "aba", "ba\n", "bab"]);
+        assert_eq!(trigrams("aBA\néb"), trigrams("ABa\nÉB"));
+        assert!(trigrams("ab").is_empty());
+        assert_eq!(fold('\u{212A}'), 'k'); // Kelvin sign
+        assert_eq!(fold('ſ'), 's');
+        assert_eq!(fold('Ж'), fold('ж'));
+    }
+
+    #[test]
+    fn literals_require_their_trigrams() {
+        assert_eq!(
+            required_trigrams("Timeout", false),
+            all(&["eou", "ime", "meo", "out", "tim"])
+        );
+        assert_eq!(
+            required_trigrams(r"http\.Status\w+", false),
+            all(&[".st", "atu", "htt", "p.s", "sta", "tat", "tp.", "ttp", "tus"])
+        );
+        // Case-insensitive literals are classes of one letter's cases.
+        assert_eq!(required_trigrams("(?i)Err", false), required_trigrams("err", false));
+        assert_eq!(
+            required_trigrams("ERR", true),
+            TrigramQuery::Trigram("err".to_string())
+        );
+    }
+
+    #[test]
+    fn only_required_runs_count() {
+        let trigram = |t: &str| TrigramQuery::Trigram(t.to_string());
+        assert_eq!(
+            required_trigrams("readDeadline|writeTimeout", false),
+            TrigramQuery::Or(vec![
+                required_trigrams("readDeadline", false),
+                required_trigrams("writeTimeout", false),
+            ])
+        );
+        assert_eq!(
+            required_trigrams("abc.def", false),
+            TrigramQuery::And(vec![trigram("abc"), trigram("def")])
+        );
+        assert_eq!(required_trigrams("ab(xyz)?cd", false), TrigramQuery::Any);
+        assert_eq!(required_trigrams("(?:abc)+", false), trigram("abc"));
+        for any in ["foo|ba", "[0-9]+", "a.b.c", "(unclosed"] {
+            assert_eq!(required_trigrams(any, false), TrigramQuery::Any, "{}", any);
+        }
+    }
+}
@@ -1,8 +1,12 @@
-use std::path::Path;
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use std::time::SystemTime;
 
+use ignore::gitignore::{Gitignore, GitignoreBuilder};
 use ignore::WalkBuilder;
 
-use super::language::detect_language;
+use super::gzip::{decompress, logical_path, GzipError};
+use super::language::detect_language_with_content;
 
 /// A file that has been read and is ready for indexing.
 pub struct WalkedFile {
@@ -12,29 +16,94 @@ pub struct WalkedFile {
     pub content: String,
     /// Detected language identifier, or `None` if unknown/unsupported.
     pub lang: Option<String>,
+    /// Modification time at walk time, recorded in the manifest.
+    pub mtime: Option<SystemTime>,
 }
 
+/// Name of the ns-specific ignore file. Same syntax and nesting rules as
+/// `.gitignore`, and takes precedence over it.
+pub const NSIGNORE_FILENAME: &str = ".nsignore";
+
 /// Walks the repository at `root`, returning indexable files.
 ///
 /// Skips:
-/// - Files ignored by `.gitignore`
+/// - Files ignored by `.gitignore` or `.nsignore` (nested files apply to
+///   their own subtree; honored even outside a git repository)
 /// - `.git/` and `.ns/` directories
-/// - Binary files (null byte in first 512 bytes)
-/// - Files larger than `max_file_size`
+/// - Binary files (see [`looks_binary`])
+/// - Files larger than `max_file_size`, compressed or decompressed (see
+///   [`read_file`])
 /// - Non-UTF-8 files
 pub fn walk_repo(root: &Path, max_file_size: u64) -> Vec<WalkedFile> {
-    let mut files = Vec::new();
+    walk_repo_with_ignores(root, max_file_size, &[])
+}
+
+/// Like [`walk_repo`], additionally skipping paths that match
+/// `ignore_patterns` — gitignore-style lines relative to `root`
+/// (`*.tmp`, `build/`, `!keep.log`).
+///
+/// A `!` pattern only re-includes paths excluded by an earlier pattern in
+/// the same list; it cannot override `.gitignore`.
+pub fn walk_repo_with_ignores(
+    root: &Path,
+    max_file_size: u64,
+    ignore_patterns: &[String],
+) -> Vec<WalkedFile> {
+    walk_paths_with_ignores(root, max_file_size, ignore_patterns)
+        .iter()
+        .filter_map(|walked| read_walked_path(walked, max_file_size))
+        .collect()
+}
+
+/// A candidate file found by the walk, not yet read.
+#[derive(Debug, Clone)]
+pub struct WalkedPath {
+    /// Absolute path; for files from another `FileSource`, whatever
+    /// path that source reads them by.
+    pub path: PathBuf,
+    /// Path relative to the repo root.
+    pub rel_path: String,
+    /// File size at walk time, in bytes.
+    pub size: u64,
+    /// Modification time at walk time, recorded in the manifest.
+    pub mtime: Option<SystemTime>,
+}
+
+/// Walks the repository like [`walk_repo_with_ignores`], but only stats
+/// files — nothing is read. Pair with [`read_walked_path`] to load them.
+pub fn walk_paths_with_ignores(
+    root: &Path,
+    max_file_size: u64,
+    ignore_patterns: &[String],
+) -> Vec<WalkedPath> {
+    walk_paths_with_skips(root, max_file_size, ignore_patterns).0
+}
+
+/// Like [`walk_paths_with_ignores`], also returning the number of files
+/// left out for being larger than `max_file_size` (ignored files aren't
+/// counted).
+pub fn walk_paths_with_skips(
+    root: &Path,
+    max_file_size: u64,
+    ignore_patterns: &[String],
+) -> (Vec<WalkedPath>, usize) {
+    let mut paths = Vec::new();
+    let mut too_large = 0;
+    let extra = build_pattern_matcher(root, ignore_patterns);
 
     let walker = WalkBuilder::new(root)
         .follow_links(false)
         .hidden(false) // don't skip dotfiles (gitignore handles that)
-        .filter_entry(|entry| {
+        .require_git(false) // honor .gitignore in plain directories too
+        .add_custom_ignore_filename(NSIGNORE_FILENAME)
+        .filter_entry(move |entry| {
             let name = entry.file_name().to_string_lossy();
+            let is_dir = entry.file_type().map_or(false, |ft| ft.is_dir());
             // Skip .git and .ns directories
-            if entry.file_type().map_or(false, |ft| ft.is_dir()) {
-                return name != ".git" && name != ".ns";
+            if is_dir && (name == ".git" || name == ".ns") {
+                return false;
             }
-            true
+            !extra.matched(entry.path(), is_dir).is_ignore()
         })
         .build();
 
@@ -63,48 +132,242 @@ pub fn walk_repo(root: &Path, max_file_size: u64) -> Vec<WalkedFile> {
             }
         };
         if metadata.len() > max_file_size {
+            too_large += 1;
             continue;
         }
 
-        // Single read: max_file_size guard above caps memory usage.
-        // Check first 512 bytes for null bytes (binary detection) on the same buffer.
-        let raw = match std::fs::read(path) {
-            Ok(bytes) => bytes,
-            Err(err) => {
-                eprintln!("warning: cannot read {}: {}", path.display(), err);
-                continue;
-            }
-        };
-        let check_len = raw.len().min(512);
-        if raw[..check_len].contains(&0) {
-            continue;
-        }
-
-        // UTF-8 check
-        let content = match String::from_utf8(raw) {
-            Ok(s) => s,
-            Err(_) => {
-                eprintln!("warning: skipping non-UTF-8 file: {}", path.display());
-                continue;
-            }
-        };
-
         // Compute relative path
         let rel_path = match path.strip_prefix(root) {
             Ok(rel) => rel.to_string_lossy().to_string(),
             Err(_) => path.to_string_lossy().to_string(),
         };
 
-        let lang = detect_language(path).map(|s| s.to_string());
-
-        files.push(WalkedFile {
+        paths.push(WalkedPath {
+            path: path.to_path_buf(),
             rel_path,
-            content,
-            lang,
+            size: metadata.len(),
+            mtime: metadata.modified().ok(),
         });
     }
 
-    files
+    (paths, too_large)
+}
+
+/// Bytes from the start of a file that [`looks_binary`] inspects.
+const BINARY_SAMPLE_LEN: usize = 8192;
+
+/// Returns `true` if `bytes` look like binary data rather than text: the
+/// first 8 KB contain a NUL byte, or more than 10% of them are control
+/// characters. Tab, newline, carriage return, form feed and escape (ANSI
+/// colors in logs) count as text.
+pub fn looks_binary(bytes: &[u8]) -> bool {
+    let sample = &bytes[..bytes.len().min(BINARY_SAMPLE_LEN)];
+    if sample.contains(&0) {
+        return true;
+    }
+    let control = sample
+        .iter()
+        .filter(|&&b| (b < 0x20 && !matches!(b, b'\t' | b'\n' | b'\r' | 0x0c | 0x1b)) || b == 0x7f)
+        .count();
+    control * 10 > sample.len()
+}
+
+/// Whether file content can be indexed: valid UTF-8 and, with
+/// `skip_binary`, not [`looks_binary`].
+pub fn is_text(bytes: &[u8], skip_binary: bool) -> bool {
+    !(skip_binary && looks_binary(bytes)) && std::str::from_utf8(bytes).is_ok()
+}
+
+/// Why [`read_file`] returned no file.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Skipped {
+    /// Binary content (see [`looks_binary`]) or not valid UTF-8.
+    Binary,
+    /// The file could not be read, or is corrupt gzip; a warning was
+    /// printed.
+    Unreadable,
+    /// A gzip file that decompresses to more than the size limit.
+    TooLarge,
+}
+
+/// Reads a walked file, skipping binary and non-UTF-8 content. Returns
+/// `None` for skipped or unreadable files.
+pub fn read_walked_path(walked: &WalkedPath, max_file_size: u64) -> Option<WalkedFile> {
+    read_file(walked, true, max_file_size).ok()
+}
+
+/// Like [`read_walked_path`], with the reason a file was skipped. With
+/// `skip_binary` off, only invalid UTF-8 counts as binary.
+///
+/// Gzip files are decompressed (see `gzip::decompress`) and indexed as
+/// their content, or skipped as [`Skipped::TooLarge`] if that is over
+/// `max_file_size` — the walk only checked their compressed size.
+pub fn read_file(
+    walked: &WalkedPath,
+    skip_binary: bool,
+    max_file_size: u64,
+) -> Result<WalkedFile, Skipped> {
+    let path = walked.path.as_path();
+
+    // Single read: the walk's max_file_size guard caps memory usage.
+    // Binary detection samples the start of the same buffer.
+    let raw = match std::fs::read(path) {
+        Ok(bytes) => bytes,
+        Err(err) => {
+            eprintln!("warning: cannot read {}: {}", path.display(), err);
+            return Err(Skipped::Unreadable);
+        }
+    };
+    let raw = match decompress(path, raw, max_file_size) {
+        Ok(content) => content,
+        Err(GzipError::TooLarge) => return Err(Skipped::TooLarge),
+        Err(GzipError::Corrupt(err)) => {
+            eprintln!("warning: cannot read {}: {}", path.display(), err);
+            return Err(Skipped::Unreadable);
+        }
+    };
+    decode_file(walked, raw, skip_binary)
+}
+
+/// Turns the bytes read for `walked`, decompressed if they were gzip, into
+/// a [`WalkedFile`], as [`read_file`] does after reading them from disk.
+/// The language of a gzip file is that of the name without `.gz`.
+pub fn decode_file(
+    walked: &WalkedPath,
+    raw: Vec<u8>,
+    skip_binary: bool,
+) -> Result<WalkedFile, Skipped> {
+    let path = walked.path.as_path();
+    if skip_binary && looks_binary(&raw) {
+        return Err(Skipped::Binary);
+    }
+
+    let content = String::from_utf8(raw).map_err(|_| Skipped::Binary)?;
+
+    let lang = detect_language_with_content(&logical_path(path), &content).map(|s| s.to_string());
+    Ok(WalkedFile {
+        rel_path: walked.rel_path.clone(),
+        content,
+        lang,
+        mtime: walked.mtime,
+    })
+}
+
+/// Gitignore-style rules for checking single paths outside a walk.
+///
+/// Used by incremental indexing, where changed paths come from `git diff`
+/// or the manifest rather than from [`walk_repo`]. Applies the same rules
+/// from the same files: explicit patterns, then `.nsignore` / `.ignore` /
+/// `.gitignore` from the deepest directory up to the root (first match
+/// wins), then the repo's `.git/info/exclude` and last the global git
+/// excludes file (`core.excludesFile`).
+pub struct IgnoreRules {
+    root: PathBuf,
+    extra: Gitignore,
+    per_dir: HashMap<PathBuf, Gitignore>,
+    git_exclude: Gitignore,
+    git_global: Gitignore,
+}
+
+impl IgnoreRules {
+    pub fn new(root: &Path, ignore_patterns: &[String]) -> Self {
+        let (git_global, err) = Gitignore::global();
+        if let Some(err) = err {
+            eprintln!("warning: global git excludes: {}", err);
+        }
+        Self {
+            root: root.to_path_buf(),
+            extra: build_pattern_matcher(root, ignore_patterns),
+            per_dir: HashMap::new(),
+            git_exclude: git_exclude_matcher(root),
+            git_global,
+        }
+    }
+
+    /// Returns `true` if the file at `rel_path` (relative to the root) is ignored.
+    pub fn is_ignored(&mut self, rel_path: &str) -> bool {
+        let abs_path = self.root.join(rel_path);
+        if self.extra.matched_path_or_any_parents(&abs_path, false).is_ignore() {
+            return true;
+        }
+
+        let mut dir = abs_path.parent();
+        while let Some(d) = dir {
+            if !d.starts_with(&self.root) {
+                break;
+            }
+            let matcher = self
+                .per_dir
+                .entry(d.to_path_buf())
+                .or_insert_with(|| dir_matcher(d));
+            let m = matcher.matched_path_or_any_parents(&abs_path, false);
+            if m.is_ignore() {
+                return true;
+            }
+            if m.is_whitelist() {
+                return false;
+            }
+            dir = d.parent();
+        }
+
+        let m = self.git_exclude.matched_path_or_any_parents(&abs_path, false);
+        if !m.is_none() {
+            return m.is_ignore();
+        }
+        // The global file isn't rooted in the repo: match the relative path.
+        self.git_global
+            .matched_path_or_any_parents(Path::new(rel_path), false)
+            .is_ignore()
+    }
+}
+
+/// Builds a matcher for the `.gitignore`, `.ignore` and `.nsignore` in
+/// `dir`. Later files' patterns win, so `.nsignore` overrides the others,
+/// as it does in a walk.
+fn dir_matcher(dir: &Path) -> Gitignore {
+    let mut builder = GitignoreBuilder::new(dir);
+    for name in [".gitignore", ".ignore", NSIGNORE_FILENAME] {
+        let path = dir.join(name);
+        if path.is_file() {
+            if let Some(err) = builder.add(&path) {
+                eprintln!("warning: {}: {}", path.display(), err);
+            }
+        }
+    }
+    builder.build().unwrap_or_else(|_| Gitignore::empty())
+}
+
+/// Builds a matcher for `root/.git/info/exclude`, the repo's untracked
+/// ignore rules.
+fn git_exclude_matcher(root: &Path) -> Gitignore {
+    let path = root.join(".git/info/exclude");
+    if !path.is_file() {
+        return Gitignore::empty();
+    }
+    let mut builder = GitignoreBuilder::new(root);
+    if let Some(err) = builder.add(&path) {
+        eprintln!("warning: {}: {}", path.display(), err);
+    }
+    builder.build().unwrap_or_else(|_| Gitignore::empty())
+}
+
+/// Builds a matcher for explicit ignore patterns rooted at `root`.
+///
+/// Invalid patterns are reported on stderr and skipped.
+pub(crate) fn build_pattern_matcher(root: &Path, patterns: &[String]) -> Gitignore {
+    if patterns.is_empty() {
+        return Gitignore::empty();
+    }
+    let mut builder = GitignoreBuilder::new(root);
+    for pattern in patterns {
+        if let Err(err) = builder.add_line(None, pattern) {
+            eprintln!("warning: invalid ignore pattern '{}': {}", pattern, err);
+        }
+    }
+    builder.build().unwrap_or_else(|err| {
+        eprintln!("warning: invalid ignore patterns: {}", err);
+        Gitignore::empty()
+    })
 }
 
 #[cfg(test)]
@@ -136,7 +399,28 @@ mod tests {
         assert_eq!(rs_file.lang.as_deref(), Some("rust"));
 
         let md_file = files.iter().find(|f| f.rel_path.contains("README.md")).unwrap();
-        assert_eq!(md_file.lang, None);
+        assert_eq!(md_file.lang.as_deref(), Some("markdown"));
+
+        let json_file = files.iter().find(|f| f.rel_path.contains("config.json")).unwrap();
+        assert_eq!(json_file.lang, None);
+    }
+
+    #[test]
+    fn binary_detection_samples_nul_and_control_bytes() {
+        assert!(!looks_binary(b"fn main() {\n\tprintln!(\"hi\");\r\n}\n"));
+        assert!(!looks_binary("\x1b[31merror\x1b[0m: caf\u{e9}\n".as_bytes()));
+        assert!(!looks_binary(b""));
+        assert!(looks_binary(b"header\0payload"));
+        assert!(looks_binary(b"\x01\x02\x03\x04abcdef"));
+
+        // Only the first 8 KB are sampled.
+        let mut late_nul = vec![b'a'; BINARY_SAMPLE_LEN];
+        late_nul.push(0);
+        assert!(!looks_binary(&late_nul));
+
+        assert!(is_text(b"a\0b", false));
+        assert!(!is_text(b"a\0b", true));
+        assert!(!is_text(b"\xff\xfe", false));
     }
 
     #[test]
@@ -152,4 +436,107 @@ mod tests {
             files.len()
         );
     }
+
+    fn write(root: &Path, rel: &str, content: &str) {
+        let path = root.join(rel);
+        std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+        std::fs::write(path, content).unwrap();
+    }
+
+    fn walked_paths(root: &Path, patterns: &[String]) -> Vec<String> {
+        let mut paths: Vec<String> = walk_repo_with_ignores(root, 1_048_576, patterns)
+            .into_iter()
+            .map(|f| f.rel_path.replace('\\', "/"))
+            .collect();
+        paths.sort();
+        paths
+    }
+
+    #[test]
+    fn gitignore_respected_without_git_repo() {
+        let dir = tempfile::tempdir().unwrap();
+        let root = dir.path();
+        write(root, ".gitignore", "*.tmp\nbuild/\n*.log\n!keep.log\n");
+        write(root, "src/main.rs", "fn main() {}");
+        write(root, "scratch.tmp", "junk");
+        write(root, "build/out.rs", "fn generated() {}");
+        write(root, "debug.log", "noise");
+        write(root, "keep.log", "signal");
+
+        assert_eq!(walked_paths(root, &[]), vec![".gitignore", "keep.log", "src/main.rs"]);
+    }
+
+    #[test]
+    fn nested_gitignore_applies_to_its_subtree() {
+        let dir = tempfile::tempdir().unwrap();
+        let root = dir.path();
+        write(root, "notes.txt", "top");
+        write(root, "docs/.gitignore", "*.txt\n");
+        write(root, "docs/draft.txt", "nested");
+        write(root, "docs/guide.md", "guide");
+
+        assert_eq!(
+            walked_paths(root, &[]),
+            vec!["docs/.gitignore", "docs/guide.md", "notes.txt"]
+        );
+    }
+
+    #[test]
+    fn nsignore_and_explicit_patterns() {
+        let dir = tempfile::tempdir().unwrap();
+        let root = dir.path();
+        write(root, ".nsignore", "vendor/\n");
+        write(root, "vendor/lib.rs", "fn vendored() {}");
+        write(root, "src/lib.rs", "fn lib() {}");
+        write(root, "src/lib_test.rs", "fn test() {}");
+        write(root, "src/snapshot_test.rs", "fn keep() {}");
+
+        let patterns = vec!["*_test.rs".to_string(), "!snapshot_test.rs".to_string()];
+        assert_eq!(
+            walked_paths(root, &patterns),
+            vec![".nsignore", "src/lib.rs", "src/snapshot_test.rs"]
+        );
+    }
+
+    #[test]
+    fn ignore_rules_agree_with_walker() {
+        let dir = tempfile::tempdir().unwrap();
+        let root = dir.path();
+        write(root, ".gitignore", "*.tmp\nnode_modules/\n");
+        write(root, "docs/.nsignore", "drafts/\n");
+
+        let mut rules = IgnoreRules::new(root, &["*.bak".to_string()]);
+        assert!(rules.is_ignored("a.tmp"));
+        assert!(rules.is_ignored("node_modules/pkg/index.js"));
+        assert!(rules.is_ignored("docs/drafts/todo.md"));
+        assert!(rules.is_ignored("src/old.bak"));
+        assert!(!rules.is_ignored("src/main.rs"));
+        assert!(!rules.is_ignored("drafts/todo.md"));
+    }
+
+    #[test]
+    fn ignore_rules_read_git_exclude_and_dot_ignore_like_walker() {
+        let dir = tempfile::tempdir().unwrap();
+        let root = dir.path();
+        write(root, ".git/info/exclude", "*.local\nscratch/\n");
+        write(root, ".gitignore", "!keep.local\n");
+        write(root, "src/.ignore", "gen/\n");
+        write(root, "src/main.rs", "fn main() {}");
+        write(root, "src/gen/out.rs", "fn generated() {}");
+        write(root, "env.local", "secret");
+        write(root, "keep.local", "kept");
+        write(root, "scratch/notes.md", "notes");
+
+        assert_eq!(
+            walked_paths(root, &[]),
+            vec![".gitignore", "keep.local", "src/.ignore", "src/main.rs"]
+        );
+        let mut rules = IgnoreRules::new(root, &[]);
+        for path in ["src/gen/out.rs", "env.local", "scratch/notes.md"] {
+            assert!(rules.is_ignored(path), "{path}");
+        }
+        for path in ["src/main.rs", "keep.local"] {
+            assert!(!rules.is_ignored(path), "{path}");
+        }
+    }
 }
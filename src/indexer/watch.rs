@@ -0,0 +1,126 @@
+use std::path::Path;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::mpsc::{self, RecvTimeoutError};
+use std::time::{Duration, Instant};
+
+use notify::{Event, RecursiveMode, Watcher};
+
+use crate::error::NsError;
+
+use super::incremental::{run_incremental, IncrementalStats};
+
+/// How often [`watch`] checks its stop flag while no events arrive.
+const STOP_POLL: Duration = Duration::from_millis(100);
+
+/// Options for [`watch`] — maps 1:1 to `ns index --watch` flags.
+#[derive(Debug, Clone)]
+pub struct WatchOptions {
+    /// Quiet period after the last file event before the index is updated,
+    /// so a burst (a `git checkout`, a formatter run) becomes one update.
+    pub debounce: Duration,
+    /// Files larger than this (in bytes) are skipped, as in
+    /// [`super::run_incremental_index`].
+    pub max_file_size: u64,
+}
+
+impl Default for WatchOptions {
+    fn default() -> Self {
+        Self {
+            debounce: Duration::from_millis(300),
+            max_file_size: 1_048_576,
+        }
+    }
+}
+
+/// Keeps the index at `root` current until `stop` is set.
+///
+/// Watches the tree with the OS file notification API (inotify, FSEvents,
+/// ReadDirectoryChangesW) and, once events have been quiet for
+/// `opts.debounce`, runs an incremental update — the same change detection
+/// as `ns index --incremental`, so creates, edits, deletes and renames are
+/// all picked up, and ignore rules and index settings from the last full
+/// build apply. Events under `.ns/` and `.git/` are ignored.
+///
+/// Searches stay consistent during an update: tantivy readers see the last
+/// commit until the update's single commit lands. Each update's outcome is
+/// passed to `on_update`; failures there (including a lock held by another
+/// `ns index`) don't stop watching, and a locked update is retried after
+/// the next quiet period. Watcher errors are also reported and trigger a
+/// full rescan.
+///
+/// Returns once `stop` is set, after dropping the watcher, which releases
+/// its OS watches. Fails only if the watch cannot be set up.
+pub fn watch(
+    root: &Path,
+    opts: &WatchOptions,
+    stop: &AtomicBool,
+    mut on_update: impl FnMut(Result<IncrementalStats, NsError>),
+) -> Result<(), NsError> {
+    // Event paths are absolute and canonical on some platforms.
+    let root = root.canonicalize()?;
+    let (tx, rx) = mpsc::channel::<notify::Result<Event>>();
+    let mut watcher = notify::recommended_watcher(tx)?;
+    watcher.watch(&root, RecursiveMode::Recursive)?;
+
+    let mut last_event: Option<Instant> = None;
+    while !stop.load(Ordering::Relaxed) {
+        match rx.recv_timeout(STOP_POLL) {
+            Ok(Ok(event)) => {
+                if is_relevant(&root, &event) {
+                    last_event = Some(Instant::now());
+                }
+            }
+            Ok(Err(err)) => {
+                on_update(Err(err.into()));
+                last_event = Some(Instant::now());
+            }
+            Err(RecvTimeoutError::Timeout) => {}
+            Err(RecvTimeoutError::Disconnected) => break,
+        }
+
+        if last_event.is_some_and(|t| t.elapsed() >= opts.debounce) {
+            let result = run_incremental(&root, opts.max_file_size);
+            last_event = match result {
+                Err(ref err) if err.is_lock_error() => Some(Instant::now()),
+                _ => None,
+            };
+            on_update(result);
+        }
+    }
+    drop(watcher);
+    Ok(())
+}
+
+/// True if `event` may change what the index should hold: not a read, and
+/// touching at least one path outside `.ns/` (the index's own writes) and
+/// `.git/`.
+fn is_relevant(root: &Path, event: &Event) -> bool {
+    if event.kind.is_access() {
+        return false;
+    }
+    let ns_dir = root.join(".ns");
+    let git_dir = root.join(".git");
+    event
+        .paths
+        .iter()
+        .any(|p| !p.starts_with(&ns_dir) && !p.starts_with(&git_dir))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use notify::event::{AccessKind, CreateKind, ModifyKind};
+    use notify::EventKind;
+
+    #[test]
+    fn ignores_reads_and_index_writes() {
+        let root = Path::new("/repo");
+        let event = |kind, path: &str| Event::new(kind).add_path(root.join(path));
+
+        assert!(is_relevant(root, &event(EventKind::Create(CreateKind::File), "src/main.rs")));
+        assert!(is_relevant(root, &event(EventKind::Modify(ModifyKind::Any), "README.md")));
+        assert!(!is_relevant(root, &event(EventKind::Access(AccessKind::Read), "src/main.rs")));
+        assert!(!is_relevant(root, &event(EventKind::Modify(ModifyKind::Any), ".ns/meta.json")));
+        assert!(!is_relevant(root, &event(EventKind::Modify(ModifyKind::Any), ".git/index")));
+    }
+}
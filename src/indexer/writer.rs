@@ -1,18 +1,37 @@
+use std::collections::BTreeMap;
 use std::fs;
 use std::path::Path;
 use std::time::Instant;
 
 use serde::{Deserialize, Serialize};
-use tantivy::tokenizer::{LowerCaser, TextAnalyzer, WhitespaceTokenizer};
+use tantivy::tokenizer::{
+    LowerCaser, RemoveLongFilter, StopWordFilter, TextAnalyzer, WhitespaceTokenizer,
+};
+use tantivy::schema::{Field, Schema};
 use tantivy::{Index, IndexWriter, TantivyDocument};
 
-use crate::error::NsError;
+use crate::error::{IndexErrors, NsError};
 use crate::schema::{
-    build_schema, content_field, lang_field, path_field, symbols_field, symbols_raw_field,
+    build_schema_with_fields, content_cased_field, content_field, doc_fields,
+    lang_field, line_index_field, mtime_field, path_field, path_text_field, simhash_field, symbols_field, symbols_raw_field, token_class_field,
+    token_offsets_field, trigrams_field,
 };
 
-use super::symbols::extract_symbols;
-use super::walker::WalkedFile;
+use super::analyzer::{Analyzer, DroppedTerms};
+use super::classes::TokenClass;
+use super::definitions::{remove_definitions, write_definitions, Definitions};
+use super::fields::{check_fields, read_field_values, write_field_values, DocField};
+use super::lines::LineIndex;
+use super::manifest::{write_manifest, Manifest};
+use super::metadata::{read_metadata, write_metadata};
+use super::offsets::OffsetIndex;
+use super::pipeline::prepare_files;
+use super::simhash::simhash;
+use super::source::FileSource;
+use super::tokenizer::{CodeTokenizer, Stemming};
+use super::trigrams::add_trigrams;
+use super::walker::WalkedPath;
+use super::IndexOptions;
 
 /// Metadata written to `.ns/meta.json` after indexing.
 #[derive(Serialize, Deserialize, Debug)]
@@ -22,90 +41,271 @@ pub struct IndexMeta {
     pub git_commit: Option<String>,
     pub file_count: usize,
     pub index_size_bytes: u64,
+    /// Extra ignore patterns from the last full build (`ns index --ignore`).
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub ignore_patterns: Vec<String>,
+    /// Stop words the index was built with (`ns index --stop-words`).
+    /// Indexes from before stop words were supported have none.
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub stop_words: Vec<String>,
+    /// Stemmer applied to `content` (`ns index --stem`), by
+    /// [`Stemming::name`]. `None` means no stemming.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub stemmer: Option<String>,
+    /// The `content` analyzer, by [`Analyzer::spec`]. Indexes from before
+    /// analyzers were configurable have none: theirs is the default one,
+    /// stemmed with `stemmer`.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub analyzer: Option<String>,
+    /// Whether `.ns/definitions.json` is maintained (`ns index --definitions`).
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub definitions: bool,
+    /// Whether `content_cased` is filled, for case-sensitive search
+    /// (`ns index --case-sensitive`).
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub case_sensitive: bool,
+    /// Whether binary-looking files are left out (off with
+    /// `ns index --include-binary`). Older indexes always skipped them.
+    #[serde(default = "skip_binary_default")]
+    pub skip_binary: bool,
+    /// Whether `line_index` is filled, so results carry their matched
+    /// lines (`ns index --track-lines`).
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub track_lines: bool,
+    /// Whether `token_offsets` is filled, so results can carry the byte
+    /// offsets of their matched terms (`ns index --track-offsets`).
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub track_offsets: bool,
+    /// Whether plain query terms also match `path_text`, so files are found
+    /// by their directory and file names alone (`ns index --match-paths`).
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub match_paths: bool,
+    /// Whether `mtime` is filled, so results can be sorted newest first
+    /// (`ns index --track-mtime`).
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub track_mtime: bool,
+    /// Whether Go files fill `content_code`, `content_comment` and
+    /// `content_string`, so searches can skip comments or strings
+    /// (`ns index --token-classes`).
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub token_classes: bool,
+    /// Whether `trigrams` is filled, so regex and substring searches can
+    /// skip files lacking a match's trigrams (`ns index --trigrams`).
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub trigrams: bool,
+    /// The fields declared with `ns index --field`, each a schema field
+    /// searched at its boost (see `fields::DocField`).
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub fields: Vec<DocField>,
+    /// Bumped by every full build, incremental update that changes the
+    /// index, merge and metadata change, so long-lived searchers
+    /// (`cached::CachedSearcher`) can tell the index changed under them.
+    #[serde(default)]
+    pub generation: u64,
+}
+
+fn skip_binary_default() -> bool {
+    true
+}
+
+impl IndexMeta {
+    /// The analyzer `content` was indexed with, which queries must use too.
+    /// Fails on a stemmer or analyzer this binary doesn't support.
+    pub fn content_analyzer(&self) -> Result<Analyzer, NsError> {
+        let stemming = Stemming::from_name(self.stemmer.as_deref())?;
+        match self.analyzer {
+            Some(ref spec) => Analyzer::parse(spec),
+            None => Ok(Analyzer::with_stemming(stemming)),
+        }
+    }
 }
 
 /// Current schema version. Bump when schema changes.
-pub const SCHEMA_VERSION: u32 = 2;
+pub const SCHEMA_VERSION: u32 = 11;
 
 /// Stats returned by a full index build.
 #[derive(Debug)]
 pub struct FullIndexStats {
     pub file_count: usize,
     pub elapsed_ms: u64,
+    /// Files left out for exceeding `IndexOptions::max_file_size`, on disk
+    /// or, for gzip files, decompressed.
+    pub skipped_too_large: usize,
+    /// Files left out as binary or non-UTF-8.
+    pub skipped_binary: usize,
+    /// Files left out because they couldn't be read, each with its error.
+    /// Always empty with `IndexOptions::strict`, which fails instead.
+    pub unreadable: IndexErrors,
+    /// Distinct tokens of `content` and `path_text` left out of the index
+    /// by the analyzer's `Filter::Length` (`--min-token-length`,
+    /// `--max-token-length`).
+    pub filtered_terms: usize,
 }
 
-/// Registers the custom "symbol" tokenizer on a tantivy index.
-pub fn register_symbol_tokenizer(index: &Index) {
-    let tokenizer = TextAnalyzer::builder(WhitespaceTokenizer::default())
+/// Registers the custom tokenizers on a tantivy index:
+/// - "symbol": whitespace + lowercase, for the `symbols` field
+/// - "code": the `content` pipeline, `analyzer` (by default camelCase/snake_case
+///   splitting + lowercase), for `content` and `path_text`
+/// - "code_cased": camelCase/snake_case splitting with case kept, for `content_cased`
+///
+/// "symbol" drops `stop_words`, as does "code" if `analyzer` has a stop word
+/// filter. "code_cased" neither drops nor stems: a case-sensitive query
+/// matches text exactly as written. The query parser
+/// analyzes query text with the same tokenizers, so queries and documents
+/// always agree on which words are dropped and how they are stemmed.
+pub fn register_tokenizers(index: &Index, stop_words: &[String], analyzer: &Analyzer) {
+    let symbol = TextAnalyzer::builder(WhitespaceTokenizer::default())
         .filter(LowerCaser)
+        .filter(StopWordFilter::remove(stop_words.to_vec()))
+        .build();
+    index.tokenizers().register("symbol", symbol);
+
+    index.tokenizers().register("code", analyzer.text_analyzer(stop_words));
+
+    let code_cased = TextAnalyzer::builder(CodeTokenizer::default())
+        .filter(RemoveLongFilter::limit(40))
         .build();
-    index.tokenizers().register("symbol", tokenizer);
+    index.tokenizers().register("code_cased", code_cased);
 }
 
-/// Builds the tantivy index from walked files.
+/// Builds the tantivy index at `root/.ns/` from files walked in `source`.
+///
+/// Files are read and parsed on `opts.threads` workers (see
+/// `pipeline::prepare_files`) and added in path order, whatever order
+/// `paths` lists them in, on one tantivy indexing thread: documents are
+/// numbered as they are added, so two builds of the same files with the
+/// same options assign the same document IDs and write the same segment
+/// files. The index is built
+/// in `.ns/index.new/` and only replaces `.ns/index/` once committed;
+/// returns `None`, leaving any existing index alone, if none of `paths` is
+/// indexable. Files that can't be read are skipped and listed in
+/// `FullIndexStats::unreadable`, or fail the build with `opts.strict`.
+///
+/// `opts.cancel` is checked before each document. On cancellation or any
+/// other error before the swap, the staging directory is removed and the
+/// previous index and `meta.json` are left as they were.
 ///
-/// Creates `.ns/index/` directory, writes documents, commits, and writes `meta.json`.
-/// Returns index stats (file count, elapsed time). Does not print to stderr.
-pub fn build_index(root: &Path, files: &[WalkedFile]) -> Result<FullIndexStats, NsError> {
+/// Commits, then writes `meta.json` plus `manifest.json` (per-file
+/// fingerprints for incremental runs), and `definitions.json` with
+/// `opts.definitions`. Files with values in `.ns/fields.json` get them in
+/// their `opts.fields`; values of files no longer indexed are dropped.
+/// Returns index stats (file count, elapsed time). Does not print to
+/// stderr.
+pub fn build_index(
+    root: &Path,
+    source: &dyn FileSource,
+    paths: &[WalkedPath],
+    opts: &IndexOptions,
+) -> Result<Option<FullIndexStats>, NsError> {
     let ns_dir = root.join(".ns");
     let index_dir = ns_dir.join("index");
+    let staging_dir = ns_dir.join("index.new");
 
-    // Wipe existing index for a clean full rebuild.
-    // create_in_dir requires an empty (or non-existent) directory.
-    if index_dir.exists() {
-        fs::remove_dir_all(&index_dir)?;
-    }
-    fs::create_dir_all(&index_dir)?;
-
-    let schema = build_schema();
-    let index = Index::create_in_dir(&index_dir, schema.clone())?;
-    register_symbol_tokenizer(&index);
-
+    check_fields(&opts.fields)?;
+    let schema = build_schema_with_fields(&opts.fields);
     let content = content_field(&schema);
+    let content_cased = content_cased_field(&schema);
     let symbols = symbols_field(&schema);
     let symbols_raw = symbols_raw_field(&schema);
     let path = path_field(&schema);
+    let path_text = path_text_field(&schema);
     let lang = lang_field(&schema);
-
-    // 50 MB heap for the writer
-    let mut writer: IndexWriter = index.writer(50_000_000)?;
+    let fingerprint = simhash_field(&schema);
+    let line_index = line_index_field(&schema);
+    let token_offsets = token_offsets_field(&schema);
+    let mtime = mtime_field(&schema);
+    let class_fs = TokenClass::ALL.map(|class| token_class_field(&schema, class));
+    let field_fs = doc_fields(&schema, &opts.fields);
+    let trigrams = trigrams_field(&schema);
+    let mut field_values = read_field_values(root)?;
+    let analyzer = opts.content_analyzer();
+    let mut positions = analyzer.position_analyzer();
 
     let start = Instant::now();
+    let mut writer: Option<IndexWriter> = None;
+    let mut manifest = Manifest::default();
+    let mut definitions = Definitions::default();
+    let dropped = DroppedTerms::default();
+
+    // The walk's order depends on the file system's directory order.
+    let mut paths = paths.to_vec();
+    paths.sort_by(|a, b| a.rel_path.cmp(&b.rel_path));
+
+    let prepared = prepare_files(source, &paths, opts, |prepared| {
+        opts.cancel.check()?;
+        let writer = match writer {
+            Some(ref mut w) => w,
+            None => {
+                writer.insert(create_index_writer(&staging_dir, &schema, opts, &dropped)?)
+            }
+        };
+        let file = prepared.file;
 
-    for file in files {
         let mut doc = TantivyDocument::new();
         doc.add_text(content, &file.content);
-
-        // Extract symbols via tree-sitter for supported languages
-        let symbol_names = file
-            .lang
-            .as_deref()
-            .map(|l| extract_symbols(l, file.content.as_bytes()))
-            .unwrap_or_default();
-
+        if opts.case_sensitive {
+            doc.add_text(content_cased, &file.content);
+        }
         // symbols: space-separated for tokenized search
-        doc.add_text(symbols, &symbol_names.join(" "));
+        doc.add_text(symbols, &prepared.symbols.join(" "));
         // symbols_raw: pipe-separated, original casing, for display
-        doc.add_text(symbols_raw, &symbol_names.join("|"));
+        doc.add_text(symbols_raw, &prepared.symbols.join("|"));
 
         doc.add_text(path, &file.rel_path);
+        doc.add_text(path_text, &file.rel_path);
         if let Some(ref lang_str) = file.lang {
             doc.add_text(lang, lang_str);
         }
+        doc.add_u64(fingerprint, simhash(&file.content));
+        if opts.track_lines {
+            let lines = LineIndex::build(&file.content, &mut positions);
+            doc.add_bytes(line_index, lines.encode().as_slice());
+        }
+        if opts.track_offsets {
+            let offsets = OffsetIndex::build(&file.content, &mut positions);
+            doc.add_bytes(token_offsets, offsets.encode().as_slice());
+        }
+        if opts.track_mtime {
+            doc.add_u64(mtime, prepared.manifest_entry.mtime_ns);
+        }
+        if let Some(ref classes) = prepared.classes {
+            for (class_f, class) in class_fs.into_iter().zip(TokenClass::ALL) {
+                doc.add_text(class_f, classes.get(class));
+            }
+        }
+        if opts.trigrams {
+            add_trigrams(&mut doc, trigrams, &file.content);
+        }
+        if let Some(values) = field_values.get(&file.rel_path) {
+            add_field_values(&mut doc, &field_fs, &opts.fields, values);
+        }
         writer.add_document(doc)?;
+        if opts.definitions {
+            definitions.insert(&file.rel_path, file.content.as_bytes());
+        }
+        manifest.files.insert(file.rel_path, prepared.manifest_entry);
+        Ok(())
+    });
+
+    let committed = prepared.and_then(|skipped| {
+        Ok(commit_writer(writer.take(), opts)?.then_some(skipped))
+    });
+    let skips = match committed {
+        Ok(Some(skips)) => skips,
+        Ok(None) => return Ok(None),
+        Err(e) => {
+            drop(writer);
+            let _ = fs::remove_dir_all(&staging_dir);
+            return Err(e);
+        }
+    };
+    if index_dir.exists() {
+        fs::remove_dir_all(&index_dir)?;
     }
-
-    writer.commit()?;
-    // wait_merging_threads() consumes the writer and blocks until all background
-    // merge threads finish. IndexWriter::drop() merely kills merge threads without
-    // waiting, which can leave .tantivy-meta.lock held briefly after the function
-    // returns — causing "index is locked" errors for large repos.
-    writer
-        .wait_merging_threads()
-        .map_err(|e| NsError::Tantivy(e))?;
+    fs::rename(&staging_dir, &index_dir)?;
 
     let elapsed = start.elapsed();
-    let file_count = files.len();
+    let file_count = manifest.files.len();
 
     // Calculate index size
     let index_size = dir_size(&index_dir);
@@ -120,16 +320,118 @@ pub fn build_index(root: &Path, files: &[WalkedFile]) -> Result<FullIndexStats,
         git_commit,
         file_count,
         index_size_bytes: index_size,
+        ignore_patterns: opts.ignore_patterns.clone(),
+        stop_words: opts.stop_words.clone(),
+        stemmer: analyzer.stemming().name().map(str::to_string),
+        analyzer: Some(analyzer.spec()),
+        definitions: opts.definitions,
+        case_sensitive: opts.case_sensitive,
+        skip_binary: opts.skip_binary,
+        track_lines: opts.track_lines,
+        track_offsets: opts.track_offsets,
+        match_paths: opts.match_paths,
+        track_mtime: opts.track_mtime,
+        token_classes: opts.token_classes,
+        trigrams: opts.trigrams,
+        fields: opts.fields.clone(),
+        generation: read_meta(root).map_or(0, |m| m.generation) + 1,
     };
 
     let meta_path = ns_dir.join("meta.json");
     let meta_json = serde_json::to_string(&meta)?;
     fs::write(&meta_path, &meta_json)?;
 
-    Ok(FullIndexStats {
+    write_manifest(root, &manifest)?;
+
+    // Definition sites live beside the full-text index, which covers every
+    // file either way.
+    if opts.definitions {
+        write_definitions(root, &definitions)?;
+    } else {
+        remove_definitions(root)?;
+    }
+
+    // Metadata and field values outlive rebuilds; only files that are gone
+    // lose theirs.
+    let mut metadata = read_metadata(root)?;
+    if metadata.retain(|rel_path| manifest.files.contains_key(rel_path)) {
+        write_metadata(root, &metadata)?;
+    }
+    if field_values.retain(|rel_path| manifest.files.contains_key(rel_path)) {
+        write_field_values(root, &field_values)?;
+    }
+
+    Ok(Some(FullIndexStats {
         file_count,
         elapsed_ms: elapsed.as_millis() as u64,
-    })
+        skipped_too_large: skips.too_large,
+        skipped_binary: skips.binary,
+        unreadable: skips.unreadable,
+        filtered_terms: dropped.count(),
+    }))
+}
+
+/// Adds the `values` of the declared `fields` (with handles `field_fs`) to
+/// `doc`. Values of fields not declared are left out.
+pub(crate) fn add_field_values(
+    doc: &mut TantivyDocument,
+    field_fs: &[Field],
+    fields: &[DocField],
+    values: &BTreeMap<String, String>,
+) {
+    for (&field_f, field) in field_fs.iter().zip(fields) {
+        if let Some(value) = values.get(&field.name) {
+            doc.add_text(field_f, value);
+        }
+    }
+}
+
+/// Commits `writer`, if any documents were added, and waits for its merges.
+/// Returns whether there was a writer to commit.
+fn commit_writer(writer: Option<IndexWriter>, opts: &IndexOptions) -> Result<bool, NsError> {
+    let Some(mut writer) = writer else {
+        return Ok(false);
+    };
+    opts.cancel.check()?;
+    writer.commit()?;
+    // wait_merging_threads() consumes the writer and blocks until all background
+    // merge threads finish. IndexWriter::drop() merely kills merge threads without
+    // waiting, which can leave .tantivy-meta.lock held briefly after the function
+    // returns — causing "index is locked" errors for large repos.
+    writer
+        .wait_merging_threads()
+        .map_err(|e| NsError::Tantivy(e))?;
+    Ok(true)
+}
+
+/// Wipes `index_dir` and creates an empty index there, returning its writer.
+/// Its "code" tokenizer collects the tokens it drops by length in `dropped`.
+///
+/// The writer indexes on a single thread: with several, each document goes
+/// to whichever thread is free, so which segment holds it, and the order
+/// of documents in the merged index, would vary from build to build.
+fn create_index_writer(
+    index_dir: &Path,
+    schema: &Schema,
+    opts: &IndexOptions,
+    dropped: &DroppedTerms,
+) -> Result<IndexWriter, NsError> {
+    // Wipe existing index for a clean full rebuild.
+    // create_in_dir requires an empty (or non-existent) directory.
+    if index_dir.exists() {
+        fs::remove_dir_all(index_dir)?;
+    }
+    fs::create_dir_all(index_dir)?;
+
+    let index = Index::create_in_dir(index_dir, schema.clone())?;
+    let analyzer = opts.content_analyzer();
+    register_tokenizers(&index, &opts.stop_words, &analyzer);
+    index
+        .tokenizers()
+        .register("code", analyzer.counting_text_analyzer(&opts.stop_words, dropped));
+
+    // 50 MB heap for the writer
+    Ok(index.writer_with_num_threads(1, 50_000_000)?)
 }
 
 /// Opens an existing index at `.ns/index/` for reading or incremental writes.
@@ -150,9 +452,10 @@ pub fn open_index(root: &Path) -> Result<(Index, IndexMeta), NsError> {
     }
 
     let index_dir = root.join(".ns").join("index");
+    let analyzer = meta.content_analyzer()?;
     let index = Index::open_in_dir(&index_dir)?;
 
-    register_symbol_tokenizer(&index);
+    register_tokenizers(&index, &meta.stop_words, &analyzer);
     Ok((index, meta))
 }
 
@@ -197,7 +500,7 @@ pub(crate) fn get_git_commit(root: &Path) -> Option<String> {
         })
 }
 
-pub(crate) fn utc_timestamp_iso8601() -> String {
+pub fn utc_timestamp_iso8601() -> String {
     let secs = std::time::SystemTime::now()
         .duration_since(std::time::UNIX_EPOCH)
         .unwrap_or_default()
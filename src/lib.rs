@@ -1,3 +1,4 @@
+pub mod cancel;
 pub mod error;
 pub mod indexer;
 pub mod schema;
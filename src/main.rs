@@ -1,9 +1,4 @@
 mod cmd;
-mod error;
-mod indexer;
-mod schema;
-mod searcher;
-mod stats;
 
 use clap::Parser;
 use cmd::{Cli, Command, SearchArgs};
@@ -25,7 +20,9 @@ fn main() {
             cmd::search::run(&args, &argv);
         }
         Some(Command::Index(args)) => cmd::index::run(args),
-        Some(Command::Status) => cmd::status::run(),
+        Some(Command::Status(args)) => cmd::status::run(&args),
+        Some(Command::Suggest(args)) => cmd::suggest::run(args),
+        Some(Command::Def(args)) => cmd::def::run(args),
         Some(Command::Hooks { action }) => cmd::hooks::run(action),
         None => {
             // Default mode: search
@@ -1,26 +1,62 @@
 use tantivy::schema::{
-    Field, IndexRecordOption, Schema, TextFieldIndexing, TextOptions, STRING, STORED,
+    BytesOptions, Field, IndexRecordOption, Schema, TextFieldIndexing, TextOptions, STORED, STRING,
 };
 
+use crate::indexer::classes::TokenClass;
+use crate::indexer::fields::DocField;
+
 /// Builds the Tantivy schema for the nanosearch index.
 ///
 /// Fields:
-/// - `content`: full text of the file, indexed with default tokenizer, not stored
+/// - `content`: full text of the file, indexed with custom "code" tokenizer, not stored
+/// - `content_cased`: full text again with case preserved ("code_cased"), only
+///   filled in case-sensitive indexes, not stored
 /// - `symbols`: extracted symbol names, indexed with custom "symbol" tokenizer, not stored
 /// - `symbols_raw`: raw symbol string, untokenized and stored (for display)
 /// - `path`: file path relative to repo root, untokenized and stored
+/// - `path_text`: the same path with the "code" tokenizer, for filename boosts, not stored
 /// - `lang`: detected language name, untokenized and stored
+/// - `simhash`: near-duplicate fingerprint of the content, stored
+/// - `line_index`: line of each `content` token position, stored, only
+///   filled in `--track-lines` indexes
+/// - `token_offsets`: byte offset of each `content` token position, stored,
+///   only filled in `--track-offsets` indexes
+/// - `mtime`: modification time in nanoseconds since the Unix epoch,
+///   stored, only filled in `--track-mtime` indexes
+/// - `content_code`, `content_comment`, `content_string`: the code, comments
+///   and string literals of Go files ("code"), only filled in
+///   `--token-classes` indexes, not stored
+/// - `trigrams`: every case-folded trigram of the content, untokenized, only
+///   filled in `--trigrams` indexes, not stored
 pub fn build_schema() -> Schema {
+    build_schema_with_fields(&[])
+}
+
+/// Builds the schema of [`build_schema`] plus one text field per declared
+/// `fields` entry, named after it and tokenized like `content` (see
+/// `indexer::fields::DocField`). Names must have passed `check_fields`.
+pub fn build_schema_with_fields(fields: &[DocField]) -> Schema {
     let mut builder = Schema::builder();
 
-    // content: TEXT indexed with default tokenizer, positions for BM25, not stored
+    // content: TEXT indexed with custom "code" tokenizer (identifier splitting +
+    // lowercase), positions for BM25 and phrases, not stored. Registered at index open time.
     let content_options = TextOptions::default().set_indexing_options(
         TextFieldIndexing::default()
-            .set_tokenizer("default")
+            .set_tokenizer("code")
             .set_index_option(IndexRecordOption::WithFreqsAndPositions),
     );
     builder.add_text_field("content", content_options);
 
+    // content_cased: like `content` but without lowercasing, stop words or
+    // stemming, for case-sensitive search. Empty unless the index was built
+    // with `--case-sensitive`, so folded-only indexes pay nothing for it.
+    let content_cased_options = TextOptions::default().set_indexing_options(
+        TextFieldIndexing::default()
+            .set_tokenizer("code_cased")
+            .set_index_option(IndexRecordOption::WithFreqsAndPositions),
+    );
+    builder.add_text_field("content_cased", content_cased_options);
+
     // symbols: TEXT indexed with custom "symbol" tokenizer (whitespace + lowercase),
     // positions for BM25, not stored. The tokenizer itself is registered at index open time.
     let symbols_options = TextOptions::default().set_indexing_options(
@@ -39,9 +75,64 @@ pub fn build_schema() -> Schema {
     // path: STRING (untokenized) | STORED — used for delete_term in incremental indexing
     builder.add_text_field("path", STRING | STORED);
 
+    // path_text: the path tokenized like `content` (`src/event_store.rs` →
+    // src, event_store, event, store, rs) so query terms can match file
+    // and directory names. Scored only as an optional boost.
+    let path_text_options = TextOptions::default().set_indexing_options(
+        TextFieldIndexing::default()
+            .set_tokenizer("code")
+            .set_index_option(IndexRecordOption::WithFreqsAndPositions),
+    );
+    builder.add_text_field("path_text", path_text_options);
+
     // lang: STRING (untokenized) | STORED
     builder.add_text_field("lang", STRING | STORED);
 
+    // simhash: u64 | STORED — `indexer::simhash` of the content, read back
+    // for every hit when results are deduplicated. Never searched.
+    builder.add_u64_field("simhash", STORED);
+
+    // line_index: bytes | STORED — an encoded `indexer::lines::LineIndex`,
+    // read back for every loaded hit to turn matched positions into lines.
+    builder.add_bytes_field("line_index", BytesOptions::default().set_stored());
+
+    // token_offsets: bytes | STORED — an encoded `indexer::offsets::OffsetIndex`,
+    // read back for loaded hits of `SearchOptions::include_positions` searches.
+    builder.add_bytes_field("token_offsets", BytesOptions::default().set_stored());
+
+    // mtime: u64 | STORED — the file's mtime when indexed, read back for
+    // every hit of a `SortMode::ModTime` search. Never searched.
+    builder.add_u64_field("mtime", STORED);
+
+    // content_code, content_comment, content_string: a Go file's content
+    // split by `indexer::classes::TokenClass`, tokenized like `content`.
+    // Only matched against, to filter by class — scores and lines come
+    // from `content` — so other languages leave them empty.
+    for class in TokenClass::ALL {
+        let class_options = TextOptions::default().set_indexing_options(
+            TextFieldIndexing::default()
+                .set_tokenizer("code")
+                .set_index_option(IndexRecordOption::WithFreqsAndPositions),
+        );
+        builder.add_text_field(&token_class_field_name(class), class_options);
+    }
+
+    // trigrams: STRING (untokenized), one value per distinct trigram of the
+    // content (`indexer::trigrams`), matched as a set to narrow `--regex`
+    // and substring searches. Doc IDs only, no frequencies or positions.
+    builder.add_text_field("trigrams", STRING);
+
+    // Declared fields: searched by plain queries at their boost, and by
+    // `name:term`. Empty for files without values.
+    for field in fields {
+        let field_options = TextOptions::default().set_indexing_options(
+            TextFieldIndexing::default()
+                .set_tokenizer("code")
+                .set_index_option(IndexRecordOption::WithFreqsAndPositions),
+        );
+        builder.add_text_field(&field.name, field_options);
+    }
+
     builder.build()
 }
 
@@ -52,6 +143,13 @@ pub fn content_field(schema: &Schema) -> Field {
         .expect("schema missing 'content' field")
 }
 
+/// Returns the `content_cased` field handle.
+pub fn content_cased_field(schema: &Schema) -> Field {
+    schema
+        .get_field("content_cased")
+        .expect("schema missing 'content_cased' field")
+}
+
 /// Returns the `symbols` field handle.
 pub fn symbols_field(schema: &Schema) -> Field {
     schema
@@ -73,6 +171,13 @@ pub fn path_field(schema: &Schema) -> Field {
         .expect("schema missing 'path' field")
 }
 
+/// Returns the `path_text` field handle.
+pub fn path_text_field(schema: &Schema) -> Field {
+    schema
+        .get_field("path_text")
+        .expect("schema missing 'path_text' field")
+}
+
 /// Returns the `lang` field handle.
 pub fn lang_field(schema: &Schema) -> Field {
     schema
@@ -80,15 +185,74 @@ pub fn lang_field(schema: &Schema) -> Field {
         .expect("schema missing 'lang' field")
 }
 
+/// Returns the `simhash` field handle.
+pub fn simhash_field(schema: &Schema) -> Field {
+    schema
+        .get_field("simhash")
+        .expect("schema missing 'simhash' field")
+}
+
+/// Returns the `line_index` field handle.
+pub fn line_index_field(schema: &Schema) -> Field {
+    schema
+        .get_field("line_index")
+        .expect("schema missing 'line_index' field")
+}
+
+/// Returns the `token_offsets` field handle.
+pub fn token_offsets_field(schema: &Schema) -> Field {
+    schema
+        .get_field("token_offsets")
+        .expect("schema missing 'token_offsets' field")
+}
+
+/// Returns the `trigrams` field handle.
+pub fn trigrams_field(schema: &Schema) -> Field {
+    schema
+        .get_field("trigrams")
+        .expect("schema missing 'trigrams' field")
+}
+
+/// Returns the `mtime` field handle.
+pub fn mtime_field(schema: &Schema) -> Field {
+    schema
+        .get_field("mtime")
+        .expect("schema missing 'mtime' field")
+}
+
+/// Returns the handle of the `content_<class>` field holding the tokens of
+/// `class`.
+pub fn token_class_field(schema: &Schema, class: TokenClass) -> Field {
+    schema
+        .get_field(&token_class_field_name(class))
+        .expect("schema missing token class field")
+}
+
+fn token_class_field_name(class: TokenClass) -> String {
+    format!("content_{}", class.name())
+}
+
+/// Returns the handles of the declared `fields`, in order.
+pub fn doc_fields(schema: &Schema, fields: &[DocField]) -> Vec<Field> {
+    fields
+        .iter()
+        .map(|field| {
+            schema
+                .get_field(&field.name)
+                .expect("schema missing declared field")
+        })
+        .collect()
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
 
     #[test]
-    fn schema_has_five_fields() {
+    fn schema_has_fifteen_fields() {
         let schema = build_schema();
         let fields: Vec<_> = schema.fields().collect();
-        assert_eq!(fields.len(), 5, "schema should have exactly 5 fields");
+        assert_eq!(fields.len(), 15, "schema should have exactly 15 fields");
     }
 
     #[test]
@@ -96,9 +260,19 @@ mod tests {
         let schema = build_schema();
         // Each helper should return without panicking
         let _ = content_field(&schema);
+        let _ = content_cased_field(&schema);
         let _ = symbols_field(&schema);
         let _ = symbols_raw_field(&schema);
         let _ = path_field(&schema);
+        let _ = path_text_field(&schema);
         let _ = lang_field(&schema);
+        let _ = simhash_field(&schema);
+        let _ = line_index_field(&schema);
+        let _ = token_offsets_field(&schema);
+        let _ = mtime_field(&schema);
+        for class in TokenClass::ALL {
+            let _ = token_class_field(&schema, class);
+        }
+        let _ = trigrams_field(&schema);
     }
 }
@@ -0,0 +1,415 @@
+use std::borrow::Cow;
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::{Arc, Mutex, MutexGuard};
+use std::time::Instant;
+
+use tantivy::{Index, IndexReader};
+
+use crate::cancel::CancelToken;
+use crate::error::NsError;
+use crate::indexer::metadata::{read_metadata, Metadata};
+use crate::indexer::writer::{open_index, read_meta, IndexMeta};
+
+use super::query::{
+    create_reader_with_retry, search_index, SearchOptions, SearchResult, SearchStats,
+};
+use super::synonyms::SynonymMap;
+
+/// Options for [`CachedSearcher::open`].
+#[derive(Debug, Clone, Default)]
+pub struct SearcherOptions {
+    /// Most result pages kept, the least recently used evicted first.
+    /// 0, the default, caches nothing.
+    pub cache_size: usize,
+    /// Threads [`CachedSearcher::search_batch`] searches on. 0 means one
+    /// per CPU.
+    pub threads: usize,
+    /// Synonyms for every search whose `SearchOptions::synonyms` is
+    /// empty, so a server's domain vocabulary (`cfg` for `config`) needn't
+    /// be passed with each query. Changing them needs no reindex, only
+    /// reopening the searcher.
+    pub synonyms: SynonymMap,
+}
+
+/// How a [`CachedSearcher`]'s cache has done, for sizing it.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct CacheStats {
+    /// Searches answered from the cache.
+    pub hits: u64,
+    /// Searches run against the index.
+    pub misses: u64,
+    /// Pages currently cached.
+    pub entries: usize,
+    /// The generation of the index they came from (`IndexMeta::generation`).
+    pub generation: u64,
+}
+
+type Page = (Vec<SearchResult>, SearchStats);
+
+/// The index of one repo, opened once for many searches, with their result
+/// pages cached — for a long-running process answering the same popular
+/// queries against a mostly static index.
+///
+/// Every search first reads the index generation from `.ns/meta.json`,
+/// which full builds, incremental updates, merges and metadata changes
+/// bump. When it changed, in this process or in another `ns index`, the
+/// index is reopened and the cache emptied, so a page never outlives the
+/// index it was searched in. Pages are keyed by the query with whitespace
+/// collapsed and by every option but `cancel`.
+///
+/// Searches are ranked as by `query::execute_search`. A searcher can be
+/// shared between threads: searches run concurrently, taking its lock only
+/// to look up, store or reopen.
+pub struct CachedSearcher {
+    root: PathBuf,
+    threads: usize,
+    synonyms: SynonymMap,
+    state: Mutex<State>,
+}
+
+struct State {
+    opened: Arc<Opened>,
+    pages: Lru<Page>,
+    hits: u64,
+    misses: u64,
+}
+
+impl State {
+    /// Reopens the index and empties the cache if the index at `root` is of
+    /// another generation than the one open.
+    fn refresh(&mut self, root: &Path, generation: u64) -> Result<(), NsError> {
+        if self.opened.meta.generation != generation {
+            self.opened = Arc::new(Opened::open(root)?);
+            self.pages.clear();
+        }
+        Ok(())
+    }
+}
+
+/// An index and what its searches read besides it.
+struct Opened {
+    index: Index,
+    meta: IndexMeta,
+    reader: IndexReader,
+    metadata: Metadata,
+}
+
+impl Opened {
+    fn open(root: &Path) -> Result<Self, NsError> {
+        let (index, meta) = open_index(root)?;
+        let reader = create_reader_with_retry(&index, root)?;
+        Ok(Self {
+            index,
+            meta,
+            reader,
+            metadata: read_metadata(root)?,
+        })
+    }
+}
+
+impl CachedSearcher {
+    /// Opens the index at `root`. Fails as `execute_search` would on a
+    /// missing or outdated index.
+    pub fn open(root: &Path, opts: SearcherOptions) -> Result<Self, NsError> {
+        let opened = Opened::open(root)?;
+        let threads = match opts.threads {
+            0 => std::thread::available_parallelism().map_or(1, |n| n.get()),
+            n => n,
+        };
+        Ok(Self {
+            root: root.to_path_buf(),
+            threads,
+            synonyms: opts.synonyms,
+            state: Mutex::new(State {
+                opened: Arc::new(opened),
+                pages: Lru::new(opts.cache_size),
+                hits: 0,
+                misses: 0,
+            }),
+        })
+    }
+
+    /// Searches the index for `query_str`, or returns the page a previous
+    /// identical search of the same index generation found. A cached page
+    /// comes back as first found, except for `elapsed_ms`.
+    pub fn search(
+        &self,
+        query_str: &str,
+        opts: &SearchOptions,
+    ) -> Result<(Vec<SearchResult>, SearchStats), NsError> {
+        opts.cancel.check()?;
+        let opts = self.options(opts);
+        let opts = opts.as_ref();
+        let start = Instant::now();
+        let generation = read_meta(&self.root)?.generation;
+        let key = cache_key(query_str, opts);
+
+        let opened = {
+            let mut state = self.lock();
+            state.refresh(&self.root, generation)?;
+            if let Some((results, mut stats)) = state.pages.get(&key) {
+                state.hits += 1;
+                stats.elapsed_ms = start.elapsed().as_millis() as u64;
+                return Ok((results, stats));
+            }
+            state.misses += 1;
+            Arc::clone(&state.opened)
+        };
+
+        let page = search_index(
+            &opened.index,
+            &opened.meta,
+            &opened.reader.searcher(),
+            &opened.metadata,
+            query_str,
+            opts,
+        )?;
+        let mut state = self.lock();
+        // Not if another search reopened a newer index meanwhile.
+        if Arc::ptr_eq(&state.opened, &opened) {
+            state.pages.insert(key, page.clone());
+        }
+        Ok(page)
+    }
+
+    /// Searches the index for each of `queries`, as `search` would, and
+    /// returns their pages in the same order. A query that fails — a syntax
+    /// error, a cancelled `opts.cancel` — gets its error in its own slot;
+    /// only failing to read or reopen the index fails the whole batch.
+    ///
+    /// Cheaper than calling `search` for each: the generation is read and
+    /// the lock taken once to look up every query, the misses share one
+    /// tantivy searcher and run on `SearcherOptions::threads` threads, and
+    /// the lock is taken once more to cache their pages.
+    pub fn search_batch(
+        &self,
+        queries: &[&str],
+        opts: &SearchOptions,
+    ) -> Result<Vec<Result<(Vec<SearchResult>, SearchStats), NsError>>, NsError> {
+        let opts = self.options(opts);
+        let opts = opts.as_ref();
+        let start = Instant::now();
+        let generation = read_meta(&self.root)?.generation;
+        let keys: Vec<String> = queries.iter().map(|q| cache_key(q, opts)).collect();
+        let mut pages: Vec<Option<Result<Page, NsError>>> = Vec::with_capacity(queries.len());
+
+        let opened = {
+            let mut state = self.lock();
+            state.refresh(&self.root, generation)?;
+            for key in &keys {
+                let page = state.pages.get(key).map(|(results, mut stats)| {
+                    stats.elapsed_ms = start.elapsed().as_millis() as u64;
+                    Ok((results, stats))
+                });
+                match page {
+                    Some(_) => state.hits += 1,
+                    None => state.misses += 1,
+                }
+                pages.push(page);
+            }
+            Arc::clone(&state.opened)
+        };
+
+        let misses: Vec<usize> = (0..pages.len()).filter(|&i| pages[i].is_none()).collect();
+        let searcher = opened.reader.searcher();
+        let next = AtomicUsize::new(0);
+        let found = std::thread::scope(|scope| {
+            let handles: Vec<_> = (0..self.threads.min(misses.len()))
+                .map(|_| {
+                    scope.spawn(|| {
+                        let mut found = Vec::new();
+                        loop {
+                            let Some(&i) = misses.get(next.fetch_add(1, Ordering::Relaxed)) else {
+                                return found;
+                            };
+                            let page = opts.cancel.check().and_then(|()| {
+                                search_index(
+                                    &opened.index,
+                                    &opened.meta,
+                                    &searcher,
+                                    &opened.metadata,
+                                    queries[i],
+                                    opts,
+                                )
+                            });
+                            found.push((i, page));
+                        }
+                    })
+                })
+                .collect();
+            handles
+                .into_iter()
+                .flat_map(|h| h.join().expect("search thread panicked"))
+                .collect::<Vec<_>>()
+        });
+
+        let mut state = self.lock();
+        let current = Arc::ptr_eq(&state.opened, &opened);
+        for (i, page) in found {
+            if let (true, Ok(page)) = (current, &page) {
+                state.pages.insert(keys[i].clone(), page.clone());
+            }
+            pages[i] = Some(page);
+        }
+        drop(state);
+        Ok(pages
+            .into_iter()
+            .map(|page| page.expect("every query searched"))
+            .collect())
+    }
+
+    /// `opts` with `SearcherOptions::synonyms` if it has none of its own.
+    fn options<'o>(&self, opts: &'o SearchOptions) -> Cow<'o, SearchOptions> {
+        if opts.synonyms.is_empty() && !self.synonyms.is_empty() {
+            Cow::Owned(SearchOptions {
+                synonyms: self.synonyms.clone(),
+                ..opts.clone()
+            })
+        } else {
+            Cow::Borrowed(opts)
+        }
+    }
+
+    /// Cache hits and misses since `open`, and the pages cached now.
+    pub fn cache_stats(&self) -> CacheStats {
+        let state = self.lock();
+        CacheStats {
+            hits: state.hits,
+            misses: state.misses,
+            entries: state.pages.len(),
+            generation: state.opened.meta.generation,
+        }
+    }
+
+    fn lock(&self) -> MutexGuard<'_, State> {
+        self.state.lock().unwrap()
+    }
+}
+
+/// The query with runs of whitespace made single spaces, which doesn't
+/// change what it matches, then every option that can change the page.
+///
+/// With `opts.prefix_last_term`, trailing whitespace does change it: it
+/// marks the last word as finished, so `shut ` isn't expanded as `shut`
+/// is, and the key keeps one trailing space.
+fn cache_key(query_str: &str, opts: &SearchOptions) -> String {
+    let mut query = query_str.split_whitespace().collect::<Vec<_>>().join(" ");
+    if opts.prefix_last_term && query_str.ends_with(char::is_whitespace) && !query.is_empty() {
+        query.push(' ');
+    }
+    let opts = SearchOptions {
+        cancel: CancelToken::default(),
+        ..opts.clone()
+    };
+    format!("{}\n{:?}", query, opts)
+}
+
+/// A map of at most `capacity` entries that evicts the least recently
+/// used one to make room. Eviction scans every entry, which is cheap next
+/// to the search a miss runs.
+struct Lru<V> {
+    capacity: usize,
+    tick: u64,
+    entries: HashMap<String, (u64, V)>,
+}
+
+impl<V: Clone> Lru<V> {
+    fn new(capacity: usize) -> Self {
+        Self {
+            capacity,
+            tick: 0,
+            entries: HashMap::new(),
+        }
+    }
+
+    fn get(&mut self, key: &str) -> Option<V> {
+        self.tick += 1;
+        let tick = self.tick;
+        self.entries.get_mut(key).map(|(used, value)| {
+            *used = tick;
+            value.clone()
+        })
+    }
+
+    fn insert(&mut self, key: String, value: V) {
+        if self.capacity == 0 {
+            return;
+        }
+        if !self.entries.contains_key(&key) && self.entries.len() >= self.capacity {
+            let oldest = self
+                .entries
+                .iter()
+                .min_by_key(|(_, (used, _))| *used)
+                .map(|(k, _)| k.clone());
+            if let Some(oldest) = oldest {
+                self.entries.remove(&oldest);
+            }
+        }
+        self.tick += 1;
+        self.entries.insert(key, (self.tick, value));
+    }
+
+    fn clear(&mut self) {
+        self.entries.clear();
+    }
+
+    fn len(&self) -> usize {
+        self.entries.len()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn lru_evicts_least_recently_used() {
+        let mut lru = Lru::new(2);
+        lru.insert("a".to_string(), 1);
+        lru.insert("b".to_string(), 2);
+        assert_eq!(lru.get("a"), Some(1));
+        lru.insert("c".to_string(), 3);
+        assert_eq!(lru.get("b"), None, "b was used least recently");
+        assert_eq!(lru.get("a"), Some(1));
+        assert_eq!(lru.get("c"), Some(3));
+        lru.insert("c".to_string(), 4);
+        assert_eq!((lru.len(), lru.get("c")), (2, Some(4)));
+
+        let mut off = Lru::new(0);
+        off.insert("a".to_string(), 1);
+        assert_eq!((off.len(), off.get("a")), (0, None));
+    }
+
+    #[test]
+    fn cache_keys_ignore_spacing_and_cancel_but_not_options() {
+        let opts = SearchOptions::default();
+        let key = cache_key("read  timeout", &opts);
+        assert_eq!(key, cache_key(" read timeout\t", &opts));
+        let cancellable = SearchOptions {
+            cancel: CancelToken::new().with_timeout(std::time::Duration::from_secs(1)),
+            ..SearchOptions::default()
+        };
+        assert_eq!(key, cache_key("read timeout", &cancellable));
+        let paged = SearchOptions {
+            offset: 10,
+            ..SearchOptions::default()
+        };
+        assert_ne!(key, cache_key("read timeout", &paged));
+        assert_ne!(key, cache_key("timeout read", &opts));
+    }
+
+    #[test]
+    fn cache_keys_keep_a_finished_last_word_apart_when_expanding() {
+        let prefix = SearchOptions {
+            prefix_last_term: true,
+            ..SearchOptions::default()
+        };
+        assert_ne!(cache_key("shut", &prefix), cache_key("shut ", &prefix));
+        assert_eq!(cache_key("graceful  shut \t", &prefix), cache_key(" graceful shut ", &prefix));
+        assert_eq!(cache_key("graceful  shut", &prefix), cache_key("graceful shut", &prefix));
+        let opts = SearchOptions::default();
+        assert_eq!(cache_key("shut", &opts), cache_key("shut ", &opts));
+    }
+}
@@ -0,0 +1,241 @@
+use std::path::Path;
+use std::sync::Arc;
+
+use tantivy::postings::{Postings, SegmentPostings, TermInfo as SegmentTermInfo};
+use tantivy::schema::{IndexRecordOption, Value};
+use tantivy::termdict::TermStreamer;
+use tantivy::{DocAddress, DocSet, InvertedIndexReader, Searcher, TantivyDocument, TERMINATED};
+
+use crate::error::NsError;
+use crate::indexer::writer::open_index;
+use crate::schema::{content_field, lang_field, path_field, symbols_raw_field};
+use crate::searcher::query::create_reader_with_retry;
+
+/// A read-only view of everything in an index, for auditing what got
+/// indexed or feeding external tools.
+///
+/// The view is a snapshot: it holds the searcher of the last commit, so
+/// searches and `ns index` runs can go on while it is iterated, and it
+/// never sees their changes. Deleted files are skipped even before their
+/// segments merge.
+pub struct IndexContents {
+    searcher: Searcher,
+    content: Vec<Arc<InvertedIndexReader>>,
+    paths: Vec<Arc<InvertedIndexReader>>,
+}
+
+/// One indexed file, as yielded by [`IndexContents::documents`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct IndexedDocument {
+    /// Path relative to the repo root.
+    pub path: String,
+    /// Detected language, if any.
+    pub lang: Option<String>,
+    /// Extracted symbol names, in extraction order.
+    pub symbols: Vec<String>,
+}
+
+/// One `content` term, as yielded by [`IndexContents::terms`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct TermInfo {
+    /// The indexed term (lowercase, and stemmed in a `--stem` index).
+    pub term: String,
+    /// Number of files containing the term.
+    pub doc_freq: u64,
+    /// Number of occurrences of the term across all files.
+    pub total_freq: u64,
+}
+
+impl IndexContents {
+    /// Opens a snapshot of the index at `root`.
+    pub fn open(root: &Path) -> Result<Self, NsError> {
+        let (index, _meta) = open_index(root)?;
+        let schema = index.schema();
+        let reader = create_reader_with_retry(&index, root)?;
+        let searcher = reader.searcher();
+        let inverted = |field| {
+            searcher
+                .segment_readers()
+                .iter()
+                .map(|segment| segment.inverted_index(field))
+                .collect::<Result<Vec<_>, _>>()
+        };
+        let content = inverted(content_field(&schema))?;
+        let paths = inverted(path_field(&schema))?;
+        Ok(Self {
+            searcher,
+            content,
+            paths,
+        })
+    }
+
+    /// Every indexed file, in path order.
+    ///
+    /// Paths are read lazily from the `path` term dictionary, one file's
+    /// stored fields at a time, so memory stays flat on large indexes.
+    pub fn documents(&self) -> Result<Documents<'_>, NsError> {
+        Ok(Documents {
+            contents: self,
+            paths: MergedTerms::new(&self.paths)?,
+            pending: Vec::new(),
+        })
+    }
+
+    /// Every `content` term, in byte order, with its document and total
+    /// frequency over live files. Terms found only in deleted files are
+    /// skipped.
+    ///
+    /// Streams the term dictionary; each term's postings are read to
+    /// count frequencies, so a full pass costs about as much as reading
+    /// the index once.
+    pub fn terms(&self) -> Result<Terms<'_>, NsError> {
+        Ok(Terms {
+            contents: self,
+            terms: MergedTerms::new(&self.content)?,
+        })
+    }
+
+    /// Postings for `info` in segment `ord`, with term frequencies.
+    fn postings(
+        &self,
+        readers: &[Arc<InvertedIndexReader>],
+        ord: usize,
+        info: &SegmentTermInfo,
+    ) -> Result<SegmentPostings, NsError> {
+        Ok(readers[ord].read_postings_from_terminfo(info, IndexRecordOption::WithFreqs)?)
+    }
+
+    /// True if `doc` of segment `ord` hasn't been deleted.
+    fn is_alive(&self, ord: usize, doc: u32) -> bool {
+        self.searcher.segment_readers()[ord]
+            .alive_bitset()
+            .is_none_or(|alive| alive.is_alive(doc))
+    }
+
+    fn load(&self, address: DocAddress) -> Result<IndexedDocument, NsError> {
+        let schema = self.searcher.schema();
+        let doc: TantivyDocument = self.searcher.doc(address)?;
+        let text = |field| {
+            doc.get_first(field)
+                .and_then(|v| v.as_str())
+                .filter(|s| !s.is_empty())
+                .map(str::to_string)
+        };
+        let symbols = text(symbols_raw_field(schema))
+            .map(|raw| raw.split('|').map(str::to_string).collect())
+            .unwrap_or_default();
+        Ok(IndexedDocument {
+            path: text(path_field(schema)).unwrap_or_default(),
+            lang: text(lang_field(schema)),
+            symbols,
+        })
+    }
+}
+
+/// Iterator returned by [`IndexContents::documents`].
+pub struct Documents<'a> {
+    contents: &'a IndexContents,
+    paths: MergedTerms<'a>,
+    /// Live documents of the current path not yielded yet, last first.
+    pending: Vec<DocAddress>,
+}
+
+impl Iterator for Documents<'_> {
+    type Item = Result<IndexedDocument, NsError>;
+
+    fn next(&mut self) -> Option<Self::Item> {
+        loop {
+            if let Some(address) = self.pending.pop() {
+                return Some(self.contents.load(address));
+            }
+            let (_, segments) = self.paths.next()?;
+            for (ord, info) in segments.iter().rev() {
+                let mut postings = match self.contents.postings(&self.contents.paths, *ord, info) {
+                    Ok(postings) => postings,
+                    Err(e) => return Some(Err(e)),
+                };
+                let mut live = Vec::new();
+                while postings.doc() != TERMINATED {
+                    if self.contents.is_alive(*ord, postings.doc()) {
+                        live.push(DocAddress::new(*ord as u32, postings.doc()));
+                    }
+                    postings.advance();
+                }
+                self.pending.extend(live.into_iter().rev());
+            }
+        }
+    }
+}
+
+/// Iterator returned by [`IndexContents::terms`].
+pub struct Terms<'a> {
+    contents: &'a IndexContents,
+    terms: MergedTerms<'a>,
+}
+
+impl Iterator for Terms<'_> {
+    type Item = Result<TermInfo, NsError>;
+
+    fn next(&mut self) -> Option<Self::Item> {
+        loop {
+            let (term, segments) = self.terms.next()?;
+            let mut doc_freq = 0;
+            let mut total_freq = 0;
+            for (ord, info) in &segments {
+                let mut postings = match self.contents.postings(&self.contents.content, *ord, info) {
+                    Ok(postings) => postings,
+                    Err(e) => return Some(Err(e)),
+                };
+                while postings.doc() != TERMINATED {
+                    if self.contents.is_alive(*ord, postings.doc()) {
+                        doc_freq += 1;
+                        total_freq += postings.term_freq() as u64;
+                    }
+                    postings.advance();
+                }
+            }
+            if doc_freq > 0 {
+                return Some(Ok(TermInfo {
+                    term: String::from_utf8_lossy(&term).into_owned(),
+                    doc_freq,
+                    total_freq,
+                }));
+            }
+        }
+    }
+}
+
+/// The term dictionaries of every segment, merged into one stream in byte
+/// order.
+struct MergedTerms<'a> {
+    /// Segment ordinal and stream of each segment with terms left, each
+    /// positioned on its next term.
+    streams: Vec<(usize, TermStreamer<'a>)>,
+}
+
+impl<'a> MergedTerms<'a> {
+    fn new(readers: &'a [Arc<InvertedIndexReader>]) -> Result<Self, NsError> {
+        let mut streams = Vec::new();
+        for (ord, reader) in readers.iter().enumerate() {
+            let mut stream = reader.terms().stream()?;
+            if stream.advance() {
+                streams.push((ord, stream));
+            }
+        }
+        Ok(Self { streams })
+    }
+
+    /// The next term, with each segment holding it and its term info there.
+    fn next(&mut self) -> Option<(Vec<u8>, Vec<(usize, SegmentTermInfo)>)> {
+        let term = self.streams.iter().map(|(_, s)| s.key()).min()?.to_vec();
+        let mut segments = Vec::new();
+        self.streams.retain_mut(|(ord, stream)| {
+            if stream.key() != term.as_slice() {
+                return true;
+            }
+            segments.push((*ord, stream.value().clone()));
+            stream.advance()
+        });
+        Some((term, segments))
+    }
+}
@@ -1,6 +1,10 @@
 use std::collections::BTreeSet;
 use std::path::Path;
 
+use crate::indexer::gzip::read_to_string;
+use crate::indexer::tokenizer::{is_word_char, split_identifier, Stemming};
+use crate::searcher::query_ast::positive_text;
+
 /// A single line from a matched file, with its 1-based line number.
 #[derive(Debug)]
 pub struct ContextLine {
@@ -8,20 +12,55 @@ pub struct ContextLine {
     pub text: String,
 }
 
+/// How context lines are gathered around matching lines, like grep's
+/// `-B`, `-A` and `-C`.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct SnippetOptions {
+    /// Lines of context before each matching line.
+    pub before: usize,
+    /// Lines of context after each matching line.
+    pub after: usize,
+    /// Maximum snippets (runs of adjacent context lines) per file.
+    /// 0 means unlimited. With a limit, the snippets covering the most
+    /// query terms are kept, rarer terms counting for more (see
+    /// [`context_around`]).
+    pub max_snippets: usize,
+    /// Maximum matching lines per file, the first in the file; the rest
+    /// are counted in [`ContextResult::omitted_matches`] and get no
+    /// context of their own. 0 means unlimited.
+    pub max_matches: usize,
+}
+
+impl SnippetOptions {
+    /// `window` lines on both sides of each match, with no snippet limit.
+    pub fn around(window: usize) -> Self {
+        Self {
+            before: window,
+            after: window,
+            max_snippets: 0,
+            max_matches: 0,
+        }
+    }
+}
+
 /// Result of context extraction, including truncation info.
 #[derive(Debug)]
 pub struct ContextResult {
     pub lines: Vec<ContextLine>,
-    /// Number of additional matching lines that were omitted due to the cap.
-    /// 0 when no truncation occurred.
+    /// Number of additional context lines that were omitted due to the
+    /// line cap or snippet limit. 0 when no truncation occurred.
     pub truncated_count: usize,
+    /// Number of matching lines left out by `SnippetOptions::max_matches`,
+    /// for a "N more matches" note. 0 when none were.
+    pub omitted_matches: usize,
 }
 
 /// Extracts context lines from a file that matched a search query.
 ///
-/// For each query term, finds all lines containing it (case-insensitive),
-/// then expands each match by ±`context_window` lines. Overlapping ranges
-/// are merged. Returns lines sorted by line number.
+/// For each query term, finds all lines containing it (case-insensitive,
+/// as whole words or identifier parts: `port` is on `PortMap` lines but not
+/// `report` ones), then expands each match by ±`context_window` lines.
+/// Overlapping ranges are merged. Returns lines sorted by line number.
 ///
 /// When `max_lines` is `Some(n)`, at most `n` context lines are returned.
 /// If the total would exceed the cap, the result is truncated to the first
@@ -35,14 +74,35 @@ pub fn extract_context(
     query: &str,
     context_window: usize,
     max_lines: Option<usize>,
+) -> ContextResult {
+    // Tokenize the query by splitting on non-alphanumeric boundaries, then lowercase.
+    // This mirrors tantivy's default tokenizer behavior — e.g. "EventStore.new" becomes
+    // ["eventstore", "new"], "HashMap<String>" becomes ["hashmap", "string"].
+    let terms: Vec<String> = tokenize_query(query);
+    let snippet = SnippetOptions::around(context_window);
+    extract_snippets(root, rel_path, &terms, Stemming::Noop, &snippet, max_lines)
+}
+
+/// Like [`extract_context`], with the query already split into lowercased
+/// `terms` (see [`tokenize_query`]), separate before/after context and a
+/// per-file snippet limit. In an index stemmed with `stemming`, a word is
+/// also on lines holding words with its stem (see [`term_lines`]).
+pub fn extract_snippets(
+    root: &Path,
+    rel_path: &str,
+    terms: &[String],
+    stemming: Stemming,
+    snippet: &SnippetOptions,
+    max_lines: Option<usize>,
 ) -> ContextResult {
     let empty = ContextResult {
         lines: Vec::new(),
         truncated_count: 0,
+        omitted_matches: 0,
     };
 
     let full_path = root.join(rel_path);
-    let content = match std::fs::read_to_string(&full_path) {
+    let content = match read_to_string(&full_path) {
         Ok(s) => s,
         Err(_) => return empty,
     };
@@ -53,41 +113,158 @@ pub fn extract_context(
         return empty;
     }
 
-    // Tokenize the query by splitting on non-alphanumeric boundaries, then lowercase.
-    // This mirrors tantivy's default tokenizer behavior — e.g. "EventStore.new" becomes
-    // ["eventstore", "new"], "HashMap<String>" becomes ["hashmap", "string"].
-    let terms: Vec<String> = tokenize_query(query);
-
     if terms.is_empty() {
         return empty;
     }
 
     // Find all line indices (0-based) that contain at least one query term
-    let mut match_indices = BTreeSet::new();
+    let term_lines = term_lines(&lines, terms, stemming);
+    let match_indices: BTreeSet<usize> = term_lines.iter().flatten().copied().collect();
+
+    context_around(&lines, &match_indices, &term_lines, snippet, max_lines)
+}
+
+/// For each of the (lowercased) query `terms`, the indices (0-based) of the
+/// `lines` containing it, as [`find_term_matches`] matches it: as whole
+/// tokens, or tokens `stemming` stems to it. In the form [`context_around`]
+/// takes.
+pub(crate) fn term_lines(
+    lines: &[&str],
+    terms: &[String],
+    stemming: Stemming,
+) -> Vec<BTreeSet<usize>> {
+    let mut found = vec![BTreeSet::new(); terms.len()];
     for (i, line) in lines.iter().enumerate() {
         let lower = line.to_lowercase();
-        for term in &terms {
-            if lower.contains(term.as_str()) {
-                match_indices.insert(i);
-                break;
+        // Most lines hold no term at all; only split those that might. A
+        // stem needn't be spelled out in the words it comes from (`happi`).
+        let mut tokens = None;
+        for (t, term) in terms.iter().enumerate() {
+            if stemming == Stemming::Noop && !lower.contains(term.as_str()) {
+                continue;
+            }
+            let tokens = tokens.get_or_insert_with(|| LineTokens::new(line, stemming));
+            if tokens.contains(line, term) {
+                found[t].insert(i);
             }
         }
     }
+    found
+}
 
+/// Like [`extract_snippets`], around the given 1-based `match_lines`
+/// (`SearchResult::lines`) instead of lines containing query terms. Lines
+/// past the end of the file, which may have shrunk since indexing, are
+/// dropped. `terms` only rank snippets for `snippet.max_snippets`.
+pub(crate) fn snippets_at_lines(
+    root: &Path,
+    rel_path: &str,
+    match_lines: &[usize],
+    terms: &[String],
+    stemming: Stemming,
+    snippet: &SnippetOptions,
+    max_lines: Option<usize>,
+) -> ContextResult {
+    let content = read_to_string(root.join(rel_path)).unwrap_or_default();
+    let lines: Vec<&str> = content.lines().collect();
+    let match_indices: BTreeSet<usize> = match_lines
+        .iter()
+        .filter(|&&n| n >= 1 && n <= lines.len())
+        .map(|&n| n - 1)
+        .collect();
+    let term_lines = if snippet.max_snippets > 0 {
+        term_lines(&lines, terms, stemming)
+    } else {
+        Vec::new()
+    };
+    context_around(&lines, &match_indices, &term_lines, snippet, max_lines)
+}
+
+/// Expands matched line indices (0-based) by `snippet.before` and
+/// `snippet.after` lines, merges overlapping or adjacent ranges into
+/// snippets, keeps the best `snippet.max_snippets` of them and applies the
+/// `max_lines` cap (see [`extract_context`]). Only the first
+/// `snippet.max_matches` matches are expanded, if that is set.
+///
+/// `term_lines` holds, per query term, the lines it is on (see
+/// [`term_lines`]). A snippet scores the sum of the weights of the distinct
+/// terms it covers, a term on few of the file's lines weighing more —
+/// `ln(1 + lines / lines with the term)`, an IDF over the file — so the
+/// passage with `read` and `timeout` beats the earlier one with `read`
+/// alone. The highest-scoring snippets are kept, earlier ones on ties, and
+/// shown in file order. Without `term_lines` (regex matches), the first
+/// snippets are kept.
+pub(crate) fn context_around(
+    lines: &[&str],
+    match_indices: &BTreeSet<usize>,
+    term_lines: &[BTreeSet<usize>],
+    snippet: &SnippetOptions,
+    max_lines: Option<usize>,
+) -> ContextResult {
     if match_indices.is_empty() {
-        return empty;
+        return ContextResult {
+            lines: Vec::new(),
+            truncated_count: 0,
+            omitted_matches: 0,
+        };
     }
+    let total_lines = lines.len();
 
-    // Expand matches by ±context_window, collecting all line indices to include
+    // Keep the first `max_matches` matching lines
+    let kept_matches = match snippet.max_matches {
+        0 => match_indices.len(),
+        n => n.min(match_indices.len()),
+    };
+    let omitted_matches = match_indices.len() - kept_matches;
+
+    // Expand matches by the before/after window, collecting all line indices to include
     let mut include_indices = BTreeSet::new();
-    for &idx in &match_indices {
-        let start = idx.saturating_sub(context_window);
-        let end = (idx + context_window).min(total_lines - 1);
+    for &idx in match_indices.iter().take(kept_matches) {
+        let start = idx.saturating_sub(snippet.before);
+        let end = idx.saturating_add(snippet.after).min(total_lines - 1);
         for i in start..=end {
             include_indices.insert(i);
         }
     }
 
+    // Keep the best `max_snippets` runs of consecutive lines
+    let mut snippet_omitted = 0;
+    if snippet.max_snippets > 0 {
+        let mut runs: Vec<(usize, usize)> = Vec::new();
+        for &i in &include_indices {
+            match runs.last_mut() {
+                Some(run) if run.1 + 1 == i => run.1 = i,
+                _ => runs.push((i, i)),
+            }
+        }
+        let weights: Vec<f32> = term_lines
+            .iter()
+            .map(|on| match on.len() {
+                0 => 0.0,
+                n => (1.0 + total_lines as f32 / n as f32).ln(),
+            })
+            .collect();
+        let score = |&(start, end): &(usize, usize)| -> f32 {
+            term_lines
+                .iter()
+                .zip(&weights)
+                .filter(|(on, _)| on.range(start..=end).next().is_some())
+                .map(|(_, w)| w)
+                .sum()
+        };
+        // Stable: runs of equal score stay in file order.
+        let mut ranked: Vec<(f32, (usize, usize))> =
+            runs.iter().map(|run| (score(run), *run)).collect();
+        ranked.sort_by(|a, b| b.0.total_cmp(&a.0));
+        let keep: BTreeSet<usize> = ranked
+            .iter()
+            .take(snippet.max_snippets)
+            .flat_map(|&(_, (start, end))| start..=end)
+            .collect();
+        snippet_omitted = include_indices.len() - keep.len();
+        include_indices = keep;
+    }
+
     // Apply per-file context line cap
     // max_lines of Some(0) means unlimited (same as None)
     let cap = match max_lines {
@@ -99,7 +276,7 @@ pub fn extract_context(
         total_context - cap
     } else {
         0
-    };
+    } + snippet_omitted;
 
     // Build context lines (1-based line numbers), taking at most `cap`
     let context_lines: Vec<ContextLine> = include_indices
@@ -114,13 +291,227 @@ pub fn extract_context(
     ContextResult {
         lines: context_lines,
         truncated_count,
+        omitted_matches,
+    }
+}
+
+/// A match within a single context line.
+///
+/// `start` and `end` are byte offsets into the line text, so
+/// `&text[start..end]` is always the matched slice, even when the line
+/// contains multi-byte UTF-8 characters.
+#[derive(Debug, PartialEq)]
+pub struct TermMatch {
+    /// 1-based line number.
+    pub line: usize,
+    pub start: usize,
+    pub end: usize,
+    /// The (lowercased) query term, or the matched text for regex searches.
+    pub term: String,
+}
+
+/// Finds query term occurrences in each of `lines` (see [`find_term_matches`]).
+pub(crate) fn find_matches_in_lines(
+    lines: &[ContextLine],
+    terms: &[String],
+    stemming: Stemming,
+) -> Vec<TermMatch> {
+    lines
+        .iter()
+        .flat_map(|line| find_term_matches(line, terms, stemming))
+        .collect()
+}
+
+/// Finds every occurrence of the (lowercased) query `terms` in `line`.
+///
+/// Matching is case-insensitive and scans left to right; at each position the
+/// longest matching term wins and the scan resumes after it, so matches never
+/// overlap. Only whole tokens match, starting and ending where the `code`
+/// tokenizer splits (see [`LineTokens`]): `port` matches `port` and
+/// `PortMap`, but not `report`, which the index never matched it to either.
+/// In an index stemmed with `stemming`, a term also matches the words with
+/// that stem, as the index does: `connect` matches `connection`.
+/// Offsets are taken from the original line rather than a lowercased
+/// copy, because lowercasing can change byte lengths (`İ` → `i̇`).
+pub(crate) fn find_term_matches(
+    line: &ContextLine,
+    terms: &[String],
+    stemming: Stemming,
+) -> Vec<TermMatch> {
+    let text = line.text.as_str();
+    let tokens = LineTokens::new(text, stemming);
+    let mut matches = Vec::new();
+    let mut resume = 0;
+
+    for &start in &tokens.boundaries {
+        if start < resume || start == text.len() {
+            continue;
+        }
+        let best = terms
+            .iter()
+            .filter_map(|term| {
+                let len = tokens.match_len(text, start, term)?;
+                Some((len, term))
+            })
+            .max_by_key(|&(len, _)| len);
+        if let Some((len, term)) = best {
+            matches.push(TermMatch {
+                line: line.line_number,
+                start,
+                end: start + len,
+                term: term.clone(),
+            });
+            resume = start + len;
+        }
+    }
+    matches
+}
+
+/// The tokens of a line as the `code` tokenizer splits it: each identifier,
+/// and its `camelCase`, `snake_case` and digit parts.
+struct LineTokens {
+    /// Byte offsets where a token starts or ends.
+    boundaries: BTreeSet<usize>,
+    /// The `(start, end)` byte range of every token and its stem, in a
+    /// stemmed index only.
+    stems: Vec<(usize, usize, String)>,
+}
+
+impl LineTokens {
+    fn new(text: &str, stemming: Stemming) -> Self {
+        let mut spans = Vec::new();
+        let mut run_start: Option<usize> = None;
+        // Trailing sentinel so the last identifier run is flushed.
+        let chars = text.char_indices().chain(std::iter::once((text.len(), ' ')));
+        for (offset, c) in chars {
+            if is_word_char(c) || c == '_' {
+                run_start.get_or_insert(offset);
+                continue;
+            }
+            let Some(start) = run_start.take() else {
+                continue;
+            };
+            let parts = split_identifier(&text[start..offset]);
+            // The whole identifier too, as the tokenizer indexes it.
+            if let (Some(first), Some(last)) = (parts.first(), parts.last()) {
+                if parts.len() > 1 {
+                    spans.push((start + first.0, start + last.1));
+                }
+            }
+            spans.extend(parts.iter().map(|&(from, to)| (start + from, start + to)));
+        }
+        let boundaries = spans.iter().flat_map(|&(from, to)| [from, to]).collect();
+        let stems = match stemming {
+            Stemming::Noop => Vec::new(),
+            _ => spans
+                .into_iter()
+                .map(|(from, to)| (from, to, stemming.stem(&text[from..to].to_lowercase())))
+                .collect(),
+        };
+        Self { boundaries, stems }
+    }
+
+    /// The byte length of the match of `term` at `start` in `text`, the
+    /// line: text spelling `term` from one token boundary to another, or a
+    /// token stemmed to `term`.
+    fn match_len(&self, text: &str, start: usize, term: &str) -> Option<usize> {
+        match_len_at(&text[start..], term)
+            .filter(|&len| self.boundaries.contains(&(start + len)))
+            .or_else(|| {
+                self.stems
+                    .iter()
+                    .find(|(from, _, stem)| *from == start && stem == term)
+                    .map(|&(from, to, _)| to - from)
+            })
+    }
+
+    /// Whether `term` matches anywhere in `text`, the line (see
+    /// [`LineTokens::match_len`]).
+    fn contains(&self, text: &str, term: &str) -> bool {
+        self.boundaries
+            .iter()
+            .any(|&start| start < text.len() && self.match_len(text, start, term).is_some())
+    }
+}
+
+/// Returns the byte length of the prefix of `text` that lowercases to `term`,
+/// or `None` if `text` does not start with `term` on a char boundary.
+fn match_len_at(text: &str, term: &str) -> Option<usize> {
+    if term.is_empty() {
+        return None;
+    }
+    let mut expected = term.chars();
+    for (offset, c) in text.char_indices() {
+        for lc in c.to_lowercase() {
+            if expected.next() != Some(lc) {
+                return None;
+            }
+        }
+        if expected.as_str().is_empty() {
+            return Some(offset + c.len_utf8());
+        }
+    }
+    None
+}
+
+/// Shortens lines longer than `width` characters to a `width`-character
+/// window, marked with `...` at each cut end. `width` of 0 disables this.
+///
+/// The window starts shortly before the line's first entry in `matches`, so
+/// the match stays visible in a long minified line. `matches` are re-based
+/// onto the shortened text; those cut off are dropped.
+pub(crate) fn truncate_long_lines(
+    lines: &mut [ContextLine],
+    matches: &mut Vec<TermMatch>,
+    width: usize,
+) {
+    const ELLIPSIS: &str = "...";
+    if width == 0 {
+        return;
+    }
+    for line in lines.iter_mut() {
+        let char_count = line.text.chars().count();
+        if char_count <= width {
+            continue;
+        }
+        let focus = matches
+            .iter()
+            .find(|m| m.line == line.line_number)
+            .map(|m| line.text[..m.start].chars().count())
+            .unwrap_or(0);
+        let first = focus.saturating_sub(width / 4).min(char_count - width);
+        let byte_at = |n: usize| {
+            line.text
+                .char_indices()
+                .nth(n)
+                .map(|(b, _)| b)
+                .unwrap_or(line.text.len())
+        };
+        let (start, end) = (byte_at(first), byte_at(first + width));
+
+        let prefix = if start > 0 { ELLIPSIS } else { "" };
+        let suffix = if end < line.text.len() { ELLIPSIS } else { "" };
+        matches.retain_mut(|m| {
+            if m.line != line.line_number {
+                return true;
+            }
+            if m.start < start || m.end > end {
+                return false;
+            }
+            m.start = m.start - start + prefix.len();
+            m.end = m.end - start + prefix.len();
+            true
+        });
+        line.text = format!("{}{}{}", prefix, &line.text[start..end], suffix);
     }
 }
 
 /// Tokenizes a query string the same way tantivy's default tokenizer does:
 /// split on non-alphanumeric boundaries, lowercase each token, drop empties.
+/// Boolean operators and negated terms (`NOT x`, `-x`) are dropped first —
+/// a matching file is not expected to contain them.
 pub(crate) fn tokenize_query(query: &str) -> Vec<String> {
-    query
+    positive_text(query)
         .split(|c: char| !c.is_alphanumeric())
         .filter(|s| !s.is_empty())
         .map(|s| s.to_lowercase())
@@ -132,6 +523,10 @@ mod tests {
     use super::*;
     use std::path::PathBuf;
 
+    fn ctx_line(line_number: usize, text: &str) -> ContextLine {
+        ContextLine { line_number, text: text.to_string() }
+    }
+
     #[test]
     fn extracts_matching_lines_with_context() {
         let fixture = PathBuf::from(env!("CARGO_MANIFEST_DIR"))
@@ -199,6 +594,81 @@ mod tests {
         assert_eq!(terms, vec!["spaced", "out"]);
     }
 
+    #[test]
+    fn tokenize_drops_operators_and_negated_terms() {
+        let terms = tokenize_query("server AND start NOT test");
+        assert_eq!(terms, vec!["server", "start"]);
+
+        let terms = tokenize_query("\"graceful shutdown\" -deprecated");
+        assert_eq!(terms, vec!["graceful", "shutdown"]);
+    }
+
+    #[test]
+    fn term_matches_have_byte_offsets() {
+        let terms = tokenize_query("port server");
+        let line = ctx_line(7, "let server = Server::new(port);");
+        let m = find_term_matches(&line, &terms, Stemming::Noop);
+        let spans: Vec<&str> = m.iter().map(|t| &line.text[t.start..t.end]).collect();
+        assert_eq!(spans, vec!["server", "Server", "port"]);
+        assert_eq!(
+            m[0],
+            TermMatch { line: 7, start: 4, end: 10, term: "server".to_string() }
+        );
+    }
+
+    #[test]
+    fn term_matches_are_whole_tokens() {
+        let terms = tokenize_query("port err");
+        let line = ctx_line(1, "report(interrupt, PortMap, err_code, errs)");
+        let m = find_term_matches(&line, &terms, Stemming::Noop);
+        let spans: Vec<&str> = m.iter().map(|t| &line.text[t.start..t.end]).collect();
+        assert_eq!(spans, vec!["Port", "err"]);
+
+        let report = ctx_line(1, "report");
+        assert!(find_term_matches(&report, &tokenize_query("port"), Stemming::Noop).is_empty());
+    }
+
+    #[test]
+    fn stemmed_term_matches_tokens_with_its_stem() {
+        let terms = vec!["connect".to_string()];
+        let line = ctx_line(1, "retry the connection, connectTimeout, reconnects");
+        let m = find_term_matches(&line, &terms, Stemming::English);
+        let spans: Vec<&str> = m.iter().map(|t| &line.text[t.start..t.end]).collect();
+        assert_eq!(spans, vec!["connection", "connect"]);
+
+        let lines = ["retry the connection", "reconnects"];
+        assert_eq!(
+            term_lines(&lines, &terms, Stemming::English),
+            vec![BTreeSet::from([0])]
+        );
+        assert_eq!(term_lines(&lines, &terms, Stemming::Noop), vec![BTreeSet::new()]);
+    }
+
+    #[test]
+    fn term_match_offsets_survive_non_ascii() {
+        let terms = tokenize_query("timeout");
+        // "é" and "→" are 2 and 3 bytes; offsets must account for both.
+        let line = ctx_line(1, "// délai → ReadTimeout (größe)");
+        let m = find_term_matches(&line, &terms, Stemming::Noop);
+        assert_eq!(m.len(), 1);
+        assert_eq!(&line.text[m[0].start..m[0].end], "Timeout");
+        assert_eq!(m[0].start, line.text.find("Timeout").unwrap());
+
+        let terms = tokenize_query("größe");
+        let m = find_term_matches(&line, &terms, Stemming::Noop);
+        assert_eq!(&line.text[m[0].start..m[0].end], "größe");
+    }
+
+    #[test]
+    fn term_matches_prefer_longest_and_do_not_overlap() {
+        let terms = vec!["event".to_string(), "eventstore".to_string()];
+        let line = ctx_line(1, "EventStore event");
+        let m = find_term_matches(&line, &terms, Stemming::Noop);
+        assert_eq!(m.len(), 2);
+        assert_eq!((m[0].start, m[0].end, m[0].term.as_str()), (0, 10, "eventstore"));
+        assert_eq!((m[1].start, m[1].end, m[1].term.as_str()), (11, 16, "event"));
+    }
+
     #[test]
     fn multiterm_query_matches() {
         let fixture = PathBuf::from(env!("CARGO_MANIFEST_DIR"))
@@ -243,4 +713,122 @@ mod tests {
         assert_eq!(unlimited.lines.len(), zero_cap.lines.len(), "Some(0) should behave like None");
         assert_eq!(zero_cap.truncated_count, 0);
     }
+
+    #[test]
+    fn before_and_after_context_clamp_at_file_boundaries() {
+        let lines = ["a", "b", "c", "d", "e"];
+        let snippet = SnippetOptions { before: 3, after: 1, max_snippets: 0, max_matches: 0 };
+
+        let first = context_around(&lines, &BTreeSet::from([0]), &[], &snippet, None);
+        let nums: Vec<usize> = first.lines.iter().map(|l| l.line_number).collect();
+        assert_eq!(nums, vec![1, 2]);
+
+        let last = context_around(&lines, &BTreeSet::from([4]), &[], &snippet, None);
+        let nums: Vec<usize> = last.lines.iter().map(|l| l.line_number).collect();
+        assert_eq!(nums, vec![2, 3, 4, 5]);
+    }
+
+    #[test]
+    fn max_snippets_keeps_first_runs_and_counts_the_rest() {
+        let lines = ["m", "x", "x", "m", "x", "x", "x", "m", "x"];
+        // Matches at 0 and 3 merge into one snippet (adjacent after ±1); 7 is a second.
+        let snippet = SnippetOptions { before: 1, after: 1, max_snippets: 1, max_matches: 0 };
+        let result = context_around(&lines, &BTreeSet::from([0, 3, 7]), &[], &snippet, None);
+
+        let nums: Vec<usize> = result.lines.iter().map(|l| l.line_number).collect();
+        assert_eq!(nums, vec![1, 2, 3, 4, 5]);
+        assert_eq!(result.truncated_count, 3, "lines 7-9 are omitted");
+    }
+
+    #[test]
+    fn context_lines_hold_terms_as_whole_tokens() {
+        let dir = tempfile::tempdir().unwrap();
+        let text = "fn report() {}\nlet x = 1;\nlet port = 8080;\nlet y = 2;\nuse PortMap;\n";
+        std::fs::write(dir.path().join("net.rs"), text).unwrap();
+
+        let result = extract_context(dir.path(), "net.rs", "port", 0, None);
+        let nums: Vec<usize> = result.lines.iter().map(|l| l.line_number).collect();
+        assert_eq!(nums, vec![3, 5], "the `report` line is not context for `port`");
+
+        let lines: Vec<&str> = text.lines().collect();
+        let terms = ["port".to_string(), "re".to_string()];
+        assert_eq!(
+            term_lines(&lines, &terms, Stemming::Noop),
+            vec![BTreeSet::from([2, 4]), BTreeSet::new()]
+        );
+    }
+
+    #[test]
+    fn max_snippets_keeps_the_runs_covering_the_most_terms() {
+        let lines = [
+            "read", "x", "x", "x", "read timeout", "x", "x", "x", "read", "x", "timeout",
+        ];
+        let terms = ["read".to_string(), "timeout".to_string()];
+        let snippet = SnippetOptions { before: 0, after: 0, max_snippets: 1, max_matches: 0 };
+        let term_lines = term_lines(&lines, &terms, Stemming::Noop);
+        let matched: BTreeSet<usize> = term_lines.iter().flatten().copied().collect();
+        let result = context_around(&lines, &matched, &term_lines, &snippet, None);
+
+        let nums: Vec<usize> = result.lines.iter().map(|l| l.line_number).collect();
+        assert_eq!(nums, vec![5], "the passage with both terms wins");
+        assert_eq!(result.truncated_count, 3);
+
+        // `timeout` is on fewer lines than `read`, so it outweighs it.
+        let snippet = SnippetOptions { max_snippets: 2, ..snippet };
+        let matched = BTreeSet::from([0, 10]);
+        let result = context_around(&lines, &matched, &term_lines, &snippet, None);
+        let nums: Vec<usize> = result.lines.iter().map(|l| l.line_number).collect();
+        assert_eq!(nums, vec![1, 11]);
+        let snippet = SnippetOptions { max_snippets: 1, ..snippet };
+        let result = context_around(&lines, &matched, &term_lines, &snippet, None);
+        let nums: Vec<usize> = result.lines.iter().map(|l| l.line_number).collect();
+        assert_eq!(nums, vec![11]);
+    }
+
+    #[test]
+    fn max_matches_keeps_the_first_matches_and_counts_the_rest() {
+        let lines = ["m", "x", "m", "x", "x", "m", "x", "m"];
+        let snippet = SnippetOptions { before: 0, after: 1, max_snippets: 0, max_matches: 2 };
+        let result = context_around(&lines, &BTreeSet::from([0, 2, 5, 7]), &[], &snippet, None);
+
+        let nums: Vec<usize> = result.lines.iter().map(|l| l.line_number).collect();
+        assert_eq!(nums, vec![1, 2, 3, 4]);
+        assert_eq!(result.omitted_matches, 2);
+        assert_eq!(result.truncated_count, 0, "omitted matches aren't truncated lines");
+
+        let snippet = SnippetOptions { max_matches: 4, ..snippet };
+        let result = context_around(&lines, &BTreeSet::from([0, 2, 5, 7]), &[], &snippet, None);
+        assert_eq!(result.omitted_matches, 0);
+    }
+
+    #[test]
+    fn long_lines_are_shortened_around_the_match() {
+        let text = format!("{} needle {}", "a".repeat(500), "b".repeat(500));
+        let mut lines = vec![ctx_line(1, &text), ctx_line(2, "short needle")];
+        let mut matches = find_matches_in_lines(&lines, &["needle".to_string()], Stemming::Noop);
+
+        truncate_long_lines(&mut lines, &mut matches, 40);
+
+        assert!(lines[0].text.starts_with("...") && lines[0].text.ends_with("..."));
+        assert_eq!(lines[0].text.chars().count(), 40 + 6);
+        assert_eq!(lines[1].text, "short needle", "short lines are untouched");
+        assert_eq!(matches.len(), 2);
+        let m = &matches[0];
+        assert_eq!(&lines[0].text[m.start..m.end], "needle");
+    }
+
+    #[test]
+    fn long_line_truncation_respects_char_boundaries_and_width_zero() {
+        let text = format!("{} ß {}", "é".repeat(30), "ü".repeat(30));
+        let mut lines = vec![ctx_line(3, &text)];
+        let mut matches = find_matches_in_lines(&lines, &["ß".to_string()], Stemming::Noop);
+
+        truncate_long_lines(&mut lines, &mut matches, 0);
+        assert_eq!(lines[0].text, text, "width 0 disables truncation");
+
+        truncate_long_lines(&mut lines, &mut matches, 10);
+        let m = &matches[0];
+        assert_eq!(&lines[0].text[m.start..m.end], "ß");
+        assert_eq!(lines[0].text.chars().count(), 10 + 6);
+    }
 }
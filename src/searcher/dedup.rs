@@ -0,0 +1,151 @@
+use std::collections::HashMap;
+
+use crate::indexer::simhash::distance;
+
+use super::query::RankedHit;
+
+/// Folds each of `hits` (in rank order) into the first earlier hit whose
+/// fingerprint is at most `max_distance` bits away, appending its path to
+/// that hit's `duplicates`. Returns the remaining hits, still in order.
+///
+/// A hit is compared with the kept hits only, not with other duplicates,
+/// so groups don't chain: `a` can absorb `b` and `c` without `b` and `c`
+/// being within `max_distance` of each other.
+pub(crate) fn collapse(hits: Vec<RankedHit>, max_distance: u32) -> Vec<RankedHit> {
+    let mut index = FingerprintIndex::new(max_distance);
+    let mut kept: Vec<RankedHit> = Vec::new();
+    for hit in hits {
+        match index.find(hit.fingerprint) {
+            Some(i) => kept[i].duplicates.push(hit.path),
+            None => {
+                index.insert(hit.fingerprint);
+                kept.push(hit);
+            }
+        }
+    }
+    kept
+}
+
+/// Fingerprints of kept hits, looked up by Hamming distance.
+///
+/// The 64 bits are cut into `max_distance + 1` blocks: two fingerprints
+/// within `max_distance` bits of each other agree on at least one whole
+/// block, so only fingerprints sharing a block need comparing.
+struct FingerprintIndex {
+    max_distance: u32,
+    /// Shift and mask of each block.
+    blocks: Vec<(u32, u64)>,
+    /// Per block, the positions in `fingerprints` with each block value.
+    buckets: Vec<HashMap<u64, Vec<usize>>>,
+    fingerprints: Vec<u64>,
+}
+
+impl FingerprintIndex {
+    fn new(max_distance: u32) -> Self {
+        // 64 one-bit blocks cover distances up to 63; 64 matches anything.
+        let count = max_distance.min(63) + 1;
+        let mut blocks = Vec::with_capacity(count as usize);
+        let mut shift = 0;
+        for i in 0..count {
+            let width = 64 / count + u32::from(i < 64 % count);
+            let mask = if width == 64 { u64::MAX } else { (1 << width) - 1 };
+            blocks.push((shift, mask));
+            shift += width;
+        }
+        Self {
+            max_distance,
+            buckets: vec![HashMap::new(); blocks.len()],
+            blocks,
+            fingerprints: Vec::new(),
+        }
+    }
+
+    /// The position of the first fingerprint within `max_distance` of
+    /// `fingerprint`.
+    fn find(&self, fingerprint: u64) -> Option<usize> {
+        if self.max_distance >= 64 {
+            return (!self.fingerprints.is_empty()).then_some(0);
+        }
+        self.blocks
+            .iter()
+            .zip(&self.buckets)
+            .filter_map(|(&(shift, mask), bucket)| bucket.get(&(fingerprint >> shift & mask)))
+            .flatten()
+            .copied()
+            .filter(|&i| distance(self.fingerprints[i], fingerprint) <= self.max_distance)
+            .min()
+    }
+
+    fn insert(&mut self, fingerprint: u64) {
+        let pos = self.fingerprints.len();
+        for (&(shift, mask), bucket) in self.blocks.iter().zip(&mut self.buckets) {
+            bucket.entry(fingerprint >> shift & mask).or_default().push(pos);
+        }
+        self.fingerprints.push(fingerprint);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use tantivy::DocAddress;
+
+    fn hit(path: &str, fingerprint: u64) -> RankedHit {
+        RankedHit {
+            score: 1.0,
+            address: DocAddress::new(0, 0),
+            path: path.to_string(),
+            mtime: 0,
+            fingerprint,
+            duplicates: Vec::new(),
+        }
+    }
+
+    fn groups(hits: Vec<RankedHit>) -> Vec<(String, Vec<String>)> {
+        hits.into_iter().map(|h| (h.path, h.duplicates)).collect()
+    }
+
+    #[test]
+    fn folds_hits_into_the_first_close_fingerprint() {
+        let base = 0x0123_4567_89ab_cdef;
+        let hits = vec![
+            hit("a.go", base),
+            hit("b.go", !base),
+            hit("vendor/a.go", base),
+            hit("copy/a.go", base ^ 0b101),
+            hit("far/a.go", base ^ 0b1111),
+        ];
+        assert_eq!(
+            groups(collapse(hits, 3)),
+            vec![
+                ("a.go".to_string(), vec!["vendor/a.go".to_string(), "copy/a.go".to_string()]),
+                ("b.go".to_string(), vec![]),
+                ("far/a.go".to_string(), vec![]),
+            ]
+        );
+    }
+
+    #[test]
+    fn distance_zero_keeps_near_misses_apart() {
+        let hits = vec![hit("a", 7), hit("b", 7), hit("c", 6)];
+        assert_eq!(
+            groups(collapse(hits, 0)),
+            vec![("a".to_string(), vec!["b".to_string()]), ("c".to_string(), vec![])]
+        );
+    }
+
+    #[test]
+    fn finds_matches_spread_over_blocks() {
+        // Distance 3 cuts four 16-bit blocks; one flipped bit in each of
+        // three blocks still leaves the fourth to find the match by.
+        let mut index = FingerprintIndex::new(3);
+        index.insert(0);
+        assert_eq!(index.find(1 | 1 << 16 | 1 << 32), Some(0));
+        assert_eq!(index.find(1 | 1 << 16 | 1 << 32 | 1 << 48), None);
+
+        let mut index = FingerprintIndex::new(63);
+        index.insert(0);
+        assert_eq!(index.find(u64::MAX >> 1), Some(0));
+        assert_eq!(index.find(u64::MAX), None);
+    }
+}
@@ -0,0 +1,46 @@
+use std::path::Path;
+
+use crate::error::NsError;
+use crate::indexer::definitions::{read_definitions, Definition, SymbolKind};
+use crate::indexer::writer::read_meta;
+
+/// A definition site returned by [`search_symbols`].
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct DefinitionHit {
+    /// File path relative to the repo root.
+    pub path: String,
+    pub definition: Definition,
+}
+
+/// Finds where `name` is defined, from `.ns/definitions.json`.
+///
+/// Names match exactly and case-sensitively (`Server` does not find
+/// `NewServer` or `server`). Non-empty `kinds` restricts the kinds
+/// returned. Hits are ordered by path, then line.
+///
+/// Fails with [`NsError::NoDefinitions`] unless the index was built with
+/// `ns index --definitions`.
+pub fn search_symbols(
+    root: &Path,
+    name: &str,
+    kinds: &[SymbolKind],
+) -> Result<Vec<DefinitionHit>, NsError> {
+    let meta = read_meta(root)?;
+    if !meta.definitions {
+        return Err(NsError::NoDefinitions);
+    }
+    let defs = read_definitions(root)?;
+
+    let mut hits = Vec::new();
+    for (path, file_defs) in defs.files {
+        for def in file_defs {
+            if def.name == name && (kinds.is_empty() || kinds.contains(&def.kind)) {
+                hits.push(DefinitionHit {
+                    path: path.clone(),
+                    definition: def,
+                });
+            }
+        }
+    }
+    Ok(hits)
+}
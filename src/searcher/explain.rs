@@ -0,0 +1,130 @@
+use std::path::Path;
+
+use tantivy::collector::TopDocs;
+use tantivy::query::{Bm25StatisticsProvider, Query, TermQuery};
+use tantivy::schema::IndexRecordOption;
+use tantivy::{DocAddress, DocSet, Postings, Searcher, Term};
+
+use crate::error::NsError;
+use crate::indexer::writer::open_index;
+use crate::schema::path_field;
+
+use super::query::{build_index_queries, create_reader_with_retry, field_score, SearchOptions};
+
+/// How one query term contributed to a document's score, as listed by
+/// `SearchOptions::explain` and [`explain`].
+#[derive(Debug, Clone, PartialEq)]
+pub struct TermScore {
+    /// The field the term was matched in: `content`, `content_cased`,
+    /// `symbols`, `path_text` or a declared field (`IndexOptions::fields`).
+    pub field: String,
+    /// The term as indexed (lowercased, and stemmed in a `--stem` index).
+    pub term: String,
+    /// Occurrences of the term in the document's field.
+    pub tf: u32,
+    /// BM25 inverse document frequency: `ln(1 + (N - n + 0.5) / (n + 0.5))`
+    /// for `n` files containing the term out of `N`. Rare terms score high.
+    pub idf: f32,
+    /// The term's BM25 score in this document times the field's boost
+    /// (3 for `symbols`, `filename_boost` for `path_text`, a declared
+    /// field's boost for its terms).
+    pub score: f32,
+}
+
+/// Why a file scored what it did for a query.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Explanation {
+    /// File path relative to the repo root.
+    pub path: String,
+    /// The file's ranking score, 0 if it doesn't match the query.
+    pub score: f32,
+    /// The query terms found in the file, by field, in query order.
+    pub terms: Vec<TermScore>,
+}
+
+/// Explains the score of the file at `path` for `query_str`, searched with
+/// `opts`: the ranking score and each matched term's tf, idf and weighted
+/// contribution. Returns `None` if `path` isn't indexed.
+///
+/// Term scores add up to the ranking score for plain queries. Phrases,
+/// `--proximity-boost` and `opts.weights` multipliers are in `score` but
+/// not itemized, and fuzzy matches other than the exact term aren't listed.
+pub fn explain(
+    root: &Path,
+    query_str: &str,
+    path: &str,
+    opts: &SearchOptions,
+) -> Result<Option<Explanation>, NsError> {
+    let (index, meta) = open_index(root)?;
+    let reader = create_reader_with_retry(&index, root)?;
+    let searcher = reader.searcher();
+    let queries = build_index_queries(&index, &meta, &searcher, query_str, opts)?;
+
+    let term = Term::from_field_text(path_field(&index.schema()), path);
+    let found = searcher.search(
+        &TermQuery::new(term, IndexRecordOption::Basic),
+        &TopDocs::with_limit(1),
+    )?;
+    let Some(&(_, address)) = found.first() else {
+        return Ok(None);
+    };
+    let score = field_score(Some(queries.query()), &searcher, &searcher, address)
+        * queries.multiplier(path);
+    let terms = if score > 0.0 {
+        term_scores(&searcher, &searcher, &queries.term_queries(), address)?
+    } else {
+        Vec::new()
+    };
+    Ok(Some(Explanation {
+        path: path.to_string(),
+        score,
+        terms,
+    }))
+}
+
+/// The terms of each `(query, boost)` in `queries` that occur in the
+/// document at `address`, scored with `stats` like the search that ranked
+/// it. A term found by several queries is listed once.
+pub(crate) fn term_scores(
+    searcher: &Searcher,
+    stats: &dyn Bm25StatisticsProvider,
+    queries: &[(&dyn Query, f32)],
+    address: DocAddress,
+) -> Result<Vec<TermScore>, NsError> {
+    let mut terms: Vec<(Term, f32)> = Vec::new();
+    for &(query, boost) in queries {
+        query.query_terms(&mut |term, _| {
+            if !terms.iter().any(|(t, _)| t == term) {
+                terms.push((term.clone(), boost));
+            }
+        });
+    }
+
+    let schema = searcher.schema();
+    let segment = searcher.segment_reader(address.segment_ord);
+    let total_docs = stats.total_num_docs()? as f32;
+    let mut scores = Vec::new();
+    for (term, boost) in terms {
+        let postings = segment
+            .inverted_index(term.field())?
+            .read_postings(&term, IndexRecordOption::WithFreqs)?;
+        let Some(mut postings) = postings else {
+            continue;
+        };
+        if postings.seek(address.doc_id) != address.doc_id {
+            continue;
+        }
+        let tf = postings.term_freq();
+        let doc_freq = stats.doc_freq(&term)? as f32;
+        let idf = (1.0 + (total_docs - doc_freq + 0.5) / (doc_freq + 0.5)).ln();
+        let term_query = TermQuery::new(term.clone(), IndexRecordOption::WithFreqs);
+        scores.push(TermScore {
+            field: schema.get_field_name(term.field()).to_string(),
+            term: String::from_utf8_lossy(term.serialized_value_bytes()).into_owned(),
+            tf,
+            idf,
+            score: field_score(Some(&term_query), searcher, stats, address) * boost,
+        });
+    }
+    Ok(scores)
+}
@@ -1,11 +1,35 @@
+use super::context::{tokenize_query, ContextLine, TermMatch};
 use super::DisplayResult;
 use super::query::SearchStats;
 
+/// ANSI styles for colored text output, in ripgrep's palette.
+const PATH_STYLE: &str = "\x1b[35m";
+const LINE_NUMBER_STYLE: &str = "\x1b[32m";
+const MATCH_STYLE: &str = "\x1b[1;31m";
+const RESET: &str = "\x1b[0m";
+
 /// Formats a single DisplayResult as human-readable text.
 ///
 /// Used by the incremental budget-aware pipeline.
 pub fn format_single_text(display: &DisplayResult) -> String {
+    format_text(display, false)
+}
+
+/// Like [`format_single_text`], with the path, line numbers and matched
+/// terms colored by ANSI escapes, for a terminal.
+pub fn format_single_text_colored(display: &DisplayResult) -> String {
+    format_text(display, true)
+}
+
+fn format_text(display: &DisplayResult, color: bool) -> String {
     let mut out = String::new();
+    let paint = |style: &str, text: &str| {
+        if color {
+            format!("{}{}{}", style, text, RESET)
+        } else {
+            text.to_string()
+        }
+    };
 
     // Header line: [rank] path (score, lang)
     let lang_str = display
@@ -16,7 +40,10 @@ pub fn format_single_text(display: &DisplayResult) -> String {
 
     out.push_str(&format!(
         " [{}] {}  (score: {:.1}, lang: {})\n",
-        display.rank, display.result.path, display.result.score, lang_str
+        display.rank,
+        paint(PATH_STYLE, &display.result.path),
+        display.result.score,
+        lang_str
     ));
 
     // Short ranking annotation when there are matched fields
@@ -27,6 +54,18 @@ pub fn format_single_text(display: &DisplayResult) -> String {
             fields, display.result.score_content, display.result.score_symbols
         ));
     }
+    for t in &display.result.terms {
+        out.push_str(&format!(
+            "      ~ term {}:{}  tf: {}, idf: {:.2}, score: {:.2}\n",
+            t.field, t.term, t.tf, t.idf, t.score
+        ));
+    }
+    if !display.result.duplicates.is_empty() {
+        out.push_str(&format!(
+            "      ~ duplicates: {}\n",
+            display.result.duplicates.join(", ")
+        ));
+    }
 
     // Context lines — insert "..." separator between non-contiguous groups
     let mut prev_line_number: Option<usize> = None;
@@ -36,9 +75,15 @@ pub fn format_single_text(display: &DisplayResult) -> String {
                 out.push_str("          ...\n");
             }
         }
+        let text = if color {
+            highlight_matches(line, &display.matches)
+        } else {
+            line.text.clone()
+        };
         out.push_str(&format!(
-            "     {:>4}: {}\n",
-            line.line_number, line.text
+            "     {}: {}\n",
+            paint(LINE_NUMBER_STYLE, &format!("{:>4}", line.line_number)),
+            text
         ));
         prev_line_number = Some(line.line_number);
     }
@@ -50,6 +95,9 @@ pub fn format_single_text(display: &DisplayResult) -> String {
             display.truncated_count
         ));
     }
+    if display.omitted_matches > 0 {
+        out.push_str(&format!("      ... ({} more matches)\n", display.omitted_matches));
+    }
 
     // Blank line between results
     out.push('\n');
@@ -57,16 +105,75 @@ pub fn format_single_text(display: &DisplayResult) -> String {
     out
 }
 
+/// Wraps each of `matches` that falls on `line` in [`MATCH_STYLE`].
+/// Matches arrive in line order and never overlap.
+fn highlight_matches(line: &ContextLine, matches: &[TermMatch]) -> String {
+    let mut out = String::new();
+    let mut pos = 0;
+    for m in matches.iter().filter(|m| m.line == line.line_number) {
+        let Some(matched) = line.text.get(m.start..m.end).filter(|_| m.start >= pos) else {
+            continue;
+        };
+        out.push_str(&line.text[pos..m.start]);
+        out.push_str(MATCH_STYLE);
+        out.push_str(matched);
+        out.push_str(RESET);
+        pos = m.end;
+    }
+    out.push_str(&line.text[pos..]);
+    out
+}
+
+/// Formats a single DisplayResult grep-style (`--format grep`), one
+/// `path:line:text` line per matching line and `path-line-text` per
+/// context line, with `--` between non-adjacent runs.
+///
+/// Matching lines are the result's tracked `lines` when it has them, and
+/// the lines with a highlighted match otherwise.
+pub fn format_single_grep(display: &DisplayResult) -> String {
+    let matched = |n: usize| {
+        if display.result.lines.is_empty() {
+            display.matches.iter().any(|m| m.line == n)
+        } else {
+            display.result.lines.contains(&n)
+        }
+    };
+    let mut out = String::new();
+    let mut prev: Option<usize> = None;
+    for line in &display.context_lines {
+        if prev.is_some_and(|p| p + 1 != line.line_number) {
+            out.push_str("--\n");
+        }
+        prev = Some(line.line_number);
+        let sep = if matched(line.line_number) { ':' } else { '-' };
+        out.push_str(&format!(
+            "{}{sep}{}{sep}{}\n",
+            display.result.path, line.line_number, line.text
+        ));
+    }
+    out
+}
+
 /// Formats the search summary line (e.g. "3 results (searched 42 files in 2ms)").
+/// When more files matched than were returned, it shows both
+/// ("20 of 412 results ..."), with a `+` when the total is a lower bound.
 ///
 /// Separated from `format_text` so the CLI layer can direct this to stderr,
 /// keeping stdout reserved for result data only.
 pub fn format_summary(stats: &SearchStats) -> String {
-    let result_word = if stats.total_results == 1 { "result" } else { "results" };
+    let shown = stats.total_results.max(stats.total_matches);
+    let result_word = if shown == 1 { "result" } else { "results" };
     let file_word = if stats.files_searched == 1 { "file" } else { "files" };
+    let count = if stats.total_is_lower_bound {
+        format!("{} of {}+", stats.total_results, stats.total_matches)
+    } else if stats.total_matches > stats.total_results {
+        format!("{} of {}", stats.total_results, stats.total_matches)
+    } else {
+        stats.total_results.to_string()
+    };
     format!(
         "{} {} (searched {} {} in {}ms)",
-        stats.total_results, result_word, stats.files_searched, file_word, stats.elapsed_ms
+        count, result_word, stats.files_searched, file_word, stats.elapsed_ms
     )
 }
 
@@ -74,11 +181,7 @@ pub fn format_summary(stats: &SearchStats) -> String {
 ///
 /// Used by the incremental budget-aware pipeline.
 pub fn format_single_json_value(d: &DisplayResult, query_str: &str) -> serde_json::Value {
-    let query_terms: Vec<String> = query_str
-        .split(|c: char| !c.is_alphanumeric())
-        .filter(|s| !s.is_empty())
-        .map(|s| s.to_lowercase())
-        .collect();
+    let query_terms = tokenize_query(query_str);
 
     let matched: Vec<&str> = d
         .result
@@ -102,6 +205,19 @@ pub fn format_single_json_value(d: &DisplayResult, query_str: &str) -> serde_jso
         })
         .collect();
 
+    let matches: Vec<serde_json::Value> = d
+        .matches
+        .iter()
+        .map(|m| {
+            serde_json::json!({
+                "line": m.line,
+                "start": m.start,
+                "end": m.end,
+                "term": m.term,
+            })
+        })
+        .collect();
+
     let mut value = serde_json::json!({
         "rank": d.rank,
         "path": d.result.path,
@@ -109,6 +225,7 @@ pub fn format_single_json_value(d: &DisplayResult, query_str: &str) -> serde_jso
         "lang": d.result.lang,
         "matched_symbols": matched,
         "lines": lines,
+        "matches": matches,
         "ranking_factors": {
             "bm25_content": ((d.result.score_content as f64) * 10.0).round() / 10.0,
             "bm25_symbols": ((d.result.score_symbols as f64) * 10.0).round() / 10.0,
@@ -120,6 +237,38 @@ pub fn format_single_json_value(d: &DisplayResult, query_str: &str) -> serde_jso
     if d.truncated_count > 0 {
         value["truncated_lines"] = serde_json::json!(d.truncated_count);
     }
+    if d.omitted_matches > 0 {
+        value["more_matches"] = serde_json::json!(d.omitted_matches);
+    }
+    if !d.result.meta.is_empty() {
+        value["meta"] = serde_json::json!(d.result.meta);
+    }
+    if !d.result.duplicates.is_empty() {
+        value["duplicates"] = serde_json::json!(d.result.duplicates);
+    }
+    if !d.result.lines.is_empty() {
+        value["match_lines"] = serde_json::json!(d.result.lines);
+    }
+    if !d.result.positions.is_empty() {
+        value["positions"] = serde_json::json!(d.result.positions);
+    }
+    if !d.result.terms.is_empty() {
+        let terms: Vec<serde_json::Value> = d
+            .result
+            .terms
+            .iter()
+            .map(|t| {
+                serde_json::json!({
+                    "field": t.field,
+                    "term": t.term,
+                    "tf": t.tf,
+                    "idf": ((t.idf as f64) * 100.0).round() / 100.0,
+                    "score": ((t.score as f64) * 100.0).round() / 100.0,
+                })
+            })
+            .collect();
+        value["ranking_factors"]["terms"] = serde_json::json!(terms);
+    }
 
     value
 }
@@ -127,7 +276,8 @@ pub fn format_single_json_value(d: &DisplayResult, query_str: &str) -> serde_jso
 #[cfg(test)]
 mod tests {
     use super::*;
-    use crate::searcher::context::ContextLine;
+    use crate::indexer::tokenizer::Stemming;
+    use crate::searcher::context::{find_matches_in_lines, ContextLine};
     use crate::searcher::query::{SearchResult, SearchStats};
     use crate::searcher::DisplayResult;
 
@@ -154,9 +304,16 @@ mod tests {
                 score_content,
                 score_symbols,
                 matched_fields: matched_fields.into_iter().map(|s| s.to_string()).collect(),
+                meta: Default::default(),
+                duplicates: Vec::new(),
+                terms: Vec::new(),
+                lines: Vec::new(),
+                positions: Default::default(),
             },
             context_lines,
             truncated_count,
+            omitted_matches: 0,
+            matches: Vec::new(),
         }
     }
 
@@ -186,6 +343,8 @@ mod tests {
     fn format_summary_correct() {
         let stats = SearchStats {
             total_results: 3,
+            total_matches: 3,
+            total_is_lower_bound: false,
             files_searched: 42,
             elapsed_ms: 2,
         };
@@ -193,6 +352,8 @@ mod tests {
 
         let stats_one = SearchStats {
             total_results: 1,
+            total_matches: 1,
+            total_is_lower_bound: false,
             files_searched: 1,
             elapsed_ms: 0,
         };
@@ -200,10 +361,30 @@ mod tests {
 
         let stats_zero = SearchStats {
             total_results: 0,
+            total_matches: 0,
+            total_is_lower_bound: false,
             files_searched: 100,
             elapsed_ms: 1,
         };
         assert_eq!(format_summary(&stats_zero), "0 results (searched 100 files in 1ms)");
+
+        let stats_page = SearchStats {
+            total_results: 20,
+            total_matches: 412,
+            total_is_lower_bound: false,
+            files_searched: 500,
+            elapsed_ms: 3,
+        };
+        assert_eq!(format_summary(&stats_page), "20 of 412 results (searched 500 files in 3ms)");
+
+        let stats_early = SearchStats {
+            total_results: 10,
+            total_matches: 11,
+            total_is_lower_bound: true,
+            files_searched: 500,
+            elapsed_ms: 1,
+        };
+        assert_eq!(format_summary(&stats_early), "10 of 11+ results (searched 500 files in 1ms)");
     }
 
     #[test]
@@ -260,7 +441,7 @@ mod tests {
 
     #[test]
     fn single_json_value_structure() {
-        let display = make_display(
+        let mut display = make_display(
             1, "src/event_store.rs", 12.4, Some("rust"),
             vec!["EventStore", "new"], 4.2, 2.8,
             vec!["content", "symbols"],
@@ -269,6 +450,8 @@ mod tests {
             ],
             0,
         );
+        let terms = tokenize_query("EventStore");
+        display.matches = find_matches_in_lines(&display.context_lines, &terms, Stemming::Noop);
 
         let parsed = format_single_json_value(&display, "EventStore");
 
@@ -277,6 +460,10 @@ mod tests {
         assert_eq!(parsed["lang"], "rust");
         assert_eq!(parsed["matched_symbols"][0], "EventStore");
         assert_eq!(parsed["lines"][0]["num"], 5);
+        assert_eq!(parsed["matches"][0]["line"], 5);
+        assert_eq!(parsed["matches"][0]["start"], 11);
+        assert_eq!(parsed["matches"][0]["end"], 21);
+        assert_eq!(parsed["matches"][0]["term"], "eventstore");
 
         // ranking_factors should be present
         let rf = &parsed["ranking_factors"];
@@ -307,6 +494,27 @@ mod tests {
         assert_eq!(matched[0], "EventStore");
     }
 
+    #[test]
+    fn colored_text_highlights_matches() {
+        let mut display = make_display(
+            1, "src/main.rs", 8.5, Some("rust"),
+            vec![], 6.0, 0.0,
+            vec!["content"],
+            vec![ContextLine { line_number: 10, text: "let main = Main::new();".to_string() }],
+            0,
+        );
+        let terms = ["main".to_string()];
+        display.matches = find_matches_in_lines(&display.context_lines, &terms, Stemming::Noop);
+
+        let output = format_single_text_colored(&display);
+        assert!(output.contains("\x1b[35msrc/main.rs\x1b[0m"));
+        assert!(output.contains("\x1b[32m  10\x1b[0m: "));
+        assert!(output.contains("let \x1b[1;31mmain\x1b[0m = \x1b[1;31mMain\x1b[0m::new();"));
+
+        let plain = format_single_text(&display);
+        assert!(!plain.contains('\x1b'));
+    }
+
     #[test]
     fn single_text_shows_truncation_indicator() {
         let display = make_display(
@@ -380,4 +588,32 @@ mod tests {
             "JSON should not include truncated_lines when truncated_count=0"
         );
     }
+
+    #[test]
+    fn grep_marks_matching_and_context_lines() {
+        let mut display = make_display(
+            1, "src/lib.rs", 5.0, Some("rust"),
+            vec![], 5.0, 0.0,
+            vec!["content"],
+            vec![
+                ContextLine { line_number: 3, text: "use store;".to_string() },
+                ContextLine { line_number: 4, text: "fn open() {}".to_string() },
+                ContextLine { line_number: 9, text: "let store = 1;".to_string() },
+            ],
+            0,
+        );
+        let terms = ["store".to_string()];
+        display.matches = find_matches_in_lines(&display.context_lines, &terms, Stemming::Noop);
+        assert_eq!(
+            format_single_grep(&display),
+            "src/lib.rs:3:use store;\nsrc/lib.rs-4-fn open() {}\n--\nsrc/lib.rs:9:let store = 1;\n"
+        );
+
+        // Tracked lines decide, not substring matches.
+        display.result.lines = vec![4];
+        assert_eq!(
+            format_single_grep(&display),
+            "src/lib.rs-3-use store;\nsrc/lib.rs:4:fn open() {}\n--\nsrc/lib.rs-9-let store = 1;\n"
+        );
+    }
 }
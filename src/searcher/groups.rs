@@ -0,0 +1,172 @@
+use std::collections::BTreeMap;
+use std::path::Path;
+use std::time::Instant;
+
+use super::query::{
+    build_index_queries, create_reader_with_retry, load_result, search_page, RankedHit,
+    SearchOptions, SearchResult, SearchStats, MAX_RESULTS_CEILING,
+};
+use crate::error::NsError;
+use crate::indexer::metadata::read_metadata;
+use crate::indexer::writer::open_index;
+
+/// How a [`DirGroup`]'s score combines the scores of its files.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub enum GroupScore {
+    /// The sum: a directory with many relevant files ranks first.
+    #[default]
+    Sum,
+    /// The best file's score: a directory with one strong hit ranks as
+    /// high as that file would.
+    Max,
+}
+
+/// Options of [`search_grouped`].
+#[derive(Debug, Clone)]
+pub struct GroupOptions {
+    /// Directory levels below the root to group by: at 1, `src/indexer/`
+    /// and `src/searcher/` files are both in `src`; at 2, in their own
+    /// directories. Files fewer levels deep are grouped by the directory
+    /// they are in, and 0 puts every file in one group.
+    pub depth: usize,
+    /// How a group's score combines its files' scores.
+    pub score: GroupScore,
+    /// Most files listed per group, the best-ranked ones.
+    pub top_files: usize,
+}
+
+impl Default for GroupOptions {
+    fn default() -> Self {
+        Self {
+            depth: 1,
+            score: GroupScore::Sum,
+            top_files: 3,
+        }
+    }
+}
+
+/// The matches of [`search_grouped`] in one directory.
+#[derive(Debug)]
+pub struct DirGroup {
+    /// The directory relative to the repo root, `""` for the root itself.
+    pub dir: String,
+    /// Its files' scores summed, or the best of them (`GroupOptions::score`).
+    pub score: f32,
+    /// How many matching files it holds.
+    pub hits: usize,
+    /// Its best-ranked files, at most `GroupOptions::top_files`, in the
+    /// order a plain search would list them.
+    pub top_files: Vec<SearchResult>,
+}
+
+/// Runs `query_str` as [`execute_search`](super::query::execute_search)
+/// does, then groups the matching files by directory for an overview of
+/// where in a tree the relevant code is, before looking at single files.
+///
+/// Every match counts towards its group, not just a page of them, so the
+/// whole result set is ranked; only the files listed in `top_files` are
+/// loaded as results. Groups are ordered by score, then directory, and
+/// `opts.offset` and `opts.max_results` page through the groups rather
+/// than the files. Filters, weights and deduplication apply to the files
+/// first, as in a plain search; `opts.early_termination` is ignored.
+///
+/// In the returned stats, `total_results` counts the groups returned and
+/// `total_matches` those before paging.
+pub fn search_grouped(
+    root: &Path,
+    query_str: &str,
+    opts: &SearchOptions,
+    group_opts: &GroupOptions,
+) -> Result<(Vec<DirGroup>, SearchStats), NsError> {
+    let opts = SearchOptions {
+        early_termination: false,
+        ..opts.clone()
+    };
+    let (index, meta) = open_index(root)?;
+    let reader = create_reader_with_retry(&index, root)?;
+    let searcher = reader.searcher();
+    let metadata = read_metadata(root)?;
+    let glob = opts.file_glob.as_deref().map(glob::Pattern::new).transpose()?;
+    let queries = build_index_queries(&index, &meta, &searcher, query_str, &opts)?;
+
+    let start = Instant::now();
+    let page = search_page(&searcher, &queries, &searcher, glob.as_ref(), 0, usize::MAX)?;
+    let mut by_dir: BTreeMap<String, Vec<RankedHit>> = BTreeMap::new();
+    for hit in page.hits {
+        by_dir
+            .entry(group_dir(&hit.path, group_opts.depth).to_string())
+            .or_default()
+            .push(hit);
+    }
+    let mut groups: Vec<(String, f32, Vec<RankedHit>)> = by_dir
+        .into_iter()
+        .map(|(dir, hits)| {
+            let scores = hits.iter().map(|hit| hit.score);
+            let score = match group_opts.score {
+                GroupScore::Sum => scores.sum(),
+                GroupScore::Max => scores.fold(0.0, f32::max),
+            };
+            (dir, score, hits)
+        })
+        .collect();
+    groups.sort_by(|a, b| b.1.total_cmp(&a.1).then_with(|| a.0.cmp(&b.0)));
+    let total_groups = groups.len();
+
+    let max_results = opts.max_results.min(MAX_RESULTS_CEILING);
+    let mut page_groups = Vec::new();
+    for (dir, score, hits) in groups.into_iter().skip(opts.offset).take(max_results) {
+        let count = hits.len();
+        let mut top_files = hits
+            .into_iter()
+            .take(group_opts.top_files)
+            .map(|hit| load_result(&searcher, &searcher, &queries, hit))
+            .collect::<Result<Vec<_>, _>>()?;
+        for result in &mut top_files {
+            result.meta = metadata.get(&result.path);
+        }
+        page_groups.push(DirGroup {
+            dir,
+            score,
+            hits: count,
+            top_files,
+        });
+    }
+
+    let stats = SearchStats {
+        total_results: page_groups.len(),
+        total_matches: total_groups,
+        total_is_lower_bound: false,
+        files_searched: meta.file_count,
+        elapsed_ms: start.elapsed().as_millis() as u64,
+    };
+    Ok((page_groups, stats))
+}
+
+/// The directory `path` is grouped under: its first `depth` directories,
+/// or all of them if it has fewer.
+fn group_dir(path: &str, depth: usize) -> &str {
+    if depth == 0 {
+        return "";
+    }
+    let dir = path.rfind('/').map_or("", |i| &path[..i]);
+    let end = dir
+        .match_indices('/')
+        .nth(depth - 1)
+        .map_or(dir.len(), |(i, _)| i);
+    &dir[..end]
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn files_group_by_their_leading_directories() {
+        assert_eq!(group_dir("src/searcher/query.rs", 1), "src");
+        assert_eq!(group_dir("src/searcher/query.rs", 2), "src/searcher");
+        assert_eq!(group_dir("src/searcher/query.rs", 5), "src/searcher");
+        assert_eq!(group_dir("src/lib.rs", 2), "src");
+        assert_eq!(group_dir("README.md", 1), "");
+        assert_eq!(group_dir("src/searcher/query.rs", 0), "");
+    }
+}
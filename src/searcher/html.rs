@@ -0,0 +1,180 @@
+use super::context::{ContextLine, TermMatch};
+
+/// Renders snippets as HTML with matched spans wrapped in a tag, for
+/// embedding results in a web page. File content is untrusted: everything
+/// outside the wrapper tags is HTML-escaped.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct HtmlHighlighter {
+    open: String,
+    close: String,
+}
+
+impl Default for HtmlHighlighter {
+    /// Wraps matches in a bare `<mark>`.
+    fn default() -> Self {
+        Self::new("mark", None).expect("mark is a valid tag")
+    }
+}
+
+impl HtmlHighlighter {
+    /// A highlighter wrapping matches in `<tag>`, or `<tag class="...">`
+    /// with `class`. The class is escaped as an attribute value.
+    ///
+    /// Returns `None` if `tag` is not an element name (ASCII letters, then
+    /// letters, digits or `-`), since it is written into the markup as is.
+    pub fn new(tag: &str, class: Option<&str>) -> Option<Self> {
+        let mut chars = tag.chars();
+        let valid = chars.next().is_some_and(|c| c.is_ascii_alphabetic())
+            && chars.all(|c| c.is_ascii_alphanumeric() || c == '-');
+        if !valid {
+            return None;
+        }
+        let open = match class {
+            Some(class) => format!("<{} class=\"{}\">", tag, escape_html(class)),
+            None => format!("<{}>", tag),
+        };
+        Some(Self {
+            open,
+            close: format!("</{}>", tag),
+        })
+    }
+
+    /// Renders `snippet` with each `start..end` byte span wrapped.
+    ///
+    /// Offsets that fall inside a multi-byte character are widened to the
+    /// enclosing character boundaries, so a character is never split. Spans
+    /// may arrive in any order; overlapping or adjacent ones are merged and
+    /// out-of-range ones clamped to the snippet.
+    pub fn render(&self, snippet: &str, spans: &[(usize, usize)]) -> String {
+        let mut spans: Vec<(usize, usize)> = spans
+            .iter()
+            .map(|&(start, end)| (floor_boundary(snippet, start), ceil_boundary(snippet, end)))
+            .filter(|(start, end)| start < end)
+            .collect();
+        spans.sort_unstable();
+
+        let mut out = String::with_capacity(snippet.len());
+        let mut pos = 0;
+        let mut iter = spans.into_iter().peekable();
+        while let Some((start, mut end)) = iter.next() {
+            while let Some(&(next_start, next_end)) = iter.peek() {
+                if next_start > end {
+                    break;
+                }
+                end = end.max(next_end);
+                iter.next();
+            }
+            out.push_str(&escape_html(&snippet[pos..start]));
+            out.push_str(&self.open);
+            out.push_str(&escape_html(&snippet[start..end]));
+            out.push_str(&self.close);
+            pos = end;
+        }
+        out.push_str(&escape_html(&snippet[pos..]));
+        out
+    }
+
+    /// Renders a context line with the `matches` that fall on it wrapped,
+    /// as found by the search that produced the line.
+    pub fn render_line(&self, line: &ContextLine, matches: &[TermMatch]) -> String {
+        let spans: Vec<(usize, usize)> = matches
+            .iter()
+            .filter(|m| m.line == line.line_number)
+            .map(|m| (m.start, m.end))
+            .collect();
+        self.render(&line.text, &spans)
+    }
+}
+
+/// Escapes text for an HTML element body or a quoted attribute value.
+fn escape_html(text: &str) -> String {
+    let mut out = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '&' => out.push_str("&amp;"),
+            '<' => out.push_str("&lt;"),
+            '>' => out.push_str("&gt;"),
+            '"' => out.push_str("&quot;"),
+            '\'' => out.push_str("&#39;"),
+            _ => out.push(c),
+        }
+    }
+    out
+}
+
+/// The largest char boundary of `s` at or before `i`.
+fn floor_boundary(s: &str, i: usize) -> usize {
+    let mut i = i.min(s.len());
+    while !s.is_char_boundary(i) {
+        i -= 1;
+    }
+    i
+}
+
+/// The smallest char boundary of `s` at or after `i`.
+fn ceil_boundary(s: &str, i: usize) -> usize {
+    let mut i = i.min(s.len());
+    while !s.is_char_boundary(i) {
+        i += 1;
+    }
+    i
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::indexer::tokenizer::Stemming;
+    use crate::searcher::context::{extract_snippets, find_matches_in_lines, SnippetOptions};
+
+    #[test]
+    fn escapes_script_in_file_but_not_match_markers() {
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::write(
+            dir.path().join("page.html"),
+            "<p>intro</p>\n<script>alert(\"token\")</script>\n",
+        )
+        .unwrap();
+        let terms = vec!["token".to_string()];
+        let context = extract_snippets(
+            dir.path(),
+            "page.html",
+            &terms,
+            Stemming::Noop,
+            &SnippetOptions::around(0),
+            None,
+        );
+        let matches = find_matches_in_lines(&context.lines, &terms, Stemming::Noop);
+        assert_eq!(context.lines.len(), 1);
+
+        let html = HtmlHighlighter::new("em", Some("hit \"x\"")).unwrap();
+        assert_eq!(
+            html.render_line(&context.lines[0], &matches),
+            "&lt;script&gt;alert(&quot;<em class=\"hit &quot;x&quot;\">token</em>&quot;)&lt;/script&gt;"
+        );
+    }
+
+    #[test]
+    fn offsets_inside_multibyte_chars_widen_to_boundaries() {
+        // "é" is bytes 3..5 and "日" bytes 6..9.
+        let html = HtmlHighlighter::default();
+        assert_eq!(html.render("café 日本", &[(4, 5)]), "caf<mark>é</mark> 日本");
+        assert_eq!(html.render("café 日本", &[(7, 8)]), "café <mark>日</mark>本");
+    }
+
+    #[test]
+    fn overlapping_and_unsorted_spans_merge() {
+        let html = HtmlHighlighter::default();
+        assert_eq!(
+            html.render("a<b> & c", &[(7, 8), (0, 2), (1, 4), (40, 50)]),
+            "<mark>a&lt;b&gt;</mark> &amp; <mark>c</mark>"
+        );
+    }
+
+    #[test]
+    fn rejects_tags_that_are_not_element_names() {
+        assert!(HtmlHighlighter::new("script><img", None).is_none());
+        assert!(HtmlHighlighter::new("", None).is_none());
+        assert!(HtmlHighlighter::new("1b", None).is_none());
+        assert!(HtmlHighlighter::new("x-hit", None).is_some());
+    }
+}
@@ -1,14 +1,37 @@
+pub mod cached;
+pub mod contents;
 pub mod context;
+pub mod dedup;
+pub mod definitions;
+pub mod explain;
 pub mod format;
+pub mod groups;
+pub mod html;
+pub mod multi;
 pub mod query;
+pub mod query_ast;
+pub mod read_only;
+pub mod regex_search;
+pub mod search_in;
 pub mod spans;
+pub mod substring;
+pub mod suggest;
+pub mod synonyms;
 
 use std::path::Path;
 
 use crate::error::NsError;
-use context::{extract_context, ContextLine};
-use format::{format_single_text, format_single_json_value};
+use crate::indexer::tokenizer::Stemming;
+use crate::indexer::writer::read_meta;
+use context::{
+    extract_snippets, find_matches_in_lines, snippets_at_lines, tokenize_query, truncate_long_lines,
+    ContextLine, TermMatch,
+};
+use format::{
+    format_single_grep, format_single_json_value, format_single_text, format_single_text_colored,
+};
 use query::{execute_search, SearchOptions, SearchResult, SearchStats};
+use synonyms::SynonymMap;
 
 /// A search result with extracted context lines, ready for display.
 #[derive(Debug)]
@@ -18,6 +41,10 @@ pub struct DisplayResult {
     pub context_lines: Vec<ContextLine>,
     /// Number of context lines omitted due to per-file cap.
     pub truncated_count: usize,
+    /// Number of matching lines omitted by `SearchOptions::max_per_file`.
+    pub omitted_matches: usize,
+    /// Match positions within `context_lines`.
+    pub matches: Vec<TermMatch>,
 }
 
 /// Output of the search pipeline, including budget metadata.
@@ -27,6 +54,11 @@ pub struct SearchOutput {
     pub stats: SearchStats,
     pub budget_exhausted: bool,
     pub results_omitted: usize,
+    /// A regex search hit its timeout; results cover only the files scanned.
+    pub timed_out: bool,
+    /// Spelling corrections of the query when it found nothing (see
+    /// `suggest::did_you_mean`); empty otherwise, and for regex searches.
+    pub suggestions: Vec<String>,
 }
 
 /// Output mode for formatting results.
@@ -37,18 +69,36 @@ pub enum OutputMode {
     FilesOnly,
     /// Machine-readable JSON (`--json`).
     Json,
+    /// One JSON object per result, one per line (`--format jsonl`), for
+    /// streaming into tools like `jq`. Results have the `--json` schema.
+    JsonLines,
+    /// `path:line:text` per matching line, like `grep -n` (`--format grep`).
+    Grep,
 }
 
 /// Runs the full search pipeline: query → context extraction → formatting.
 ///
 /// Returns a `SearchOutput` containing formatted output, stats, and budget metadata.
+/// With `opts.regex`, `query_str` is a regex pattern (see `regex_search::search_regex`).
+/// A query with no matches also gets `suggestions`, which JSON output
+/// includes as a top-level `suggestions` array.
 pub fn search(
     root: &Path,
     query_str: &str,
     output_mode: OutputMode,
     opts: &SearchOptions,
 ) -> Result<SearchOutput, NsError> {
+    if opts.regex {
+        return regex_search::search_regex(root, query_str, output_mode, opts);
+    }
     let (results, stats) = execute_search(root, query_str, opts)?;
+    opts.cancel.check()?;
+    // Corrections are only a hint: failing to find them doesn't fail the search.
+    let suggestions = if stats.total_matches == 0 {
+        suggest::did_you_mean(root, query_str).unwrap_or_default()
+    } else {
+        Vec::new()
+    };
 
     match output_mode {
         OutputMode::FilesOnly => {
@@ -59,6 +109,8 @@ pub fn search(
                 stats,
                 budget_exhausted,
                 results_omitted,
+                timed_out: false,
+                suggestions,
             })
         }
         OutputMode::Text => {
@@ -69,16 +121,50 @@ pub fn search(
                 stats,
                 budget_exhausted,
                 results_omitted,
+                timed_out: false,
+                suggestions,
             })
         }
         OutputMode::Json => {
             let (output, budget_exhausted, results_omitted) =
-                build_json_with_budget(root, results, query_str, opts, &stats);
+                build_json_with_budget(root, results, query_str, opts, &stats, &suggestions);
             Ok(SearchOutput {
                 formatted: output,
                 stats,
                 budget_exhausted,
                 results_omitted,
+                timed_out: false,
+                suggestions,
+            })
+        }
+        OutputMode::JsonLines => {
+            let (output, budget_exhausted, results_omitted) =
+                build_json_lines_with_budget(root, results, query_str, opts);
+            Ok(SearchOutput {
+                formatted: output,
+                stats,
+                budget_exhausted,
+                results_omitted,
+                timed_out: false,
+                suggestions,
+            })
+        }
+        OutputMode::Grep => {
+            let total = results.len();
+            let (query_terms, stemming) = highlight_terms(root, query_str, &opts.synonyms);
+            let displays = results.into_iter().enumerate().map(|(i, result)| {
+                let terms = &query_terms;
+                term_display(root, opts.offset + i + 1, result, query_str, terms, stemming, opts)
+            });
+            let (output, budget_exhausted, results_omitted) =
+                render_grep_with_budget(displays, total, opts.budget);
+            Ok(SearchOutput {
+                formatted: output,
+                stats,
+                budget_exhausted,
+                results_omitted,
+                timed_out: false,
+                suggestions,
             })
         }
     }
@@ -116,33 +202,120 @@ fn build_text_with_budget(
     query_str: &str,
     opts: &SearchOptions,
 ) -> (String, bool, usize) {
-    let budget_chars = opts.budget.map(|b| b * 4);
-    let mut out = String::new();
     let total = results.len();
+    let (query_terms, stemming) = highlight_terms(root, query_str, &opts.synonyms);
+    let displays = results
+        .into_iter()
+        .enumerate()
+        .map(|(i, result)| {
+            let terms = &query_terms;
+                term_display(root, opts.offset + i + 1, result, query_str, terms, stemming, opts)
+        });
+    render_text_with_budget(displays, total, opts.budget, opts.color)
+}
+
+/// Query terms to highlight in context lines: [`tokenize_query`] and the
+/// terms' `synonyms` minus the index's stop words, which never cause a
+/// match, plus each term as the index's analyzer filters it when that
+/// differs, and the index's stemming. In a stemmed index `connections`
+/// also looks for `connect`, which shows and highlights the words with that
+/// stem, like `connection`, as whole tokens (see
+/// [`context::find_term_matches`]). Filters nothing if the index metadata
+/// can't be read.
+fn highlight_terms(
+    root: &Path,
+    query_str: &str,
+    synonyms: &SynonymMap,
+) -> (Vec<String>, Stemming) {
+    let meta = read_meta(root).ok();
+    let stop_words = meta.as_ref().map(|m| m.stop_words.as_slice()).unwrap_or_default();
+    let analyzer = meta.as_ref().and_then(|m| m.content_analyzer().ok());
+    let stemming = analyzer.as_ref().map(|a| a.stemming()).unwrap_or_default();
+    let mut normalizer = analyzer.map(|a| a.normalizer(stop_words));
+
+    let mut terms = tokenize_query(query_str);
+    terms.extend(synonyms.expansions(&terms));
+    terms.retain(|t| !stop_words.contains(t));
+    let stems: Vec<String> = terms
+        .iter()
+        .filter_map(|t| normalizer.as_mut()?.normalize(t))
+        .filter(|s| !s.is_empty() && !terms.contains(s))
+        .collect();
+    terms.extend(stems);
+    terms.dedup();
+    (terms, stemming)
+}
+
+/// Builds the display form of a ranked result: context lines around the
+/// query terms (or AST spans with `opts.spans`) and the term positions in them.
+/// Results with tracked `lines` get context around those lines, rather
+/// than around every line containing a query term. `query_terms` and
+/// `stemming` are from [`highlight_terms`].
+fn term_display(
+    root: &Path,
+    rank: usize,
+    result: SearchResult,
+    query_str: &str,
+    query_terms: &[String],
+    stemming: Stemming,
+    opts: &SearchOptions,
+) -> DisplayResult {
+    let mut ctx = if opts.spans {
+        spans::extract_best_spans(root, &result.path, query_str, opts.max_context_lines)
+    } else if !result.lines.is_empty() {
+        let snippet = opts.snippet_options();
+        let (lines, max_lines) = (&result.lines, opts.max_context_lines);
+        snippets_at_lines(root, &result.path, lines, query_terms, stemming, &snippet, max_lines)
+    } else {
+        let snippet = opts.snippet_options();
+        let max_lines = opts.max_context_lines;
+        extract_snippets(root, &result.path, query_terms, stemming, &snippet, max_lines)
+    };
+    let mut matches = find_matches_in_lines(&ctx.lines, query_terms, stemming);
+    truncate_long_lines(&mut ctx.lines, &mut matches, opts.max_columns);
+    DisplayResult {
+        rank,
+        result,
+        matches,
+        context_lines: ctx.lines,
+        truncated_count: ctx.truncated_count,
+        omitted_matches: ctx.omitted_matches,
+    }
+}
+
+/// Renders `total` display results as text until `budget` (tokens) is spent,
+/// with ANSI colors if `color` is set.
+///
+/// `displays` is consumed lazily, so context for results past the budget is
+/// never extracted. The budget counts the text without color escapes, so
+/// the same results fit whether or not stdout is a terminal.
+fn render_text_with_budget(
+    displays: impl Iterator<Item = DisplayResult>,
+    total: usize,
+    budget: Option<usize>,
+    color: bool,
+) -> (String, bool, usize) {
+    let budget_chars = budget.map(|b| b * 4);
+    let mut out = String::new();
+    let mut plain_len = 0;
     let mut emitted = 0;
 
-    for (i, result) in results.into_iter().enumerate() {
-        let ctx = if opts.spans {
-            spans::extract_best_spans(root, &result.path, query_str, opts.max_context_lines)
-        } else {
-            extract_context(root, &result.path, query_str, opts.context_window, opts.max_context_lines)
-        };
-        let display = DisplayResult {
-            rank: i + 1,
-            result,
-            context_lines: ctx.lines,
-            truncated_count: ctx.truncated_count,
-        };
+    for display in displays {
         let chunk = format_single_text(&display);
 
         if let Some(cap) = budget_chars {
-            if out.len() + chunk.len() > cap && !out.is_empty() {
+            if plain_len + chunk.len() > cap && !out.is_empty() {
                 let omitted = total - emitted;
                 out.push_str(&format!("... ({} more results, budget exceeded)\n", omitted));
                 return (out, true, omitted);
             }
         }
-        out.push_str(&chunk);
+        plain_len += chunk.len();
+        if color {
+            out.push_str(&format_single_text_colored(&display));
+        } else {
+            out.push_str(&chunk);
+        }
         emitted += 1;
     }
 
@@ -156,9 +329,124 @@ fn build_json_with_budget(
     query_str: &str,
     opts: &SearchOptions,
     stats: &SearchStats,
+    suggestions: &[String],
+) -> (String, bool, usize) {
+    let total = results.len();
+    let (query_terms, stemming) = highlight_terms(root, query_str, &opts.synonyms);
+    let displays = results
+        .into_iter()
+        .enumerate()
+        .map(|(i, result)| {
+            let terms = &query_terms;
+                term_display(root, opts.offset + i + 1, result, query_str, terms, stemming, opts)
+        });
+    render_json_with_budget(
+        displays,
+        total,
+        query_str,
+        opts.budget,
+        stats,
+        false,
+        suggestions,
+        |d| format_single_json_value(d, query_str),
+    )
+}
+
+/// Build JSON Lines output incrementally with optional budget.
+fn build_json_lines_with_budget(
+    root: &Path,
+    results: Vec<SearchResult>,
+    query_str: &str,
+    opts: &SearchOptions,
 ) -> (String, bool, usize) {
-    let budget_chars = opts.budget.map(|b| b * 4);
     let total = results.len();
+    let (query_terms, stemming) = highlight_terms(root, query_str, &opts.synonyms);
+    let displays = results
+        .into_iter()
+        .enumerate()
+        .map(|(i, result)| {
+            let terms = &query_terms;
+                term_display(root, opts.offset + i + 1, result, query_str, terms, stemming, opts)
+        });
+    render_json_lines_with_budget(displays, total, opts.budget, |d| {
+        format_single_json_value(d, query_str)
+    })
+}
+
+/// Renders `total` display results grep-style (see `format_single_grep`)
+/// until `budget` (tokens) is spent, with `--` between files. Like JSON
+/// Lines, nothing marks the cut, so every line stays greppable.
+fn render_grep_with_budget(
+    displays: impl Iterator<Item = DisplayResult>,
+    total: usize,
+    budget: Option<usize>,
+) -> (String, bool, usize) {
+    let budget_chars = budget.map(|b| b * 4);
+    let mut out = String::new();
+    let mut emitted = 0;
+
+    for display in displays {
+        let mut chunk = format_single_grep(&display);
+        if !out.is_empty() && !chunk.is_empty() {
+            chunk.insert_str(0, "--\n");
+        }
+
+        if let Some(cap) = budget_chars {
+            if out.len() + chunk.len() > cap && !out.is_empty() {
+                return (out, true, total - emitted);
+            }
+        }
+        out.push_str(&chunk);
+        emitted += 1;
+    }
+
+    (out, false, 0)
+}
+
+/// Renders `total` display results as JSON Lines, one `to_value` object per
+/// line, until `budget` (tokens) is spent. Unlike text output, nothing marks
+/// the cut: every line stays a result, and the caller reports the omission.
+/// No results render as empty output.
+fn render_json_lines_with_budget(
+    displays: impl Iterator<Item = DisplayResult>,
+    total: usize,
+    budget: Option<usize>,
+    to_value: impl Fn(&DisplayResult) -> serde_json::Value,
+) -> (String, bool, usize) {
+    let budget_chars = budget.map(|b| b * 4);
+    let mut out = String::new();
+    let mut emitted = 0;
+
+    for display in displays {
+        let mut line = serde_json::to_string(&to_value(&display)).unwrap_or_default();
+        line.push('\n');
+
+        if let Some(cap) = budget_chars {
+            if out.len() + line.len() > cap && !out.is_empty() {
+                return (out, true, total - emitted);
+            }
+        }
+        out.push_str(&line);
+        emitted += 1;
+    }
+
+    (out, false, 0)
+}
+
+/// Renders `total` display results as one JSON document until `budget`
+/// (tokens) is spent. `to_value` formats each result; non-empty
+/// `suggestions` are added as a top-level array.
+fn render_json_with_budget(
+    displays: impl Iterator<Item = DisplayResult>,
+    total: usize,
+    query_str: &str,
+    budget: Option<usize>,
+    stats: &SearchStats,
+    timed_out: bool,
+    suggestions: &[String],
+    to_value: impl Fn(&DisplayResult) -> serde_json::Value,
+) -> (String, bool, usize) {
+    let budget_chars = budget.map(|b| b * 4);
     let mut result_values: Vec<serde_json::Value> = Vec::new();
     let mut emitted = 0;
     let mut budget_exhausted = false;
@@ -169,19 +457,8 @@ fn build_json_with_budget(
     let envelope_estimate = 200;
     let mut running_chars = envelope_estimate;
 
-    for (i, result) in results.into_iter().enumerate() {
-        let ctx = if opts.spans {
-            spans::extract_best_spans(root, &result.path, query_str, opts.max_context_lines)
-        } else {
-            extract_context(root, &result.path, query_str, opts.context_window, opts.max_context_lines)
-        };
-        let display = DisplayResult {
-            rank: i + 1,
-            result,
-            context_lines: ctx.lines,
-            truncated_count: ctx.truncated_count,
-        };
-        let value = format_single_json_value(&display, query_str);
+    for display in displays {
+        let value = to_value(&display);
         let value_str = serde_json::to_string(&value).unwrap_or_default();
 
         if let Some(cap) = budget_chars {
@@ -199,6 +476,7 @@ fn build_json_with_budget(
     // Build final JSON
     let mut stats_obj = serde_json::json!({
         "total_results": stats.total_results,
+        "total_matches": stats.total_matches,
         "files_searched": stats.files_searched,
         "elapsed_ms": stats.elapsed_ms,
     });
@@ -206,12 +484,21 @@ fn build_json_with_budget(
         stats_obj["budget_exceeded"] = serde_json::json!(true);
         stats_obj["results_omitted"] = serde_json::json!(results_omitted);
     }
+    if timed_out {
+        stats_obj["timed_out"] = serde_json::json!(true);
+    }
+    if stats.total_is_lower_bound {
+        stats_obj["total_is_lower_bound"] = serde_json::json!(true);
+    }
 
-    let json = serde_json::json!({
+    let mut json = serde_json::json!({
         "query": query_str,
         "results": result_values,
         "stats": stats_obj,
     });
+    if !suggestions.is_empty() {
+        json["suggestions"] = serde_json::json!(suggestions);
+    }
 
     let formatted = serde_json::to_string(&json).unwrap_or_else(|_| "{}".to_string());
     (formatted, budget_exhausted, results_omitted)
@@ -230,6 +517,11 @@ mod tests {
             score_content: 5.0,
             score_symbols: 0.0,
             matched_fields: vec!["content".to_string()],
+            meta: Default::default(),
+            duplicates: Vec::new(),
+            terms: Vec::new(),
+            lines: Vec::new(),
+            positions: Default::default(),
         }
     }
 
@@ -338,6 +630,8 @@ mod tests {
 
         let stats = SearchStats {
             total_results: 3,
+            total_matches: 3,
+            total_is_lower_bound: false,
             files_searched: 10,
             elapsed_ms: 1,
         };
@@ -349,7 +643,7 @@ mod tests {
         };
 
         let (output, exhausted, omitted) =
-            build_json_with_budget(&fixture, results, "EventStore", &opts, &stats);
+            build_json_with_budget(&fixture, results, "EventStore", &opts, &stats, &[]);
 
         let parsed: serde_json::Value = serde_json::from_str(&output).expect("valid JSON");
         assert!(parsed["results"].is_array());
@@ -374,6 +668,8 @@ mod tests {
 
         let stats = SearchStats {
             total_results: 1,
+            total_matches: 1,
+            total_is_lower_bound: false,
             files_searched: 10,
             elapsed_ms: 1,
         };
@@ -384,7 +680,7 @@ mod tests {
         };
 
         let (output, exhausted, _) =
-            build_json_with_budget(&fixture, results, "EventStore", &opts, &stats);
+            build_json_with_budget(&fixture, results, "EventStore", &opts, &stats, &[]);
 
         assert!(!exhausted);
         let parsed: serde_json::Value = serde_json::from_str(&output).expect("valid JSON");
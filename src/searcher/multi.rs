@@ -0,0 +1,240 @@
+use std::path::{Path, PathBuf};
+use std::time::Instant;
+
+use tantivy::query::Bm25StatisticsProvider;
+use tantivy::schema::Field;
+use tantivy::{Index, IndexReader, Searcher, Term};
+
+use crate::error::NsError;
+use crate::indexer::metadata::{read_metadata, Metadata};
+use crate::indexer::writer::{open_index, IndexMeta};
+use crate::searcher::query::{
+    build_index_queries, create_reader_with_retry, load_result, search_page, RankedHit,
+    SearchOptions, SearchResult, SearchStats, MAX_RESULTS_CEILING,
+};
+
+/// A search result tagged with the repo whose index it came from.
+#[derive(Debug)]
+pub struct MultiSearchResult {
+    /// Repo name (see [`MultiSearcher::open`]).
+    pub repo: String,
+    /// Repo root, as given to [`MultiSearcher::open`].
+    pub root: PathBuf,
+    /// The result, with `path` relative to `root`.
+    pub result: SearchResult,
+}
+
+/// One repo's index, opened for searching.
+struct Member {
+    name: String,
+    root: PathBuf,
+    index: Index,
+    meta: IndexMeta,
+    metadata: Metadata,
+    reader: IndexReader,
+}
+
+/// Searches the indexes of several repos at once and merges their results
+/// into a single ranking.
+///
+/// Each index is queried with its own tokenizers, stop words and stemmer,
+/// but scored with document frequencies and field lengths summed over all
+/// of them, so BM25 scores are comparable across repos: a term that is rare
+/// overall counts as rare in every repo, not only in the one where it is.
+///
+/// Ties are ordered by repo (in the order given to `open`), then path,
+/// whatever `SearchOptions::sort_by`.
+pub struct MultiSearcher {
+    members: Vec<Member>,
+}
+
+impl MultiSearcher {
+    /// Opens the index of each repo in `roots`.
+    ///
+    /// Repos are named by their directory name, or by the path as given
+    /// when two roots share a directory name. Fails if any repo has no
+    /// index or one built with an older schema.
+    pub fn open(roots: &[PathBuf]) -> Result<Self, NsError> {
+        let names = repo_names(roots);
+        let mut members = Vec::with_capacity(roots.len());
+        for (root, name) in roots.iter().zip(names) {
+            let (index, meta) = open_index(root)?;
+            let reader = create_reader_with_retry(&index, root)?;
+            members.push(Member {
+                name,
+                root: root.clone(),
+                index,
+                meta,
+                metadata: read_metadata(root)?,
+                reader,
+            });
+        }
+        Ok(Self { members })
+    }
+
+    /// Repo names, in the order given to `open`.
+    pub fn repos(&self) -> Vec<&str> {
+        self.members.iter().map(|m| m.name.as_str()).collect()
+    }
+
+    /// Runs `query_str` against every index concurrently and returns the
+    /// merged `offset..offset + max_results` page, with stats summed over
+    /// all repos. Takes the same options as `execute_search`; `file_glob`
+    /// matches paths relative to each repo root.
+    pub fn search(
+        &self,
+        query_str: &str,
+        opts: &SearchOptions,
+    ) -> Result<(Vec<MultiSearchResult>, SearchStats), NsError> {
+        let max_results = opts.max_results.min(MAX_RESULTS_CEILING);
+        let glob = opts.file_glob.as_deref().map(glob::Pattern::new).transpose()?;
+        let searchers: Vec<Searcher> = self.members.iter().map(|m| m.reader.searcher()).collect();
+        let stats: Vec<CombinedStatistics> = (0..searchers.len())
+            .map(|own| CombinedStatistics {
+                searchers: &searchers,
+                own,
+            })
+            .collect();
+
+        let start = Instant::now();
+        // The global page is drawn from each index's own top `window`.
+        let window = opts.offset.saturating_add(max_results);
+        let pages = std::thread::scope(|scope| {
+            let glob = glob.as_ref();
+            let handles: Vec<_> = self
+                .members
+                .iter()
+                .zip(&searchers)
+                .zip(&stats)
+                .map(|((member, searcher), stats)| {
+                    scope.spawn(move || -> Result<_, NsError> {
+                        let (index, meta) = (&member.index, &member.meta);
+                        let queries = build_index_queries(index, meta, searcher, query_str, opts)?;
+                        let page = search_page(searcher, &queries, stats, glob, 0, window)?;
+                        Ok((queries, page))
+                    })
+                })
+                .collect();
+            handles
+                .into_iter()
+                .map(|h| h.join().expect("search thread panicked"))
+                .collect::<Result<Vec<_>, _>>()
+        })?;
+
+        let total_matches = pages.iter().map(|(_, page)| page.total).sum();
+        let total_is_lower_bound = pages.iter().any(|(_, page)| page.total_is_lower_bound);
+        let mut ranked: Vec<(usize, RankedHit)> = Vec::new();
+        let mut queries = Vec::with_capacity(pages.len());
+        for (i, (member_queries, page)) in pages.into_iter().enumerate() {
+            ranked.extend(page.hits.into_iter().map(|hit| (i, hit)));
+            queries.push(member_queries);
+        }
+        ranked.sort_by(|(a_repo, a), (b_repo, b)| {
+            opts.sort_by
+                .compare(a, b)
+                .then_with(|| a_repo.cmp(b_repo))
+                .then_with(|| a.path.cmp(&b.path))
+                .then_with(|| a.address.cmp(&b.address))
+        });
+        let elapsed_ms = start.elapsed().as_millis() as u64;
+
+        let mut results = Vec::with_capacity(max_results);
+        for (i, hit) in ranked.into_iter().skip(opts.offset).take(max_results) {
+            let member = &self.members[i];
+            let mut result = load_result(&searchers[i], &stats[i], &queries[i], hit)?;
+            result.meta = member.metadata.get(&result.path);
+            results.push(MultiSearchResult {
+                repo: member.name.clone(),
+                root: member.root.clone(),
+                result,
+            });
+        }
+
+        let stats = SearchStats {
+            total_results: results.len(),
+            total_matches,
+            total_is_lower_bound,
+            files_searched: self.members.iter().map(|m| m.meta.file_count).sum(),
+            elapsed_ms,
+        };
+        Ok((results, stats))
+    }
+}
+
+/// BM25 statistics summed over several indexes, for the queries of
+/// member `own`. Fields are matched by name: fixed fields have the same
+/// `Field` ids in every index, but declared ones (`IndexOptions::fields`)
+/// come after them in each index's own order, and an index that doesn't
+/// declare a field adds nothing to its statistics.
+struct CombinedStatistics<'a> {
+    searchers: &'a [Searcher],
+    own: usize,
+}
+
+impl CombinedStatistics<'_> {
+    /// `field` of member `own` in each index that has it.
+    fn fields(&self, field: Field) -> impl Iterator<Item = (&Searcher, Field)> + '_ {
+        let name = self.searchers[self.own].schema().get_field_name(field).to_string();
+        self.searchers
+            .iter()
+            .filter_map(move |s| Some((s, s.schema().get_field(&name).ok()?)))
+    }
+}
+
+impl Bm25StatisticsProvider for CombinedStatistics<'_> {
+    fn total_num_tokens(&self, field: Field) -> tantivy::Result<u64> {
+        self.fields(field).map(|(s, f)| s.total_num_tokens(f)).sum()
+    }
+
+    fn total_num_docs(&self) -> tantivy::Result<u64> {
+        self.searchers.iter().map(|s| s.total_num_docs()).sum()
+    }
+
+    fn doc_freq(&self, term: &Term) -> tantivy::Result<u64> {
+        self.fields(term.field())
+            .map(|(s, f)| {
+                if f == term.field() {
+                    return s.doc_freq(term);
+                }
+                // Only declared fields move, and they are all text.
+                match std::str::from_utf8(term.serialized_value_bytes()) {
+                    Ok(text) => s.doc_freq(&Term::from_field_text(f, text)),
+                    Err(_) => Ok(0),
+                }
+            })
+            .sum()
+    }
+}
+
+/// Names each root by its directory name, falling back to the path as given
+/// when the name is shared (or the root has none, like `/`).
+fn repo_names(roots: &[PathBuf]) -> Vec<String> {
+    let base: Vec<Option<String>> = roots.iter().map(|r| dir_name(r)).collect();
+    base.iter()
+        .zip(roots)
+        .map(|(name, root)| match name {
+            Some(n) if base.iter().filter(|b| b.as_ref() == Some(n)).count() == 1 => n.clone(),
+            _ => root.display().to_string(),
+        })
+        .collect()
+}
+
+fn dir_name(root: &Path) -> Option<String> {
+    let abs = root.canonicalize().unwrap_or_else(|_| root.to_path_buf());
+    abs.file_name().map(|n| n.to_string_lossy().into_owned())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn repo_names_use_directory_names_unless_shared() {
+        let roots = vec![
+            PathBuf::from("/work/api"),
+            PathBuf::from("/work/web/app"),
+            PathBuf::from("/other/app"),
+        ];
+        assert_eq!(repo_names(&roots), vec!["api", "/work/web/app", "/other/app"]);
+    }
+}
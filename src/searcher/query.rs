@@ -1,19 +1,42 @@
+use std::collections::{BTreeMap, BTreeSet};
 use std::path::Path;
-use std::time::Instant;
+use std::time::{Duration, Instant};
 
-use tantivy::collector::TopDocs;
+use serde::Serialize;
+use tantivy::collector::{Count, TopDocs};
 use tantivy::query::{
-    BooleanQuery, BoostQuery, FuzzyTermQuery, Occur, Query, QueryParser, TermQuery,
+    AllQuery, Bm25StatisticsProvider, BooleanQuery, BoostQuery, EnableScoring, FuzzyTermQuery, Occur, Query,
+    PhraseQuery, QueryParser, Scorer, TermQuery,
+};
+use tantivy::schema::{Field, IndexRecordOption, Value};
+use tantivy::{
+    DocAddress, DocSet, Index, Postings, ReloadPolicy, Searcher, TantivyDocument, Term, TERMINATED,
 };
-use tantivy::schema::{IndexRecordOption, Value};
-use tantivy::{ReloadPolicy, TantivyDocument, Term};
 
+use crate::cancel::CancelToken;
 use crate::error::NsError;
-use crate::indexer::writer::open_index;
-use crate::schema::{content_field, lang_field, path_field, symbols_field, symbols_raw_field};
+use crate::indexer::analyzer::{Analyzer, Normalizer};
+use crate::indexer::classes::{TokenClass, TokenClasses};
+use crate::indexer::lines::LineIndex;
+use crate::indexer::metadata::{read_metadata, Metadata};
+use crate::indexer::offsets::OffsetIndex;
+use crate::indexer::writer::{dir_size, open_index, IndexMeta};
+use crate::schema::{
+    content_cased_field, content_field, doc_fields, lang_field, line_index_field, mtime_field,
+    path_field, path_text_field, simhash_field, symbols_field, symbols_raw_field,
+    token_class_field, token_offsets_field,
+};
+use crate::searcher::context::SnippetOptions;
+use crate::searcher::dedup::collapse;
+use crate::searcher::explain::{term_scores, TermScore};
+use crate::searcher::query_ast::{
+    build_query, positive_text, split_field_filters, FieldFilter, FilterField,
+};
+use crate::searcher::suggest::expand_last_term;
+use crate::searcher::synonyms::SynonymMap;
 
 /// A single search result from the tantivy index.
-#[derive(Debug)]
+#[derive(Debug, Clone)]
 pub struct SearchResult {
     /// File path relative to the repo root.
     pub path: String,
@@ -27,15 +50,41 @@ pub struct SearchResult {
     pub score_content: f32,
     /// BM25 score contribution from the `symbols` field only.
     pub score_symbols: f32,
-    /// Which fields contributed to the match (e.g. ["content"], ["symbols"], or both).
+    /// Which fields contributed to the match (e.g. ["content"], ["symbols"], or both),
+    /// plus "path" when query terms matched the file path.
     pub matched_fields: Vec<String>,
+    /// Metadata attached with `indexer::index_with_meta`, empty if none.
+    pub meta: BTreeMap<String, String>,
+    /// With `SearchOptions::dedup`, the paths of lower-ranked matches with
+    /// near-identical content folded into this result, in rank order.
+    pub duplicates: Vec<String>,
+    /// With `SearchOptions::explain`, how each query term found in the
+    /// file contributed to `score` (see `explain::term_scores`).
+    pub terms: Vec<TermScore>,
+    /// In an index built with `IndexOptions::track_lines`, the 1-based
+    /// lines where the query's content terms occur, read from the index.
+    /// Empty in other indexes and for `sym_only` searches; regex results
+    /// always have their matching lines.
+    pub lines: Vec<usize>,
+    /// With `SearchOptions::include_positions`, the byte offsets into the
+    /// file where each of the query's content terms occurs, keyed by the
+    /// term as indexed (lowercased, and stemmed in a `--stem` index):
+    /// ascending, without duplicates, so a term's tf is its list's length.
+    /// Empty for `sym_only` searches and regex results.
+    pub positions: BTreeMap<String, Vec<usize>>,
 }
 
 /// Summary statistics for a search operation.
-#[derive(Debug)]
+#[derive(Debug, Clone)]
 pub struct SearchStats {
     /// Number of results returned.
     pub total_results: usize,
+    /// Number of matching files before `offset` and `max_results` are
+    /// applied (for "showing 20 of 412").
+    pub total_matches: usize,
+    /// The search stopped early (see `SearchOptions::early_termination`),
+    /// so `total_matches` only counts the files ranked before it did.
+    pub total_is_lower_bound: bool,
     /// Total files in the index.
     pub files_searched: usize,
     /// Time taken for the search in milliseconds.
@@ -43,46 +92,262 @@ pub struct SearchStats {
 }
 
 /// Options that control search behaviour — maps 1:1 to CLI flags.
-#[derive(Debug)]
+#[derive(Debug, Clone)]
 pub struct SearchOptions {
     /// Maximum number of results.
     pub max_results: usize,
+    /// Number of top-ranked results to skip, for paging with `max_results`.
+    pub offset: usize,
     /// Context lines around matches (±N).
     pub context_window: usize,
-    /// Language filter (e.g. "rust", "python").
-    pub file_type: Option<String>,
+    /// Context lines before matches, overriding `context_window` on that side.
+    pub context_before: Option<usize>,
+    /// Context lines after matches, overriding `context_window` on that side.
+    pub context_after: Option<usize>,
+    /// Maximum snippets (separate runs of context) per file. 0 means unlimited.
+    pub max_snippets: usize,
+    /// Maximum matching lines shown per file, so a file with hundreds of
+    /// matches doesn't use up the output (or `budget`) on its own: the
+    /// first are shown with their context, and the file's result notes how
+    /// many more there are. 0 means unlimited. The file is still ranked and
+    /// listed once; not applied with `spans`.
+    pub max_per_file: usize,
+    /// Lines longer than this many characters are shortened around the
+    /// match. 0 means unlimited. Default: 300.
+    pub max_columns: usize,
+    /// Language filter: results must be in one of these languages
+    /// (e.g. "rust", "markdown"). Empty means any language.
+    pub languages: Vec<String>,
     /// Glob pattern to filter file paths (e.g. "src/*").
     pub file_glob: Option<String>,
     /// Search only symbol names, not file content.
     pub sym_only: bool,
-    /// Use fuzzy matching (Levenshtein distance 1).
+    /// Use fuzzy matching (Levenshtein distance up to `fuzzy_distance`).
     pub fuzzy: bool,
+    /// Maximum edit distance for `fuzzy` (1 or 2; clamped to that range).
+    pub fuzzy_distance: u8,
     /// Maximum context lines per file. Some(0) means unlimited. Default: Some(30).
     pub max_context_lines: Option<usize>,
     /// Token budget for total output. None means unlimited (default).
     pub budget: Option<usize>,
     /// Use AST-guided span extraction instead of grep-and-expand.
     pub spans: bool,
+    /// Treat the query as a regex matched against raw file lines.
+    pub regex: bool,
+    /// Case-insensitive regex matching. Ranked search is case-insensitive
+    /// unless `case_sensitive` is set.
+    pub ignore_case: bool,
+    /// Match terms with their case as written (`Err` not `err`). Needs an
+    /// index built with `IndexOptions::case_sensitive`; ranks on content
+    /// alone, and does not apply to `fuzzy` or `sym_only`, which always fold.
+    pub case_sensitive: bool,
+    /// Stop a regex search after this long and return partial results.
+    /// None means no limit (default).
+    pub regex_timeout: Option<Duration>,
+    /// Color paths, line numbers and matches in text output with ANSI
+    /// escapes (the CLI sets this when stdout is a terminal).
+    pub color: bool,
+    /// Multiplier on the score of query terms found in a matching file's
+    /// path, so `server` ranks `server.go` above files that only mention
+    /// it. 0 disables the boost. Not applied with `sym_only`.
+    pub filename_boost: f32,
+    /// Score multipliers for files whose path matches a glob, to push down
+    /// generated code or fixtures without excluding them. Applied after
+    /// BM25, before ranking and paging.
+    pub weights: Vec<WeightRule>,
+    /// Score bump for consecutive query terms found near each other in a
+    /// file, falling with the gap between them (`read timeout` side by
+    /// side beats the two words far apart). 0, the default, disables it.
+    /// Not applied with `sym_only`.
+    pub proximity_boost: f32,
+    /// Most tokens between two query terms for `proximity_boost` to apply.
+    pub proximity_window: usize,
+    /// Rank only enough files to fill the page: files whose best possible
+    /// score can't beat the page's current last result are skipped without
+    /// being scored, which cuts latency on terms found in most files. The
+    /// total becomes a lower bound. Ignored with `file_glob`, inline `path:`
+    /// and `ext:` filters, `weights` and `dedup`, which must see every match.
+    pub early_termination: bool,
+    /// Fold matches whose content is near-identical to a higher-ranked
+    /// match (vendored copies, generated files) into that result's
+    /// `duplicates`. Totals and pages count the folded results.
+    pub dedup: bool,
+    /// Most bits two SimHash fingerprints may differ by for `dedup` to
+    /// fold them: 0 folds only copies with the same words, and each extra
+    /// bit tolerates more edited lines.
+    pub dedup_distance: u32,
+    /// Fill `SearchResult::terms` with each matched query term's tf, idf
+    /// and score in the result, for tuning relevance.
+    pub explain: bool,
+    /// Fill `SearchResult::positions` with where each query term occurs
+    /// in the file, read from the index rather than the file. Off by
+    /// default, since it reads every term's positions for every loaded
+    /// result. Needs an index built with `IndexOptions::track_offsets`,
+    /// or fails with `NsError::NoOffsets`; ignored with `regex`.
+    pub include_positions: bool,
+    /// The order of results: by score (the default), by path, or newest
+    /// file first. Scores are reported whatever the order.
+    pub sort_by: SortMode,
+    /// Where in a Go file the query must match: its code, comments or
+    /// string literals. Files of other languages are all code, so they
+    /// match only while `Code` is in the set. Ranking still scores the
+    /// whole file. Anything but the default (all classes) needs an index
+    /// built with `IndexOptions::token_classes`, or fails with
+    /// `NsError::NoTokenClasses`. Not applied with `sym_only` or `regex`.
+    pub token_classes: TokenClasses,
+    /// Boosts for the index's declared fields (`IndexOptions::fields`) by
+    /// name, replacing the boost each was declared with. Names the index
+    /// doesn't have are ignored.
+    pub field_boosts: BTreeMap<String, f32>,
+    /// Words each query term also matches, scored lower (see
+    /// [`SynonymMap::expand_query`]). Empty by default. Not applied with
+    /// `fuzzy` or `regex`.
+    pub synonyms: SynonymMap,
+    /// Search as you type: the query's last word also matches the indexed
+    /// terms it is a prefix of, up to `suggest::MAX_PREFIX_EXPANSIONS`
+    /// (see `suggest::expand_last_term`), so `graceful shut` already finds
+    /// `shutdown`. Earlier words stay exact, and a query ending in
+    /// whitespace is taken as finished. Not applied with `fuzzy`, `regex`
+    /// or `case_sensitive`.
+    pub prefix_last_term: bool,
+    /// Stops the search with `NsError::Cancelled` or `DeadlineExceeded`.
+    /// Checked between segments and every `CANCEL_CHECK_DOCS` documents
+    /// scored or loaded, and before context is read.
+    pub cancel: CancelToken,
+}
+
+/// How [`SearchOptions::sort_by`] orders results. Every mode breaks ties
+/// by path, then document address, so pages stay stable across `offset`s.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub enum SortMode {
+    /// Highest score first.
+    #[default]
+    Relevance,
+    /// Path, alphabetically by bytes.
+    Path,
+    /// Last modified first, as of indexing. Needs an index built with
+    /// `IndexOptions::track_mtime`, or fails with `NsError::NoMtimes`.
+    ModTime,
+}
+
+impl SortMode {
+    /// The names `ns --sort` takes, in variant order.
+    pub const NAMES: &'static [&'static str] = &["relevance", "path", "mtime"];
+
+    /// The mode named `name`, one of [`SortMode::NAMES`].
+    pub fn from_name(name: &str) -> Option<Self> {
+        match name {
+            "relevance" => Some(Self::Relevance),
+            "path" => Some(Self::Path),
+            "mtime" => Some(Self::ModTime),
+            _ => None,
+        }
+    }
+
+    /// Orders two hits by this mode's key alone: score or mtime
+    /// descending, or path.
+    pub(crate) fn compare(self, a: &RankedHit, b: &RankedHit) -> std::cmp::Ordering {
+        match self {
+            Self::Relevance => b.score.total_cmp(&a.score),
+            Self::Path => a.path.cmp(&b.path),
+            Self::ModTime => b.mtime.cmp(&a.mtime),
+        }
+    }
+}
+
+/// A score multiplier for files whose path matches `glob` (e.g. `0.3` for
+/// `*_test.go`, or `2` to lift `src/**`). A file's multipliers over all
+/// matching rules multiply together; files matching none keep their score.
+#[derive(Debug, Clone, PartialEq)]
+pub struct WeightRule {
+    /// Glob matched against the path relative to the repo root, as with
+    /// `file_glob`; `*` also matches `/`.
+    pub glob: String,
+    /// Non-negative factor applied to the score.
+    pub multiplier: f32,
 }
 
 impl Default for SearchOptions {
     fn default() -> Self {
         Self {
             max_results: 10,
+            offset: 0,
             context_window: 1,
-            file_type: None,
+            context_before: None,
+            context_after: None,
+            max_snippets: 0,
+            max_per_file: 0,
+            max_columns: 300,
+            languages: Vec::new(),
             file_glob: None,
             sym_only: false,
             fuzzy: false,
+            fuzzy_distance: 1,
             max_context_lines: Some(30),
             budget: None,
             spans: false,
+            regex: false,
+            ignore_case: false,
+            case_sensitive: false,
+            regex_timeout: None,
+            color: false,
+            filename_boost: DEFAULT_FILENAME_BOOST,
+            weights: Vec::new(),
+            proximity_boost: 0.0,
+            proximity_window: DEFAULT_PROXIMITY_WINDOW,
+            early_termination: false,
+            dedup: false,
+            dedup_distance: DEFAULT_DEDUP_DISTANCE,
+            explain: false,
+            include_positions: false,
+            sort_by: SortMode::Relevance,
+            token_classes: TokenClasses::default(),
+            field_boosts: BTreeMap::new(),
+            synonyms: SynonymMap::default(),
+            prefix_last_term: false,
+            cancel: CancelToken::default(),
+        }
+    }
+}
+
+impl SearchOptions {
+    /// The context-gathering options for each result.
+    pub fn snippet_options(&self) -> SnippetOptions {
+        SnippetOptions {
+            before: self.context_before.unwrap_or(self.context_window),
+            after: self.context_after.unwrap_or(self.context_window),
+            max_snippets: self.max_snippets,
+            max_matches: self.max_per_file,
         }
     }
 }
 
 /// Maximum number of results to prevent unbounded file I/O during context extraction.
-const MAX_RESULTS_CEILING: usize = 100;
+pub(crate) const MAX_RESULTS_CEILING: usize = 100;
+
+/// Default [`SearchOptions::filename_boost`]: enough to lift a file named
+/// after the query over files that mention it, without outweighing content.
+pub const DEFAULT_FILENAME_BOOST: f32 = 1.5;
+
+/// Default [`SearchOptions::proximity_window`]: a short line of code.
+pub const DEFAULT_PROXIMITY_WINDOW: usize = 10;
+
+/// Default [`SearchOptions::dedup_distance`]: folds copies with a few
+/// edited words, while unrelated files land about 32 bits apart.
+pub const DEFAULT_DEDUP_DISTANCE: u32 = 6;
+
+/// Documents scored or loaded between checks of `SearchOptions::cancel`:
+/// one postings block.
+pub(crate) const CANCEL_CHECK_DOCS: usize = 128;
+
+/// Largest supported fuzzy edit distance (tantivy builds Levenshtein automata up to 2).
+pub const MAX_FUZZY_DISTANCE: u8 = 2;
+
+/// Boost for each fuzzy distance level a term falls within. A term at edit
+/// distance `k` matches levels `k..=max`, scoring `(max - k + 1) × 0.5`, so
+/// the score drops with each extra edit. Exact matches also get BM25 from an
+/// exact `TermQuery` and always rank first.
+const FUZZY_LEVEL_BOOST: f32 = 0.5;
 
 /// Executes a search query against the index at `root`.
 ///
@@ -91,153 +356,906 @@ const MAX_RESULTS_CEILING: usize = 100;
 /// `max_results` is clamped to `MAX_RESULTS_CEILING` (100) to prevent
 /// unbounded disk I/O during context extraction.
 ///
+/// Results are ordered by score (descending), then path, then document
+/// address, so equal scores always come back in the same order and
+/// consecutive `offset` pages neither repeat nor skip a file. `sort_by`
+/// orders them by path or mtime instead, with the same tie-breaks.
+///
 /// Search modes:
 /// - Default: searches both `content` and `symbols` fields, 3x boost on `symbols`.
+///   Query terms that also occur in a matching file's path add their
+///   `path_text` score times `filename_boost` (except with `sym_only`).
+///   With `proximity_boost`, each pair of consecutive content terms found
+///   within `proximity_window` tokens of each other, in query order, adds
+///   its phrase score times `proximity_boost / (gap + 1)`.
 /// - `sym_only`: searches only `symbols` field (no content).
-/// - `fuzzy`: builds per-term `FuzzyTermQuery` (Levenshtein distance 1) instead
-///   of using the `QueryParser`, with `Should` occurrence so any term can match.
+/// - `fuzzy`: builds per-term `FuzzyTermQuery` (Levenshtein distance up to
+///   `fuzzy_distance`) instead of using the `QueryParser`, with `Should`
+///   occurrence so any term can match. Closer matches score higher.
+///   Boolean operators and negated terms are ignored in this mode.
+///
+/// Outside fuzzy mode, `AND` / `OR` / `NOT` queries are evaluated via
+/// `query_ast::build_query`; plain queries go to tantivy's `QueryParser`
+/// unchanged (terms OR-ed, `"quoted phrases"` matched by position).
 ///
 /// Filters:
-/// - `file_type`: restricts results to files with the given language via a
-///   `TermQuery` on the `lang` field combined with `BooleanQuery`.
-/// - `file_glob`: post-filters results by matching `path` against a glob pattern.
+/// - `languages`: restricts results to files in any of the given languages
+///   via `TermQuery`s on the `lang` field combined with `BooleanQuery`.
+/// - `file_glob`: filters matches by `path` glob before paging, so the
+///   total and every page only count matching paths.
+///
+/// `weights` scale the score of files whose path matches their glob; the
+/// scaled score is the one ranked and reported.
+///
+/// With `early_termination`, tantivy's block-max WAND skips postings that
+/// can't reach the top `offset + max_results` (see `search_page`).
+///
+/// With `dedup`, each match within `dedup_distance` bits of a higher-ranked
+/// match's content fingerprint is folded into it (see `dedup::collapse`).
 pub fn execute_search(
     root: &Path,
     query_str: &str,
     opts: &SearchOptions,
 ) -> Result<(Vec<SearchResult>, SearchStats), NsError> {
-    let max_results = opts.max_results.min(MAX_RESULTS_CEILING);
     let (index, meta) = open_index(root)?;
+    let reader = create_reader_with_retry(&index, root)?;
+    let metadata = read_metadata(root)?;
+    search_index(&index, &meta, &reader.searcher(), &metadata, query_str, opts)
+}
+
+/// [`execute_search`] against an index already opened, with `searcher`
+/// from its reader and `metadata` read from the same repo.
+pub(crate) fn search_index(
+    index: &Index,
+    meta: &IndexMeta,
+    searcher: &Searcher,
+    metadata: &Metadata,
+    query_str: &str,
+    opts: &SearchOptions,
+) -> Result<(Vec<SearchResult>, SearchStats), NsError> {
+    let max_results = opts.max_results.min(MAX_RESULTS_CEILING);
+    let glob = opts.file_glob.as_deref().map(glob::Pattern::new).transpose()?;
+    let queries = build_index_queries(index, meta, searcher, query_str, opts)?;
+
+    let start = Instant::now();
+    let page = search_page(searcher, &queries, searcher, glob.as_ref(), opts.offset, max_results)?;
+    let elapsed_ms = start.elapsed().as_millis() as u64;
+
+    let mut results = page
+        .hits
+        .into_iter()
+        .map(|hit| load_result(searcher, searcher, &queries, hit))
+        .collect::<Result<Vec<_>, _>>()?;
+    for result in &mut results {
+        result.meta = metadata.get(&result.path);
+    }
+
+    let stats = SearchStats {
+        total_results: results.len(),
+        total_matches: page.total,
+        total_is_lower_bound: page.total_is_lower_bound,
+        files_searched: meta.file_count,
+        elapsed_ms,
+    };
+
+    Ok((results, stats))
+}
+
+/// The queries run against one index: `query` ranks documents; the
+/// per-field queries re-score the returned page for explainable ranking;
+/// `path_filters` holds the query's inline `path:` and `ext:` filters, and
+/// `weights` the compiled `SearchOptions::weights`. `early_termination` is
+/// the option's value, cleared when filters, weights or dedup rule it out;
+/// `dedup` holds the fingerprint distance when deduplicating, `sort_by`
+/// the order of the page, and `cancel` the search's token.
+/// `symbols_boost` and `path_boost` are the boosts the ranking query gives
+/// those fields, for `explain`; `field_queries` hold each declared field's
+/// name, query and boost.
+pub(crate) struct IndexQueries {
+    query: Box<dyn Query>,
+    content_query: Option<Box<dyn Query>>,
+    symbols_query: Option<Box<dyn Query>>,
+    path_query: Option<Box<dyn Query>>,
+    proximity_query: Option<Box<dyn Query>>,
+    field_queries: Vec<(String, Box<dyn Query>, f32)>,
+    path_filters: PathFilters,
+    weights: Weights,
+    early_termination: bool,
+    dedup: Option<u32>,
+    sort_by: SortMode,
+    cancel: CancelToken,
+    symbols_boost: f32,
+    path_boost: f32,
+    explain: bool,
+    include_positions: bool,
+}
+
+impl IndexQueries {
+    /// The ranking query.
+    pub(crate) fn query(&self) -> &dyn Query {
+        self.query.as_ref()
+    }
+
+    /// The `SearchOptions::weights` multiplier for `path`.
+    pub(crate) fn multiplier(&self, path: &str) -> f32 {
+        self.weights.multiplier(path)
+    }
+
+    /// The per-field queries, each with its boost in the ranking query.
+    pub(crate) fn term_queries(&self) -> Vec<(&dyn Query, f32)> {
+        [
+            (&self.content_query, 1.0),
+            (&self.symbols_query, self.symbols_boost),
+            (&self.path_query, self.path_boost),
+        ]
+        .into_iter()
+        .filter_map(|(query, boost)| query.as_deref().map(|q| (q, boost)))
+        .chain(self.field_queries.iter().map(|(_, q, boost)| (q.as_ref(), *boost)))
+        .collect()
+    }
+}
+
+/// Builds the ranking and per-field queries for `query_str` against `index`,
+/// using its tokenizers and the stop words and analyzer recorded in `meta`,
+/// and the term dictionary seen by `searcher` for `prefix_last_term`.
+pub(crate) fn build_index_queries(
+    index: &Index,
+    meta: &IndexMeta,
+    searcher: &Searcher,
+    query_str: &str,
+    opts: &SearchOptions,
+) -> Result<IndexQueries, NsError> {
+    let case_sensitive = opts.case_sensitive && !opts.fuzzy && !opts.sym_only;
+    if case_sensitive && !meta.case_sensitive {
+        return Err(NsError::CaseFoldedIndex);
+    }
+    if opts.sort_by == SortMode::ModTime && !meta.track_mtime {
+        return Err(NsError::NoMtimes);
+    }
+    if opts.include_positions && !meta.track_offsets {
+        return Err(NsError::NoOffsets);
+    }
+    let by_class = !opts.token_classes.is_all() && !opts.sym_only;
+    if by_class && !meta.token_classes {
+        return Err(NsError::NoTokenClasses);
+    }
+    let field_names: Vec<&str> = meta.fields.iter().map(|f| f.name.as_str()).collect();
+    let (query_str, filters) = split_field_filters(query_str, &field_names)?;
+    let query_str = query_str.as_str();
+    let path_filters = PathFilters::new(&filters)?;
+    // What the query parser sees: the query with its last word's
+    // completions and its terms' synonyms.
+    let parsed = if opts.fuzzy {
+        query_str.to_string()
+    } else if opts.prefix_last_term && !case_sensitive {
+        let completed = expand_last_term(searcher, content_field(&index.schema()), query_str)?;
+        opts.synonyms.expand_query(&completed)
+    } else {
+        opts.synonyms.expand_query(query_str)
+    };
+    let parsed = parsed.as_str();
 
     let schema = index.schema();
     let content = content_field(&schema);
     let symbols_f = symbols_field(&schema);
-    let path_f = path_field(&schema);
     let lang_f = lang_field(&schema);
-    let symbols_raw_f = symbols_raw_field(&schema);
+    let field_boosts: Vec<(&str, Field, f32)> = meta
+        .fields
+        .iter()
+        .zip(doc_fields(&schema, &meta.fields))
+        .map(|(f, field)| {
+            let boost = opts.field_boosts.get(&f.name).copied().unwrap_or(f.boost);
+            (f.name.as_str(), field, boost)
+        })
+        .collect();
+    let fuzzy_terms = fuzzy_terms(query_str, &meta.stop_words);
+    // A query of only filters (`lang:go`) lists every file that passes them.
+    let filters_only = query_str.is_empty() && !filters.is_empty();
+    // Fuzzy terms bypass the query parser, so filter the content terms here
+    // the way the "code" analyzer filtered (stemmed, folded) the indexed ones.
+    let analyzer = meta.content_analyzer()?;
+    let mut normalizer = analyzer.normalizer(&meta.stop_words);
+    let content_terms: Vec<String> = fuzzy_terms
+        .iter()
+        .map(|t| normalizer.normalize(t).unwrap_or_else(|| t.clone()))
+        .collect();
 
     // Build the base query based on mode
-    let base_query: Box<dyn Query> = if opts.fuzzy {
-        build_fuzzy_query(query_str, content, symbols_f, opts.sym_only)
+    let base_query: Box<dyn Query> = if filters_only {
+        Box::new(AllQuery)
+    } else if opts.fuzzy {
+        build_fuzzy_query(
+            &fuzzy_terms,
+            &content_terms,
+            content,
+            symbols_f,
+            opts.sym_only,
+            opts.fuzzy_distance,
+        )
     } else if opts.sym_only {
-        let parser = QueryParser::for_index(&index, vec![symbols_f]);
-        parser.parse_query(query_str)?
+        let parser = QueryParser::for_index(index, vec![symbols_f]);
+        build_query(&parser, parsed)?
+    } else if case_sensitive {
+        let parser = QueryParser::for_index(index, vec![content_cased_field(&schema)]);
+        build_query(&parser, parsed)?
     } else {
-        let mut parser = QueryParser::for_index(&index, vec![content, symbols_f]);
+        let mut fields = vec![content, symbols_f];
+        if meta.match_paths {
+            fields.push(path_text_field(&schema));
+        }
+        fields.extend(field_boosts.iter().map(|&(_, field, _)| field));
+        let mut parser = QueryParser::for_index(index, fields);
         parser.set_field_boost(symbols_f, 3.0);
-        parser.parse_query(query_str)?
+        if meta.match_paths {
+            parser.set_field_boost(path_text_field(&schema), opts.filename_boost);
+        }
+        for &(_, field, boost) in &field_boosts {
+            parser.set_field_boost(field, boost);
+        }
+        build_query(&parser, parsed)?
     };
 
-    // Wrap with language filter if specified
-    let query: Box<dyn Query> = if let Some(ref lang_filter) = opts.file_type {
-        let lang_query: Box<dyn Query> = Box::new(TermQuery::new(
-            Term::from_field_text(lang_f, lang_filter),
-            IndexRecordOption::Basic,
-        ));
-        Box::new(BooleanQuery::new(vec![
-            (Occur::Must, base_query),
-            (Occur::Must, lang_query),
-        ]))
+    // Wrap with language filters: `-t`, then inline `lang:` (both apply)
+    let inline_langs: Vec<String> = filters
+        .iter()
+        .filter(|f| f.field == FilterField::Lang && !f.negated)
+        .map(|f| f.value.clone())
+        .collect();
+    let mut clauses: Vec<(Occur, Box<dyn Query>)> = vec![(Occur::Must, base_query)];
+    for langs in [&opts.languages, &inline_langs] {
+        if let Some(lang_query) = language_filter(lang_f, langs) {
+            clauses.push((Occur::Must, lang_query));
+        }
+    }
+    for f in filters.iter().filter(|f| f.field == FilterField::Lang && f.negated) {
+        let term = Term::from_field_text(lang_f, &f.value);
+        clauses.push((Occur::MustNot, Box::new(TermQuery::new(term, IndexRecordOption::Basic))));
+    }
+    // Token classes only filter: the match must also be found in a wanted
+    // class, but scores come from the base query alone.
+    if by_class && !filters_only {
+        let class_query = token_class_filter(index, lang_f, parsed, &content_terms, opts)?;
+        clauses.push((Occur::Must, Box::new(BoostQuery::new(class_query, 0.0))));
+    }
+    // Optional clause: scores files whose path holds a query term, but
+    // never makes a file match on its path alone — unless the index was
+    // built with `--match-paths`, where the base query already matches and
+    // scores `path_text` and this only re-scores the page.
+    let path_query: Option<Box<dyn Query>> = if opts.sym_only || filters_only {
+        None
+    } else if opts.filename_boost > 0.0 {
+        let parser = QueryParser::for_index(index, vec![path_text_field(&schema)]);
+        parser.parse_query(&positive_text(query_str)).ok()
     } else {
-        base_query
+        None
+    };
+    let paths_matched = meta.match_paths && !opts.fuzzy && !case_sensitive;
+    if let (Some(ref path_query), false) = (&path_query, paths_matched) {
+        let boosted = BoostQuery::new(path_query.box_clone(), opts.filename_boost);
+        clauses.push((Occur::Should, Box::new(boosted)));
+    }
+    // Optional clause too: ranks files where the terms are close together.
+    let proximity_query = if opts.sym_only || filters_only || opts.proximity_boost <= 0.0 {
+        None
+    } else {
+        let field = if case_sensitive { content_cased_field(&schema) } else { content };
+        let terms = proximity_terms(query_str, &analyzer, &mut normalizer, case_sensitive);
+        build_proximity_query(field, &terms, opts.proximity_boost, opts.proximity_window)
+    };
+    if let Some(ref proximity_query) = proximity_query {
+        clauses.push((Occur::Should, proximity_query.box_clone()));
+    }
+    let query: Box<dyn Query> = if clauses.len() == 1 {
+        clauses.pop().map(|(_, q)| q).expect("base query clause")
+    } else {
+        Box::new(BooleanQuery::new(clauses))
     };
-
-    let reader = create_reader_with_retry(&index, root)?;
-    let searcher = reader.searcher();
-
-    let start = Instant::now();
-    let top_docs = searcher.search(&query, &TopDocs::with_limit(max_results))?;
-    let elapsed_ms = start.elapsed().as_millis() as u64;
 
     // Build per-field queries for re-scoring (explainable ranking).
     // These are only evaluated against the top-N docs, not the full index.
-    let content_query: Option<Box<dyn Query>> = if opts.sym_only {
+    let content_query: Option<Box<dyn Query>> = if opts.sym_only || filters_only {
         None // No content field in sym-only mode
     } else if opts.fuzzy {
-        Some(build_fuzzy_single_field_query(query_str, content))
+        Some(build_fuzzy_single_field_query(&content_terms, content, opts.fuzzy_distance))
+    } else {
+        let field = if case_sensitive { content_cased_field(&schema) } else { content };
+        let parser = QueryParser::for_index(index, vec![field]);
+        build_query(&parser, parsed).ok()
+    };
+    let symbols_query: Option<Box<dyn Query>> = if filters_only || case_sensitive {
+        None
+    } else if opts.fuzzy {
+        Some(build_fuzzy_single_field_query(&fuzzy_terms, symbols_f, opts.fuzzy_distance))
     } else {
-        let parser = QueryParser::for_index(&index, vec![content]);
-        parser.parse_query(query_str).ok()
+        let parser = QueryParser::for_index(index, vec![symbols_f]);
+        build_query(&parser, parsed).ok()
     };
-    let symbols_query: Option<Box<dyn Query>> = if opts.fuzzy {
-        Some(build_fuzzy_single_field_query(query_str, symbols_f))
+    // Only plain queries search declared fields; `title:x` in another mode
+    // still matches through the parser, but isn't re-scored.
+    let field_queries = if opts.sym_only || opts.fuzzy || case_sensitive || filters_only {
+        Vec::new()
     } else {
-        let parser = QueryParser::for_index(&index, vec![symbols_f]);
-        parser.parse_query(query_str).ok()
+        field_boosts
+            .iter()
+            .filter_map(|&(name, field, boost)| {
+                let parser = QueryParser::for_index(index, vec![field]);
+                let query = build_query(&parser, parsed).ok()?;
+                Some((name.to_string(), query, boost))
+            })
+            .collect()
     };
 
-    let mut results = Vec::with_capacity(top_docs.len());
-    for (score, doc_address) in &top_docs {
-        let doc: TantivyDocument = searcher.doc(*doc_address)?;
+    let weights = Weights::new(&opts.weights)?;
+    let early_termination = opts.early_termination
+        && path_filters.is_empty()
+        && weights.is_empty()
+        && !opts.dedup
+        && opts.sort_by == SortMode::Relevance;
+    Ok(IndexQueries {
+        query,
+        content_query,
+        symbols_query,
+        path_query,
+        proximity_query,
+        field_queries,
+        path_filters,
+        weights,
+        early_termination,
+        dedup: opts.dedup.then_some(opts.dedup_distance),
+        sort_by: opts.sort_by,
+        cancel: opts.cancel.clone(),
+        symbols_boost: if opts.sym_only { 1.0 } else { 3.0 },
+        path_boost: opts.filename_boost,
+        explain: opts.explain,
+        include_positions: opts.include_positions,
+    })
+}
 
-        let path_val = doc
-            .get_first(path_f)
-            .and_then(|v| v.as_str())
-            .unwrap_or("")
-            .to_string();
+/// Compiled [`WeightRule`]s.
+#[derive(Default)]
+pub(crate) struct Weights(Vec<(glob::Pattern, f32)>);
 
-        let lang_val = doc
-            .get_first(lang_f)
-            .and_then(|v| v.as_str())
-            .map(|s| s.to_string())
-            .filter(|s| !s.is_empty());
+impl Weights {
+    pub(crate) fn new(rules: &[WeightRule]) -> Result<Self, NsError> {
+        let rules = rules
+            .iter()
+            .map(|r| Ok((glob::Pattern::new(&r.glob)?, r.multiplier)))
+            .collect::<Result<Vec<_>, NsError>>()?;
+        Ok(Self(rules))
+    }
 
-        let symbols_raw_val = doc
-            .get_first(symbols_raw_f)
-            .and_then(|v| v.as_str())
-            .unwrap_or("")
-            .to_string();
+    fn is_empty(&self) -> bool {
+        self.0.is_empty()
+    }
+
+    /// The product of the multipliers of every rule matching `path`.
+    pub(crate) fn multiplier(&self, path: &str) -> f32 {
+        self.0
+            .iter()
+            .filter(|(glob, _)| glob.matches(path))
+            .map(|(_, multiplier)| multiplier)
+            .product()
+    }
+}
+
+/// The inline `path:` and `ext:` filters of a query, checked against each
+/// hit's path before paging (like `-g`). Filters on the same field are
+/// alternatives; different fields must all pass; any negated match excludes.
+#[derive(Default)]
+pub(crate) struct PathFilters {
+    paths: Vec<(PathPattern, bool)>,
+    exts: Vec<(String, bool)>,
+}
+
+/// A `path:` value: a glob when it has glob metacharacters, else a substring.
+enum PathPattern {
+    Substring(String),
+    Glob(glob::Pattern),
+}
 
-        let symbols: Vec<String> = if symbols_raw_val.is_empty() {
-            Vec::new()
+impl PathFilters {
+    fn new(filters: &[FieldFilter]) -> Result<Self, NsError> {
+        let mut path_filters = Self::default();
+        for f in filters {
+            match f.field {
+                FilterField::Path => {
+                    let pattern = if f.value.contains(['*', '?', '[']) {
+                        PathPattern::Glob(glob::Pattern::new(&f.value)?)
+                    } else {
+                        PathPattern::Substring(f.value.clone())
+                    };
+                    path_filters.paths.push((pattern, f.negated));
+                }
+                FilterField::Ext => path_filters.exts.push((f.value.clone(), f.negated)),
+                FilterField::Lang => {}
+            }
+        }
+        Ok(path_filters)
+    }
+
+    fn is_empty(&self) -> bool {
+        self.paths.is_empty() && self.exts.is_empty()
+    }
+
+    fn matches(&self, path: &str) -> bool {
+        let ext = Path::new(path)
+            .extension()
+            .and_then(|e| e.to_str())
+            .map(|e| e.to_lowercase());
+        group_matches(&self.paths, |pattern| match pattern {
+            PathPattern::Substring(s) => path.contains(s.as_str()),
+            PathPattern::Glob(g) => g.matches(path),
+        }) && group_matches(&self.exts, |e| ext.as_deref() == Some(e.as_str()))
+    }
+}
+
+/// True if no negated item of `group` matches and, when it has any
+/// non-negated items, at least one of them does.
+fn group_matches<T>(group: &[(T, bool)], hit: impl Fn(&T) -> bool) -> bool {
+    let mut has_include = false;
+    let mut included = false;
+    for (item, negated) in group {
+        let matched = hit(item);
+        if *negated {
+            if matched {
+                return false;
+            }
         } else {
-            symbols_raw_val.split('|').map(|s| s.to_string()).collect()
-        };
+            has_include = true;
+            included |= matched;
+        }
+    }
+    !has_include || included
+}
 
-        // Re-score against individual field queries for explainability.
-        let score_content = content_query
-            .as_ref()
-            .and_then(|q| q.explain(&searcher, *doc_address).ok())
-            .map(|e| e.value() as f32)
-            .unwrap_or(0.0);
-        let score_symbols = symbols_query
-            .as_ref()
-            .and_then(|q| q.explain(&searcher, *doc_address).ok())
-            .map(|e| e.value() as f32)
-            .unwrap_or(0.0);
-
-        let mut matched_fields = Vec::new();
-        if score_content > 0.0 {
-            matched_fields.push("content".to_string());
+/// A ranked page of hits and how many documents matched in all.
+pub(crate) struct Page {
+    pub(crate) hits: Vec<RankedHit>,
+    pub(crate) total: usize,
+    /// The search stopped early, so `total` only counts the hits it ranked.
+    pub(crate) total_is_lower_bound: bool,
+}
+
+/// Runs `queries.query` on `searcher` and returns the ranked
+/// `offset..offset + limit` page with the total match count (see
+/// `rank_page`). BM25 statistics come from `stats`: the searcher itself,
+/// or statistics combined across several indexes.
+///
+/// With early termination, only the top `offset + limit + 1` hits are
+/// collected, which lets tantivy prune documents below the heap's lowest
+/// score without scoring them. The extra hit shows whether the page ends
+/// inside a score tie; if it does, the tie's members beyond the heap are
+/// unknown and the search is rerun in full to order them by path.
+pub(crate) fn search_page(
+    searcher: &Searcher,
+    queries: &IndexQueries,
+    stats: &dyn Bm25StatisticsProvider,
+    glob: Option<&glob::Pattern>,
+    offset: usize,
+    limit: usize,
+) -> Result<Page, NsError> {
+    let path_f = path_field(searcher.schema());
+    queries.cancel.check()?;
+    if queries.early_termination && glob.is_none() {
+        let heap = offset.saturating_add(limit).saturating_add(1);
+        let hits = searcher.search_with_statistics_provider(
+            &queries.query,
+            &TopDocs::with_limit(heap),
+            stats,
+        )?;
+        let cut = hits.len() == heap;
+        if !cut || heap < 2 || hits[heap - 1].0 < hits[heap - 2].0 {
+            let (hits, total) = rank_page(searcher, hits, path_f, glob, queries, offset, limit)?;
+            return Ok(Page {
+                hits,
+                total,
+                total_is_lower_bound: cut,
+            });
         }
-        if score_symbols > 0.0 {
-            matched_fields.push("symbols".to_string());
+    }
+
+    // Collect every hit: the total must be exact, and ties at the page
+    // boundary can only be ordered by path once the whole tie is known.
+    let hits = collect_all(searcher, queries, stats)?;
+    let (hits, total) = rank_page(searcher, hits, path_f, glob, queries, offset, limit)?;
+    Ok(Page {
+        hits,
+        total,
+        total_is_lower_bound: false,
+    })
+}
+
+/// Scores every live document matching `queries.query`, most relevant
+/// first (ties by address), checking `queries.cancel` every
+/// `CANCEL_CHECK_DOCS` documents. What `TopDocs` with a limit of every
+/// document returns, but stoppable partway through a segment.
+fn collect_all(
+    searcher: &Searcher,
+    queries: &IndexQueries,
+    stats: &dyn Bm25StatisticsProvider,
+) -> Result<Vec<(f32, DocAddress)>, NsError> {
+    let weight = queries
+        .query
+        .weight(EnableScoring::enabled_from_statistics_provider(stats, searcher))?;
+    let mut hits = Vec::new();
+    for (ord, reader) in searcher.segment_readers().iter().enumerate() {
+        queries.cancel.check()?;
+        let alive = reader.alive_bitset();
+        let mut scorer = weight.scorer(reader, 1.0)?;
+        let mut scored = 0;
+        while scorer.doc() != TERMINATED {
+            let doc = scorer.doc();
+            if alive.is_none_or(|alive| alive.is_alive(doc)) {
+                hits.push((scorer.score(), DocAddress::new(ord as u32, doc)));
+            }
+            scored += 1;
+            if scored % CANCEL_CHECK_DOCS == 0 {
+                queries.cancel.check()?;
+            }
+            scorer.advance();
         }
+    }
+    hits.sort_by(|a, b| b.0.total_cmp(&a.0).then_with(|| a.1.cmp(&b.1)));
+    Ok(hits)
+}
 
-        results.push(SearchResult {
-            path: path_val,
-            score: *score,
-            lang: lang_val,
-            symbols_raw: symbols,
-            score_content,
-            score_symbols,
-            matched_fields,
-        });
+/// Loads the stored fields of a ranked hit and re-scores it against the
+/// per-field queries, with the same BM25 statistics used to rank it.
+pub(crate) fn load_result(
+    searcher: &Searcher,
+    stats: &dyn Bm25StatisticsProvider,
+    queries: &IndexQueries,
+    hit: RankedHit,
+) -> Result<SearchResult, NsError> {
+    let schema = searcher.schema();
+    let doc: TantivyDocument = searcher.doc(hit.address)?;
+
+    let lang_val = doc
+        .get_first(lang_field(schema))
+        .and_then(|v| v.as_str())
+        .map(|s| s.to_string())
+        .filter(|s| !s.is_empty());
+
+    let symbols_raw_val = doc
+        .get_first(symbols_raw_field(schema))
+        .and_then(|v| v.as_str())
+        .unwrap_or("")
+        .to_string();
+
+    let symbols: Vec<String> = if symbols_raw_val.is_empty() {
+        Vec::new()
+    } else {
+        symbols_raw_val.split('|').map(|s| s.to_string()).collect()
+    };
+
+    // Re-score against individual field queries for explainability.
+    let score_content = field_score(queries.content_query.as_deref(), searcher, stats, hit.address);
+    let score_symbols = field_score(queries.symbols_query.as_deref(), searcher, stats, hit.address);
+
+    let mut matched_fields = Vec::new();
+    if score_content > 0.0 {
+        matched_fields.push("content".to_string());
+    }
+    if score_symbols > 0.0 {
+        matched_fields.push("symbols".to_string());
+    }
+    if field_score(queries.path_query.as_deref(), searcher, stats, hit.address) > 0.0 {
+        matched_fields.push("path".to_string());
+    }
+    if field_score(queries.proximity_query.as_deref(), searcher, stats, hit.address) > 0.0 {
+        matched_fields.push("proximity".to_string());
     }
+    for (name, query, _) in &queries.field_queries {
+        if field_score(Some(query.as_ref()), searcher, stats, hit.address) > 0.0 {
+            matched_fields.push(name.clone());
+        }
+    }
+    let terms = if queries.explain {
+        term_scores(searcher, stats, &queries.term_queries(), hit.address)?
+    } else {
+        Vec::new()
+    };
+    let lines = match doc
+        .get_first(line_index_field(schema))
+        .and_then(|v| v.as_bytes())
+        .and_then(LineIndex::decode)
+    {
+        Some(line_index) => matched_lines(searcher, queries.content_query.as_deref(), hit.address, &line_index)?,
+        None => Vec::new(),
+    };
+    let offsets = doc
+        .get_first(token_offsets_field(schema))
+        .filter(|_| queries.include_positions)
+        .and_then(|v| v.as_bytes())
+        .and_then(OffsetIndex::decode);
+    let positions = match offsets {
+        Some(offsets) => matched_offsets(searcher, queries.content_query.as_deref(), hit.address, &offsets)?,
+        None => BTreeMap::new(),
+    };
+
+    Ok(SearchResult {
+        path: hit.path,
+        score: hit.score,
+        lang: lang_val,
+        symbols_raw: symbols,
+        score_content,
+        score_symbols,
+        matched_fields,
+        meta: BTreeMap::new(),
+        duplicates: hit.duplicates,
+        terms,
+        lines,
+        positions,
+    })
+}
 
-    // Post-filter by glob pattern if specified
-    if let Some(ref glob_pattern) = opts.file_glob {
-        let pattern = glob::Pattern::new(glob_pattern)?;
-        results.retain(|r| pattern.matches(&r.path));
+/// The sorted 1-based lines on which the terms of `query` occur in the
+/// document at `address`, from their postings' positions.
+fn matched_lines(
+    searcher: &Searcher,
+    query: Option<&dyn Query>,
+    address: DocAddress,
+    line_index: &LineIndex,
+) -> Result<Vec<usize>, NsError> {
+    let mut lines = BTreeSet::new();
+    for (_, positions) in term_positions(searcher, query, address)? {
+        lines.extend(positions.iter().map(|&p| line_index.line(p)));
     }
+    Ok(lines.into_iter().collect())
+}
 
-    let stats = SearchStats {
-        total_results: results.len(),
-        files_searched: meta.file_count,
-        elapsed_ms,
+/// The sorted byte offsets at which each term of `query` occurs in the
+/// document at `address`, by term text, from their postings' positions.
+/// Terms the document doesn't have are left out.
+fn matched_offsets(
+    searcher: &Searcher,
+    query: Option<&dyn Query>,
+    address: DocAddress,
+    offsets: &OffsetIndex,
+) -> Result<BTreeMap<String, Vec<usize>>, NsError> {
+    let mut by_term: BTreeMap<String, BTreeSet<usize>> = BTreeMap::new();
+    for (term, positions) in term_positions(searcher, query, address)? {
+        let text = String::from_utf8_lossy(term.serialized_value_bytes()).into_owned();
+        by_term
+            .entry(text)
+            .or_default()
+            .extend(positions.iter().filter_map(|&p| offsets.offset(p)));
+    }
+    Ok(by_term
+        .into_iter()
+        .map(|(term, offsets)| (term, offsets.into_iter().collect()))
+        .collect())
+}
+
+/// Each term of `query` found in the document at `address`, with the
+/// positions of its occurrences there.
+fn term_positions(
+    searcher: &Searcher,
+    query: Option<&dyn Query>,
+    address: DocAddress,
+) -> Result<Vec<(Term, Vec<u32>)>, NsError> {
+    let Some(query) = query else {
+        return Ok(Vec::new());
     };
+    let mut terms = Vec::new();
+    query.query_terms(&mut |term, _| terms.push(term.clone()));
 
-    Ok((results, stats))
+    let segment = searcher.segment_reader(address.segment_ord);
+    let mut found = Vec::new();
+    for term in terms {
+        let postings = segment
+            .inverted_index(term.field())?
+            .read_postings(&term, IndexRecordOption::WithFreqsAndPositions)?;
+        let Some(mut postings) = postings else {
+            continue;
+        };
+        if postings.seek(address.doc_id) != address.doc_id {
+            continue;
+        }
+        let mut positions = Vec::new();
+        postings.positions(&mut positions);
+        found.push((term, positions));
+    }
+    Ok(found)
+}
+
+/// Scores one document against a per-field query, or 0.0 if there is no
+/// query or it does not match.
+pub(crate) fn field_score(
+    query: Option<&dyn Query>,
+    searcher: &Searcher,
+    stats: &dyn Bm25StatisticsProvider,
+    address: DocAddress,
+) -> f32 {
+    query
+        .and_then(|q| q.weight(EnableScoring::enabled_from_statistics_provider(stats, searcher)).ok())
+        .and_then(|w| w.explain(searcher.segment_reader(address.segment_ord), address.doc_id).ok())
+        .map(|e| e.value() as f32)
+        .unwrap_or(0.0)
+}
+
+/// A matching document with the path used to break score ties, its mtime
+/// when sorting by it, and with dedup, its content fingerprint and the
+/// paths folded into it.
+pub(crate) struct RankedHit {
+    pub(crate) score: f32,
+    pub(crate) address: DocAddress,
+    pub(crate) path: String,
+    pub(crate) mtime: u64,
+    pub(crate) fingerprint: u64,
+    pub(crate) duplicates: Vec<String>,
+}
+
+/// Orders `hits` by `queries.sort_by` (score, descending, by default),
+/// then path, then address, and returns the `offset..offset + limit` page
+/// with the total hit count.
+///
+/// `hits` arrive sorted by score alone. Without a glob, path filters,
+/// weights, dedup or another sort, paths are loaded only up to the end of
+/// the score tie the page ends in — every hit before that point outranks
+/// every hit after it. A glob or path filter must see every path to count
+/// the total, weights to know every score, dedup to fold every copy, and
+/// sorting by path or mtime every key.
+fn rank_page(
+    searcher: &Searcher,
+    hits: Vec<(f32, DocAddress)>,
+    path_f: Field,
+    glob: Option<&glob::Pattern>,
+    queries: &IndexQueries,
+    offset: usize,
+    limit: usize,
+) -> Result<(Vec<RankedHit>, usize), NsError> {
+    let path_filters = &queries.path_filters;
+    let weights = &queries.weights;
+    let simhash_f = simhash_field(searcher.schema());
+    let mtime_f = mtime_field(searcher.schema());
+    let filtered = glob.is_some() || !path_filters.is_empty() || queries.dedup.is_some();
+    let mut end = hits.len();
+    if !filtered && weights.is_empty() && queries.sort_by == SortMode::Relevance {
+        end = offset.saturating_add(limit).min(hits.len());
+        while end > 0 && end < hits.len() && hits[end].0 == hits[end - 1].0 {
+            end += 1;
+        }
+    }
+
+    let mut ranked = Vec::with_capacity(end);
+    for (i, &(score, address)) in hits[..end].iter().enumerate() {
+        if i % CANCEL_CHECK_DOCS == 0 {
+            queries.cancel.check()?;
+        }
+        let doc: TantivyDocument = searcher.doc(address)?;
+        let path = doc
+            .get_first(path_f)
+            .and_then(|v| v.as_str())
+            .unwrap_or("")
+            .to_string();
+        if glob.is_some_and(|g| !g.matches(&path)) || !path_filters.matches(&path) {
+            continue;
+        }
+        let score = score * weights.multiplier(&path);
+        let fingerprint = if queries.dedup.is_some() {
+            doc.get_first(simhash_f).and_then(|v| v.as_u64()).unwrap_or(0)
+        } else {
+            0
+        };
+        let mtime = if queries.sort_by == SortMode::ModTime {
+            doc.get_first(mtime_f).and_then(|v| v.as_u64()).unwrap_or(0)
+        } else {
+            0
+        };
+        ranked.push(RankedHit {
+            score,
+            address,
+            path,
+            mtime,
+            fingerprint,
+            duplicates: Vec::new(),
+        });
+    }
+
+    ranked.sort_by(|a, b| {
+        queries
+            .sort_by
+            .compare(a, b)
+            .then_with(|| a.path.cmp(&b.path))
+            .then_with(|| a.address.cmp(&b.address))
+    });
+    if let Some(max_distance) = queries.dedup {
+        ranked = collapse(ranked, max_distance);
+    }
+    let total = if filtered { ranked.len() } else { hits.len() };
+    let page = ranked.into_iter().skip(offset).take(limit).collect();
+    Ok((page, total))
+}
+
+/// Builds a filter matching documents whose `lang` is any of `languages`,
+/// or `None` when no language filter applies.
+pub(crate) fn language_filter(lang_f: Field, languages: &[String]) -> Option<Box<dyn Query>> {
+    let mut clauses: Vec<(Occur, Box<dyn Query>)> = languages
+        .iter()
+        .map(|lang| {
+            let q: Box<dyn Query> = Box::new(TermQuery::new(
+                Term::from_field_text(lang_f, lang),
+                IndexRecordOption::Basic,
+            ));
+            (Occur::Should, q)
+        })
+        .collect();
+    match clauses.len() {
+        0 => None,
+        1 => clauses.pop().map(|(_, q)| q),
+        _ => Some(Box::new(BooleanQuery::new(clauses))),
+    }
+}
+
+/// Lists the languages present in the index with their file counts,
+/// sorted by count (descending), then name.
+pub fn indexed_languages(root: &Path) -> Result<Vec<(String, usize)>, NsError> {
+    let (index, _meta) = open_index(root)?;
+    let lang_f = lang_field(&index.schema());
+    let reader = create_reader_with_retry(&index, root)?;
+    let searcher = reader.searcher();
+
+    // Term dictionaries may still hold languages whose files were all
+    // deleted; those count zero and are dropped.
+    let mut names = std::collections::BTreeSet::new();
+    for segment in searcher.segment_readers() {
+        let inverted = segment.inverted_index(lang_f)?;
+        let mut terms = inverted.terms().stream()?;
+        while terms.advance() {
+            names.insert(String::from_utf8_lossy(terms.key()).into_owned());
+        }
+    }
+
+    let mut counts = Vec::new();
+    for name in names {
+        let query = TermQuery::new(Term::from_field_text(lang_f, &name), IndexRecordOption::Basic);
+        let count = searcher.search(&query, &Count)?;
+        if count > 0 {
+            counts.push((name, count));
+        }
+    }
+    counts.sort_by(|a, b| b.1.cmp(&a.1).then_with(|| a.0.cmp(&b.0)));
+    Ok(counts)
+}
+
+/// Size and freshness figures for an index, for `ns status`.
+#[derive(Debug, Serialize)]
+pub struct IndexStats {
+    /// Indexed files.
+    pub documents: u64,
+    /// `content` tokens over all files. Files deleted since the last
+    /// segment merge still count until the merge.
+    pub total_terms: u64,
+    /// `total_terms / documents`, or 0 for an empty index.
+    pub avg_doc_length: f64,
+    /// Bytes on disk under `.ns/index/`, measured now.
+    pub index_size_bytes: u64,
+    /// When the index was last built or updated (ISO 8601).
+    pub indexed_at: String,
+}
+
+/// Reads [`IndexStats`] for the index at `root`.
+pub fn index_stats(root: &Path) -> Result<IndexStats, NsError> {
+    let (index, meta) = open_index(root)?;
+    let content = content_field(&index.schema());
+    let reader = create_reader_with_retry(&index, root)?;
+    let searcher = reader.searcher();
+
+    let documents = searcher.num_docs();
+    let total_terms = searcher.total_num_tokens(content)?;
+    let avg_doc_length = if documents == 0 {
+        0.0
+    } else {
+        total_terms as f64 / documents as f64
+    };
+    Ok(IndexStats {
+        documents,
+        total_terms,
+        avg_doc_length,
+        index_size_bytes: dir_size(&root.join(".ns").join("index")),
+        indexed_at: meta.indexed_at,
+    })
 }
 
 /// Creates an IndexReader with retry logic for transient lock failures.
@@ -250,7 +1268,7 @@ pub fn execute_search(
 ///
 /// Retry strategy: up to 3 attempts with 100ms delay. On final attempt,
 /// clean stale lock files and retry once more.
-fn create_reader_with_retry(
+pub(crate) fn create_reader_with_retry(
     index: &tantivy::Index,
     root: &Path,
 ) -> Result<tantivy::IndexReader, NsError> {
@@ -314,27 +1332,166 @@ fn clean_stale_locks(root: &Path) {
     }
 }
 
-/// Tokenizes a query string: splits on whitespace and lowercases each token.
-fn tokenize_query(query: &str) -> Vec<String> {
-    query
+/// Tokenizes a query string for fuzzy matching: drops operators and negated
+/// terms, splits on whitespace, lowercases each token and drops
+/// `stop_words` (they are not in the index, so would only match typos).
+fn fuzzy_terms(query: &str, stop_words: &[String]) -> Vec<String> {
+    positive_text(query)
         .split_whitespace()
         .filter(|s| !s.is_empty())
         .map(|s| s.to_lowercase())
+        .filter(|s| !stop_words.contains(s))
+        .collect()
+}
+
+/// The content terms of `query_str` in order, analyzed like indexed
+/// `content` tokens so they line up with indexed positions: split into
+/// words as `analyzer` splits them (`readTimeout` → `read`, `timeout` by
+/// default), then, unless `case_sensitive`, put through its filters with
+/// `normalizer` (by default lowercased and stop words dropped).
+fn proximity_terms(
+    query_str: &str,
+    analyzer: &Analyzer,
+    normalizer: &mut Normalizer,
+    case_sensitive: bool,
+) -> Vec<String> {
+    let positive = positive_text(query_str);
+    if case_sensitive {
+        // `content_cased` is always split like the default analyzer.
+        return Analyzer::default()
+            .split_words(&positive)
+            .into_iter()
+            .map(str::to_string)
+            .collect();
+    }
+    analyzer
+        .split_words(&positive)
+        .into_iter()
+        .filter_map(|word| normalizer.normalize(word))
         .collect()
 }
 
+/// Slops of the proximity levels for `window`: 0, 1, 3, 7, … below
+/// `window`, then `window` itself.
+fn proximity_levels(window: usize) -> Vec<u32> {
+    let window = window.min(u32::MAX as usize) as u32;
+    let mut levels = Vec::new();
+    let mut slop = 0u32;
+    while slop < window {
+        levels.push(slop);
+        slop = slop.saturating_mul(2).saturating_add(1);
+    }
+    levels.push(window);
+    levels
+}
+
+/// Builds the proximity query: for each pair of consecutive `terms`, one
+/// sloppy `PhraseQuery` per level of [`proximity_levels`]. A pair `gap`
+/// tokens apart matches every level with slop ≥ `gap`; level boosts are
+/// the differences between `boost / (slop + 1)` of successive levels, so
+/// together they add `boost / (slop + 1)` for the tightest level matched.
+/// `None` for fewer than two terms.
+fn build_proximity_query(
+    field: Field,
+    terms: &[String],
+    boost: f32,
+    window: usize,
+) -> Option<Box<dyn Query>> {
+    let levels = proximity_levels(window);
+    let weight = |slop: u32| boost / (slop as f32 + 1.0);
+    let mut clauses: Vec<(Occur, Box<dyn Query>)> = Vec::new();
+    for pair in terms.windows(2) {
+        let pair_terms: Vec<Term> = pair.iter().map(|t| Term::from_field_text(field, t)).collect();
+        for (i, &slop) in levels.iter().enumerate() {
+            let level_boost = match levels.get(i + 1) {
+                Some(&next) => weight(slop) - weight(next),
+                None => weight(slop),
+            };
+            let mut phrase = PhraseQuery::new(pair_terms.clone());
+            phrase.set_slop(slop);
+            clauses.push((Occur::Should, Box::new(BoostQuery::new(Box::new(phrase), level_boost))));
+        }
+    }
+    if clauses.is_empty() {
+        None
+    } else {
+        Some(Box::new(BooleanQuery::new(clauses)))
+    }
+}
+
+/// Builds the fuzzy query for one term on one field: an exact `TermQuery`
+/// (BM25) plus one constant-score `FuzzyTermQuery` per distance level up to
+/// `max_distance`, each boosted by `FUZZY_LEVEL_BOOST`.
+///
+/// tantivy's fuzzy queries walk the term dictionary FST with a Levenshtein
+/// automaton, so expansion cost does not grow with the number of terms.
+fn build_fuzzy_term_query(
+    field: tantivy::schema::Field,
+    text: &str,
+    max_distance: u8,
+) -> Box<dyn Query> {
+    let term = Term::from_field_text(field, text);
+    let mut clauses: Vec<(Occur, Box<dyn Query>)> = vec![(
+        Occur::Should,
+        Box::new(TermQuery::new(term.clone(), IndexRecordOption::WithFreqs)),
+    )];
+    for distance in 1..=max_distance.clamp(1, MAX_FUZZY_DISTANCE) {
+        let fuzzy = FuzzyTermQuery::new(term.clone(), distance, true);
+        clauses.push((
+            Occur::Should,
+            Box::new(BoostQuery::new(Box::new(fuzzy), FUZZY_LEVEL_BOOST)),
+        ));
+    }
+    Box::new(BooleanQuery::new(clauses))
+}
+
+/// Matches files where `query_str` (or, fuzzy, its `content_terms`) is
+/// found in the `content_<class>` fields of `opts.token_classes`, plus
+/// every file not lexed as Go — all code — when `Code` is wanted.
+fn token_class_filter(
+    index: &Index,
+    lang_f: Field,
+    query_str: &str,
+    content_terms: &[String],
+    opts: &SearchOptions,
+) -> Result<Box<dyn Query>, NsError> {
+    let schema = index.schema();
+    let fields: Vec<Field> = opts
+        .token_classes
+        .iter()
+        .map(|class| token_class_field(&schema, class))
+        .collect();
+    let mut clauses: Vec<(Occur, Box<dyn Query>)> = Vec::new();
+    if opts.fuzzy {
+        for &field in &fields {
+            let query = build_fuzzy_single_field_query(content_terms, field, opts.fuzzy_distance);
+            clauses.push((Occur::Should, query));
+        }
+    } else if !fields.is_empty() {
+        let parser = QueryParser::for_index(index, fields);
+        clauses.push((Occur::Should, build_query(&parser, query_str)?));
+    }
+    if opts.token_classes.contains(TokenClass::Code) {
+        let go = Term::from_field_text(lang_f, "go");
+        let not_go = BooleanQuery::new(vec![
+            (Occur::Must, Box::new(AllQuery) as Box<dyn Query>),
+            (Occur::MustNot, Box::new(TermQuery::new(go, IndexRecordOption::Basic))),
+        ]);
+        clauses.push((Occur::Should, Box::new(not_go)));
+    }
+    Ok(Box::new(BooleanQuery::new(clauses)))
+}
+
 /// Builds a fuzzy query targeting a single field (no boost).
 /// Used for per-field re-scoring in explainable ranking.
 fn build_fuzzy_single_field_query(
-    query_str: &str,
+    terms: &[String],
     field: tantivy::schema::Field,
+    max_distance: u8,
 ) -> Box<dyn Query> {
-    let tokens = tokenize_query(query_str);
-
     let mut clauses: Vec<(Occur, Box<dyn Query>)> = Vec::new();
-    for lower in &tokens {
-        let ft = FuzzyTermQuery::new(Term::from_field_text(field, lower), 1, true);
-        clauses.push((Occur::Should, Box::new(ft)));
+    for lower in terms {
+        clauses.push((Occur::Should, build_fuzzy_term_query(field, lower, max_distance)));
     }
 
     if clauses.is_empty() {
@@ -344,47 +1501,37 @@ fn build_fuzzy_single_field_query(
     }
 }
 
-/// Builds a fuzzy query by tokenizing the input, creating a `FuzzyTermQuery`
-/// per token (Levenshtein distance 1, transposition cost 1), and combining
-/// them with `Should` occurrence so any term match contributes.
+/// Builds a fuzzy query from the query's `terms` (see `fuzzy_terms`): one
+/// fuzzy term query per term (see `build_fuzzy_term_query`), combined with
+/// `Should` occurrence so any term match contributes.
 ///
 /// If `sym_only` is false, each term generates two clauses: one for `content`
-/// and one for `symbols` (with 3x boost on symbols).
+/// (using its stemmed form from `content_terms`, parallel to `terms`) and one
+/// for `symbols` (with 3x boost on symbols).
 fn build_fuzzy_query(
-    query_str: &str,
+    terms: &[String],
+    content_terms: &[String],
     content_field: tantivy::schema::Field,
     symbols_field: tantivy::schema::Field,
     sym_only: bool,
+    max_distance: u8,
 ) -> Box<dyn Query> {
-    let tokens = tokenize_query(query_str);
-
     let mut clauses: Vec<(Occur, Box<dyn Query>)> = Vec::new();
 
-    for lower in &tokens {
+    for (lower, stemmed) in terms.iter().zip(content_terms) {
+        let symbols_query = build_fuzzy_term_query(symbols_field, lower, max_distance);
         if sym_only {
             // Only symbols field
-            let ft = FuzzyTermQuery::new(
-                Term::from_field_text(symbols_field, lower),
-                1,
-                true,
-            );
-            clauses.push((Occur::Should, Box::new(ft)));
+            clauses.push((Occur::Should, symbols_query));
         } else {
             // Content field (no boost)
-            let ft_content = FuzzyTermQuery::new(
-                Term::from_field_text(content_field, lower),
-                1,
-                true,
-            );
-            clauses.push((Occur::Should, Box::new(ft_content)));
+            clauses.push((
+                Occur::Should,
+                build_fuzzy_term_query(content_field, stemmed, max_distance),
+            ));
 
             // Symbols field with 3x boost
-            let ft_symbols = FuzzyTermQuery::new(
-                Term::from_field_text(symbols_field, lower),
-                1,
-                true,
-            );
-            let boosted: Box<dyn Query> = Box::new(BoostQuery::new(Box::new(ft_symbols), 3.0));
+            let boosted: Box<dyn Query> = Box::new(BoostQuery::new(symbols_query, 3.0));
             clauses.push((Occur::Should, boosted));
         }
     }
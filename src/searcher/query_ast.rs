@@ -0,0 +1,586 @@
+use tantivy::query::{BooleanQuery, Occur, Query, QueryParser, QueryParserError};
+
+/// A boolean query, parsed from `AND` / `OR` / `NOT` syntax.
+///
+/// Leaves are handed to tantivy's `QueryParser` one at a time, so field
+/// boosts and tokenization are identical to a plain query.
+#[derive(Debug, Clone, PartialEq)]
+pub enum QueryNode {
+    And(Vec<QueryNode>),
+    Or(Vec<QueryNode>),
+    Not(Box<QueryNode>),
+    Term(String),
+    /// Double-quoted text; matches adjacent positions only.
+    Phrase(String),
+}
+
+/// A field filter written inline in the query: `path:src/`, `lang:go`,
+/// `ext:rs`, or negated with a leading `-`.
+#[derive(Debug, Clone, PartialEq)]
+pub struct FieldFilter {
+    pub field: FilterField,
+    /// The value after the colon. Lowercased for `lang`, and for `ext`
+    /// without a leading dot; `path` values are kept as written.
+    pub value: String,
+    /// Written `-field:value`: excludes matching files.
+    pub negated: bool,
+}
+
+/// Fields accepted in a [`FieldFilter`].
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum FilterField {
+    /// Substring of the file path, or a glob when the value has `*`, `?` or `[`.
+    Path,
+    /// Detected language, as in `-t`.
+    Lang,
+    /// File extension.
+    Ext,
+}
+
+/// Schema fields tantivy's `QueryParser` handles itself (`symbols:Server`);
+/// these stay in the query text.
+const PARSER_FIELDS: &[&str] = &["content", "symbols"];
+
+/// Splits inline `field:value` filters out of `query`, returning the text
+/// that is left and the filters in query order.
+///
+/// A filter is a whitespace-separated word outside quotes whose field name
+/// is lowercase ASCII letters, followed by a non-empty value not starting
+/// with `:` (so `std::io` stays text). An `AND` joining a filter to the
+/// rest of the query is dropped with it: filters always apply to the whole
+/// query. Unknown field names are a syntax error; those of the index's
+/// declared `doc_fields` (`title:setup`) stay in the text, for the parser.
+pub fn split_field_filters(
+    query: &str,
+    doc_fields: &[&str],
+) -> Result<(String, Vec<FieldFilter>), QueryParserError> {
+    let words = unquoted_words(query);
+    let mut remove = vec![false; words.len()];
+    let mut filters = Vec::new();
+
+    for (k, &(start, end)) in words.iter().enumerate() {
+        let Some(parsed) = parse_field_word(&query[start..end], doc_fields) else {
+            continue;
+        };
+        filters.push(parsed.map_err(QueryParserError::SyntaxError)?);
+        remove[k] = true;
+        let is_and = |i: usize| &query[words[i].0..words[i].1] == "AND";
+        if k + 1 < words.len() && is_and(k + 1) {
+            remove[k + 1] = true;
+        } else if k > 0 && is_and(k - 1) && !remove[k - 1] {
+            remove[k - 1] = true;
+        }
+    }
+
+    let mut rest = String::with_capacity(query.len());
+    let mut last = 0;
+    for (&(start, end), removed) in words.iter().zip(&remove) {
+        if *removed {
+            rest.push_str(&query[last..start]);
+            last = end;
+        }
+    }
+    rest.push_str(&query[last..]);
+    Ok((rest.trim().to_string(), filters))
+}
+
+/// Parses one word as a field filter. Returns `None` for ordinary words
+/// (including [`PARSER_FIELDS`] and `doc_fields`) and an error message for
+/// unknown fields.
+fn parse_field_word(word: &str, doc_fields: &[&str]) -> Option<Result<FieldFilter, String>> {
+    let (negated, body) = match word.strip_prefix('-') {
+        Some(body) => (true, body),
+        None => (false, word),
+    };
+    let (name, value) = body.split_once(':')?;
+    if name.is_empty()
+        || !name.bytes().all(|b| b.is_ascii_lowercase())
+        || value.is_empty()
+        || value.starts_with(':')
+        || PARSER_FIELDS.contains(&name)
+        || doc_fields.contains(&name)
+    {
+        return None;
+    }
+    let (field, value) = match name {
+        "path" => (FilterField::Path, value.to_string()),
+        "lang" => (FilterField::Lang, value.to_lowercase()),
+        "ext" => (FilterField::Ext, value.trim_start_matches('.').to_lowercase()),
+        _ => {
+            return Some(Err(format!(
+                "unknown field '{}' in '{}' (supported: path, lang, ext)",
+                name, word
+            )))
+        }
+    };
+    Some(Ok(FieldFilter {
+        field,
+        value,
+        negated,
+    }))
+}
+
+/// Byte ranges of the whitespace-separated words of `query` that contain
+/// no double quote (so nothing inside a phrase).
+pub(crate) fn unquoted_words(query: &str) -> Vec<(usize, usize)> {
+    let mut words = Vec::new();
+    let mut start = None;
+    let mut in_quote = false;
+    let mut quoted = false;
+    for (i, c) in query.char_indices() {
+        if c == '"' {
+            in_quote = !in_quote;
+            quoted = true;
+        }
+        if c.is_whitespace() && !in_quote {
+            if let Some(s) = start.take() {
+                if !quoted {
+                    words.push((s, i));
+                }
+            }
+            quoted = false;
+        } else if start.is_none() {
+            start = Some(i);
+        }
+    }
+    if let (Some(s), false) = (start, quoted) {
+        words.push((s, query.len()));
+    }
+    words
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Lexeme {
+    LParen,
+    RParen,
+    And,
+    Or,
+    Not,
+    Word(String),
+    Phrase(String),
+}
+
+/// Parses `query` into a [`QueryNode`] if it uses the `AND`, `OR` or `NOT`
+/// keywords (uppercase, as standalone words).
+///
+/// Returns `Ok(None)` for plain queries, which keep their existing meaning:
+/// space-separated terms are OR-ed and go to tantivy's `QueryParser` as-is.
+///
+/// Precedence is `NOT` > `AND` > `OR`. Adjacent operands with no keyword
+/// are OR-ed, except before `NOT`: `server NOT test` means
+/// `server AND NOT test`. `-term` is shorthand for `NOT term`.
+pub fn parse_boolean_query(query: &str) -> Result<Option<QueryNode>, QueryParserError> {
+    let (lexemes, has_keywords) = lex(query);
+    if !has_keywords {
+        return Ok(None);
+    }
+
+    let mut parser = Parser { lexemes, pos: 0 };
+    let node = parser.parse_or().map_err(QueryParserError::SyntaxError)?;
+    if parser.pos < parser.lexemes.len() {
+        return Err(QueryParserError::SyntaxError(
+            "unbalanced ')' in query".to_string(),
+        ));
+    }
+    Ok(Some(node))
+}
+
+/// Whether `word` is a plain query term — letters, digits and `_`, and not
+/// a keyword — that can be rewritten without changing the query's syntax.
+pub(crate) fn is_bare_word(word: &str) -> bool {
+    !word.is_empty()
+        && word.chars().all(|c| c.is_alphanumeric() || c == '_')
+        && !matches!(word, "AND" | "OR" | "NOT")
+}
+
+/// Whether `query` uses the `AND`, `OR` or `NOT` keywords, so
+/// [`build_query`] evaluates it as boolean syntax.
+pub(crate) fn has_boolean_keywords(query: &str) -> bool {
+    lex(query).1
+}
+
+/// Builds the tantivy query for `query_str`: boolean syntax is evaluated
+/// via [`QueryNode`], anything else goes straight to `parser`.
+pub fn build_query(
+    parser: &QueryParser,
+    query_str: &str,
+) -> Result<Box<dyn Query>, QueryParserError> {
+    match parse_boolean_query(query_str)? {
+        Some(node) => to_tantivy(parser, &node),
+        None => parser.parse_query(query_str),
+    }
+}
+
+/// Returns the words of `query` that a matching file is expected to contain:
+/// operator keywords, negated clauses (`NOT x`, `-x`) and field filters
+/// (`lang:go`, and declared fields' `title:setup`) are dropped, phrase
+/// quotes are removed. Used for context-line and symbol highlighting.
+pub fn positive_text(query: &str) -> String {
+    let (lexemes, _) = lex(query);
+    let mut words = Vec::new();
+    let mut i = 0;
+    while i < lexemes.len() {
+        match &lexemes[i] {
+            Lexeme::Not => {
+                i = skip_operand(&lexemes, i + 1);
+                continue;
+            }
+            Lexeme::Word(w) if parse_field_word(w, &[]).is_some() => {}
+            Lexeme::Word(w) | Lexeme::Phrase(w) => words.push(w.as_str()),
+            _ => {}
+        }
+        i += 1;
+    }
+    words.join(" ")
+}
+
+/// Returns the index just past the operand starting at `start` (a word,
+/// phrase, nested `NOT`, or parenthesized group).
+fn skip_operand(lexemes: &[Lexeme], start: usize) -> usize {
+    match lexemes.get(start) {
+        Some(Lexeme::Not) => skip_operand(lexemes, start + 1),
+        Some(Lexeme::LParen) => {
+            let mut depth = 0;
+            for (i, lexeme) in lexemes.iter().enumerate().skip(start) {
+                match lexeme {
+                    Lexeme::LParen => depth += 1,
+                    Lexeme::RParen => {
+                        depth -= 1;
+                        if depth == 0 {
+                            return i + 1;
+                        }
+                    }
+                    _ => {}
+                }
+            }
+            lexemes.len()
+        }
+        Some(_) => start + 1,
+        None => start,
+    }
+}
+
+fn to_tantivy(parser: &QueryParser, node: &QueryNode) -> Result<Box<dyn Query>, QueryParserError> {
+    match node {
+        QueryNode::Term(text) => parser.parse_query(text),
+        QueryNode::Phrase(text) => parser.parse_query(&format!("\"{}\"", text)),
+        QueryNode::Not(inner) => Ok(Box::new(BooleanQuery::new(vec![(
+            Occur::MustNot,
+            to_tantivy(parser, inner)?,
+        )]))),
+        QueryNode::And(children) => combine(parser, children, Occur::Must),
+        QueryNode::Or(children) => combine(parser, children, Occur::Should),
+    }
+}
+
+/// Combines children with `occur`; `Not` children become `MustNot` clauses.
+fn combine(
+    parser: &QueryParser,
+    children: &[QueryNode],
+    occur: Occur,
+) -> Result<Box<dyn Query>, QueryParserError> {
+    let mut clauses: Vec<(Occur, Box<dyn Query>)> = Vec::with_capacity(children.len());
+    for child in children {
+        match child {
+            QueryNode::Not(inner) => clauses.push((Occur::MustNot, to_tantivy(parser, inner)?)),
+            _ => clauses.push((occur, to_tantivy(parser, child)?)),
+        }
+    }
+    Ok(Box::new(BooleanQuery::new(clauses)))
+}
+
+/// Splits `query` into lexemes. Returns `true` alongside if any `AND` /
+/// `OR` / `NOT` keyword was seen.
+fn lex(query: &str) -> (Vec<Lexeme>, bool) {
+    let mut lexemes = Vec::new();
+    let mut has_keywords = false;
+    let mut chars = query.char_indices().peekable();
+
+    while let Some(&(start, c)) = chars.peek() {
+        if c.is_whitespace() {
+            chars.next();
+            continue;
+        }
+        match c {
+            '(' => {
+                chars.next();
+                lexemes.push(Lexeme::LParen);
+            }
+            ')' => {
+                chars.next();
+                lexemes.push(Lexeme::RParen);
+            }
+            '"' => {
+                chars.next();
+                let mut end = query.len();
+                for (i, c) in chars.by_ref() {
+                    if c == '"' {
+                        end = i;
+                        break;
+                    }
+                }
+                lexemes.push(Lexeme::Phrase(query[start + 1..end].to_string()));
+            }
+            _ => {
+                let mut end = query.len();
+                while let Some(&(i, c)) = chars.peek() {
+                    if c.is_whitespace() || matches!(c, '(' | ')' | '"') {
+                        end = i;
+                        break;
+                    }
+                    chars.next();
+                }
+                let word = &query[start..end];
+                let before_quote = chars.peek().is_some_and(|&(_, c)| c == '"');
+                match word {
+                    "AND" => {
+                        has_keywords = true;
+                        lexemes.push(Lexeme::And);
+                    }
+                    "OR" => {
+                        has_keywords = true;
+                        lexemes.push(Lexeme::Or);
+                    }
+                    "NOT" => {
+                        has_keywords = true;
+                        lexemes.push(Lexeme::Not);
+                    }
+                    // `-"a phrase"` negates the phrase that follows
+                    "-" if before_quote => lexemes.push(Lexeme::Not),
+                    w if w.len() > 1 && w.starts_with('-') => {
+                        lexemes.push(Lexeme::Not);
+                        lexemes.push(Lexeme::Word(w[1..].to_string()));
+                    }
+                    w => lexemes.push(Lexeme::Word(w.to_string())),
+                }
+            }
+        }
+    }
+    (lexemes, has_keywords)
+}
+
+/// Recursive-descent parser over lexemes:
+///
+/// ```text
+/// or    := and (["OR"] and)*
+/// and   := unary ("AND" unary | &"NOT" unary)*
+/// unary := "NOT" unary | primary
+/// primary := "(" or ")" | word | phrase
+/// ```
+struct Parser {
+    lexemes: Vec<Lexeme>,
+    pos: usize,
+}
+
+impl Parser {
+    fn peek(&self) -> Option<&Lexeme> {
+        self.lexemes.get(self.pos)
+    }
+
+    fn parse_or(&mut self) -> Result<QueryNode, String> {
+        let mut nodes = vec![self.parse_and()?];
+        loop {
+            match self.peek() {
+                None | Some(Lexeme::RParen) => break,
+                Some(Lexeme::Or) => {
+                    self.pos += 1;
+                    nodes.push(self.parse_and()?);
+                }
+                Some(_) => nodes.push(self.parse_and()?),
+            }
+        }
+        Ok(collapse(nodes, QueryNode::Or))
+    }
+
+    fn parse_and(&mut self) -> Result<QueryNode, String> {
+        let mut nodes = vec![self.parse_unary()?];
+        loop {
+            match self.peek() {
+                Some(Lexeme::And) => {
+                    self.pos += 1;
+                    nodes.push(self.parse_unary()?);
+                }
+                Some(Lexeme::Not) => nodes.push(self.parse_unary()?),
+                _ => break,
+            }
+        }
+        Ok(collapse(nodes, QueryNode::And))
+    }
+
+    fn parse_unary(&mut self) -> Result<QueryNode, String> {
+        if self.peek() == Some(&Lexeme::Not) {
+            self.pos += 1;
+            return Ok(QueryNode::Not(Box::new(self.parse_unary()?)));
+        }
+        self.parse_primary()
+    }
+
+    fn parse_primary(&mut self) -> Result<QueryNode, String> {
+        let lexeme = self.lexemes.get(self.pos).cloned();
+        self.pos += 1;
+        match lexeme {
+            Some(Lexeme::Word(w)) => Ok(QueryNode::Term(w)),
+            Some(Lexeme::Phrase(p)) => Ok(QueryNode::Phrase(p)),
+            Some(Lexeme::LParen) => {
+                let node = self.parse_or()?;
+                if self.peek() != Some(&Lexeme::RParen) {
+                    return Err("missing ')' in query".to_string());
+                }
+                self.pos += 1;
+                Ok(node)
+            }
+            Some(Lexeme::RParen) => Err("unexpected ')' in query".to_string()),
+            Some(Lexeme::And) | Some(Lexeme::Or) | Some(Lexeme::Not) => {
+                Err("operator without a term before it".to_string())
+            }
+            None => Err("query ends with an operator".to_string()),
+        }
+    }
+}
+
+fn collapse(mut nodes: Vec<QueryNode>, wrap: fn(Vec<QueryNode>) -> QueryNode) -> QueryNode {
+    if nodes.len() == 1 {
+        nodes.pop().unwrap()
+    } else {
+        wrap(nodes)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn term(s: &str) -> QueryNode {
+        QueryNode::Term(s.to_string())
+    }
+
+    fn not(node: QueryNode) -> QueryNode {
+        QueryNode::Not(Box::new(node))
+    }
+
+    fn parse(query: &str) -> QueryNode {
+        parse_boolean_query(query)
+            .expect("should parse")
+            .expect("should be a boolean query")
+    }
+
+    #[test]
+    fn plain_queries_are_not_parsed() {
+        assert_eq!(parse_boolean_query("EventStore").unwrap(), None);
+        assert_eq!(parse_boolean_query("validate port").unwrap(), None);
+        assert_eq!(parse_boolean_query("\"graceful shutdown\"").unwrap(), None);
+        // Lowercase words are search terms, not operators
+        assert_eq!(parse_boolean_query("read and write").unwrap(), None);
+    }
+
+    #[test]
+    fn and_binds_tighter_than_or() {
+        assert_eq!(
+            parse("a OR b AND c"),
+            QueryNode::Or(vec![term("a"), QueryNode::And(vec![term("b"), term("c")])])
+        );
+    }
+
+    #[test]
+    fn not_after_operand_means_and_not() {
+        assert_eq!(
+            parse("server AND start NOT test"),
+            QueryNode::And(vec![term("server"), term("start"), not(term("test"))])
+        );
+    }
+
+    #[test]
+    fn implicit_adjacency_is_or() {
+        assert_eq!(
+            parse("a b AND c"),
+            QueryNode::Or(vec![term("a"), QueryNode::And(vec![term("b"), term("c")])])
+        );
+    }
+
+    #[test]
+    fn phrases_and_groups() {
+        assert_eq!(
+            parse("\"graceful shutdown\" AND (server OR client)"),
+            QueryNode::And(vec![
+                QueryNode::Phrase("graceful shutdown".to_string()),
+                QueryNode::Or(vec![term("server"), term("client")]),
+            ])
+        );
+    }
+
+    #[test]
+    fn dash_prefix_is_not() {
+        assert_eq!(
+            parse("a AND -b"),
+            QueryNode::And(vec![term("a"), not(term("b"))])
+        );
+        assert_eq!(
+            parse("a AND -\"x y\""),
+            QueryNode::And(vec![term("a"), not(QueryNode::Phrase("x y".to_string()))])
+        );
+    }
+
+    #[test]
+    fn syntax_errors() {
+        assert!(parse_boolean_query("a AND").is_err());
+        assert!(parse_boolean_query("AND a").is_err());
+        assert!(parse_boolean_query("(a OR b").is_err());
+        assert!(parse_boolean_query("a OR b)").is_err());
+    }
+
+    fn filter(field: FilterField, value: &str, negated: bool) -> FieldFilter {
+        FieldFilter {
+            field,
+            value: value.to_string(),
+            negated,
+        }
+    }
+
+    #[test]
+    fn field_filters_are_split_from_text() {
+        let (rest, filters) =
+            split_field_filters("path:src/ timeout lang:Go -ext:.RS", &[]).unwrap();
+        assert_eq!(rest, "timeout");
+        assert_eq!(
+            filters,
+            vec![
+                filter(FilterField::Path, "src/", false),
+                filter(FilterField::Lang, "go", false),
+                filter(FilterField::Ext, "rs", true),
+            ]
+        );
+
+        let (rest, filters) =
+            split_field_filters("lang:go AND shutdown AND NOT test", &[]).unwrap();
+        assert_eq!((rest.as_str(), filters.len()), ("shutdown AND NOT test", 1));
+        let (rest, _) = split_field_filters("a AND b AND path:x", &[]).unwrap();
+        assert_eq!(rest, "a AND b");
+    }
+
+    #[test]
+    fn non_filter_colons_stay_text() {
+        for query in ["std::io", "\"lang:go here\"", "symbols:Server", "TODO:fix", "key:"] {
+            let (rest, filters) = split_field_filters(query, &[]).unwrap();
+            assert_eq!((rest.as_str(), filters.len()), (query, 0), "{query}");
+        }
+    }
+
+    #[test]
+    fn unknown_field_is_a_syntax_error() {
+        let err = split_field_filters("author:me timeout", &[]).unwrap_err();
+        assert!(format!("{err}").contains("unknown field 'author'"), "got {err}");
+
+        let (rest, filters) = split_field_filters("author:me timeout", &["author"]).unwrap();
+        assert_eq!((rest.as_str(), filters.len()), ("author:me timeout", 0));
+    }
+
+    #[test]
+    fn positive_text_drops_operators_and_negations() {
+        assert_eq!(positive_text("server AND start NOT test"), "server start");
+        assert_eq!(positive_text("a -b c"), "a c");
+        assert_eq!(positive_text("a NOT (b OR c) d"), "a d");
+        assert_eq!(positive_text("\"graceful shutdown\" OR x"), "graceful shutdown x");
+        assert_eq!(positive_text("EventStore.new"), "EventStore.new");
+        assert_eq!(positive_text("lang:go shutdown -path:test/"), "shutdown");
+    }
+}
@@ -0,0 +1,81 @@
+use std::path::Path;
+
+use tantivy::directory::{Directory, DirectoryLock, INDEX_WRITER_LOCK};
+use tantivy::{Index, Searcher, TantivyError};
+
+use crate::error::NsError;
+use crate::indexer::metadata::{read_metadata, Metadata};
+use crate::indexer::writer::{open_index, IndexMeta};
+
+use super::query::{
+    create_reader_with_retry, search_index, SearchOptions, SearchResult, SearchStats,
+};
+
+/// The index of one repo, frozen as it was when opened, for a server
+/// answering many concurrent searches of an index nothing updates.
+///
+/// Unlike [`super::cached::CachedSearcher`], a search reads no files and
+/// takes no lock of this type's own: no generation check, no cache, one
+/// tantivy searcher opened up front and shared by every thread, so
+/// throughput grows with the threads searching (`cargo bench --bench
+/// read_only` measures it). Searches rank as by `query::execute_search`.
+///
+/// While open, it holds the index's writer lock, so incremental updates,
+/// `indexer::remove_file` and `indexer::index_fields` fail with a lock
+/// error (`NsError::is_lock_error`) instead of changing the index under
+/// it. A full `ns index` builds a new index beside the old one and is not
+/// blocked; searches keep seeing the segments opened here until the view
+/// is dropped and reopened.
+pub struct ReadOnlyIndex {
+    index: Index,
+    meta: IndexMeta,
+    searcher: Searcher,
+    metadata: Metadata,
+    _writer_lock: DirectoryLock,
+}
+
+impl ReadOnlyIndex {
+    /// Opens the index at `root` read-only. Fails as `execute_search` would
+    /// on a missing or outdated index, and with a lock error while an
+    /// incremental update or other writer has the index.
+    pub fn open(root: &Path) -> Result<Self, NsError> {
+        let (index, meta) = open_index(root)?;
+        let writer_lock = index
+            .directory()
+            .acquire_lock(&INDEX_WRITER_LOCK)
+            .map_err(|e| {
+                let reason = "the index is being updated".to_string();
+                NsError::Tantivy(TantivyError::LockFailure(e, Some(reason)))
+            })?;
+        let searcher = create_reader_with_retry(&index, root)?.searcher();
+        Ok(Self {
+            index,
+            meta,
+            searcher,
+            metadata: read_metadata(root)?,
+            _writer_lock: writer_lock,
+        })
+    }
+
+    /// Searches the index for `query_str`.
+    pub fn search(
+        &self,
+        query_str: &str,
+        opts: &SearchOptions,
+    ) -> Result<(Vec<SearchResult>, SearchStats), NsError> {
+        opts.cancel.check()?;
+        search_index(
+            &self.index,
+            &self.meta,
+            &self.searcher,
+            &self.metadata,
+            query_str,
+            opts,
+        )
+    }
+
+    /// The `meta.json` of the index as opened.
+    pub fn meta(&self) -> &IndexMeta {
+        &self.meta
+    }
+}
@@ -0,0 +1,506 @@
+use std::collections::BTreeSet;
+use std::path::Path;
+use std::time::Instant;
+
+use regex::{Regex, RegexBuilder};
+use regex_syntax::hir::{Hir, HirKind};
+use tantivy::collector::DocSetCollector;
+use tantivy::query::{AllQuery, BooleanQuery, Occur, Query, RegexQuery};
+use tantivy::schema::Value;
+use tantivy::{Index, Searcher, TantivyDocument};
+
+use super::context::{context_around, truncate_long_lines, TermMatch};
+use super::format::format_single_json_value;
+use super::query::{
+    create_reader_with_retry, language_filter, SearchOptions, SearchResult, SearchStats, Weights,
+};
+use super::{
+    build_files_only_with_budget, render_grep_with_budget, render_json_lines_with_budget,
+    render_json_with_budget, render_text_with_budget, DisplayResult, OutputMode, SearchOutput,
+};
+use crate::error::NsError;
+use crate::indexer::gzip::read_to_string;
+use crate::indexer::tokenizer::split_identifier;
+use crate::indexer::metadata::read_metadata;
+use crate::indexer::trigrams::{required_trigrams, TrigramQuery};
+use crate::indexer::writer::{open_index, IndexMeta};
+use crate::schema::{content_field, lang_field, path_field, symbols_raw_field, trigrams_field};
+
+/// Tokens this long are dropped at index time (`RemoveLongFilter` in the
+/// `code` analyzer), so fragments of this length can't be looked up.
+const MAX_INDEXED_TOKEN_LEN: usize = 40;
+
+/// How many lines to scan between deadline checks.
+const DEADLINE_CHECK_LINES: usize = 1024;
+
+/// An indexed file that may hold a match, from [`candidate_files`].
+#[derive(Debug)]
+pub(crate) struct Candidate {
+    pub(crate) path: String,
+    pub(crate) lang: Option<String>,
+    pub(crate) symbols_raw: Vec<String>,
+}
+
+/// A file with at least one regex match.
+#[derive(Debug)]
+struct RegexFileMatch {
+    path: String,
+    lang: Option<String>,
+    symbols_raw: Vec<String>,
+    /// 0-based indices of matching lines.
+    match_lines: BTreeSet<usize>,
+}
+
+/// Runs a regex search (`--regex`): the pattern is matched line by line
+/// against the raw file text, bypassing the tokenizer.
+///
+/// Candidate files come from the index. Word fragments that every match must
+/// contain (e.g. `status` in `http\.Status\w+`) are looked up in the content
+/// term dictionary, so only files containing all of them are read. Patterns
+/// with no usable fragment scan every indexed file.
+///
+/// Files are ranked by number of matching lines times any `opts.weights`
+/// multipliers for their path (the result `score`), then path.
+/// `opts.ignore_case` makes the pattern case-insensitive. When
+/// `opts.regex_timeout` elapses, scanning stops and the files matched so far
+/// are returned with `timed_out` set.
+pub fn search_regex(
+    root: &Path,
+    pattern: &str,
+    output_mode: OutputMode,
+    opts: &SearchOptions,
+) -> Result<SearchOutput, NsError> {
+    let start = Instant::now();
+    let deadline = opts.regex_timeout.map(|t| start + t);
+    let regex = RegexBuilder::new(pattern)
+        .case_insensitive(opts.ignore_case)
+        .build()?;
+    let weights = Weights::new(&opts.weights)?;
+
+    let (index, meta) = open_index(root)?;
+    let reader = create_reader_with_retry(&index, root)?;
+    let searcher = reader.searcher();
+    let trigrams = required_trigrams(pattern, opts.ignore_case);
+    let fragments = required_fragments(pattern);
+    let candidates = candidate_files(&index, &meta, &searcher, fragments, &trigrams, opts)?;
+
+    let mut matched = Vec::new();
+    let mut timed_out = false;
+    for candidate in candidates {
+        opts.cancel.check()?;
+        let Ok(text) = read_to_string(root.join(&candidate.path)) else {
+            continue; // deleted or unreadable since indexing
+        };
+        let mut match_lines = BTreeSet::new();
+        timed_out = !scan_lines(&regex, &text, deadline, &mut match_lines);
+        if !match_lines.is_empty() {
+            matched.push(RegexFileMatch {
+                path: candidate.path,
+                lang: candidate.lang,
+                symbols_raw: candidate.symbols_raw,
+                match_lines,
+            });
+        }
+        if timed_out {
+            break;
+        }
+    }
+
+    let score = |m: &RegexFileMatch| m.match_lines.len() as f32 * weights.multiplier(&m.path);
+    matched.sort_by(|a, b| score(b).total_cmp(&score(a)).then_with(|| a.path.cmp(&b.path)));
+    let total_matches = matched.len();
+    matched.drain(..opts.offset.min(total_matches));
+    matched.truncate(opts.max_results.min(super::query::MAX_RESULTS_CEILING));
+
+    let stats = SearchStats {
+        total_results: matched.len(),
+        total_matches,
+        total_is_lower_bound: false,
+        files_searched: meta.file_count,
+        elapsed_ms: start.elapsed().as_millis() as u64,
+    };
+
+    let metadata = read_metadata(root)?;
+    let results: Vec<(SearchResult, BTreeSet<usize>)> = matched
+        .into_iter()
+        .map(|m| {
+            let score = score(&m);
+            let file_meta = metadata.get(&m.path);
+            let lines = m.match_lines.iter().map(|&i| i + 1).collect();
+            let result = SearchResult {
+                path: m.path,
+                score,
+                lang: m.lang,
+                symbols_raw: m.symbols_raw,
+                score_content: score,
+                score_symbols: 0.0,
+                matched_fields: vec!["content".to_string()],
+                meta: file_meta,
+                duplicates: Vec::new(),
+                terms: Vec::new(),
+                lines,
+                positions: Default::default(),
+            };
+            (result, m.match_lines)
+        })
+        .collect();
+
+    // Context is rebuilt lazily from the matched files only, so the
+    // budget-aware renderers stop reading files once the budget is spent.
+    let total = results.len();
+    let displays = |results: Vec<(SearchResult, BTreeSet<usize>)>| {
+        results.into_iter().enumerate().map(|(i, (result, match_lines))| {
+            regex_display(root, opts.offset + i + 1, result, &match_lines, &regex, opts)
+        })
+    };
+
+    let (formatted, budget_exhausted, results_omitted) = match output_mode {
+        OutputMode::FilesOnly => {
+            let paths: Vec<SearchResult> = results.into_iter().map(|(r, _)| r).collect();
+            build_files_only_with_budget(&paths, opts.budget)
+        }
+        OutputMode::Text => {
+            render_text_with_budget(displays(results), total, opts.budget, opts.color)
+        }
+        OutputMode::Json => render_json_with_budget(
+            displays(results),
+            total,
+            pattern,
+            opts.budget,
+            &stats,
+            timed_out,
+            &[],
+            |d| regex_json_value(d, &regex),
+        ),
+        OutputMode::JsonLines => {
+            render_json_lines_with_budget(displays(results), total, opts.budget, |d| {
+                regex_json_value(d, &regex)
+            })
+        }
+        OutputMode::Grep => render_grep_with_budget(displays(results), total, opts.budget),
+    };
+
+    Ok(SearchOutput {
+        formatted,
+        stats,
+        budget_exhausted,
+        results_omitted,
+        timed_out,
+        suggestions: Vec::new(),
+    })
+}
+
+/// The indexed files that may contain a match, by path: those in
+/// `opts.languages` and `opts.file_glob` holding every one of `fragments`
+/// (lowercased words each match contains, as [`required_fragments`]
+/// returns) inside some indexed term, and in a `--trigrams` index the
+/// files matching `trigrams`.
+///
+/// The lookups only narrow the search where they can't drop a match, so
+/// on an index whose terms aren't the lowercased words of the text, and
+/// without trigrams, every file is a candidate.
+pub(crate) fn candidate_files(
+    index: &Index,
+    meta: &IndexMeta,
+    searcher: &Searcher,
+    fragments: Vec<String>,
+    trigrams: &TrigramQuery,
+    opts: &SearchOptions,
+) -> Result<Vec<Candidate>, NsError> {
+    let schema = index.schema();
+    let content = content_field(&schema);
+    let path_f = path_field(&schema);
+    let lang_f = lang_field(&schema);
+    let symbols_raw_f = symbols_raw_field(&schema);
+
+    let mut clauses: Vec<(Occur, Box<dyn Query>)> = Vec::new();
+    // A stop word containing the fragment is not in the index, so a file
+    // whose only occurrence is that word would be wrongly filtered out.
+    // Stemmed terms differ from the text (`connections` is indexed as
+    // `connect`), as do folded, case-kept or whole-identifier ones, so an
+    // index with such an analyzer can't pre-filter at all.
+    let prefilter = meta.content_analyzer()?.indexes_lowercased_words();
+    let fragments = fragments
+        .into_iter()
+        .filter(|_| prefilter)
+        .filter(|f| !meta.stop_words.iter().any(|w| w.contains(f.as_str())));
+    for fragment in fragments {
+        let term_pattern = format!(".*{}.*", fragment);
+        clauses.push((Occur::Must, Box::new(RegexQuery::from_pattern(&term_pattern, content)?)));
+    }
+    if meta.trigrams {
+        if let Some(trigram_query) = trigrams.to_query(trigrams_field(&schema)) {
+            clauses.push((Occur::Must, trigram_query));
+        }
+    }
+    if let Some(lang_query) = language_filter(lang_f, &opts.languages) {
+        clauses.push((Occur::Must, lang_query));
+    }
+    let query: Box<dyn Query> = if clauses.is_empty() {
+        Box::new(AllQuery)
+    } else {
+        Box::new(BooleanQuery::new(clauses))
+    };
+    let glob = opts.file_glob.as_deref().map(glob::Pattern::new).transpose()?;
+
+    let mut candidates = Vec::new();
+    for doc_address in searcher.search(&query, &DocSetCollector)? {
+        let doc: TantivyDocument = searcher.doc(doc_address)?;
+        let path = doc
+            .get_first(path_f)
+            .and_then(|v| v.as_str())
+            .unwrap_or("")
+            .to_string();
+        if glob.as_ref().is_some_and(|g| !g.matches(&path)) {
+            continue;
+        }
+        let lang = doc
+            .get_first(lang_f)
+            .and_then(|v| v.as_str())
+            .map(|s| s.to_string())
+            .filter(|s| !s.is_empty());
+        let symbols_raw: Vec<String> = doc
+            .get_first(symbols_raw_f)
+            .and_then(|v| v.as_str())
+            .filter(|s| !s.is_empty())
+            .map(|s| s.split('|').map(|sym| sym.to_string()).collect())
+            .unwrap_or_default();
+        candidates.push(Candidate {
+            path,
+            lang,
+            symbols_raw,
+        });
+    }
+    candidates.sort_by(|a, b| a.path.cmp(&b.path));
+    Ok(candidates)
+}
+
+/// The `--json` value of a regex result, with the symbols `regex` matches.
+fn regex_json_value(d: &DisplayResult, regex: &Regex) -> serde_json::Value {
+    let mut value = format_single_json_value(d, "");
+    let symbols: Vec<&str> = d
+        .result
+        .symbols_raw
+        .iter()
+        .filter(|sym| regex.is_match(sym))
+        .map(|s| s.as_str())
+        .collect();
+    value["matched_symbols"] = serde_json::json!(symbols);
+    value
+}
+
+/// Records the 0-based indices of lines in `text` that `regex` matches.
+///
+/// Returns `false` if `deadline` passed before the whole text was scanned.
+fn scan_lines(
+    regex: &Regex,
+    text: &str,
+    deadline: Option<Instant>,
+    match_lines: &mut BTreeSet<usize>,
+) -> bool {
+    for (i, line) in text.lines().enumerate() {
+        if i % DEADLINE_CHECK_LINES == 0 && deadline.is_some_and(|d| Instant::now() >= d) {
+            return false;
+        }
+        if regex.is_match(line) {
+            match_lines.insert(i);
+        }
+    }
+    true
+}
+
+/// Builds the display form of a regex result: context around the matching
+/// lines, plus the byte range of every match within those lines.
+fn regex_display(
+    root: &Path,
+    rank: usize,
+    result: SearchResult,
+    match_lines: &BTreeSet<usize>,
+    regex: &Regex,
+    opts: &SearchOptions,
+) -> DisplayResult {
+    let text = read_to_string(root.join(&result.path)).unwrap_or_default();
+    let lines: Vec<&str> = text.lines().collect();
+    // The file may have shrunk since it was scanned.
+    let match_lines: BTreeSet<usize> = match_lines
+        .iter()
+        .copied()
+        .filter(|&i| i < lines.len())
+        .collect();
+    let snippet = opts.snippet_options();
+    let mut ctx = context_around(&lines, &match_lines, &[], &snippet, opts.max_context_lines);
+
+    let mut matches = ctx
+        .lines
+        .iter()
+        .flat_map(|line| {
+            regex.find_iter(&line.text).map(move |m| TermMatch {
+                line: line.line_number,
+                start: m.start(),
+                end: m.end(),
+                term: m.as_str().to_string(),
+            })
+        })
+        .collect();
+    truncate_long_lines(&mut ctx.lines, &mut matches, opts.max_columns);
+
+    DisplayResult {
+        rank,
+        result,
+        context_lines: ctx.lines,
+        truncated_count: ctx.truncated_count,
+        omitted_matches: ctx.omitted_matches,
+        matches,
+    }
+}
+
+/// Lowercased word fragments that every match of `pattern` must contain.
+///
+/// Taken from literal runs that are required on every path through the
+/// pattern (top-level concatenations, groups and `+`/`{n,}` repetitions; not
+/// alternations or optional parts). Each word of a run is split into the
+/// parts the `code` tokenizer would split it into, and each part is a
+/// fragment: an identifier too long to index whole, such as
+/// `AbstractSingletonProxyFactoryBeanProvider`, is only indexed by its
+/// parts, so `BeanProvider` must be looked up as `bean` and `provider`.
+///
+/// Non-ASCII words are skipped (lowercasing may not commute with slicing),
+/// as are single characters, which nearly every file holds, and parts of
+/// [`MAX_INDEXED_TOKEN_LEN`] bytes or more.
+pub(crate) fn required_fragments(pattern: &str) -> Vec<String> {
+    let Ok(hir) = regex_syntax::Parser::new().parse(pattern) else {
+        return Vec::new();
+    };
+    let mut literals = Vec::new();
+    collect_required_literals(&hir, &mut literals);
+
+    let mut fragments: Vec<String> = Vec::new();
+    for literal in literals {
+        let Ok(text) = String::from_utf8(literal) else {
+            continue;
+        };
+        for word in text.split(|c: char| !(c.is_alphanumeric() || c == '_')) {
+            if !word.is_ascii() {
+                continue;
+            }
+            for part in word_parts(word) {
+                if part.len() < 2 || part.len() >= MAX_INDEXED_TOKEN_LEN {
+                    continue;
+                }
+                let lower = part.to_ascii_lowercase();
+                if !fragments.contains(&lower) {
+                    fragments.push(lower);
+                }
+            }
+        }
+    }
+    fragments
+}
+
+/// The parts of the ASCII `word` that each lie within one part of any
+/// identifier containing it, as [`split_identifier`] splits identifiers.
+///
+/// Those are `word`'s own parts, except that a trailing capital after
+/// another is split off: in the text, a lowercase letter may follow it and
+/// start a new part there, as `HTTPS` lies across `HTTPServer`'s `HTTP`
+/// and `Server`.
+fn word_parts(word: &str) -> Vec<&str> {
+    let bytes = word.as_bytes();
+    let mut parts = split_identifier(word);
+    if let Some(last) = parts.last_mut() {
+        let (from, to) = *last;
+        let trailing_capitals = bytes[from..to]
+            .iter()
+            .rev()
+            .take_while(|b| b.is_ascii_uppercase())
+            .count();
+        if trailing_capitals >= 2 {
+            last.1 = to - 1;
+            parts.push((to - 1, to));
+        }
+    }
+    parts.into_iter().map(|(from, to)| &word[from..to]).collect()
+}
+
+/// Collects the literal byte runs that every match of `hir` contains.
+fn collect_required_literals(hir: &Hir, out: &mut Vec<Vec<u8>>) {
+    match hir.kind() {
+        HirKind::Literal(lit) => out.push(lit.0.to_vec()),
+        HirKind::Capture(cap) => collect_required_literals(&cap.sub, out),
+        HirKind::Repetition(rep) if rep.min > 0 => collect_required_literals(&rep.sub, out),
+        HirKind::Concat(subs) => {
+            // Adjacent literals form one run; anything else ends the run.
+            let mut run = Vec::new();
+            for sub in subs {
+                if let HirKind::Literal(lit) = sub.kind() {
+                    run.extend_from_slice(&lit.0);
+                    continue;
+                }
+                if !run.is_empty() {
+                    out.push(std::mem::take(&mut run));
+                }
+                collect_required_literals(sub, out);
+            }
+            if !run.is_empty() {
+                out.push(run);
+            }
+        }
+        _ => {}
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn fragments_from_plain_literal() {
+        assert_eq!(required_fragments(r"http\.Status\w+"), vec!["http", "status"]);
+        assert_eq!(required_fragments("fn main"), vec!["fn", "main"]);
+    }
+
+    #[test]
+    fn fragments_skip_optional_and_alternated_parts() {
+        assert_eq!(required_fragments("foo(bar)?baz"), vec!["foo", "baz"]);
+        assert!(required_fragments("foo|bar").is_empty());
+        assert_eq!(required_fragments("(?:qux)+ end"), vec!["qux", "end"]);
+    }
+
+    #[test]
+    fn fragments_from_hex_pattern() {
+        // `0x1f` is indexed as `0`, `x`, `1`, `f`: no part worth looking up.
+        assert!(required_fragments("0x[0-9a-f]+").is_empty());
+        assert!(required_fragments("[0-9]+").is_empty());
+    }
+
+    #[test]
+    fn fragments_are_identifier_parts() {
+        // Inside an identifier too long to index whole, only parts are terms.
+        assert_eq!(required_fragments("BeanProvider"), vec!["bean", "provider"]);
+        assert_eq!(required_fragments("read_timeout_ms"), vec!["read", "timeout", "ms"]);
+        assert_eq!(required_fragments("eadTim"), vec!["ead", "tim"]);
+        // The letter after `HTTPS` may start a part: `HTTPServer`.
+        assert_eq!(required_fragments("getHTTPS"), vec!["get", "http"]);
+        assert_eq!(required_fragments("utf8Decoder"), vec!["utf", "decoder"]);
+    }
+
+    #[test]
+    fn fragments_skip_case_insensitive_and_long_literals() {
+        // (?i) literals become classes — no fragment, which is still correct.
+        assert!(required_fragments("(?i)status").is_empty());
+        let long = "a".repeat(MAX_INDEXED_TOKEN_LEN);
+        assert!(required_fragments(&long).is_empty());
+    }
+
+    #[test]
+    fn scan_lines_respects_deadline() {
+        let regex = Regex::new("x").unwrap();
+        let mut lines = BTreeSet::new();
+        let past = Instant::now() - std::time::Duration::from_secs(1);
+        assert!(!scan_lines(&regex, "x\nx\n", Some(past), &mut lines));
+        assert!(lines.is_empty());
+
+        assert!(scan_lines(&regex, "a\nx\nb\nx", None, &mut lines));
+        assert_eq!(lines.into_iter().collect::<Vec<_>>(), vec![1, 3]);
+    }
+}
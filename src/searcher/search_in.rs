@@ -0,0 +1,204 @@
+use std::collections::HashSet;
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+use std::time::Instant;
+
+use tantivy::{Index, TantivyDocument};
+
+use crate::error::NsError;
+use crate::indexer::classes::{split_classes, TokenClass};
+use crate::indexer::language::detect_language_with_content;
+use crate::indexer::manifest::mtime_to_ns;
+use crate::indexer::simhash::simhash;
+use crate::indexer::symbols::extract_symbols;
+use crate::indexer::walker::is_text;
+use crate::indexer::writer::{register_tokenizers, IndexMeta, SCHEMA_VERSION};
+use crate::indexer::IndexOptions;
+use crate::schema::{
+    build_schema, content_cased_field, content_field, lang_field, mtime_field, path_field,
+    path_text_field, simhash_field, symbols_field, symbols_raw_field, token_class_field,
+};
+use crate::searcher::query::{
+    build_index_queries, load_result, search_page, SearchOptions, SearchResult, SearchStats,
+    MAX_RESULTS_CEILING,
+};
+
+/// A path passed to [`search_in`] that could not be searched.
+#[derive(Debug)]
+pub struct PathError {
+    /// The path, as given.
+    pub path: PathBuf,
+    /// Why it was left out: an I/O error, or `InvalidData` for a file
+    /// that isn't text.
+    pub error: NsError,
+}
+
+/// Ranks `paths` against `query_str` without consulting `.ns/`: the files
+/// are read, tokenized and scored on the fly in an in-memory index.
+///
+/// Analysis matches a default `ns index` build (code tokenizer, default
+/// stop words, no stemming; case kept as well with `opts.case_sensitive`)
+/// and ranking is the same BM25 with symbol boost, so results agree with
+/// a search of the same files in a full index. Document frequencies come
+/// from `paths` alone, so scores are only comparable within one call.
+/// Mtimes are read with the files, so every `SortMode` works, and Go files
+/// are split by token class when `opts.token_classes` asks for it.
+///
+/// Result paths are the given paths as strings. Paths that can't be read
+/// or aren't text are returned in the `Vec<PathError>` rather than failing
+/// the search; so are duplicates after their first occurrence.
+pub fn search_in(
+    paths: &[PathBuf],
+    query_str: &str,
+    opts: &SearchOptions,
+) -> Result<(Vec<SearchResult>, SearchStats, Vec<PathError>), NsError> {
+    let start = Instant::now();
+    let max_results = opts.max_results.min(MAX_RESULTS_CEILING);
+    let glob = opts.file_glob.as_deref().map(glob::Pattern::new).transpose()?;
+    let index_opts = IndexOptions {
+        case_sensitive: opts.case_sensitive,
+        ..Default::default()
+    };
+
+    let schema = build_schema();
+    let index = Index::create_in_ram(schema.clone());
+    let analyzer = index_opts.content_analyzer();
+    register_tokenizers(&index, &index_opts.stop_words, &analyzer);
+    let mut writer = index.writer(15_000_000)?;
+
+    let by_class = !opts.token_classes.is_all();
+    let mut errors = Vec::new();
+    let mut seen = HashSet::new();
+    for path in paths {
+        if !seen.insert(path) {
+            errors.push(PathError {
+                path: path.clone(),
+                error: NsError::Io(io::Error::new(io::ErrorKind::AlreadyExists, "duplicate path")),
+            });
+            continue;
+        }
+        match file_document(&schema, path, index_opts.case_sensitive, by_class) {
+            Ok(doc) => {
+                writer.add_document(doc)?;
+            }
+            Err(error) => errors.push(PathError {
+                path: path.clone(),
+                error,
+            }),
+        }
+    }
+    writer.commit()?;
+
+    let meta = IndexMeta {
+        schema_version: SCHEMA_VERSION,
+        indexed_at: String::new(),
+        git_commit: None,
+        file_count: paths.len() - errors.len(),
+        index_size_bytes: 0,
+        ignore_patterns: Vec::new(),
+        stop_words: index_opts.stop_words.clone(),
+        stemmer: None,
+        analyzer: Some(analyzer.spec()),
+        definitions: false,
+        case_sensitive: index_opts.case_sensitive,
+        skip_binary: index_opts.skip_binary,
+        track_lines: false,
+        track_offsets: false,
+        match_paths: index_opts.match_paths,
+        track_mtime: true,
+        token_classes: true,
+        trigrams: false,
+        fields: Vec::new(),
+        generation: 0,
+    };
+    let reader = index.reader()?;
+    let searcher = reader.searcher();
+    let queries = build_index_queries(&index, &meta, &searcher, query_str, opts)?;
+
+    let page = search_page(&searcher, &queries, &searcher, glob.as_ref(), opts.offset, max_results)?;
+    let results = page
+        .hits
+        .into_iter()
+        .map(|hit| load_result(&searcher, &searcher, &queries, hit))
+        .collect::<Result<Vec<_>, _>>()?;
+
+    let stats = SearchStats {
+        total_results: results.len(),
+        total_matches: page.total,
+        total_is_lower_bound: page.total_is_lower_bound,
+        files_searched: meta.file_count,
+        elapsed_ms: start.elapsed().as_millis() as u64,
+    };
+    Ok((results, stats, errors))
+}
+
+/// Reads `path` into a document with the fields a full build would give it.
+fn file_document(
+    schema: &tantivy::schema::Schema,
+    path: &Path,
+    case_sensitive: bool,
+    token_classes: bool,
+) -> Result<TantivyDocument, NsError> {
+    let raw = fs::read(path)?;
+    if !is_text(&raw, true) {
+        return Err(NsError::Io(io::Error::new(
+            io::ErrorKind::InvalidData,
+            "binary or non-UTF-8 file",
+        )));
+    }
+    let content = String::from_utf8(raw).expect("is_text checked UTF-8");
+    let lang = detect_language_with_content(path, &content);
+    let symbols = lang
+        .map(|l| extract_symbols(l, content.as_bytes()))
+        .unwrap_or_default();
+
+    let mut doc = TantivyDocument::new();
+    doc.add_text(content_field(schema), &content);
+    if case_sensitive {
+        doc.add_text(content_cased_field(schema), &content);
+    }
+    doc.add_text(symbols_field(schema), &symbols.join(" "));
+    doc.add_text(symbols_raw_field(schema), &symbols.join("|"));
+    let path_str = path.to_string_lossy();
+    doc.add_text(path_field(schema), &path_str);
+    doc.add_text(path_text_field(schema), &path_str);
+    if let Some(lang) = lang {
+        doc.add_text(lang_field(schema), lang);
+    }
+    doc.add_u64(simhash_field(schema), simhash(&content));
+    let mtime = fs::metadata(path).ok().and_then(|m| m.modified().ok());
+    doc.add_u64(mtime_field(schema), mtime_to_ns(mtime));
+    if let Some(classes) = lang.filter(|_| token_classes).and_then(|l| split_classes(l, &content)) {
+        for class in TokenClass::ALL {
+            doc.add_text(token_class_field(schema, class), classes.get(class));
+        }
+    }
+    Ok(doc)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn missing_and_binary_paths_are_reported_not_fatal() {
+        let dir = tempfile::tempdir().unwrap();
+        let good = dir.path().join("good.rs");
+        let blob = dir.path().join("blob.bin");
+        fs::write(&good, "fn connect() {}\n").unwrap();
+        fs::write(&blob, b"\x00\x01").unwrap();
+        let missing = dir.path().join("missing.rs");
+
+        let paths = vec![good.clone(), missing.clone(), blob.clone(), good.clone()];
+        let (results, stats, errors) =
+            search_in(&paths, "connect", &SearchOptions::default()).unwrap();
+
+        assert_eq!(results.len(), 1);
+        assert_eq!(results[0].path, good.to_string_lossy());
+        assert_eq!(stats.files_searched, 1);
+        let failed: Vec<&PathBuf> = errors.iter().map(|e| &e.path).collect();
+        assert_eq!(failed, vec![&missing, &blob, &good]);
+        assert!(matches!(&errors[0].error, NsError::Io(e) if e.kind() == io::ErrorKind::NotFound));
+    }
+}
@@ -2,7 +2,8 @@ use std::path::Path;
 
 use tree_sitter::{Node, Parser};
 
-use crate::indexer::language::detect_language;
+use crate::indexer::gzip::{logical_path, read_to_string};
+use crate::indexer::language::detect_language_with_content;
 use crate::searcher::context::{tokenize_query, ContextLine, ContextResult};
 
 /// A candidate span from the AST (or a fallback fixed window).
@@ -49,10 +50,11 @@ pub fn extract_best_spans(
     let empty = ContextResult {
         lines: Vec::new(),
         truncated_count: 0,
+        omitted_matches: 0,
     };
 
     let full_path = root.join(rel_path);
-    let content = match std::fs::read_to_string(&full_path) {
+    let content = match read_to_string(&full_path) {
         Ok(s) => s,
         Err(_) => return empty,
     };
@@ -76,7 +78,8 @@ pub fn extract_best_spans(
     };
 
     // Phase 1: extract candidates
-    let lang = detect_language(Path::new(rel_path)).unwrap_or("");
+    let lang = detect_language_with_content(&logical_path(Path::new(rel_path)), &content)
+        .unwrap_or("");
     let candidates = extract_span_candidates(lang, source, total_lines);
     if candidates.is_empty() {
         return empty;
@@ -708,6 +711,7 @@ fn build_context_result(
     ContextResult {
         lines: context_lines,
         truncated_count,
+        omitted_matches: 0,
     }
 }
 
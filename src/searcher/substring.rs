@@ -0,0 +1,128 @@
+use std::ops::Range;
+use std::path::Path;
+use std::time::Instant;
+
+use regex::bytes::{Regex, RegexBuilder};
+
+use super::query::{create_reader_with_retry, SearchOptions, SearchStats, Weights};
+use super::regex_search::{candidate_files, required_fragments};
+use crate::error::NsError;
+use crate::indexer::gzip;
+use crate::indexer::trigrams::required_trigrams;
+use crate::indexer::writer::open_index;
+
+/// A file containing the substring searched for, from [`search_substring`].
+#[derive(Debug, Clone, PartialEq)]
+pub struct SubstringMatch {
+    /// Path relative to the repo root.
+    pub path: String,
+    pub lang: Option<String>,
+    /// Number of matches times any `opts.weights` multipliers for the path.
+    pub score: f32,
+    /// The byte range of every match in the file's content (decompressed,
+    /// for gzip files), in order and non-overlapping.
+    pub ranges: Vec<Range<usize>>,
+}
+
+/// Finds the indexed files containing `needle` verbatim, wherever it
+/// falls: `Timeout` matches `ReadTimeout` and `WriteTimeout`, and
+/// `eadTim` matches both too, though no token is either.
+///
+/// The index only narrows the search: files are candidates if their
+/// indexed terms contain every identifier part of `needle`'s words (so
+/// `BeanProvider` still finds an identifier too long to be indexed but by
+/// its parts), and in a `--trigrams` index if they hold its every trigram
+/// (see `regex_search::candidate_files`); each candidate's bytes are then
+/// scanned for the substring itself, so what the tokenizer dropped,
+/// split or folded can't hide or fake a match. Matching is exact unless
+/// `opts.ignore_case`, which folds case the way `-i` does for `--regex`.
+///
+/// Files are ranked by number of matches times any `opts.weights`
+/// multipliers (the result `score`), then path, and paged by `opts.offset`
+/// and `opts.max_results`; `opts.languages` and `opts.file_glob` filter
+/// them. An empty `needle` matches nothing.
+pub fn search_substring(
+    root: &Path,
+    needle: &str,
+    opts: &SearchOptions,
+) -> Result<(Vec<SubstringMatch>, SearchStats), NsError> {
+    let start = Instant::now();
+    let escaped = regex::escape(needle);
+    let matcher = RegexBuilder::new(&escaped)
+        .case_insensitive(opts.ignore_case)
+        .build()?;
+    let weights = Weights::new(&opts.weights)?;
+
+    let (index, meta) = open_index(root)?;
+    let mut matched = Vec::new();
+    if !needle.is_empty() {
+        let reader = create_reader_with_retry(&index, root)?;
+        let searcher = reader.searcher();
+        let fragments = required_fragments(&escaped);
+        let trigrams = required_trigrams(&escaped, opts.ignore_case);
+        for candidate in candidate_files(&index, &meta, &searcher, fragments, &trigrams, opts)? {
+            opts.cancel.check()?;
+            let Ok(content) = gzip::read(root.join(&candidate.path)) else {
+                continue; // deleted or unreadable since indexing
+            };
+            let ranges = find_ranges(&matcher, &content);
+            if !ranges.is_empty() {
+                matched.push(SubstringMatch {
+                    score: ranges.len() as f32 * weights.multiplier(&candidate.path),
+                    path: candidate.path,
+                    lang: candidate.lang,
+                    ranges,
+                });
+            }
+        }
+    }
+
+    matched.sort_by(|a, b| b.score.total_cmp(&a.score).then_with(|| a.path.cmp(&b.path)));
+    let total_matches = matched.len();
+    matched.drain(..opts.offset.min(total_matches));
+    matched.truncate(opts.max_results.min(super::query::MAX_RESULTS_CEILING));
+
+    let stats = SearchStats {
+        total_results: matched.len(),
+        total_matches,
+        total_is_lower_bound: false,
+        files_searched: meta.file_count,
+        elapsed_ms: start.elapsed().as_millis() as u64,
+    };
+    Ok((matched, stats))
+}
+
+/// The byte ranges of the matches of `matcher` in `content`.
+fn find_ranges(matcher: &Regex, content: &[u8]) -> Vec<Range<usize>> {
+    matcher.find_iter(content).map(|m| m.range()).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn ranges(needle: &str, ignore_case: bool, content: &str) -> Vec<Range<usize>> {
+        let matcher = RegexBuilder::new(&regex::escape(needle))
+            .case_insensitive(ignore_case)
+            .build()
+            .unwrap();
+        find_ranges(&matcher, content.as_bytes())
+    }
+
+    #[test]
+    fn substrings_match_inside_tokens_and_across_punctuation() {
+        let content = "ReadTimeout, WriteTimeout; timeout";
+        assert_eq!(ranges("Timeout", false, content), vec![4..11, 18..25]);
+        assert_eq!(ranges("Timeout", true, content), vec![4..11, 18..25, 27..34]);
+        assert_eq!(ranges("t, W", false, content), vec![10..14]);
+        assert_eq!(ranges("a.b(", false, "a.b( axb("), vec![0..4]);
+    }
+
+    #[test]
+    fn ranges_are_bytes_into_multi_byte_text() {
+        let content = "ключ: Größe, GRÖSSE";
+        assert_eq!(ranges("Größe", false, content), vec![10..17]);
+        assert_eq!(ranges("größe", true, content), vec![10..17]);
+        assert_eq!(ranges("aa", false, "aaaa"), vec![0..2, 2..4]);
+    }
+}
@@ -0,0 +1,343 @@
+use std::collections::{BTreeMap, BTreeSet, HashMap};
+use std::path::Path;
+
+use tantivy::schema::Field;
+use tantivy::Searcher;
+
+use crate::error::NsError;
+use crate::indexer::writer::open_index;
+use crate::schema::content_field;
+use crate::searcher::query::create_reader_with_retry;
+use crate::searcher::query_ast::{
+    has_boolean_keywords, is_bare_word, positive_text, unquoted_words,
+};
+
+/// Most corrected queries [`did_you_mean`] returns.
+pub const MAX_CORRECTIONS: usize = 3;
+
+/// Most indexed terms the last word of a `SearchOptions::prefix_last_term`
+/// query expands to.
+pub const MAX_PREFIX_EXPANSIONS: usize = 50;
+
+/// Query words shorter than this are never corrected: too many terms are
+/// an edit away.
+const MIN_CORRECTED_LEN: usize = 3;
+
+/// A completion for a typed prefix.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Suggestion {
+    /// The indexed term (lowercase, and stemmed in a `--stem` index).
+    pub term: String,
+    /// Number of files containing the term.
+    pub doc_freq: u64,
+}
+
+/// Suggests up to `limit` indexed `content` terms starting with `prefix`,
+/// most frequent first (ties by term), for type-ahead search.
+///
+/// Each segment's term dictionary is an FST, so only the terms inside the
+/// `[prefix, successor)` byte range are visited — cost grows with the
+/// number of completions, not the dictionary size. An empty prefix visits
+/// every term and returns the most frequent ones.
+///
+/// Indexed terms are lowercase: with `ignore_case` the prefix is lowercased
+/// first, otherwise it is matched as written, so `Serv` matches nothing.
+/// Frequencies still count deleted files until their segments merge.
+pub fn suggest(
+    root: &Path,
+    prefix: &str,
+    limit: usize,
+    ignore_case: bool,
+) -> Result<Vec<Suggestion>, NsError> {
+    let (index, _meta) = open_index(root)?;
+    let content = content_field(&index.schema());
+    let reader = create_reader_with_retry(&index, root)?;
+    let searcher = reader.searcher();
+
+    let prefix = if ignore_case {
+        prefix.to_lowercase()
+    } else {
+        prefix.to_string()
+    };
+    let upper = prefix_successor(prefix.as_bytes());
+
+    let mut freqs: BTreeMap<Vec<u8>, u64> = BTreeMap::new();
+    for segment in searcher.segment_readers() {
+        let inverted = segment.inverted_index(content)?;
+        let mut range = inverted.terms().range().ge(prefix.as_bytes());
+        if let Some(ref upper) = upper {
+            range = range.lt(upper);
+        }
+        let mut terms = range.into_stream()?;
+        while terms.advance() {
+            *freqs.entry(terms.key().to_vec()).or_insert(0) += terms.value().doc_freq as u64;
+        }
+    }
+
+    let mut suggestions: Vec<Suggestion> = freqs
+        .into_iter()
+        .map(|(term, doc_freq)| Suggestion {
+            term: String::from_utf8_lossy(&term).into_owned(),
+            doc_freq,
+        })
+        .collect();
+    suggestions.sort_by(|a, b| b.doc_freq.cmp(&a.doc_freq).then_with(|| a.term.cmp(&b.term)));
+    suggestions.truncate(limit);
+    Ok(suggestions)
+}
+
+/// `query_str` with its last word replaced by the word or any `field` term
+/// it is a prefix of, for search as you type: `graceful shut` becomes
+/// `graceful shut shutdown shutter`, or `graceful AND (shut OR shutdown OR
+/// shutter)` in boolean syntax. Earlier words stay exact.
+///
+/// Takes the first [`MAX_PREFIX_EXPANSIONS`] terms in byte order, which
+/// puts a word before its longer forms (`shut`, `shutdown`, `shutdowns`).
+/// Each segment's FST streams the terms of the `[prefix, successor)` range
+/// in that order, so at most that many are read per segment, however many
+/// terms share the prefix.
+///
+/// The query is left as it is when it ends in whitespace (the last word
+/// was finished) or its last word isn't a bare word: a phrase, a negated
+/// or fielded term, or one with query syntax.
+pub(crate) fn expand_last_term(
+    searcher: &Searcher,
+    field: Field,
+    query_str: &str,
+) -> Result<String, NsError> {
+    let Some(&(start, end)) = unquoted_words(query_str).last() else {
+        return Ok(query_str.to_string());
+    };
+    let word = &query_str[start..end];
+    let core = word.trim_start_matches('(');
+    if end < query_str.len() || !is_bare_word(core) {
+        return Ok(query_str.to_string());
+    }
+    let prefix = core.to_lowercase();
+    let upper = prefix_successor(prefix.as_bytes());
+
+    let mut terms: BTreeSet<Vec<u8>> = BTreeSet::new();
+    for segment in searcher.segment_readers() {
+        let inverted = segment.inverted_index(field)?;
+        let mut range = inverted.terms().range().ge(prefix.as_bytes());
+        if let Some(ref upper) = upper {
+            range = range.lt(upper);
+        }
+        let mut stream = range.into_stream()?;
+        let mut read = 0;
+        while read < MAX_PREFIX_EXPANSIONS && stream.advance() {
+            terms.insert(stream.key().to_vec());
+            read += 1;
+        }
+    }
+    let completions: Vec<String> = terms
+        .into_iter()
+        .take(MAX_PREFIX_EXPANSIONS)
+        .filter_map(|term| String::from_utf8(term).ok())
+        .filter(|term| *term != prefix)
+        .collect();
+    if completions.is_empty() {
+        return Ok(query_str.to_string());
+    }
+
+    let core_start = end - core.len();
+    let expanded = if has_boolean_keywords(query_str) {
+        format!("({} OR {})", core, completions.join(" OR "))
+    } else {
+        format!("{} {}", core, completions.join(" "))
+    };
+    Ok(format!("{}{}", &query_str[..core_start], expanded))
+}
+
+/// Corrected versions of `query_str` for a search that found nothing,
+/// best first, at most [`MAX_CORRECTIONS`].
+///
+/// Each query word missing from the `content` term dictionary (after the
+/// index's analyzer filters, such as stemming; stop words and short words
+/// are left alone) is
+/// replaced by the closest indexed term: fewest edits — insertions,
+/// deletions, substitutions and swaps of adjacent characters, up to 1 for
+/// words of 4 characters or fewer and 2 for longer ones — then most
+/// files. The first misspelt word with any candidate varies over its best
+/// few, giving one query each; other misspelt words take their best. So
+/// `connect serevr` yields `connect server`. Words with no candidate, and
+/// the query's operators and filters, are kept as written.
+///
+/// Empty when every word is indexed (the query found nothing for another
+/// reason). Candidates come from one pass over the term dictionary, so
+/// call this only once a search has come back empty. In a `--stem` index
+/// the suggested words are stems, as with [`suggest`].
+pub fn did_you_mean(root: &Path, query_str: &str) -> Result<Vec<String>, NsError> {
+    let (index, meta) = open_index(root)?;
+    let mut normalizer = meta.content_analyzer()?.normalizer(&meta.stop_words);
+    let content = content_field(&index.schema());
+    let reader = create_reader_with_retry(&index, root)?;
+    let searcher = reader.searcher();
+    let inverted = searcher
+        .segment_readers()
+        .iter()
+        .map(|segment| segment.inverted_index(content))
+        .collect::<Result<Vec<_>, _>>()?;
+
+    let positive = positive_text(query_str);
+    let mut misspelt: Vec<(&str, String)> = Vec::new();
+    for word in positive.split(|c: char| !c.is_alphanumeric() && c != '_') {
+        let lower = word.to_lowercase();
+        if lower.chars().count() < MIN_CORRECTED_LEN
+            || meta.stop_words.contains(&lower)
+            || misspelt.iter().any(|(w, _)| *w == word)
+        {
+            continue;
+        }
+        let Some(term) = normalizer.normalize(word) else {
+            continue;
+        };
+        let mut indexed = false;
+        for segment in &inverted {
+            indexed |= segment.terms().get(&term)?.is_some();
+        }
+        if !indexed {
+            misspelt.push((word, lower));
+        }
+    }
+    if misspelt.is_empty() {
+        return Ok(Vec::new());
+    }
+
+    // (edits, files) for each candidate term of each misspelt word.
+    let mut candidates: Vec<HashMap<String, (usize, u64)>> = vec![HashMap::new(); misspelt.len()];
+    for segment in &inverted {
+        let mut terms = segment.terms().stream()?;
+        while terms.advance() {
+            let Ok(term) = std::str::from_utf8(terms.key()) else {
+                continue;
+            };
+            for ((_, lower), found) in misspelt.iter().zip(candidates.iter_mut()) {
+                if let Some(edits) = within_edits(lower, term) {
+                    let entry = found.entry(term.to_string()).or_insert((edits, 0));
+                    entry.1 += terms.value().doc_freq as u64;
+                }
+            }
+        }
+    }
+    let ranked: Vec<Vec<String>> = candidates
+        .into_iter()
+        .map(|found| {
+            let mut found: Vec<(String, (usize, u64))> = found.into_iter().collect();
+            found.sort_by(|(a, (a_edits, a_freq)), (b, (b_edits, b_freq))| {
+                a_edits.cmp(b_edits).then(b_freq.cmp(a_freq)).then_with(|| a.cmp(b))
+            });
+            found.into_iter().map(|(term, _)| term).collect()
+        })
+        .collect();
+
+    let Some(varied) = ranked.iter().position(|terms| !terms.is_empty()) else {
+        return Ok(Vec::new());
+    };
+    let corrections = ranked[varied]
+        .iter()
+        .take(MAX_CORRECTIONS)
+        .map(|choice| {
+            let mut corrected = query_str.to_string();
+            for (i, ((word, _), terms)) in misspelt.iter().zip(&ranked).enumerate() {
+                let replacement = if i == varied { Some(choice) } else { terms.first() };
+                if let Some(replacement) = replacement {
+                    corrected = replace_word(&corrected, word, replacement);
+                }
+            }
+            corrected
+        })
+        .collect();
+    Ok(corrections)
+}
+
+/// The edit distance between `word` and `term` if it is within the
+/// allowance for `word`'s length, else `None`.
+fn within_edits(word: &str, term: &str) -> Option<usize> {
+    let len = word.chars().count();
+    let max = if len <= 4 { 1 } else { 2 };
+    if len.abs_diff(term.chars().count()) > max {
+        return None;
+    }
+    let edits = edit_distance(word, term);
+    (edits <= max).then_some(edits)
+}
+
+/// Optimal string alignment distance: Levenshtein distance where swapping
+/// two adjacent characters (`serevr` → `server`) counts as one edit.
+fn edit_distance(a: &str, b: &str) -> usize {
+    let a: Vec<char> = a.chars().collect();
+    let b: Vec<char> = b.chars().collect();
+    // Rows i-2, i-1 and i of the distance matrix.
+    let mut before: Vec<usize> = Vec::new();
+    let mut prev: Vec<usize> = (0..=b.len()).collect();
+    for i in 1..=a.len() {
+        let mut row = vec![i; b.len() + 1];
+        for j in 1..=b.len() {
+            let cost = usize::from(a[i - 1] != b[j - 1]);
+            row[j] = (prev[j] + 1).min(row[j - 1] + 1).min(prev[j - 1] + cost);
+            if i > 1 && j > 1 && a[i - 1] == b[j - 2] && a[i - 2] == b[j - 1] {
+                row[j] = row[j].min(before[j - 2] + 1);
+            }
+        }
+        before = std::mem::replace(&mut prev, row);
+    }
+    prev[b.len()]
+}
+
+/// Replaces the first whole-word occurrence of `word` in `text`.
+fn replace_word(text: &str, word: &str, replacement: &str) -> String {
+    let is_word_char = |c: char| c.is_alphanumeric() || c == '_';
+    for (start, _) in text.match_indices(word) {
+        let end = start + word.len();
+        let bounded_before = !text[..start].chars().next_back().is_some_and(is_word_char);
+        let bounded_after = !text[end..].chars().next().is_some_and(is_word_char);
+        if bounded_before && bounded_after {
+            return format!("{}{}{}", &text[..start], replacement, &text[end..]);
+        }
+    }
+    text.to_string()
+}
+
+/// The smallest byte string greater than every string starting with
+/// `prefix`, or `None` when there is none (empty or all-`0xFF` prefix).
+fn prefix_successor(prefix: &[u8]) -> Option<Vec<u8>> {
+    let mut upper = prefix.to_vec();
+    while let Some(last) = upper.pop() {
+        if last < u8::MAX {
+            upper.push(last + 1);
+            return Some(upper);
+        }
+    }
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn swaps_count_as_one_edit() {
+        assert_eq!(edit_distance("serevr", "server"), 1);
+        assert_eq!(edit_distance("sever", "server"), 1);
+        assert_eq!(edit_distance("kitten", "sitting"), 3);
+        assert_eq!(edit_distance("", "abc"), 3);
+        assert_eq!(within_edits("srv", "server"), None);
+        assert_eq!(within_edits("hadnler", "handler"), Some(1));
+    }
+
+    #[test]
+    fn replaces_whole_words_only() {
+        assert_eq!(replace_word("serv OR serv.x", "serv", "server"), "server OR serv.x");
+        assert_eq!(replace_word("preserv serv", "serv", "server"), "preserv server");
+        assert_eq!(replace_word("lang:go serevr", "serevr", "server"), "lang:go server");
+    }
+
+    #[test]
+    fn successor_bounds_the_prefix_range() {
+        assert_eq!(prefix_successor(b"serv"), Some(b"serw".to_vec()));
+        assert_eq!(prefix_successor(b"a\xff"), Some(b"b".to_vec()));
+        assert_eq!(prefix_successor(b"\xff\xff"), None);
+        assert_eq!(prefix_successor(b""), None);
+    }
+}
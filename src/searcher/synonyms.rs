@@ -0,0 +1,203 @@
+use std::collections::{BTreeMap, BTreeSet};
+
+use super::query_ast::{has_boolean_keywords, is_bare_word, unquoted_words};
+
+/// Default [`SynonymMap`] weight: a synonym's matches count half as much
+/// as those of the term written in the query.
+pub const DEFAULT_SYNONYM_WEIGHT: f32 = 0.5;
+
+/// Words that mean the same thing in a codebase (`cfg` and `config`,
+/// `auth` and `authentication`), consulted at query time: each query term
+/// with synonyms also matches files holding only one of them. The index
+/// is untouched, so a map can change between searches without a rebuild.
+///
+/// Entries are single words (letters, digits and `_`), looked up
+/// case-insensitively; others are ignored, as no query term could name
+/// them. Matches of a synonym are scored at `weight` times those of the
+/// term itself, so files using the word from the query still rank first.
+#[derive(Debug, Clone, PartialEq)]
+pub struct SynonymMap {
+    synonyms: BTreeMap<String, BTreeSet<String>>,
+    weight: f32,
+}
+
+impl Default for SynonymMap {
+    fn default() -> Self {
+        Self::new(DEFAULT_SYNONYM_WEIGHT)
+    }
+}
+
+impl SynonymMap {
+    /// An empty map whose synonyms score at `weight` (0 makes them match
+    /// without adding to the score).
+    pub fn new(weight: f32) -> Self {
+        Self {
+            synonyms: BTreeMap::new(),
+            weight: weight.max(0.0),
+        }
+    }
+
+    /// Makes `words` synonyms of each other: a query for any of them also
+    /// matches the rest.
+    pub fn add_equivalent(&mut self, words: &[&str]) {
+        for &word in words {
+            self.add_one_way(word, words);
+        }
+    }
+
+    /// Makes a query for `word` also match `synonyms`, but not the other
+    /// way round: `db` can expand to `postgres` without every `postgres`
+    /// query matching `db`.
+    pub fn add_one_way(&mut self, word: &str, synonyms: &[&str]) {
+        let key = word.to_lowercase();
+        if !is_bare_word(&key) {
+            return;
+        }
+        let targets: Vec<String> = synonyms
+            .iter()
+            .map(|s| s.to_lowercase())
+            .filter(|s| is_bare_word(s) && *s != key)
+            .collect();
+        self.synonyms.entry(key).or_default().extend(targets);
+    }
+
+    /// Whether the map has no synonyms, so queries are left as written.
+    pub fn is_empty(&self) -> bool {
+        self.synonyms.values().all(BTreeSet::is_empty)
+    }
+
+    /// The synonyms a query for `word` also matches.
+    pub fn synonyms(&self, word: &str) -> impl Iterator<Item = &str> {
+        self.synonyms
+            .get(&word.to_lowercase())
+            .into_iter()
+            .flatten()
+            .map(String::as_str)
+    }
+
+    /// `query` with each term that has synonyms replaced by the term or
+    /// any of them, each synonym boosted by `weight`: `config timeout`
+    /// becomes `config cfg^0.5 timeout`, and in boolean syntax
+    /// `config AND timeout` becomes `(config OR cfg^0.5) AND timeout`.
+    ///
+    /// Only bare words are expanded: phrases, negated terms (`-config`),
+    /// fielded terms (`symbols:Config`) and words with query syntax
+    /// (`conf*`, `config^2`) are left alone, as are synonyms the query
+    /// already names or an earlier term brought in.
+    pub(crate) fn expand_query(&self, query: &str) -> String {
+        if self.is_empty() {
+            return query.to_string();
+        }
+        let words = unquoted_words(query);
+        let written: BTreeSet<String> = words
+            .iter()
+            .map(|&(start, end)| query[start..end].trim_matches(['(', ')']).to_lowercase())
+            .collect();
+        let mut added: BTreeSet<&str> = BTreeSet::new();
+        let boolean = has_boolean_keywords(query);
+
+        let mut out = String::with_capacity(query.len());
+        let mut copied = 0;
+        for (start, end) in words {
+            let word = &query[start..end];
+            let core = word.trim_start_matches('(').trim_end_matches(')');
+            if !is_bare_word(core) {
+                continue;
+            }
+            let extra: Vec<String> = self
+                .synonyms(core)
+                .filter(|s| !written.contains(*s) && added.insert(s))
+                .map(|s| format!("{}^{}", s, self.weight))
+                .collect();
+            if extra.is_empty() {
+                continue;
+            }
+            let core_start = start + (word.len() - word.trim_start_matches('(').len());
+            out.push_str(&query[copied..core_start]);
+            if boolean {
+                out.push_str(&format!("({} OR {})", core, extra.join(" OR ")));
+            } else {
+                out.push_str(&format!("{} {}", core, extra.join(" ")));
+            }
+            copied = core_start + core.len();
+        }
+        out.push_str(&query[copied..]);
+        out
+    }
+
+    /// The synonyms of `terms` that aren't among them, for highlighting
+    /// what an expanded query matched.
+    pub(crate) fn expansions(&self, terms: &[String]) -> Vec<String> {
+        let mut extra: Vec<String> = Vec::new();
+        for term in terms {
+            for synonym in self.synonyms(term) {
+                if !terms.iter().any(|t| t == synonym) && !extra.iter().any(|t| t == synonym) {
+                    extra.push(synonym.to_string());
+                }
+            }
+        }
+        extra
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn map() -> SynonymMap {
+        let mut map = SynonymMap::default();
+        map.add_equivalent(&["config", "cfg", "conf"]);
+        map.add_one_way("db", &["postgres"]);
+        map
+    }
+
+    #[test]
+    fn equivalent_words_expand_both_ways_and_one_way_entries_do_not() {
+        let map = map();
+        assert_eq!(
+            map.synonyms("CFG").collect::<Vec<_>>(),
+            vec!["conf", "config"]
+        );
+        assert_eq!(map.synonyms("db").collect::<Vec<_>>(), vec!["postgres"]);
+        assert_eq!(map.synonyms("postgres").count(), 0);
+        assert!(SynonymMap::default().is_empty());
+
+        let mut ignored = SynonymMap::default();
+        ignored.add_one_way("std::io", &["io"]);
+        ignored.add_equivalent(&["solo"]);
+        assert!(ignored.is_empty());
+    }
+
+    #[test]
+    fn queries_expand_bare_words_only() {
+        let map = map();
+        assert_eq!(
+            map.expand_query("config timeout"),
+            "config cfg^0.5 conf^0.5 timeout"
+        );
+        assert_eq!(
+            map.expand_query("db AND (timeout OR config)"),
+            "(db OR postgres^0.5) AND (timeout OR (config OR cfg^0.5 OR conf^0.5))"
+        );
+        assert_eq!(map.expand_query("config cfg"), "config conf^0.5 cfg");
+        for untouched in [
+            "-config",
+            "\"read config\"",
+            "symbols:config",
+            "conf*",
+            "db^2",
+        ] {
+            assert_eq!(map.expand_query(untouched), untouched);
+        }
+        assert_eq!(SynonymMap::default().expand_query("config"), "config");
+        let mut weighted = SynonymMap::new(0.25);
+        weighted.add_equivalent(&["auth", "authentication"]);
+        assert_eq!(weighted.expand_query("Auth"), "Auth authentication^0.25");
+    }
+
+    #[test]
+    fn expansions_add_unwritten_synonyms() {
+        let terms = vec!["cfg".to_string(), "config".to_string()];
+        assert_eq!(map().expansions(&terms), vec!["conf"]);
+    }
+}
@@ -190,14 +190,46 @@ pub struct SearchLogFlags {
     pub file_glob: Option<String>,
     pub files_only: bool,
     pub ignore_case: bool,
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    pub case_sensitive: bool,
     pub json: bool,
     pub sym: bool,
     pub fuzzy: bool,
+    pub fuzzy_distance: Option<u8>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub filename_boost: Option<f32>,
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub weight: Vec<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub proximity_boost: Option<f32>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub proximity_window: Option<usize>,
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    pub early_termination: bool,
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    pub dedup: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub dedup_distance: Option<u32>,
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    pub explain: bool,
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    pub positions: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub sort: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub token_classes: Option<String>,
     pub max_count: usize,
+    pub offset: usize,
     pub context: usize,
+    pub before_context: Option<usize>,
+    pub after_context: Option<usize>,
+    pub max_snippets: usize,
+    pub max_per_file: usize,
+    pub max_columns: usize,
     pub max_context_lines: usize,
     pub budget: Option<usize>,
     pub spans: bool,
+    pub regex: bool,
 }
 
 #[derive(Serialize)]
@@ -323,14 +355,34 @@ mod tests {
                 file_glob: None,
                 files_only: false,
                 ignore_case: false,
+                case_sensitive: false,
                 json: false,
                 sym: false,
                 fuzzy: false,
+                fuzzy_distance: None,
+                filename_boost: None,
+                weight: Vec::new(),
+                proximity_boost: None,
+                proximity_window: None,
+                early_termination: false,
+                dedup: false,
+                dedup_distance: None,
+                explain: false,
+                positions: false,
+                sort: None,
+                token_classes: None,
                 max_count: 10,
+                offset: 0,
                 context: 1,
+                before_context: None,
+                after_context: None,
+                max_snippets: 0,
+                max_per_file: 0,
+                max_columns: 300,
                 max_context_lines: 30,
                 budget: None,
                 spans: false,
+                regex: false,
             },
             argv: vec!["--".to_string(), "EventStore".to_string()],
             error: None,
@@ -353,14 +405,34 @@ mod tests {
                 file_glob: Some("src/*.rs".to_string()),
                 files_only: false,
                 ignore_case: true,
+                case_sensitive: false,
                 json: true,
                 sym: false,
                 fuzzy: false,
+                fuzzy_distance: None,
+                filename_boost: None,
+                weight: Vec::new(),
+                proximity_boost: None,
+                proximity_window: None,
+                early_termination: false,
+                dedup: false,
+                dedup_distance: None,
+                explain: false,
+                positions: false,
+                sort: None,
+                token_classes: None,
                 max_count: 5,
+                offset: 0,
                 context: 0,
+                before_context: None,
+                after_context: None,
+                max_snippets: 0,
+                max_per_file: 0,
+                max_columns: 300,
                 max_context_lines: 10,
                 budget: Some(500),
                 spans: false,
+                regex: false,
             },
             argv: vec![
                 "--json".to_string(),
@@ -418,14 +490,34 @@ mod tests {
                 file_glob: None,
                 files_only: false,
                 ignore_case: false,
+                case_sensitive: false,
                 json: false,
                 sym: false,
                 fuzzy: false,
+                fuzzy_distance: None,
+                filename_boost: None,
+                weight: Vec::new(),
+                proximity_boost: None,
+                proximity_window: None,
+                early_termination: false,
+                dedup: false,
+                dedup_distance: None,
+                explain: false,
+                positions: false,
+                sort: None,
+                token_classes: None,
                 max_count: 10,
+                offset: 0,
                 context: 1,
+                before_context: None,
+                after_context: None,
+                max_snippets: 0,
+                max_per_file: 0,
+                max_columns: 300,
                 max_context_lines: 30,
                 budget: None,
                 spans: false,
+                regex: false,
             },
             argv: vec!["EventStore".to_string()],
             error: Some(SearchLogError {
@@ -471,14 +563,34 @@ mod tests {
                                 file_glob: None,
                                 files_only: true,
                                 ignore_case: false,
+                                case_sensitive: false,
                                 json: false,
                                 sym: false,
                                 fuzzy: false,
+                                fuzzy_distance: None,
+                                filename_boost: None,
+                                weight: Vec::new(),
+                                proximity_boost: None,
+                                proximity_window: None,
+                                early_termination: false,
+                                dedup: false,
+                                dedup_distance: None,
+                                explain: false,
+                                positions: false,
+                                sort: None,
+                                token_classes: None,
                                 max_count: 20,
+                                offset: 0,
                                 context: 1,
+                                before_context: None,
+                                after_context: None,
+                                max_snippets: 0,
+                                max_per_file: 0,
+                                max_columns: 300,
                                 max_context_lines: 30,
                                 budget: None,
                                 spans: false,
+                                regex: false,
                             },
                             argv: vec![
                                 "-l".to_string(),
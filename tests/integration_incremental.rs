@@ -206,6 +206,157 @@ fn incremental_repeated_runs_are_idempotent_mtime() {
     }
 }
 
+// ── Manifest tests (mtime + size + content hash) ────────────────────────────
+
+#[test]
+fn full_index_writes_manifest() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let manifest = ns::indexer::manifest::read_manifest(&root).expect("manifest should exist");
+    let meta = ns::indexer::writer::read_meta(&root).expect("read meta");
+    assert_eq!(manifest.files.len(), meta.file_count);
+    assert!(manifest.files.contains_key("src/event_store.rs"));
+}
+
+#[test]
+fn incremental_skips_touched_but_identical_file() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    thread::sleep(Duration::from_secs(1));
+
+    // Rewrite with identical content — mtime changes, hash does not
+    let file_path = root.join("src").join("event_store.rs");
+    let content = fs::read_to_string(&file_path).expect("should read file");
+    fs::write(&file_path, &content).expect("should rewrite file");
+
+    let stats = ns::indexer::run_incremental_index(&root, 1_048_576)
+        .expect("incremental should succeed");
+    assert_eq!(stats.modified, 0, "identical content should not be re-indexed");
+
+    // The refreshed stat is persisted, so a second run doesn't hash it again
+    let manifest = ns::indexer::manifest::read_manifest(&root).expect("manifest");
+    let file_meta = file_path.metadata().expect("stat");
+    assert!(manifest.files["src/event_store.rs"].matches_stat(&file_meta));
+}
+
+#[test]
+fn incremental_detects_rename_mtime() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    fs::rename(root.join("src").join("utils.js"), root.join("src").join("helpers.js"))
+        .expect("should rename file");
+
+    let stats = ns::indexer::run_incremental_index(&root, 1_048_576)
+        .expect("incremental should succeed");
+
+    assert_eq!(stats.renamed, 1, "should detect the rename");
+    assert_eq!(stats.added, 0, "renamed file should not count as added");
+    assert_eq!(stats.deleted, 0, "renamed file should not count as deleted");
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "debounce", &opts(10))
+        .expect("search should work");
+    assert!(results.iter().any(|r| r.path.contains("helpers.js")));
+    assert!(!results.iter().any(|r| r.path.contains("utils.js")));
+
+    let manifest = ns::indexer::manifest::read_manifest(&root).expect("manifest");
+    assert!(manifest.files.contains_key("src/helpers.js"));
+    assert!(!manifest.files.contains_key("src/utils.js"));
+}
+
+// ── Ignore rules ───────────────────────────────────────────────────────────
+
+#[test]
+fn incremental_applies_recorded_ignore_patterns() {
+    let (_tmp, root) = common::isolated_fixture();
+    let index_opts = ns::indexer::IndexOptions {
+        ignore_patterns: vec!["*.bak".to_string()],
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &index_opts).expect("indexing should succeed");
+
+    thread::sleep(Duration::from_secs(1));
+    fs::write(root.join("src/old.bak"), "fn backup_marker_qq() {}\n").unwrap();
+
+    let stats = ns::indexer::run_incremental_index(&root, 1_048_576)
+        .expect("incremental should succeed");
+    assert_eq!(stats.added, 0, "*.bak should stay ignored after a full build");
+}
+
+#[test]
+fn incremental_drops_newly_ignored_file() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    thread::sleep(Duration::from_secs(1));
+    fs::write(root.join(".nsignore"), "src/utils.js\n").unwrap();
+
+    let stats = ns::indexer::run_incremental_index(&root, 1_048_576)
+        .expect("incremental should succeed");
+    assert_eq!(stats.deleted, 1, "utils.js should be removed from the index");
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "debounce", &opts(10))
+        .expect("search should work");
+    assert!(results.iter().all(|r| !r.path.contains("utils.js")));
+}
+
+#[test]
+fn incremental_keeps_tracking_lines() {
+    let (_tmp, root) = common::isolated_fixture();
+    let index_opts = ns::indexer::IndexOptions {
+        track_lines: true,
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &index_opts).expect("indexing should succeed");
+
+    thread::sleep(Duration::from_secs(1));
+    fs::write(root.join("src/late.rs"), "// first\r\n// second\npub fn late_marker_qq() {}\n").unwrap();
+
+    let stats = ns::indexer::run_incremental_index(&root, 1_048_576)
+        .expect("incremental should succeed");
+    assert_eq!(stats.added, 1);
+    let (results, _) = ns::searcher::query::execute_search(&root, "late_marker_qq", &opts(10))
+        .expect("search should work");
+    assert_eq!(results[0].path, "src/late.rs");
+    assert_eq!(results[0].lines, vec![3]);
+}
+
+// ── Targeted removal ───────────────────────────────────────────────────────
+
+#[test]
+fn removed_file_no_longer_matches_or_counts_in_scores() {
+    let (_tmp, root) = common::indexed_fixture();
+    let before = ns::indexer::writer::read_meta(&root).unwrap().file_count;
+
+    ns::indexer::remove_file(&root, "src/utils.js").expect("removal should succeed");
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "debounce", &opts(10))
+        .expect("search should work");
+    assert!(results.iter().all(|r| r.path != "src/utils.js"));
+    assert_eq!(ns::indexer::writer::read_meta(&root).unwrap().file_count, before - 1);
+    let manifest = ns::indexer::manifest::read_manifest(&root).expect("manifest");
+    assert!(!manifest.files.contains_key("src/utils.js"));
+
+    // Scores match an index that never had the file.
+    let (_fresh_tmp, fresh) = common::isolated_fixture();
+    fs::remove_file(fresh.join("src/utils.js")).unwrap();
+    ns::indexer::run_full_index(&fresh, 1_048_576).expect("indexing should succeed");
+    let scores = |root: &std::path::Path| -> Vec<(String, f32)> {
+        let (results, _) = ns::searcher::query::execute_search(root, "event", &opts(20))
+            .expect("search should work");
+        results.into_iter().map(|r| (r.path, r.score)).collect()
+    };
+    let (removed, rebuilt) = (scores(&root), scores(&fresh));
+    assert_eq!(removed.len(), rebuilt.len());
+    for ((path, score), (fresh_path, fresh_score)) in removed.iter().zip(&rebuilt) {
+        assert_eq!(path, fresh_path);
+        assert!((score - fresh_score).abs() < 1e-4, "{}: {} vs {}", path, score, fresh_score);
+    }
+
+    match ns::indexer::remove_file(&root, "src/utils.js") {
+        Err(ns::error::NsError::Io(e)) => assert_eq!(e.kind(), std::io::ErrorKind::NotFound),
+        other => panic!("expected a not-indexed error, got {:?}", other.map(|_| ())),
+    }
+}
+
 // ── Git-based tests ─────────────────────────────────────────────────────────
 
 /// Creates an isolated fixture with a git repo initialized and initial commit made.
@@ -18,7 +18,7 @@ fn full_index_creates_ns_directory() {
     );
 
     let meta = ns::indexer::writer::read_meta(&root).expect("should read meta.json");
-    assert_eq!(meta.schema_version, 2);
+    assert_eq!(meta.schema_version, ns::indexer::writer::SCHEMA_VERSION);
     assert_eq!(meta.file_count, count);
     assert!(meta.index_size_bytes > 0);
     assert!(meta.indexed_at.contains('T'), "indexed_at should be ISO 8601");
@@ -45,3 +45,684 @@ fn status_without_index_fails() {
     let result = ns::indexer::writer::read_meta(&root);
     assert!(result.is_err(), "reading meta without index should fail");
 }
+
+#[test]
+fn full_index_respects_gitignore_outside_git() {
+    let (_tmp, root) = common::isolated_fixture();
+    std::fs::write(root.join(".gitignore"), "*.tmp\n").unwrap();
+    std::fs::write(root.join("src/scratch.tmp"), "zanzibar_tmp_marker\n").unwrap();
+
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let (results, _) = ns::searcher::query::execute_search(
+        &root,
+        "zanzibar_tmp_marker",
+        &ns::searcher::query::SearchOptions::default(),
+    )
+    .expect("search should work");
+    assert!(results.is_empty(), "*.tmp files should never be indexed");
+}
+
+#[test]
+fn full_index_applies_explicit_ignore_patterns() {
+    let (_tmp, root) = common::isolated_fixture();
+
+    let opts = ns::indexer::IndexOptions {
+        ignore_patterns: vec!["*.py".to_string(), "config.json".to_string()],
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &opts).expect("indexing should succeed");
+
+    let (results, _) = ns::searcher::query::execute_search(
+        &root,
+        "UserRepository",
+        &ns::searcher::query::SearchOptions::default(),
+    )
+    .expect("search should work");
+    assert!(results.iter().all(|r| !r.path.ends_with(".py")));
+
+    let meta = ns::indexer::writer::read_meta(&root).expect("should read meta.json");
+    assert_eq!(meta.ignore_patterns, vec!["*.py", "config.json"]);
+}
+
+/// Every indexed term of `content` and `symbols`, mapped to its postings as
+/// sorted `(path, term_freq, positions)`. Doc ids and segment layout are
+/// left out, so only what the files put in the index is compared.
+fn postings_by_path(
+    root: &std::path::Path,
+) -> std::collections::BTreeMap<(&'static str, Vec<u8>), Vec<(String, u32, Vec<u32>)>> {
+    use tantivy::schema::{IndexRecordOption, Value};
+    use tantivy::{DocSet, Postings, TantivyDocument, TERMINATED};
+
+    let (index, _) = ns::indexer::writer::open_index(root).expect("should open index");
+    let searcher = index.reader().expect("should open reader").searcher();
+    let schema = index.schema();
+    let path_f = ns::schema::path_field(&schema);
+    let fields = [
+        ("content", ns::schema::content_field(&schema)),
+        ("symbols", ns::schema::symbols_field(&schema)),
+    ];
+
+    let mut out = std::collections::BTreeMap::new();
+    for segment in searcher.segment_readers() {
+        let store = segment.get_store_reader(0).unwrap();
+        for (name, field) in fields {
+            let inverted = segment.inverted_index(field).unwrap();
+            let mut terms = inverted.terms().stream().unwrap();
+            while terms.advance() {
+                let mut postings = inverted
+                    .read_postings_from_terminfo(terms.value(), IndexRecordOption::WithFreqsAndPositions)
+                    .unwrap();
+                let entry: &mut Vec<_> = out.entry((name, terms.key().to_vec())).or_default();
+                let mut doc = postings.doc();
+                while doc != TERMINATED {
+                    let stored: TantivyDocument = store.get(doc).unwrap();
+                    let path = stored.get_first(path_f).and_then(|v| v.as_str()).unwrap().to_string();
+                    let mut positions = Vec::new();
+                    postings.positions(&mut positions);
+                    entry.push((path, postings.term_freq(), positions));
+                    doc = postings.advance();
+                }
+            }
+        }
+    }
+    for postings in out.values_mut() {
+        postings.sort();
+    }
+    out
+}
+
+#[test]
+fn parallel_build_matches_serial_build() {
+    let (_serial_tmp, serial_root) = common::isolated_fixture();
+    let (_parallel_tmp, parallel_root) = common::isolated_fixture();
+
+    let serial = ns::indexer::IndexOptions {
+        threads: 1,
+        ..Default::default()
+    };
+    // A tiny in-flight budget forces workers to wait on each other.
+    let parallel = ns::indexer::IndexOptions {
+        threads: 8,
+        max_in_flight_bytes: 256,
+        ..Default::default()
+    };
+    let serial_stats = ns::indexer::run_full_index_with_options(&serial_root, &serial)
+        .unwrap()
+        .unwrap();
+    let parallel_stats = ns::indexer::run_full_index_with_options(&parallel_root, &parallel)
+        .unwrap()
+        .unwrap();
+
+    assert_eq!(serial_stats.file_count, parallel_stats.file_count);
+    let serial_postings = postings_by_path(&serial_root);
+    assert!(!serial_postings.is_empty());
+    assert_eq!(serial_postings, postings_by_path(&parallel_root));
+}
+
+#[test]
+fn full_index_of_only_binary_files_keeps_existing_index() {
+    let dir = tempfile::tempdir().unwrap();
+    let root = dir.path();
+    std::fs::write(root.join("blob.bin"), b"\x00\x01binary").unwrap();
+
+    let result = ns::indexer::run_full_index(root, 1_048_576).expect("indexing should succeed");
+    assert!(result.is_none(), "no indexable files");
+    assert!(!root.join(".ns/index").exists(), "nothing should be created");
+}
+
+fn write_mixed_files(root: &std::path::Path) {
+    std::fs::write(root.join("main.rs"), "fn main() {}\n").unwrap();
+    std::fs::write(root.join("blob.bin"), b"\x00\xff\xfe payload").unwrap();
+    std::fs::write(root.join("nul.txt"), "text with a \0 stray nul\n").unwrap();
+    std::fs::write(root.join("big.txt"), "words ".repeat(200)).unwrap();
+}
+
+#[test]
+fn full_index_reports_skipped_files() {
+    let dir = tempfile::tempdir().unwrap();
+    let root = dir.path();
+    write_mixed_files(root);
+
+    let opts = ns::indexer::IndexOptions {
+        max_file_size: 1000,
+        ..Default::default()
+    };
+    let stats = ns::indexer::run_full_index_with_options(root, &opts)
+        .expect("indexing should succeed")
+        .expect("main.rs is indexable");
+    assert_eq!(stats.file_count, 1);
+    assert_eq!(stats.skipped_binary, 2);
+    assert_eq!(stats.skipped_too_large, 1);
+}
+
+#[test]
+fn include_binary_indexes_utf8_files_with_nul_bytes() {
+    let dir = tempfile::tempdir().unwrap();
+    let root = dir.path();
+    write_mixed_files(root);
+
+    let opts = ns::indexer::IndexOptions {
+        skip_binary: false,
+        ..Default::default()
+    };
+    let stats = ns::indexer::run_full_index_with_options(root, &opts)
+        .expect("indexing should succeed")
+        .expect("files are indexable");
+    // blob.bin is not valid UTF-8, so it stays out either way.
+    assert_eq!(stats.file_count, 3);
+    assert_eq!(stats.skipped_binary, 1);
+    let meta = ns::indexer::writer::read_meta(root).unwrap();
+    assert!(!meta.skip_binary);
+
+    // Incremental runs follow the recorded setting.
+    std::fs::write(root.join("nul2.txt"), "another \0 nul\n").unwrap();
+    let inc = ns::indexer::run_incremental_index(root, 1_048_576).expect("incremental should succeed");
+    assert_eq!(inc.added, 1);
+}
+
+#[test]
+fn watch_applies_file_changes_until_stopped() {
+    use std::sync::atomic::{AtomicBool, Ordering};
+    use std::sync::{mpsc, Arc};
+    use std::time::Duration;
+
+    let (_tmp, root) = common::isolated_fixture();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let stop = Arc::new(AtomicBool::new(false));
+    let (tx, rx) = mpsc::channel();
+    let watcher = {
+        let root = root.clone();
+        let stop = Arc::clone(&stop);
+        let opts = ns::indexer::watch::WatchOptions {
+            debounce: Duration::from_millis(100),
+            ..Default::default()
+        };
+        std::thread::spawn(move || {
+            ns::indexer::watch::watch(&root, &opts, &stop, |update| {
+                let _ = tx.send(update.map(|stats| stats.added + stats.modified));
+            })
+        })
+    };
+    // Give the watch time to be registered before changing files.
+    std::thread::sleep(Duration::from_millis(300));
+
+    std::fs::write(root.join("src/quokka.rs"), "fn quokka() {}\n").unwrap();
+    let changed = (0..20)
+        .map_while(|_| rx.recv_timeout(Duration::from_secs(5)).ok())
+        .map(|update| update.expect("update should succeed"))
+        .find(|&changed| changed > 0);
+    assert_eq!(changed, Some(1));
+
+    let (results, _) =
+        ns::searcher::query::execute_search(&root, "quokka", &ns::searcher::query::SearchOptions::default())
+            .expect("search should work");
+    assert_eq!(results.len(), 1);
+    assert_eq!(results[0].path, "src/quokka.rs");
+
+    stop.store(true, Ordering::Relaxed);
+    watcher.join().unwrap().expect("watch should stop cleanly");
+}
+
+#[test]
+fn index_stats_reports_documents_and_terms() {
+    let (_tmp, root) = common::isolated_fixture();
+    let full = ns::indexer::run_full_index(&root, 1_048_576).unwrap().unwrap();
+
+    let stats = ns::searcher::query::index_stats(&root).expect("stats should be readable");
+    assert_eq!(stats.documents as usize, full.file_count);
+    assert!(stats.total_terms > stats.documents);
+    let avg = stats.total_terms as f64 / stats.documents as f64;
+    assert!((stats.avg_doc_length - avg).abs() < 1e-9);
+    assert!(stats.index_size_bytes > 0);
+    assert_eq!(stats.indexed_at, ns::indexer::writer::read_meta(&root).unwrap().indexed_at);
+
+    let output = std::process::Command::new(env!("CARGO_BIN_EXE_ns"))
+        .args(["status", "--json"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(output.status.success());
+    let parsed: serde_json::Value = serde_json::from_slice(&output.stdout).expect("valid JSON");
+    assert_eq!(parsed["documents"], stats.documents);
+    assert_eq!(parsed["total_terms"], stats.total_terms);
+    assert!(parsed["languages"].as_array().unwrap().iter().any(|l| l["name"] == "rust"));
+}
+
+#[test]
+fn index_source_builds_from_files_not_on_disk() {
+    let dir = tempfile::tempdir().unwrap();
+    let mut source = ns::indexer::source::MemorySource::new();
+    source.insert("src/quokka.rs", "pub fn feed_quokka() {}\n");
+    source.insert("vendor/quokka.rs", "pub fn vendored_quokka() {}\n");
+    source.insert("big.txt", format!("quokka {}\n", "x".repeat(200)));
+
+    let opts = ns::indexer::IndexOptions {
+        max_file_size: 100,
+        ignore_patterns: vec!["vendor/".to_string()],
+        ..Default::default()
+    };
+    let stats = ns::indexer::index_source(dir.path(), &source, &opts)
+        .expect("indexing should succeed")
+        .expect("source has text files");
+    assert_eq!(stats.file_count, 1);
+    assert_eq!(stats.skipped_too_large, 1);
+    assert!(!dir.path().join("src").exists(), "source files should not be written out");
+
+    let (results, _) = ns::searcher::query::execute_search(
+        dir.path(),
+        "quokka",
+        &ns::searcher::query::SearchOptions::default(),
+    )
+    .expect("search should work");
+    let paths: Vec<&str> = results.iter().map(|r| r.path.as_str()).collect();
+    assert_eq!(paths, vec!["src/quokka.rs"]);
+    assert_eq!(results[0].symbols_raw, vec!["feed_quokka"]);
+}
+
+/// A source that cancels `token` when the file at `rel_path` is read.
+struct CancelOnRead {
+    files: ns::indexer::source::MemorySource,
+    rel_path: &'static str,
+    token: ns::cancel::CancelToken,
+}
+
+impl ns::indexer::source::FileSource for CancelOnRead {
+    fn walk(
+        &self,
+        max_file_size: u64,
+        ignore_patterns: &[String],
+    ) -> (Vec<ns::indexer::walker::WalkedPath>, usize) {
+        self.files.walk(max_file_size, ignore_patterns)
+    }
+
+    fn read(&self, walked: &ns::indexer::walker::WalkedPath) -> std::io::Result<Vec<u8>> {
+        if walked.rel_path == self.rel_path {
+            self.token.cancel();
+        }
+        self.files.read(walked)
+    }
+}
+
+#[test]
+fn cancelled_rebuild_keeps_previous_index() {
+    let (_tmp, root) = common::isolated_fixture();
+    ns::indexer::run_full_index(&root, 1_048_576)
+        .expect("first index should succeed")
+        .expect("should have indexable files");
+    let meta_before = std::fs::read_to_string(root.join(".ns/meta.json")).unwrap();
+
+    let mut files = ns::indexer::source::MemorySource::new();
+    for name in ["a.rs", "b.rs", "c.rs", "d.rs"] {
+        files.insert(name, "pub fn replaced_wombat() {}\n");
+    }
+    let token = ns::cancel::CancelToken::new();
+    let source = CancelOnRead {
+        files,
+        rel_path: "b.rs",
+        token: token.clone(),
+    };
+    let opts = ns::indexer::IndexOptions {
+        threads: 1,
+        cancel: token,
+        ..Default::default()
+    };
+    let err = ns::indexer::index_source(&root, &source, &opts).unwrap_err();
+    assert!(matches!(err, ns::error::NsError::Cancelled), "got {:?}", err);
+
+    assert!(!root.join(".ns/index.new").exists(), "staging index should be removed");
+    assert_eq!(std::fs::read_to_string(root.join(".ns/meta.json")).unwrap(), meta_before);
+    let opts = ns::searcher::query::SearchOptions::default();
+    let (old, _) = ns::searcher::query::execute_search(&root, "EventStore", &opts).unwrap();
+    assert!(!old.is_empty(), "previous index should still answer queries");
+    let (new, _) = ns::searcher::query::execute_search(&root, "replaced_wombat", &opts).unwrap();
+    assert!(new.is_empty());
+}
+
+#[test]
+fn progress_reports_each_file_and_can_cancel_the_build() {
+    let dir = tempfile::tempdir().unwrap();
+    let mut source = ns::indexer::source::MemorySource::new();
+    for name in ["a.rs", "b.rs", "c.rs", "d.rs"] {
+        source.insert(name, "pub fn progress_wombat() {}\n");
+    }
+    let events = std::sync::Arc::new(std::sync::Mutex::new(Vec::new()));
+    let seen = std::sync::Arc::clone(&events);
+    let progress = ns::indexer::progress::Progress::new(move |e| {
+        seen.lock().unwrap().push(e.clone());
+    })
+    .with_interval(std::time::Duration::ZERO);
+    let opts = ns::indexer::IndexOptions {
+        threads: 4,
+        progress: Some(progress),
+        ..Default::default()
+    };
+    ns::indexer::index_source(dir.path(), &source, &opts)
+        .expect("indexing should succeed")
+        .expect("source has text files");
+    let events = events.lock().unwrap();
+    let done: Vec<(usize, &str)> = events.iter().map(|e| (e.files_done, e.path.as_str())).collect();
+    assert_eq!(done, vec![(1, "a.rs"), (2, "b.rs"), (3, "c.rs"), (4, "d.rs")]);
+    let last = events.last().unwrap();
+    assert_eq!((last.files_total, last.bytes_done), (4, last.bytes_total));
+
+    // A caller stops the build by cancelling from its callback.
+    let token = ns::cancel::CancelToken::new();
+    let canceller = token.clone();
+    let progress = ns::indexer::progress::Progress::new(move |e| {
+        if e.files_done == 2 {
+            canceller.cancel();
+        }
+    })
+    .with_interval(std::time::Duration::ZERO);
+    let opts = ns::indexer::IndexOptions {
+        progress: Some(progress),
+        cancel: token,
+        ..Default::default()
+    };
+    let err = ns::indexer::index_source(dir.path(), &source, &opts).unwrap_err();
+    assert!(matches!(err, ns::error::NsError::Cancelled), "got {:?}", err);
+}
+
+/// Files of a small repo, split by top-level directory.
+const SHARDED_FILES: &[(&str, &str)] = &[
+    ("api/server.go", "package api\n\nfunc StartServer() { listen(); serve() }\n"),
+    ("api/routes.go", "package api\n\nfunc routes() { serve(); serve() }\n"),
+    ("store/cache.go", "package store\n\ntype Cache struct{}\n\nfunc (c *Cache) serve() {}\n"),
+    ("store/disk.go", "package store\n\nfunc flushToDisk() { listen() }\n"),
+    ("docs/serve.md", "# Serving\n\nCall StartServer to serve requests.\n"),
+];
+
+fn index_files(root: &std::path::Path, files: &[&(&str, &str)], opts: &ns::indexer::IndexOptions) {
+    let mut source = ns::indexer::source::MemorySource::new();
+    for (path, content) in files {
+        source.insert(path, *content);
+    }
+    ns::indexer::index_source(root, &source, opts)
+        .expect("indexing should succeed")
+        .expect("shard should have files");
+}
+
+/// Score and path of each result for `query`, sorted by path.
+fn scored_paths(root: &std::path::Path, query: &str) -> Vec<(String, f32)> {
+    let opts = ns::searcher::query::SearchOptions::default();
+    let (results, _) = ns::searcher::query::execute_search(root, query, &opts).unwrap();
+    let mut scored: Vec<_> = results.into_iter().map(|r| (r.path, r.score)).collect();
+    scored.sort_by(|a, b| a.0.cmp(&b.0));
+    scored
+}
+
+#[test]
+fn merged_shards_search_like_one_index() {
+    let whole = tempfile::tempdir().unwrap();
+    let opts = ns::indexer::IndexOptions::default();
+    index_files(whole.path(), &SHARDED_FILES.iter().collect::<Vec<_>>(), &opts);
+
+    let shard_dirs: Vec<_> = ["api/", "store/", "docs/"]
+        .iter()
+        .map(|prefix| {
+            let dir = tempfile::tempdir().unwrap();
+            let files: Vec<_> = SHARDED_FILES
+                .iter()
+                .filter(|(path, _)| path.starts_with(prefix))
+                .collect();
+            index_files(dir.path(), &files, &opts);
+            dir
+        })
+        .collect();
+    let shards: Vec<&std::path::Path> = shard_dirs.iter().map(|d| d.path()).collect();
+
+    let merged = tempfile::tempdir().unwrap();
+    let stats = ns::indexer::merge::merge_indexes(merged.path(), &shards)
+        .expect("merge should succeed")
+        .expect("there are shards");
+    assert_eq!((stats.file_count, stats.shard_count), (5, 3));
+    assert!(!merged.path().join(".ns/index.new").exists());
+    let meta = ns::indexer::writer::read_meta(merged.path()).unwrap();
+    assert_eq!(meta.file_count, 5);
+    let manifest = ns::indexer::manifest::read_manifest(merged.path()).unwrap();
+    assert_eq!(manifest.files.len(), 5);
+
+    for query in ["serve", "listen", "StartServer", "cache disk", "\"serve requests\""] {
+        let expected = scored_paths(whole.path(), query);
+        assert!(!expected.is_empty(), "{} should match", query);
+        let found = scored_paths(merged.path(), query);
+        assert_eq!(
+            found.iter().map(|(p, _)| p).collect::<Vec<_>>(),
+            expected.iter().map(|(p, _)| p).collect::<Vec<_>>(),
+            "paths for {}",
+            query
+        );
+        for ((path, score), (_, want)) in found.iter().zip(&expected) {
+            assert!(
+                (score - want).abs() < 1e-4,
+                "{} scored {} for {}, want {}",
+                path,
+                score,
+                query,
+                want
+            );
+        }
+    }
+}
+
+#[test]
+fn merge_rejects_incompatible_or_overlapping_shards() {
+    let files: Vec<_> = SHARDED_FILES.iter().collect();
+    let plain = tempfile::tempdir().unwrap();
+    index_files(plain.path(), &files[..2], &ns::indexer::IndexOptions::default());
+    let stemmed = tempfile::tempdir().unwrap();
+    let stem_opts = ns::indexer::IndexOptions {
+        stemming: ns::indexer::tokenizer::Stemming::English,
+        ..Default::default()
+    };
+    index_files(stemmed.path(), &files[2..], &stem_opts);
+    let overlapping = tempfile::tempdir().unwrap();
+    index_files(overlapping.path(), &files[1..3], &ns::indexer::IndexOptions::default());
+
+    let dst = tempfile::tempdir().unwrap();
+    let err = ns::indexer::merge::merge_indexes(dst.path(), &[plain.path(), stemmed.path()])
+        .unwrap_err();
+    assert!(
+        matches!(err, ns::error::NsError::IncompatibleIndexes { option: "stemmers" }),
+        "got {:?}",
+        err
+    );
+    let err = ns::indexer::merge::merge_indexes(dst.path(), &[plain.path(), overlapping.path()])
+        .unwrap_err();
+    match err {
+        ns::error::NsError::DuplicateShardPath(path) => assert_eq!(path, "api/routes.go"),
+        other => panic!("expected a duplicate path, got {:?}", other),
+    }
+    assert!(!dst.path().join(".ns").exists(), "failed merges should write nothing");
+}
+
+/// A source whose reads of `unreadable` fail with permission errors.
+struct FailingReads {
+    files: ns::indexer::source::MemorySource,
+    unreadable: &'static [&'static str],
+}
+
+impl ns::indexer::source::FileSource for FailingReads {
+    fn walk(
+        &self,
+        max_file_size: u64,
+        ignore_patterns: &[String],
+    ) -> (Vec<ns::indexer::walker::WalkedPath>, usize) {
+        self.files.walk(max_file_size, ignore_patterns)
+    }
+
+    fn read(&self, walked: &ns::indexer::walker::WalkedPath) -> std::io::Result<Vec<u8>> {
+        if self.unreadable.contains(&walked.rel_path.as_str()) {
+            return Err(std::io::Error::new(std::io::ErrorKind::PermissionDenied, "denied"));
+        }
+        self.files.read(walked)
+    }
+}
+
+fn failing_reads() -> FailingReads {
+    let mut files = ns::indexer::source::MemorySource::new();
+    for name in ["a.rs", "b.rs", "c.rs", "d.rs"] {
+        files.insert(name, "pub fn readable_wombat() {}\n");
+    }
+    FailingReads {
+        files,
+        unreadable: &["b.rs", "d.rs"],
+    }
+}
+
+#[test]
+fn unreadable_files_are_skipped_and_listed() {
+    let dir = tempfile::tempdir().unwrap();
+    let stats = ns::indexer::index_source(dir.path(), &failing_reads(), &Default::default())
+        .expect("unreadable files should not fail the build")
+        .expect("a.rs and c.rs are indexable");
+    assert_eq!(stats.file_count, 2);
+    let skipped: Vec<&str> = stats.unreadable.files.iter().map(|f| f.path.as_str()).collect();
+    assert_eq!(skipped, vec!["b.rs", "d.rs"]);
+    assert_eq!(
+        stats.unreadable.to_string(),
+        "cannot read 2 files: b.rs: denied; d.rs: denied"
+    );
+    let (results, _) = ns::searcher::query::execute_search(
+        dir.path(),
+        "readable_wombat",
+        &ns::searcher::query::SearchOptions::default(),
+    )
+    .unwrap();
+    assert_eq!(results.len(), 2);
+}
+
+#[test]
+fn strict_build_fails_on_first_unreadable_file() {
+    let dir = tempfile::tempdir().unwrap();
+    let opts = ns::indexer::IndexOptions {
+        strict: true,
+        ..Default::default()
+    };
+    match ns::indexer::index_source(dir.path(), &failing_reads(), &opts) {
+        Err(ns::error::NsError::Unreadable(file)) => {
+            assert_eq!(file.path, "b.rs");
+            assert_eq!(file.error.kind(), std::io::ErrorKind::PermissionDenied);
+        }
+        other => panic!("expected an unreadable file error, got {:?}", other.err()),
+    }
+    assert!(!dir.path().join(".ns/index").exists(), "nothing should be created");
+}
+
+fn gzip(content: &[u8]) -> Vec<u8> {
+    use std::io::Write;
+    let mut encoder = flate2::write::GzEncoder::new(Vec::new(), flate2::Compression::default());
+    encoder.write_all(content).unwrap();
+    encoder.finish().unwrap()
+}
+
+#[test]
+fn gzip_files_are_indexed_as_their_content() {
+    let dir = tempfile::tempdir().unwrap();
+    let root = dir.path();
+    let server = "package main\n\nfunc handleCompressedWombat() {}\n";
+    std::fs::write(root.join("server.go.gz"), gzip(server.as_bytes())).unwrap();
+    let mut corrupt = gzip(b"package main\nfunc truncatedWombat() {}\n");
+    corrupt.truncate(corrupt.len() / 2);
+    std::fs::write(root.join("broken.go.gz"), corrupt).unwrap();
+    std::fs::write(root.join("bomb.txt.gz"), gzip(&vec![b'a'; 100_000])).unwrap();
+
+    let opts = ns::indexer::IndexOptions {
+        max_file_size: 10_000,
+        ..Default::default()
+    };
+    let stats = ns::indexer::run_full_index_with_options(root, &opts)
+        .expect("corrupt gzip should not fail the build")
+        .expect("server.go.gz is indexable");
+    assert_eq!(stats.file_count, 1);
+    assert_eq!(stats.skipped_too_large, 1, "bomb.txt.gz decompresses too large");
+    let skipped: Vec<&str> = stats.unreadable.files.iter().map(|f| f.path.as_str()).collect();
+    assert_eq!(skipped, vec!["broken.go.gz"]);
+
+    let search = |query: &str| {
+        ns::searcher::query::execute_search(
+            root,
+            query,
+            &ns::searcher::query::SearchOptions::default(),
+        )
+        .unwrap()
+        .0
+    };
+    let results = search("handleCompressedWombat");
+    assert_eq!(results.len(), 1);
+    assert_eq!(results[0].path, "server.go.gz");
+    assert_eq!(results[0].lang.as_deref(), Some("go"));
+
+    // Incremental updates decompress added files too.
+    let notes = "package notes\n\nfunc addedLaterWombat() {}\n";
+    std::fs::write(root.join("notes.go.gz"), gzip(notes.as_bytes())).unwrap();
+    let stats = ns::indexer::run_incremental_index(root, 10_000).unwrap();
+    assert_eq!(stats.added, 1);
+    assert_eq!(search("addedLaterWombat")[0].path, "notes.go.gz");
+}
+
+/// The segment files of the index at `root`, keyed by extension: segment
+/// file names are random per build, their bytes shouldn't be.
+fn segment_files(root: &std::path::Path) -> std::collections::BTreeMap<String, Vec<u8>> {
+    let mut files = std::collections::BTreeMap::new();
+    for entry in std::fs::read_dir(root.join(".ns/index")).unwrap() {
+        let path = entry.unwrap().path();
+        let name = path.file_name().unwrap().to_string_lossy().to_string();
+        let Some((segment, ext)) = name.split_once('.') else {
+            continue;
+        };
+        if segment.len() == 32 && segment.bytes().all(|b| b.is_ascii_hexdigit()) {
+            let previous = files.insert(ext.to_string(), std::fs::read(&path).unwrap());
+            assert!(previous.is_none(), "expected one segment, found two .{} files", ext);
+        }
+    }
+    files
+}
+
+#[test]
+fn builds_of_the_same_files_are_byte_identical() {
+    let files = [
+        ("src/server.rs", "pub fn serve() { listen(); }\n"),
+        ("src/client.rs", "pub fn connect() { dial(); }\n"),
+        ("README.md", "# Demo\n\nserve and connect\n"),
+        ("lib/util.go", "package util\n\nfunc Retry() {}\n"),
+    ];
+    let build = |order: &[usize]| {
+        let dir = tempfile::tempdir().unwrap();
+        for &i in order {
+            let (rel_path, content) = files[i];
+            let path = dir.path().join(rel_path);
+            std::fs::create_dir_all(path.parent().unwrap()).unwrap();
+            std::fs::write(path, content).unwrap();
+        }
+        let opts = ns::indexer::IndexOptions {
+            threads: 4,
+            ..Default::default()
+        };
+        ns::indexer::run_full_index_with_options(dir.path(), &opts).unwrap();
+        dir
+    };
+    let first = build(&[0, 1, 2, 3]);
+    let second = build(&[3, 2, 1, 0]);
+
+    let first_files = segment_files(first.path());
+    assert!(first_files.contains_key("store"), "found {:?}", first_files.keys());
+    assert!(first_files == segment_files(second.path()), "segment files differ");
+
+    // Document IDs follow path order.
+    use tantivy::schema::Value;
+    let (index, _) = ns::indexer::writer::open_index(first.path()).unwrap();
+    let searcher = index.reader().unwrap().searcher();
+    let path_f = ns::schema::path_field(&index.schema());
+    let segment = &searcher.segment_readers()[0];
+    let store = segment.get_store_reader(1).unwrap();
+    let paths: Vec<String> = (0..segment.max_doc())
+        .map(|doc_id| {
+            let doc: tantivy::TantivyDocument = store.get(doc_id).unwrap();
+            doc.get_first(path_f).and_then(|v| v.as_str()).unwrap().to_string()
+        })
+        .collect();
+    assert_eq!(paths, vec!["README.md", "lib/util.go", "src/client.rs", "src/server.rs"]);
+}
@@ -1,7 +1,9 @@
 mod common;
 
-use ns::searcher::query::SearchOptions;
+use ns::indexer::classes::{TokenClass, TokenClasses};
+use ns::searcher::query::{SearchOptions, SearchStats, SortMode, WeightRule};
 use ns::searcher::OutputMode;
+use std::collections::BTreeMap;
 use std::fs;
 use std::path::Path;
 
@@ -92,6 +94,70 @@ fn search_respects_max_results() {
     );
 }
 
+/// Indexes `count` files with identical content, so every match ties on score.
+fn tied_fixture(count: usize) -> (tempfile::TempDir, std::path::PathBuf) {
+    let (tmp, root) = common::isolated_fixture();
+    for i in 0..count {
+        std::fs::write(
+            root.join(format!("src/tied_{:02}.rs", (i * 7) % count)),
+            "fn wombat_handler() {}\n",
+        )
+        .unwrap();
+    }
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+    (tmp, root)
+}
+
+#[test]
+fn pages_concatenate_to_full_result_set() {
+    let (_tmp, root) = tied_fixture(23);
+
+    let all_opts = SearchOptions {
+        max_results: 100,
+        ..Default::default()
+    };
+    let (all, all_stats) = ns::searcher::query::execute_search(&root, "wombat", &all_opts)
+        .expect("search should work");
+    assert_eq!(all.len(), 23);
+    assert_eq!(all_stats.total_matches, 23);
+    let all_paths: Vec<String> = all.iter().map(|r| r.path.clone()).collect();
+    let mut sorted = all_paths.clone();
+    sorted.sort();
+    assert_eq!(all_paths, sorted, "equal scores should be ordered by path");
+
+    let mut paged = Vec::new();
+    for offset in (0..30).step_by(5) {
+        let page_opts = SearchOptions {
+            max_results: 5,
+            offset,
+            ..Default::default()
+        };
+        let (page, stats) = ns::searcher::query::execute_search(&root, "wombat", &page_opts)
+            .expect("search should work");
+        assert_eq!(stats.total_matches, 23, "total is independent of the page");
+        assert_eq!(stats.total_results, page.len());
+        paged.extend(page.into_iter().map(|r| r.path));
+    }
+    assert_eq!(paged, all_paths, "pages should have no duplicates or gaps");
+}
+
+#[test]
+fn glob_filter_applies_before_paging() {
+    let (_tmp, root) = tied_fixture(12);
+
+    let glob_opts = SearchOptions {
+        max_results: 3,
+        offset: 3,
+        file_glob: Some("src/tied_0*".to_string()),
+        ..Default::default()
+    };
+    let (results, stats) = ns::searcher::query::execute_search(&root, "wombat", &glob_opts)
+        .expect("search should work");
+    assert_eq!(stats.total_matches, 10, "tied_00..tied_09 match the glob");
+    let paths: Vec<&str> = results.iter().map(|r| r.path.as_str()).collect();
+    assert_eq!(paths, vec!["src/tied_03.rs", "src/tied_04.rs", "src/tied_05.rs"]);
+}
+
 #[test]
 fn search_context_lines_are_present() {
     let (_tmp, root) = common::indexed_fixture();
@@ -254,7 +320,7 @@ fn filter_by_language_type() {
 
     let rust_opts = SearchOptions {
         max_results: 10,
-        file_type: Some("rust".to_string()),
+        languages: vec!["rust".to_string()],
         ..Default::default()
     };
     let (results, _stats) =
@@ -279,7 +345,7 @@ fn filter_by_language_excludes_other_langs() {
 
     let py_opts = SearchOptions {
         max_results: 10,
-        file_type: Some("python".to_string()),
+        languages: vec!["python".to_string()],
         ..Default::default()
     };
     let (results, _stats) =
@@ -297,6 +363,84 @@ fn filter_by_language_excludes_other_langs() {
     }
 }
 
+#[test]
+fn filter_by_markdown_language() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let md_opts = SearchOptions {
+        max_results: 10,
+        languages: vec!["markdown".to_string()],
+        ..Default::default()
+    };
+    let (results, _stats) =
+        ns::searcher::query::execute_search(&root, "fixture", &md_opts)
+            .expect("search should work");
+
+    let paths: Vec<&str> = results.iter().map(|r| r.path.as_str()).collect();
+    assert_eq!(paths, vec!["README.md"]);
+}
+
+#[test]
+fn filter_by_multiple_languages() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let multi_opts = SearchOptions {
+        max_results: 20,
+        languages: vec!["go".to_string(), "python".to_string()],
+        ..Default::default()
+    };
+    let (results, _stats) =
+        ns::searcher::query::execute_search(&root, "server models event", &multi_opts)
+            .expect("search should work");
+
+    assert!(results.iter().any(|r| r.lang.as_deref() == Some("go")));
+    assert!(results.iter().any(|r| r.lang.as_deref() == Some("python")));
+    for r in &results {
+        assert!(
+            matches!(r.lang.as_deref(), Some("go") | Some("python")),
+            "unexpected language {:?} for {}",
+            r.lang,
+            r.path
+        );
+    }
+}
+
+#[test]
+fn shebang_script_without_extension_is_shell() {
+    let (_tmp, root) = common::isolated_fixture();
+    std::fs::create_dir_all(root.join("bin")).unwrap();
+    std::fs::write(
+        root.join("bin/deploy"),
+        "#!/bin/bash\nset -euo pipefail\necho rolling out kangaroo\n",
+    )
+    .unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let sh_opts = SearchOptions {
+        max_results: 10,
+        languages: vec!["shell".to_string()],
+        ..Default::default()
+    };
+    let (results, _stats) =
+        ns::searcher::query::execute_search(&root, "kangaroo", &sh_opts)
+            .expect("search should work");
+    assert_eq!(results.len(), 1);
+    assert_eq!(results[0].path, "bin/deploy");
+    assert_eq!(results[0].lang.as_deref(), Some("shell"));
+}
+
+#[test]
+fn indexed_languages_lists_counts() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let langs = ns::searcher::query::indexed_languages(&root).expect("should list languages");
+    let count = |name: &str| langs.iter().find(|(l, _)| l == name).map(|(_, n)| *n);
+    assert_eq!(langs[0], ("rust".to_string(), 2), "most common language first");
+    assert_eq!(count("markdown"), Some(1));
+    assert_eq!(count("go"), Some(1));
+    assert_eq!(count("json"), None, "files without a language are not listed");
+}
+
 #[test]
 fn glob_filter_restricts_paths() {
     let (_tmp, root) = common::indexed_fixture();
@@ -455,6 +599,42 @@ fn json_output_lines_use_num_field() {
     assert!(lines[0]["text"].is_string(), "line entries should have 'text' field");
 }
 
+#[test]
+fn json_output_match_offsets_index_into_line_text() {
+    let (_tmp, root) = common::isolated_fixture();
+    fs::write(
+        root.join("notes.md"),
+        "# Zeitüberschreitung\n\nDer Zähler → quokkaCount zählt Quokka-Sprünge.\n",
+    )
+    .unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let opts = SearchOptions {
+        context_window: 0,
+        ..Default::default()
+    };
+    let so = ns::searcher::search(&root, "quokka", OutputMode::Json, &opts)
+        .expect("search should work");
+    let parsed: serde_json::Value = serde_json::from_str(&so.formatted).unwrap();
+    let result = parsed["results"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .find(|r| r["path"] == "notes.md")
+        .expect("notes.md should match");
+
+    let line = result["lines"][0]["text"].as_str().unwrap();
+    let matches = result["matches"].as_array().unwrap();
+    assert_eq!(matches.len(), 2, "both occurrences on the line: {:?}", matches);
+    for m in matches {
+        assert_eq!(m["line"], 3);
+        assert_eq!(m["term"], "quokka");
+        let start = m["start"].as_u64().unwrap() as usize;
+        let end = m["end"].as_u64().unwrap() as usize;
+        assert!(line[start..end].eq_ignore_ascii_case("quokka"), "offsets drifted: {:?}", m);
+    }
+}
+
 #[test]
 fn fuzzy_search_finds_typo() {
     let (_tmp, root) = common::indexed_fixture();
@@ -477,6 +657,60 @@ fn fuzzy_search_finds_typo() {
     );
 }
 
+#[test]
+fn fuzzy_distance_two_finds_double_typo() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    // "EvntStre" is two deletions away from "EventStore"
+    let distance = |d: u8| SearchOptions {
+        max_results: 10,
+        fuzzy: true,
+        fuzzy_distance: d,
+        ..Default::default()
+    };
+    let (results, _) = ns::searcher::query::execute_search(&root, "EvntStre", &distance(1))
+        .expect("fuzzy search should work");
+    assert!(results.iter().all(|r| !r.path.contains("event_store.rs")));
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "EvntStre", &distance(2))
+        .expect("fuzzy search should work");
+    assert!(results.iter().any(|r| r.path.contains("event_store.rs")));
+}
+
+#[test]
+fn fuzzy_transposition_finds_server() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let fuzzy_opts = SearchOptions {
+        max_results: 10,
+        fuzzy: true,
+        ..Default::default()
+    };
+    let (results, _) = ns::searcher::query::execute_search(&root, "serevr", &fuzzy_opts)
+        .expect("fuzzy search should work");
+    assert!(results.iter().any(|r| r.path.contains("server.go")));
+}
+
+#[test]
+fn fuzzy_exact_match_ranks_above_near_match() {
+    let (_tmp, root) = common::isolated_fixture();
+    fs::write(root.join("exact.txt"), "the quokka sleeps\n").unwrap();
+    fs::write(root.join("near.txt"), "the quokkas sleep\n").unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let fuzzy_opts = SearchOptions {
+        max_results: 10,
+        fuzzy: true,
+        fuzzy_distance: 2,
+        ..Default::default()
+    };
+    let (results, _) = ns::searcher::query::execute_search(&root, "quokka", &fuzzy_opts)
+        .expect("fuzzy search should work");
+    let paths: Vec<&str> = results.iter().map(|r| r.path.as_str()).collect();
+    assert_eq!(paths, vec!["exact.txt", "near.txt"]);
+    assert!(results[0].score > results[1].score);
+}
+
 #[test]
 fn case_insensitive_search_matches() {
     let (_tmp, root) = common::indexed_fixture();
@@ -498,13 +732,111 @@ fn case_insensitive_search_matches() {
     assert!(results_pascal.iter().any(|r| r.path.contains("event_store.rs")));
 }
 
+#[test]
+fn camel_case_identifier_parts_are_searchable() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    // server.go has `ReadTimeout` and `WriteTimeout` but never a bare "timeout"
+    for query in ["timeout", "readtimeout", "ReadTimeout"] {
+        let (results, _) = ns::searcher::query::execute_search(&root, query, &opts(10))
+            .expect("search should work");
+        assert!(
+            results.iter().any(|r| r.path.contains("server.go")),
+            "'{}' should find server.go, got: {:?}",
+            query,
+            results.iter().map(|r| &r.path).collect::<Vec<_>>()
+        );
+    }
+}
+
+#[test]
+fn go_method_receiver_and_name_are_searchable() {
+    let (_tmp, root) = common::isolated_fixture();
+    fs::write(
+        root.join("src/worker.go"),
+        "package main\n\nfunc (s *Server) Start() error {\n\treturn nil\n}\n",
+    )
+    .unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    for query in ["server", "start"] {
+        let (results, _) = ns::searcher::query::execute_search(&root, query, &opts(10))
+            .expect("search should work");
+        assert!(
+            results.iter().any(|r| r.path.contains("worker.go")),
+            "'{}' should find worker.go",
+            query
+        );
+    }
+}
+
+#[test]
+fn boolean_and_requires_all_terms() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    // "server" is in server.go and README.md; "start" only co-occurs in server.go
+    let (results, _) = ns::searcher::query::execute_search(&root, "server AND start", &opts(10))
+        .expect("search should work");
+    let paths: Vec<&str> = results.iter().map(|r| r.path.as_str()).collect();
+    assert_eq!(paths, vec!["src/server.go"]);
+}
+
+#[test]
+fn boolean_not_excludes_files() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let (results, _) =
+        ns::searcher::query::execute_search(&root, "server AND start NOT graceful", &opts(10))
+            .expect("search should work");
+    assert!(results.is_empty(), "server.go mentions 'graceful' and should be excluded");
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "server NOT graceful", &opts(10))
+        .expect("search should work");
+    assert!(!results.is_empty());
+    assert!(results.iter().all(|r| !r.path.contains("server.go")));
+}
+
+#[test]
+fn phrase_query_requires_adjacent_terms() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let (results, _) =
+        ns::searcher::query::execute_search(&root, "\"graceful shutdown\"", &opts(10))
+            .expect("search should work");
+    assert!(results.iter().any(|r| r.path.contains("server.go")));
+
+    let (results, _) =
+        ns::searcher::query::execute_search(&root, "\"shutdown graceful\"", &opts(10))
+            .expect("search should work");
+    assert!(results.is_empty(), "reversed phrase should not match");
+}
+
+#[test]
+fn plain_multiterm_query_is_still_or() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "graceful debounce", &opts(10))
+        .expect("search should work");
+    assert!(results.iter().any(|r| r.path.contains("server.go")));
+    assert!(results.iter().any(|r| r.path.contains("utils.js")));
+}
+
+#[test]
+fn boolean_syntax_error_is_query_parse_error() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let err = ns::searcher::query::execute_search(&root, "server AND", &opts(10))
+        .expect_err("dangling operator should fail");
+    assert!(matches!(err, ns::error::NsError::QueryParse(_)));
+}
+
 #[test]
 fn language_filter_with_fuzzy() {
     let (_tmp, root) = common::indexed_fixture();
 
     let fuzzy_rust_opts = SearchOptions {
         max_results: 10,
-        file_type: Some("rust".to_string()),
+        languages: vec!["rust".to_string()],
         fuzzy: true,
         ..Default::default()
     };
@@ -528,7 +860,7 @@ fn language_filter_with_sym_only() {
 
     let sym_rust_opts = SearchOptions {
         max_results: 10,
-        file_type: Some("rust".to_string()),
+        languages: vec!["rust".to_string()],
         sym_only: true,
         ..Default::default()
     };
@@ -571,7 +903,8 @@ fn search_with_stale_schema_version_returns_error() {
     // Tamper with meta.json to simulate a stale schema version
     let meta_path = root.join(".ns").join("meta.json");
     let content = std::fs::read_to_string(&meta_path).expect("should read meta");
-    let tampered = content.replace("\"schema_version\":2", "\"schema_version\":999");
+    let current = format!("\"schema_version\":{}", ns::indexer::writer::SCHEMA_VERSION);
+    let tampered = content.replace(&current, "\"schema_version\":999");
     std::fs::write(&meta_path, &tampered).expect("should write tampered meta");
 
     let result = ns::searcher::search(
@@ -597,7 +930,7 @@ fn glob_and_language_filter_combined() {
 
     let combo_opts = SearchOptions {
         max_results: 10,
-        file_type: Some("rust".to_string()),
+        languages: vec!["rust".to_string()],
         file_glob: Some("src/event_*".to_string()),
         ..Default::default()
     };
@@ -683,6 +1016,58 @@ fn context_window_larger_shows_more_lines() {
     );
 }
 
+#[test]
+fn after_context_only_shows_following_lines() {
+    let (_tmp, root) = common::isolated_fixture();
+    std::fs::write(
+        root.join("src/steps.rs"),
+        "// one\n// two\nfn platypus_step() {}\n// four\n// five\n// six\n",
+    )
+    .unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let after_opts = SearchOptions {
+        max_results: 1,
+        context_before: Some(0),
+        context_after: Some(2),
+        ..Default::default()
+    };
+    let so = ns::searcher::search(&root, "platypus", OutputMode::Json, &after_opts)
+        .expect("search should work");
+    let parsed: serde_json::Value = serde_json::from_str(&so.formatted).unwrap();
+    let nums: Vec<u64> = parsed["results"][0]["lines"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .map(|l| l["num"].as_u64().unwrap())
+        .collect();
+    assert_eq!(nums, vec![3, 4, 5]);
+}
+
+#[test]
+fn minified_line_is_shortened_around_match() {
+    let (_tmp, root) = common::isolated_fixture();
+    let minified = format!(
+        "var a=1;{}function echidnaInit(){{}}{}",
+        "x=1;".repeat(400),
+        "y=2;".repeat(400)
+    );
+    std::fs::write(root.join("src/bundle.min.js"), &minified).unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let so = ns::searcher::search(&root, "echidnaInit", OutputMode::Json, &opts(1))
+        .expect("search should work");
+    let parsed: serde_json::Value = serde_json::from_str(&so.formatted).unwrap();
+    let result = &parsed["results"][0];
+    let text = result["lines"][0]["text"].as_str().unwrap();
+    assert!(text.len() < 400, "line should be shortened, got {} bytes", text.len());
+    assert!(text.starts_with("...") && text.ends_with("..."));
+
+    let m = &result["matches"][0];
+    let (start, end) = (m["start"].as_u64().unwrap() as usize, m["end"].as_u64().unwrap() as usize);
+    assert_eq!(&text[start..end], "echidnaInit");
+}
+
 #[test]
 fn end_to_end_index_incremental_search() {
     let (_tmp, root) = common::isolated_fixture();
@@ -815,7 +1200,8 @@ fn cli_search_stale_schema_stderr_and_exit_code() {
     // Tamper with meta.json
     let meta_path = root.join(".ns").join("meta.json");
     let content = std::fs::read_to_string(&meta_path).expect("should read meta");
-    let tampered = content.replace("\"schema_version\":2", "\"schema_version\":999");
+    let current = format!("\"schema_version\":{}", ns::indexer::writer::SCHEMA_VERSION);
+    let tampered = content.replace(&current, "\"schema_version\":999");
     std::fs::write(&meta_path, &tampered).expect("should write tampered meta");
 
     let output = std::process::Command::new(ns_binary())
@@ -893,6 +1279,52 @@ fn cli_no_results_json_exits_1_with_json_on_stdout() {
     assert_eq!(parsed["stats"]["total_results"], 0);
 }
 
+#[test]
+fn json_lines_output_has_one_result_per_line() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let so = ns::searcher::search(&root, "handler", OutputMode::JsonLines, &opts(3))
+        .expect("search should work");
+    let lines: Vec<&str> = so.formatted.lines().collect();
+    assert_eq!(lines.len(), so.stats.total_results);
+    assert!(lines.len() > 1);
+    for (i, line) in lines.iter().enumerate() {
+        let value: serde_json::Value = serde_json::from_str(line).expect("each line is JSON");
+        assert_eq!(value["rank"], i + 1);
+        assert!(value["path"].is_string());
+        assert!(value["score"].is_number());
+        assert!(value["lines"].is_array());
+    }
+
+    let empty = ns::searcher::search(&root, "xyzzy_nonexistent_42", OutputMode::JsonLines, &opts(3))
+        .expect("search should succeed even with no results");
+    assert!(empty.formatted.is_empty());
+}
+
+#[test]
+fn cli_format_flag_selects_output() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let run = |format: &str| {
+        let output = std::process::Command::new(ns_binary())
+            .args(["--format", format, "--", "EventStore"])
+            .current_dir(&root)
+            .output()
+            .expect("should run ns binary");
+        assert!(output.status.success());
+        String::from_utf8(output.stdout).unwrap()
+    };
+
+    let json: serde_json::Value = serde_json::from_str(&run("json")).expect("valid JSON");
+    assert!(json["results"].as_array().is_some_and(|r| !r.is_empty()));
+    for line in run("jsonl").lines() {
+        serde_json::from_str::<serde_json::Value>(line).expect("each line is JSON");
+    }
+    // Piped text output is never colored.
+    let text = run("text");
+    assert!(text.contains("[1]") && !text.contains('\x1b'));
+}
+
 #[test]
 fn cli_search_success_exits_0() {
     let (_tmp, root) = common::indexed_fixture();
@@ -1146,6 +1578,53 @@ fn cli_logs_normalized_flags_and_raw_argv() {
     assert_eq!(argv[6], "--sym");
 }
 
+#[test]
+fn cli_offset_pages_results_and_reports_total() {
+    let (_tmp, root) = tied_fixture(4);
+
+    let output = std::process::Command::new(ns_binary())
+        .args(["--json", "-m", "2", "--offset", "1", "--", "wombat"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(output.status.success());
+
+    let parsed: serde_json::Value =
+        serde_json::from_slice(&output.stdout).expect("should be valid JSON");
+    let results = parsed["results"].as_array().unwrap();
+    assert_eq!(results.len(), 2);
+    assert_eq!(results[0]["path"], "src/tied_01.rs");
+    assert_eq!(results[0]["rank"], 2, "ranks count from the start of the full list");
+    assert_eq!(parsed["stats"]["total_results"], 2);
+    assert_eq!(parsed["stats"]["total_matches"], 4);
+    assert!(
+        String::from_utf8_lossy(&output.stderr).contains("2 of 4 results"),
+        "summary should show the page against the total"
+    );
+
+    let logs = read_search_log_entries(&root);
+    assert_eq!(logs[0]["flags"]["offset"], 1);
+}
+
+#[test]
+fn cli_type_accepts_comma_separated_languages() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let output = std::process::Command::new(ns_binary())
+        .args(["-t", "go,python", "-t", "rust", "-l", "server models event"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(output.status.success());
+
+    let stdout = String::from_utf8_lossy(&output.stdout);
+    assert!(stdout.lines().all(|p| p.ends_with(".go") || p.ends_with(".py") || p.ends_with(".rs")));
+    assert!(stdout.contains("server.go"));
+
+    let logs = read_search_log_entries(&root);
+    assert_eq!(logs[0]["flags"]["file_type"], "go,python,rust");
+}
+
 // ── Feature 5: Explainable ranking tests ──────────────────────────────────────
 
 #[test]
@@ -1214,7 +1693,7 @@ fn content_only_match_has_zero_bm25_symbols() {
     // "pub" appears in Rust source content but is not a symbol name.
     let sym_opts = SearchOptions {
         max_results: 10,
-        file_type: Some("rust".to_string()),
+        languages: vec!["rust".to_string()],
         ..Default::default()
     };
     let (results, _stats) =
@@ -1512,3 +1991,2407 @@ fn cli_budget_flag_works() {
         "should find event_store.rs"
     );
 }
+
+// ── Regex search ──────────────────────────────────────────────────────────────
+
+fn regex_opts() -> SearchOptions {
+    SearchOptions {
+        regex: true,
+        context_window: 0,
+        ..Default::default()
+    }
+}
+
+fn regex_fixture() -> (tempfile::TempDir, std::path::PathBuf) {
+    let (tmp, root) = common::isolated_fixture();
+    fs::write(
+        root.join("src/status.go"),
+        "package main\n\nconst mask = 0x1f\n\nfunc ok() int { return http.StatusOK }\n",
+    )
+    .unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+    (tmp, root)
+}
+
+#[test]
+fn regex_finds_patterns_the_tokenizer_splits() {
+    let (_tmp, root) = regex_fixture();
+
+    for pattern in [r"0x[0-9a-f]+", r"http\.Status\w+"] {
+        let so = ns::searcher::search(&root, pattern, OutputMode::FilesOnly, &regex_opts())
+            .expect("regex search should work");
+        assert_eq!(so.formatted, "src/status.go\n", "pattern {pattern}");
+        assert!(!so.timed_out);
+    }
+}
+
+#[test]
+fn regex_is_case_sensitive_unless_ignore_case() {
+    let (_tmp, root) = regex_fixture();
+
+    let so = ns::searcher::search(&root, "HTTP\\.status", OutputMode::FilesOnly, &regex_opts())
+        .unwrap();
+    assert_eq!(so.stats.total_results, 0);
+
+    let opts = SearchOptions {
+        ignore_case: true,
+        ..regex_opts()
+    };
+    let so = ns::searcher::search(&root, "HTTP\\.status", OutputMode::FilesOnly, &opts).unwrap();
+    assert_eq!(so.formatted, "src/status.go\n");
+}
+
+#[test]
+fn regex_json_reports_match_offsets() {
+    let (_tmp, root) = regex_fixture();
+
+    let so = ns::searcher::search(&root, r"Status\w+", OutputMode::Json, &regex_opts()).unwrap();
+    let parsed: serde_json::Value = serde_json::from_str(&so.formatted).unwrap();
+    let first = &parsed["results"][0];
+    assert_eq!(first["path"], "src/status.go");
+
+    let line = first["lines"][0]["text"].as_str().unwrap();
+    let m = &first["matches"][0];
+    assert_eq!(m["line"], 5);
+    assert_eq!(m["term"], "StatusOK");
+    let (start, end) = (m["start"].as_u64().unwrap() as usize, m["end"].as_u64().unwrap() as usize);
+    assert_eq!(&line[start..end], "StatusOK");
+}
+
+#[test]
+fn regex_timeout_returns_partial_result() {
+    let (_tmp, root) = regex_fixture();
+
+    let opts = SearchOptions {
+        regex_timeout: Some(std::time::Duration::ZERO),
+        ..regex_opts()
+    };
+    let so = ns::searcher::search(&root, "e", OutputMode::Json, &opts).unwrap();
+    assert!(so.timed_out, "a zero timeout should stop the scan");
+    let parsed: serde_json::Value = serde_json::from_str(&so.formatted).unwrap();
+    assert_eq!(parsed["stats"]["timed_out"], true);
+}
+
+#[test]
+fn cli_invalid_regex_exits_1() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let output = std::process::Command::new(ns_binary())
+        .args(["--regex", "foo("])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+
+    assert_eq!(output.status.code(), Some(1));
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("error: invalid regex"), "got: {}", stderr);
+
+    let logs = read_search_log_entries(&root);
+    assert_eq!(logs[0]["error"]["code"], "invalid_regex");
+    assert_eq!(logs[0]["flags"]["regex"], true);
+}
+
+// ── Stop words ────────────────────────────────────────────────────────────────
+
+fn index_with_stop_words(root: &Path, lists: &[&str]) {
+    let lists: Vec<String> = lists.iter().map(|s| s.to_string()).collect();
+    let opts = ns::indexer::IndexOptions {
+        stop_words: ns::indexer::stopwords::resolve(&lists, &[]),
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(root, &opts).expect("indexing should succeed");
+}
+
+#[test]
+fn query_of_only_stop_words_returns_nothing() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    for fuzzy in [false, true] {
+        let stop_opts = SearchOptions { fuzzy, ..opts(10) };
+        let (results, stats) = ns::searcher::query::execute_search(&root, "the", &stop_opts)
+            .expect("search should work");
+        let paths: Vec<&str> = results.iter().map(|r| r.path.as_str()).collect();
+        assert!(paths.is_empty(), "fuzzy={fuzzy}: got {paths:?}");
+        assert_eq!(stats.total_matches, 0);
+    }
+}
+
+#[test]
+fn stop_words_in_query_do_not_change_results() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let paths = |q: &str| -> Vec<String> {
+        let (results, _) = ns::searcher::query::execute_search(&root, q, &opts(10))
+            .expect("search should work");
+        results.into_iter().map(|r| r.path).collect()
+    };
+    assert!(!paths("debounce").is_empty());
+    assert_eq!(paths("the debounce"), paths("debounce"));
+}
+
+#[test]
+fn disabled_stop_words_are_searchable() {
+    let (_tmp, root) = common::isolated_fixture();
+    index_with_stop_words(&root, &["none"]);
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "the", &opts(10))
+        .expect("search should work");
+    assert!(!results.is_empty(), "'the' is indexed when stop words are disabled");
+}
+
+#[test]
+fn code_stop_words_survive_incremental_updates() {
+    let (_tmp, root) = common::isolated_fixture();
+    index_with_stop_words(&root, &["english", "code"]);
+    fs::write(root.join("src/later.go"), "package later\n\nfunc numbat() {}\n").unwrap();
+    ns::indexer::run_incremental_index(&root, 1_048_576).expect("incremental should succeed");
+
+    let meta = ns::indexer::writer::read_meta(&root).unwrap();
+    assert!(meta.stop_words.contains(&"func".to_string()));
+    let (results, _) = ns::searcher::query::execute_search(&root, "func", &opts(10))
+        .expect("search should work");
+    assert!(results.is_empty(), "'func' is a code stop word");
+    let (results, _) = ns::searcher::query::execute_search(&root, "numbat", &opts(10))
+        .expect("search should work");
+    assert_eq!(results[0].path, "src/later.go");
+}
+
+#[test]
+fn regex_prefilter_skips_fragments_inside_stop_words() {
+    let (_tmp, root) = common::isolated_fixture();
+    fs::write(root.join("notes.txt"), "see the wallaby\n").unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let so = ns::searcher::search(&root, "the wallaby", OutputMode::FilesOnly, &regex_opts())
+        .expect("regex search should work");
+    assert_eq!(so.formatted, "notes.txt\n");
+}
+
+#[test]
+fn regex_prefilter_finds_words_inside_identifiers_too_long_to_index() {
+    let (_tmp, root) = common::isolated_fixture();
+    // 41 characters: indexed only as its parts, `abstract` ... `provider`.
+    fs::write(
+        root.join("beans.java"),
+        "class AbstractSingletonProxyFactoryBeanProvider {}\n",
+    )
+    .unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    for pattern in ["BeanProvider", r"Proxy\w+Bean", "tonProxyFac"] {
+        let so = ns::searcher::search(&root, pattern, OutputMode::FilesOnly, &regex_opts())
+            .expect("regex search should work");
+        assert_eq!(so.formatted, "beans.java\n", "pattern {pattern}");
+    }
+}
+
+// ── Stemming ──────────────────────────────────────────────────────────────────
+
+fn stemmed_fixture() -> (tempfile::TempDir, std::path::PathBuf) {
+    let (tmp, root) = common::isolated_fixture();
+    fs::write(root.join("notes.txt"), "retry the connection\nkeep running\n").unwrap();
+    let opts = ns::indexer::IndexOptions {
+        stemming: ns::indexer::tokenizer::Stemming::English,
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &opts).expect("indexing should succeed");
+    (tmp, root)
+}
+
+#[test]
+fn stemmed_index_matches_plural_and_verb_forms() {
+    let (_tmp, root) = stemmed_fixture();
+
+    for fuzzy in [false, true] {
+        for query in ["connections", "run"] {
+            let stem_opts = SearchOptions { fuzzy, ..opts(10) };
+            let (results, _) = ns::searcher::query::execute_search(&root, query, &stem_opts)
+                .expect("search should work");
+            let paths: Vec<&str> = results.iter().map(|r| r.path.as_str()).collect();
+            assert!(paths.contains(&"notes.txt"), "fuzzy={fuzzy} {query}: got {paths:?}");
+        }
+    }
+}
+
+#[test]
+fn unstemmed_index_needs_exact_forms() {
+    let (_tmp, root) = common::isolated_fixture();
+    fs::write(root.join("notes.txt"), "retry the connection\n").unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "connections", &opts(10))
+        .expect("search should work");
+    assert!(results.iter().all(|r| r.path != "notes.txt"));
+    assert!(ns::indexer::writer::read_meta(&root).unwrap().stemmer.is_none());
+}
+
+#[test]
+fn stemming_survives_incremental_and_highlights_original_lines() {
+    let (_tmp, root) = stemmed_fixture();
+    fs::write(root.join("later.txt"), "two connections dropped\n").unwrap();
+    ns::indexer::run_incremental_index(&root, 1_048_576).expect("incremental should succeed");
+
+    let meta = ns::indexer::writer::read_meta(&root).unwrap();
+    assert_eq!(meta.stemmer.as_deref(), Some("english"));
+
+    let so = ns::searcher::search(&root, "connections", OutputMode::Text, &opts(10))
+        .expect("search should work");
+    assert!(so.formatted.contains("later.txt"));
+    assert!(so.formatted.contains("retry the connection"), "got:\n{}", so.formatted);
+}
+
+#[test]
+fn regex_search_finds_surface_forms_in_stemmed_index() {
+    let (_tmp, root) = stemmed_fixture();
+
+    let so = ns::searcher::search(&root, "connection", OutputMode::FilesOnly, &regex_opts())
+        .expect("regex search should work");
+    assert_eq!(so.formatted, "notes.txt\n");
+}
+
+#[test]
+fn unknown_stemmer_in_meta_is_an_error() {
+    let (_tmp, root) = stemmed_fixture();
+    let meta_path = root.join(".ns/meta.json");
+    let meta = fs::read_to_string(&meta_path).unwrap();
+    fs::write(&meta_path, meta.replace("\"english\"", "\"klingon\"")).unwrap();
+
+    let err = ns::searcher::query::execute_search(&root, "connection", &opts(10))
+        .expect_err("unknown stemmer should fail");
+    assert!(matches!(err, ns::error::NsError::UnsupportedStemmer(ref name) if name == "klingon"));
+}
+
+#[test]
+fn trigram_index_narrows_regex_candidates_without_missing_matches() {
+    let tmp = tempfile::tempdir().unwrap();
+    let plain = tmp.path().join("plain");
+    let trigram = tmp.path().join("trigram");
+    for root in [&plain, &trigram] {
+        fs::create_dir_all(root).unwrap();
+        fs::write(root.join("read.go"), "conn.SetReadDeadline(t)\n").unwrap();
+        fs::write(root.join("write.go"), "conn.SETWRITEDEADLINE(t)\n").unwrap();
+        fs::write(root.join("late.go"), "nothing here yet\n").unwrap();
+        let opts = ns::indexer::IndexOptions {
+            trigrams: root == &trigram,
+            ..Default::default()
+        };
+        ns::indexer::run_full_index_with_options(root, &opts).expect("indexing should succeed");
+        // Written after the build: only a full scan of the files sees it.
+        fs::write(root.join("late.go"), "conn.SetReadDeadline(t)\n").unwrap();
+    }
+    assert!(ns::indexer::writer::read_meta(&trigram).unwrap().trigrams);
+
+    let files = |root: &Path, pattern: &str, ignore_case: bool| {
+        let search_opts = SearchOptions {
+            ignore_case,
+            ..regex_opts()
+        };
+        ns::searcher::search(root, pattern, OutputMode::FilesOnly, &search_opts)
+            .unwrap()
+            .formatted
+    };
+    // The alternation has no word every match needs, so only trigrams narrow it.
+    let pattern = "ReadDeadline|WriteDeadline";
+    assert_eq!(files(&plain, pattern, true), "late.go\nread.go\nwrite.go\n");
+    assert_eq!(files(&trigram, pattern, true), "read.go\nwrite.go\n");
+    assert_eq!(files(&trigram, pattern, false), "read.go\n");
+    assert_eq!(files(&trigram, r"Set\w+Deadline\(", false), "read.go\n");
+    assert_eq!(files(&trigram, "(?i)set.*dead", false), "read.go\nwrite.go\n");
+
+    let (matches, _) =
+        ns::searcher::substring::search_substring(&trigram, "tWriteD", &opts(10)).unwrap();
+    assert!(matches.is_empty());
+    let ignore_case = SearchOptions {
+        ignore_case: true,
+        ..opts(10)
+    };
+    let (matches, _) =
+        ns::searcher::substring::search_substring(&trigram, "tWriteD", &ignore_case).unwrap();
+    let paths: Vec<String> = matches.into_iter().map(|m| m.path).collect();
+    assert_eq!(paths, vec!["write.go"]);
+}
+
+// ── Multi-index search ────────────────────────────────────────────────────────
+
+/// Indexes the fixture repo and a second, smaller repo next to it, each
+/// holding an identical `shared.txt`.
+fn two_repos() -> (tempfile::TempDir, std::path::PathBuf, std::path::PathBuf) {
+    let (tmp, repo) = common::isolated_fixture();
+    let other = tmp.path().join("other");
+    fs::create_dir_all(other.join("lib")).unwrap();
+    fs::write(other.join("lib/throttle.py"), "def debounce(fn, wait):\n    return fn\n").unwrap();
+    fs::write(other.join("lib/util.py"), "def noop():\n    pass\n").unwrap();
+    for root in [&repo, &other] {
+        fs::write(root.join("shared.txt"), "zebrafish notes\n").unwrap();
+        ns::indexer::run_full_index(root, 1_048_576).expect("indexing should succeed");
+    }
+    (tmp, repo, other)
+}
+
+#[test]
+fn multi_search_returns_hits_from_every_repo() {
+    let (_tmp, repo, other) = two_repos();
+    let multi = ns::searcher::multi::MultiSearcher::open(&[repo.clone(), other.clone()])
+        .expect("indexes should open");
+    assert_eq!(multi.repos(), vec!["repo", "other"]);
+
+    let (results, stats) = multi.search("debounce", &opts(20)).expect("search should work");
+    let hits: Vec<(&str, &str)> =
+        results.iter().map(|r| (r.repo.as_str(), r.result.path.as_str())).collect();
+    assert!(hits.iter().any(|(repo, _)| *repo == "repo"), "got {hits:?}");
+    assert!(hits.contains(&("other", "lib/throttle.py")), "got {hits:?}");
+    assert!(results.windows(2).all(|w| w[0].result.score >= w[1].result.score));
+    assert!(results.iter().all(|r| r.root.join(&r.result.path).is_file()));
+    assert_eq!(stats.total_matches, results.len());
+}
+
+#[test]
+fn multi_search_scores_are_comparable_across_repos() {
+    let (_tmp, repo, other) = two_repos();
+
+    // Ranked alone, each index weighs the term by its own document count,
+    // so the identical file scores differently in each repo.
+    let alone = |root: &Path| {
+        let (results, _) = ns::searcher::query::execute_search(root, "zebrafish", &opts(10))
+            .expect("search should work");
+        results[0].score
+    };
+    assert_ne!(alone(&repo), alone(&other));
+
+    let multi = ns::searcher::multi::MultiSearcher::open(&[other.clone(), repo.clone()])
+        .expect("indexes should open");
+    let (results, _) = multi.search("zebrafish", &opts(10)).expect("search should work");
+    let hits: Vec<(&str, &str)> =
+        results.iter().map(|r| (r.repo.as_str(), r.result.path.as_str())).collect();
+    assert_eq!(hits, vec![("other", "shared.txt"), ("repo", "shared.txt")]);
+    assert_eq!(results[0].result.score, results[1].result.score);
+}
+
+#[test]
+fn multi_search_pages_across_repos() {
+    let (_tmp, repo, other) = two_repos();
+    let multi = ns::searcher::multi::MultiSearcher::open(&[repo, other])
+        .expect("indexes should open");
+
+    let key = |r: &ns::searcher::multi::MultiSearchResult| (r.repo.clone(), r.result.path.clone());
+    let (all, _) = multi.search("def debounce", &opts(50)).expect("search should work");
+    let mut paged = Vec::new();
+    for offset in (0..all.len()).step_by(2) {
+        let page_opts = SearchOptions { offset, ..opts(2) };
+        let (page, stats) = multi.search("def debounce", &page_opts).expect("search should work");
+        assert_eq!(stats.total_matches, all.len());
+        paged.extend(page.iter().map(key));
+    }
+    assert_eq!(paged, all.iter().map(key).collect::<Vec<_>>());
+}
+
+/// Indexes `root` with one declared field, `field`, set to `install notes`
+/// for each of `with_values`, and rebuilt so no deleted documents remain.
+fn declared_field_repo(root: &Path, field: &str, with_values: &[&str]) {
+    fs::create_dir_all(root).unwrap();
+    for name in ["guide.md", "a.md", "b.md"] {
+        fs::write(root.join(name), "Release notes.\n").unwrap();
+    }
+    let opts = ns::indexer::IndexOptions {
+        fields: vec![ns::indexer::fields::DocField {
+            name: field.to_string(),
+            boost: 3.0,
+        }],
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(root, &opts).expect("indexing should succeed");
+    let values = BTreeMap::from([(field.to_string(), "install notes".to_string())]);
+    for path in with_values {
+        ns::indexer::index_fields(root, path, &values, 1_048_576).unwrap();
+    }
+    ns::indexer::run_full_index_with_options(root, &opts).expect("indexing should succeed");
+}
+
+#[test]
+fn multi_search_keeps_statistics_of_different_declared_fields_apart() {
+    let tmp = tempfile::tempdir().unwrap();
+    let titled = tmp.path().join("titled");
+    declared_field_repo(&titled, "title", &["guide.md"]);
+    // Both `summary` fields have the `Field` id of `titled`'s `title`.
+    let (few, many) = (tmp.path().join("few"), tmp.path().join("many"));
+    declared_field_repo(&few, "summary", &["a.md"]);
+    declared_field_repo(&many, "summary", &["a.md", "b.md", "guide.md"]);
+
+    let titled_score = |other: &Path| {
+        let multi = ns::searcher::multi::MultiSearcher::open(&[titled.clone(), other.to_path_buf()])
+            .expect("indexes should open");
+        let (results, _) = multi.search("install", &opts(10)).expect("search should work");
+        let hit = results.iter().find(|r| r.repo == "titled").expect("titled should match");
+        assert_eq!(hit.result.path, "guide.md");
+        hit.result.score
+    };
+    // How many files hold `install` in another repo's `summary` doesn't
+    // change how rare it is in `title`.
+    assert_eq!(titled_score(&few), titled_score(&many));
+}
+
+// ── Suggest ───────────────────────────────────────────────────────────────────
+
+fn suggest_fixture() -> tempfile::TempDir {
+    let tmp = tempfile::tempdir().unwrap();
+    fs::write(tmp.path().join("a.txt"), "server service\n").unwrap();
+    fs::write(tmp.path().join("b.txt"), "server serve\n").unwrap();
+    fs::write(tmp.path().join("c.txt"), "server sieve\n").unwrap();
+    ns::indexer::run_full_index(tmp.path(), 1_048_576).expect("indexing should succeed");
+    tmp
+}
+
+fn suggestion_pairs(suggestions: &[ns::searcher::suggest::Suggestion]) -> Vec<(&str, u64)> {
+    suggestions.iter().map(|s| (s.term.as_str(), s.doc_freq)).collect()
+}
+
+#[test]
+fn suggest_completes_prefix_by_document_frequency() {
+    let tmp = suggest_fixture();
+    let suggestions = ns::searcher::suggest::suggest(tmp.path(), "serv", 10, false).unwrap();
+    assert_eq!(
+        suggestion_pairs(&suggestions),
+        vec![("server", 3), ("serve", 1), ("service", 1)]
+    );
+
+    let top = ns::searcher::suggest::suggest(tmp.path(), "serv", 1, false).unwrap();
+    assert_eq!(suggestion_pairs(&top), vec![("server", 3)]);
+}
+
+#[test]
+fn suggest_empty_prefix_returns_most_frequent_terms() {
+    let tmp = suggest_fixture();
+    let suggestions = ns::searcher::suggest::suggest(tmp.path(), "", 2, false).unwrap();
+    assert_eq!(suggestion_pairs(&suggestions), vec![("server", 3), ("serve", 1)]);
+}
+
+#[test]
+fn suggest_case_sensitivity_is_optional() {
+    let tmp = suggest_fixture();
+    let insensitive = ns::searcher::suggest::suggest(tmp.path(), "SERV", 10, true).unwrap();
+    assert_eq!(insensitive.len(), 3);
+    let sensitive = ns::searcher::suggest::suggest(tmp.path(), "SERV", 10, false).unwrap();
+    assert!(sensitive.is_empty(), "indexed terms are lowercase");
+}
+
+#[test]
+fn cli_suggest_prints_terms_and_frequencies() {
+    let tmp = suggest_fixture();
+    let output = std::process::Command::new(ns_binary())
+        .args(["suggest", "serv", "-n", "2"])
+        .current_dir(tmp.path())
+        .output()
+        .expect("should run ns binary");
+    assert!(output.status.success());
+    assert_eq!(String::from_utf8_lossy(&output.stdout), "server\t3\nserve\t1\n");
+}
+
+#[test]
+fn zero_result_query_suggests_closest_terms() {
+    let tmp = suggest_fixture();
+    let dym = |q: &str| ns::searcher::suggest::did_you_mean(tmp.path(), q).unwrap();
+
+    assert_eq!(dym("serevr"), vec!["server", "serve"]);
+    // Only the misspelt word of a multi-term query is corrected.
+    assert_eq!(dym("sieve serevr")[0], "sieve server");
+    assert!(dym("service AND sieve").is_empty(), "every word is indexed");
+
+    let so = ns::searcher::search(tmp.path(), "serevr", OutputMode::Json, &opts(10)).unwrap();
+    assert_eq!(so.stats.total_matches, 0);
+    assert_eq!(so.suggestions, vec!["server", "serve"]);
+    let parsed: serde_json::Value = serde_json::from_str(&so.formatted).unwrap();
+    assert_eq!(parsed["suggestions"][0], "server");
+
+    let so = ns::searcher::search(tmp.path(), "server", OutputMode::Json, &opts(10)).unwrap();
+    assert!(so.suggestions.is_empty(), "no suggestions when the query matches");
+}
+
+#[test]
+fn cli_zero_results_prints_suggestion() {
+    let tmp = suggest_fixture();
+    let output = std::process::Command::new(ns_binary())
+        .arg("serevr")
+        .current_dir(tmp.path())
+        .output()
+        .expect("should run ns binary");
+    assert_eq!(output.status.code(), Some(1));
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("did you mean: 'server', 'serve'?"), "stderr: {}", stderr);
+}
+
+// ── Definitions ───────────────────────────────────────────────────────────────
+
+fn index_with_definitions(root: &Path) {
+    let opts = ns::indexer::IndexOptions {
+        definitions: true,
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(root, &opts).expect("indexing should succeed");
+}
+
+fn definition_sites(root: &Path, name: &str) -> Vec<(String, &'static str, usize)> {
+    ns::searcher::definitions::search_symbols(root, name, &[])
+        .expect("definition search should work")
+        .into_iter()
+        .map(|h| (h.path, h.definition.kind.name(), h.definition.line))
+        .collect()
+}
+
+#[test]
+fn definitions_find_go_types_and_functions() {
+    let (_tmp, root) = common::isolated_fixture();
+    index_with_definitions(&root);
+
+    let src = |s: &str| s.to_string();
+    assert_eq!(definition_sites(&root, "Server"), vec![(src("src/server.go"), "type", 19)]);
+    assert_eq!(definition_sites(&root, "NewServer"), vec![(src("src/server.go"), "func", 27)]);
+
+    use ns::indexer::definitions::SymbolKind;
+    let funcs = ns::searcher::definitions::search_symbols(&root, "Server", &[SymbolKind::Func])
+        .expect("definition search should work");
+    assert!(funcs.is_empty(), "Server is a type, not a func");
+
+    // The full-text index still covers every file.
+    let (results, _) = ns::searcher::query::execute_search(&root, "EventStore", &opts(10))
+        .expect("search should work");
+    assert!(results[0].path.contains("event_store.rs"));
+}
+
+#[test]
+fn definitions_require_opt_in_and_are_dropped_by_plain_rebuild() {
+    let (_tmp, root) = common::isolated_fixture();
+    index_with_definitions(&root);
+    assert!(root.join(".ns/definitions.json").exists());
+
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+    assert!(!root.join(".ns/definitions.json").exists());
+    let err = ns::searcher::definitions::search_symbols(&root, "Server", &[])
+        .expect_err("definitions were not recorded");
+    assert!(matches!(err, ns::error::NsError::NoDefinitions));
+}
+
+#[test]
+fn definitions_follow_incremental_updates() {
+    let (_tmp, root) = common::isolated_fixture();
+    index_with_definitions(&root);
+
+    fs::write(root.join("src/later.go"), "package main\n\nfunc Later() {}\n").unwrap();
+    fs::remove_file(root.join("src/server.go")).unwrap();
+    ns::indexer::run_incremental_index(&root, 1_048_576).expect("incremental should succeed");
+
+    assert_eq!(definition_sites(&root, "Later"), vec![("src/later.go".to_string(), "func", 3)]);
+    assert!(definition_sites(&root, "Server").is_empty());
+}
+
+#[test]
+fn cli_def_prints_definition_sites() {
+    let (_tmp, root) = common::isolated_fixture();
+    index_with_definitions(&root);
+
+    let output = std::process::Command::new(ns_binary())
+        .args(["def", "NewServer"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(output.status.success());
+    assert_eq!(String::from_utf8_lossy(&output.stdout), "src/server.go:27:6: func NewServer\n");
+}
+
+// ── Field filters ─────────────────────────────────────────────────────────────
+
+fn result_paths(root: &Path, query: &str) -> Vec<String> {
+    let (results, _) = ns::searcher::query::execute_search(root, query, &opts(20))
+        .expect("search should work");
+    let mut paths: Vec<String> = results.into_iter().map(|r| r.path).collect();
+    paths.sort();
+    paths
+}
+
+#[test]
+fn path_filter_restricts_results() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    assert!(result_paths(&root, "handler").contains(&"README.md".to_string()));
+    let paths = result_paths(&root, "handler path:src/");
+    assert!(!paths.is_empty());
+    assert!(paths.iter().all(|p| p.starts_with("src/")), "got {:?}", paths);
+
+    assert_eq!(result_paths(&root, "handler path:src/*.go"), vec!["src/server.go"]);
+}
+
+#[test]
+fn lang_and_ext_filters_restrict_results() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    assert_eq!(result_paths(&root, "handler lang:go"), vec!["src/server.go"]);
+    assert_eq!(result_paths(&root, "event ext:rs"), vec!["src/event_store.rs"]);
+    assert_eq!(result_paths(&root, "event AND ext:.RS"), vec!["src/event_store.rs"]);
+}
+
+#[test]
+fn negated_filters_exclude_results() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let paths = result_paths(&root, "event -ext:md -lang:elixir");
+    assert_eq!(paths, vec!["src/event_store.rs", "src/utils.js"]);
+}
+
+#[test]
+fn filters_alone_list_matching_files() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let (results, stats) = ns::searcher::query::execute_search(&root, "lang:rust", &opts(20))
+        .expect("search should work");
+    let mut paths: Vec<&str> = results.iter().map(|r| r.path.as_str()).collect();
+    paths.sort();
+    assert_eq!(paths, vec!["src/event_store.rs", "src/validator.rs"]);
+    assert_eq!(stats.total_matches, 2);
+}
+
+#[test]
+fn unknown_field_is_an_invalid_query() {
+    let (_tmp, root) = common::indexed_fixture();
+
+    let err = ns::searcher::query::execute_search(&root, "handler file:server", &opts(10))
+        .expect_err("unknown field should fail");
+    assert!(matches!(err, ns::error::NsError::QueryParse(_)));
+
+    let output = std::process::Command::new(ns_binary())
+        .args(["--", "handler file:server"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(!output.status.success());
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("unknown field 'file'"), "stderr: {}", stderr);
+}
+
+// ── Case-sensitive search ─────────────────────────────────────────────────────
+
+fn case_fixture(case_sensitive: bool) -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().join("repo");
+    fs::create_dir_all(&root).unwrap();
+    fs::write(root.join("result.rs"), "fn parse() -> Result<u8, Error> {\n    Err(Error::Empty)\n}\n").unwrap();
+    fs::write(root.join("check.go"), "package main\n\nfunc check(err error) bool {\n    return err != nil\n}\n").unwrap();
+    let opts = ns::indexer::IndexOptions {
+        case_sensitive,
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &opts).expect("indexing should succeed");
+    (tmp, root)
+}
+
+fn case_sensitive_opts() -> SearchOptions {
+    SearchOptions {
+        case_sensitive: true,
+        ..opts(10)
+    }
+}
+
+fn case_paths(root: &Path, query: &str, opts: &SearchOptions) -> Vec<String> {
+    let (results, _) = ns::searcher::query::execute_search(root, query, opts)
+        .expect("search should work");
+    let mut paths: Vec<String> = results.into_iter().map(|r| r.path).collect();
+    paths.sort();
+    paths
+}
+
+#[test]
+fn case_sensitive_search_tells_err_from_upper_err() {
+    let (_tmp, root) = case_fixture(true);
+
+    assert_eq!(case_paths(&root, "Err", &case_sensitive_opts()), vec!["result.rs"]);
+    assert_eq!(case_paths(&root, "err", &case_sensitive_opts()), vec!["check.go"]);
+    assert_eq!(case_paths(&root, "ERR", &case_sensitive_opts()), Vec::<String>::new());
+
+    // Without the option the same index still folds case.
+    assert_eq!(case_paths(&root, "Err", &opts(10)), vec!["check.go", "result.rs"]);
+}
+
+#[test]
+fn case_sensitive_search_on_folded_index_is_an_error() {
+    let (_tmp, root) = case_fixture(false);
+
+    let err = ns::searcher::query::execute_search(&root, "Err", &case_sensitive_opts())
+        .expect_err("folded index cannot answer case-sensitively");
+    assert!(matches!(err, ns::error::NsError::CaseFoldedIndex));
+
+    let output = std::process::Command::new(ns_binary())
+        .args(["-s", "--", "Err"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(!output.status.success());
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("ns index --case-sensitive"), "stderr: {}", stderr);
+}
+
+#[test]
+fn incremental_updates_keep_case() {
+    let (_tmp, root) = case_fixture(true);
+
+    fs::write(root.join("later.rs"), "fn later() -> Option<u8> { None }\n").unwrap();
+    ns::indexer::run_incremental_index(&root, 1_048_576).expect("incremental should succeed");
+
+    assert_eq!(case_paths(&root, "None", &case_sensitive_opts()), vec!["later.rs"]);
+    assert!(case_paths(&root, "none", &case_sensitive_opts()).is_empty());
+}
+
+// ── Search in files ───────────────────────────────────────────────────────────
+
+const FIXTURE_FILES: &[&str] = &[
+    "README.md",
+    "config.json",
+    "src/event_manager.ex",
+    "src/handlers.ts",
+    "src/utils.js",
+    "src/event_store.rs",
+    "src/server.go",
+    "src/validator.rs",
+    "src/models.py",
+];
+
+#[test]
+fn search_in_ranks_like_the_index_over_the_same_files() {
+    let (_tmp, root) = common::indexed_fixture();
+    let paths: Vec<_> = FIXTURE_FILES.iter().map(|f| root.join(f)).collect();
+
+    let (indexed, _) = ns::searcher::query::execute_search(&root, "event", &opts(20)).unwrap();
+    let (found, stats, errors) =
+        ns::searcher::search_in::search_in(&paths, "event", &opts(20)).unwrap();
+
+    assert!(errors.is_empty());
+    assert_eq!(stats.files_searched, FIXTURE_FILES.len());
+    let found_rel: Vec<String> = found
+        .iter()
+        .map(|r| {
+            Path::new(&r.path)
+                .strip_prefix(&root)
+                .unwrap()
+                .to_string_lossy()
+                .into_owned()
+        })
+        .collect();
+    let indexed_rel: Vec<&str> = indexed.iter().map(|r| r.path.as_str()).collect();
+    assert_eq!(found_rel, indexed_rel);
+    for (a, b) in found.iter().zip(&indexed) {
+        assert!((a.score - b.score).abs() < 1e-4, "{}: {} vs {}", b.path, a.score, b.score);
+    }
+}
+
+#[test]
+fn search_in_reports_missing_paths_and_searches_the_rest() {
+    let (_tmp, root) = common::isolated_fixture();
+    let missing = root.join("src/missing.rs");
+    let paths = vec![root.join("src/event_store.rs"), missing.clone(), root.join("src/server.go")];
+
+    let (results, stats, errors) =
+        ns::searcher::search_in::search_in(&paths, "event", &opts(10)).unwrap();
+
+    assert!(!root.join(".ns").exists(), "search_in must not create an index");
+    assert_eq!(stats.files_searched, 2);
+    assert!(!results.is_empty());
+    assert!(results.iter().all(|r| !r.path.ends_with("missing.rs")));
+    assert_eq!(errors.len(), 1);
+    assert_eq!(errors[0].path, missing);
+}
+
+// ── Filename boost ────────────────────────────────────────────────────────────
+
+/// A repo where `server.go` mentions "server" once and a long prose file
+/// mentions it thirty times.
+fn filename_boost_fixture() -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().join("repo");
+    fs::create_dir_all(root.join("docs")).unwrap();
+    fs::write(
+        root.join("server.go"),
+        "package main\n\n// Entry point for the server.\nfunc main() {\n\tlisten(8080)\n\tawaitShutdown()\n}\n\nfunc listen(port int) {\n\tbind(port)\n\taccept()\n}\n",
+    )
+    .unwrap();
+    fs::write(
+        root.join("docs/NOTES.md"),
+        "The server restarts when the server config changes, and every server writes logs.\n".repeat(10),
+    )
+    .unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+    (tmp, root)
+}
+
+#[test]
+fn filename_match_outranks_prose_mentions() {
+    let (_tmp, root) = filename_boost_fixture();
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "server", &opts(10)).unwrap();
+    let paths: Vec<&str> = results.iter().map(|r| r.path.as_str()).collect();
+    assert_eq!(paths, vec!["server.go", "docs/NOTES.md"]);
+    assert!(results[0].matched_fields.contains(&"path".to_string()));
+    assert!(!results[1].matched_fields.contains(&"path".to_string()));
+}
+
+#[test]
+fn zero_filename_boost_ranks_on_content_alone() {
+    let (_tmp, root) = filename_boost_fixture();
+    let no_boost = SearchOptions {
+        filename_boost: 0.0,
+        ..opts(10)
+    };
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "server", &no_boost).unwrap();
+    let paths: Vec<&str> = results.iter().map(|r| r.path.as_str()).collect();
+    assert_eq!(paths, vec!["docs/NOTES.md", "server.go"]);
+    assert!(results.iter().all(|r| !r.matched_fields.contains(&"path".to_string())));
+
+    // A path match alone never makes a file a hit.
+    let (results, _) = ns::searcher::query::execute_search(&root, "notes", &opts(10)).unwrap();
+    assert!(results.is_empty());
+}
+
+// ── Document metadata ─────────────────────────────────────────────────────────
+
+fn team(name: &str) -> std::collections::BTreeMap<String, String> {
+    std::collections::BTreeMap::from([("owner".to_string(), name.to_string())])
+}
+
+#[test]
+fn metadata_is_returned_with_results() {
+    let (_tmp, root) = common::indexed_fixture();
+    ns::indexer::index_with_meta(&root, "src/server.go", &team("platform"), 1_048_576)
+        .expect("recording metadata should succeed");
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "Server", &opts(10)).unwrap();
+    let server = results.iter().find(|r| r.path == "src/server.go").expect("server.go matches");
+    assert_eq!(server.meta, team("platform"));
+    assert!(results.iter().filter(|r| r.path != "src/server.go").all(|r| r.meta.is_empty()));
+
+    let so = ns::searcher::search(&root, "Server", OutputMode::Json, &opts(10)).unwrap();
+    let parsed: serde_json::Value = serde_json::from_str(&so.formatted).unwrap();
+    let server = parsed["results"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .find(|r| r["path"] == "src/server.go")
+        .unwrap();
+    assert_eq!(server["meta"]["owner"], "platform");
+}
+
+#[test]
+fn metadata_follows_renames_and_survives_rebuilds() {
+    let (_tmp, root) = common::indexed_fixture();
+    ns::indexer::index_with_meta(&root, "src/server.go", &team("platform"), 1_048_576).unwrap();
+    ns::indexer::index_with_meta(&root, "src/utils.js", &team("web"), 1_048_576).unwrap();
+
+    fs::rename(root.join("src/server.go"), root.join("src/http_server.go")).unwrap();
+    fs::remove_file(root.join("src/utils.js")).unwrap();
+    let stats = ns::indexer::run_incremental_index(&root, 1_048_576).unwrap();
+    assert_eq!((stats.renamed, stats.deleted), (1, 1));
+
+    ns::indexer::run_full_index(&root, 1_048_576).unwrap();
+    let metadata = ns::indexer::metadata::read_metadata(&root).unwrap();
+    assert_eq!(metadata.get("src/http_server.go"), team("platform"));
+    assert!(metadata.get("src/server.go").is_empty());
+    assert!(metadata.get("src/utils.js").is_empty());
+}
+
+// ── Weight rules ──────────────────────────────────────────────────────────────
+
+fn weight(glob: &str, multiplier: f32) -> WeightRule {
+    WeightRule {
+        glob: glob.to_string(),
+        multiplier,
+    }
+}
+
+#[test]
+fn weight_rules_demote_matching_paths() {
+    let (_tmp, root) = filename_boost_fixture();
+    let (plain, _) = ns::searcher::query::execute_search(&root, "server", &opts(10)).unwrap();
+    assert_eq!(plain[0].path, "server.go");
+
+    let demoted = SearchOptions {
+        weights: vec![weight("*.go", 0.1)],
+        ..opts(10)
+    };
+    let (results, stats) = ns::searcher::query::execute_search(&root, "server", &demoted).unwrap();
+    let paths: Vec<&str> = results.iter().map(|r| r.path.as_str()).collect();
+    assert_eq!(paths, vec!["docs/NOTES.md", "server.go"], "demoted files stay searchable");
+    assert_eq!(stats.total_matches, 2);
+    assert!((results[1].score - plain[0].score * 0.1).abs() < 1e-4);
+}
+
+#[test]
+fn matching_weight_rules_multiply() {
+    let (_tmp, root) = filename_boost_fixture();
+    let (plain, _) = ns::searcher::query::execute_search(&root, "server", &opts(10)).unwrap();
+
+    let cancelling = SearchOptions {
+        weights: vec![weight("*.go", 4.0), weight("server*", 0.25), weight("vendor/**", 0.1)],
+        ..opts(10)
+    };
+    let (results, _) = ns::searcher::query::execute_search(&root, "server", &cancelling).unwrap();
+    assert_eq!(results.len(), plain.len());
+    for (weighted, unweighted) in results.iter().zip(&plain) {
+        assert_eq!(weighted.path, unweighted.path);
+        assert!((weighted.score - unweighted.score).abs() < 1e-4);
+    }
+
+    let regex = SearchOptions {
+        regex: true,
+        weights: vec![weight("docs/**", 0.01)],
+        ..opts(10)
+    };
+    let so = ns::searcher::search(&root, "server", OutputMode::FilesOnly, &regex).unwrap();
+    assert_eq!(so.formatted.lines().next(), Some("server.go"));
+}
+
+// ── Index contents ────────────────────────────────────────────────────────────
+
+#[test]
+fn contents_lists_documents_and_terms_in_order() {
+    let tmp = tempfile::tempdir().unwrap();
+    fs::write(tmp.path().join("b.txt"), "server serve\n").unwrap();
+    fs::write(tmp.path().join("a.txt"), "server server service\n").unwrap();
+    fs::write(tmp.path().join("lib.rs"), "fn connect() {}\n").unwrap();
+    ns::indexer::run_full_index(tmp.path(), 1_048_576).expect("indexing should succeed");
+
+    let contents = ns::searcher::contents::IndexContents::open(tmp.path()).unwrap();
+    let docs: Vec<_> = contents.documents().unwrap().map(Result::unwrap).collect();
+    let paths: Vec<&str> = docs.iter().map(|d| d.path.as_str()).collect();
+    assert_eq!(paths, vec!["a.txt", "b.txt", "lib.rs"]);
+    assert_eq!(docs[2].lang.as_deref(), Some("rust"));
+    assert_eq!(docs[2].symbols, vec!["connect"]);
+
+    let terms: Vec<(String, u64, u64)> = contents
+        .terms()
+        .unwrap()
+        .map(|t| t.map(|t| (t.term, t.doc_freq, t.total_freq)).unwrap())
+        .collect();
+    assert_eq!(
+        terms,
+        vec![
+            ("connect".to_string(), 1, 1),
+            ("fn".to_string(), 1, 1),
+            ("serve".to_string(), 1, 1),
+            ("server".to_string(), 2, 3),
+            ("service".to_string(), 1, 1),
+        ]
+    );
+}
+
+#[test]
+fn contents_skip_deleted_files() {
+    let tmp = tempfile::tempdir().unwrap();
+    fs::write(tmp.path().join("a.txt"), "server\n").unwrap();
+    fs::write(tmp.path().join("b.txt"), "server sieve\n").unwrap();
+    ns::indexer::run_full_index(tmp.path(), 1_048_576).expect("indexing should succeed");
+    let before = ns::searcher::contents::IndexContents::open(tmp.path()).unwrap();
+
+    fs::remove_file(tmp.path().join("b.txt")).unwrap();
+    ns::indexer::run_incremental_index(tmp.path(), 1_048_576).unwrap();
+
+    let contents = ns::searcher::contents::IndexContents::open(tmp.path()).unwrap();
+    let paths: Vec<String> = contents.documents().unwrap().map(|d| d.unwrap().path).collect();
+    assert_eq!(paths, vec!["a.txt"]);
+    let terms: Vec<String> = contents.terms().unwrap().map(|t| t.unwrap().term).collect();
+    assert_eq!(terms, vec!["server"]);
+
+    // A view opened earlier keeps its snapshot.
+    assert_eq!(before.documents().unwrap().count(), 2);
+}
+
+// ── Proximity boost ───────────────────────────────────────────────────────────
+
+fn proximity_fixture() -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    // near.txt is longer, so it ranks below far.txt on term frequencies alone.
+    let filler = "lorem ipsum dolor sit amet ".repeat(8);
+    fs::write(root.join("near.txt"), format!("the read timeout {}\n", filler)).unwrap();
+    fs::write(root.join("far.txt"), format!("read {}\ntimeout\n", &filler[..90])).unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+    (tmp, root)
+}
+
+fn ranked_paths(root: &Path, query: &str, opts: &SearchOptions) -> Vec<String> {
+    let (results, _) = ns::searcher::query::execute_search(root, query, opts).unwrap();
+    results.into_iter().map(|r| r.path).collect()
+}
+
+#[test]
+fn proximity_boost_ranks_adjacent_terms_first() {
+    let (_tmp, root) = proximity_fixture();
+    assert_eq!(ranked_paths(&root, "read timeout", &opts(10)), vec!["far.txt", "near.txt"]);
+
+    let near = SearchOptions {
+        proximity_boost: 2.0,
+        ..opts(10)
+    };
+    assert_eq!(ranked_paths(&root, "read timeout", &near), vec!["near.txt", "far.txt"]);
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "read timeout", &near).unwrap();
+    assert!(results[0].matched_fields.contains(&"proximity".to_string()));
+    assert!(!results[1].matched_fields.contains(&"proximity".to_string()));
+}
+
+#[test]
+fn proximity_bump_falls_with_the_gap() {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path();
+    fs::write(root.join("gap1.txt"), "read the timeout\n").unwrap();
+    fs::write(root.join("gap4.txt"), "read a big slow timeout\n").unwrap();
+    fs::write(root.join("gap20.txt"), format!("read {}timeout\n", "word ".repeat(20))).unwrap();
+    ns::indexer::run_full_index(root, 1_048_576).expect("indexing should succeed");
+
+    let opts = |proximity_boost: f32| SearchOptions {
+        proximity_boost,
+        proximity_window: 8,
+        ..opts(10)
+    };
+    let score = |proximity_boost: f32, path: &str| {
+        let (results, _) =
+            ns::searcher::query::execute_search(root, "read timeout", &opts(proximity_boost)).unwrap();
+        results.iter().find(|r| r.path == path).expect("file matches").score
+    };
+    let bump = |path: &str| score(1.0, path) - score(0.0, path);
+    assert!(bump("gap1.txt") > bump("gap4.txt"));
+    assert!(bump("gap4.txt") > 0.0);
+    assert!(bump("gap20.txt").abs() < 1e-6, "outside the window");
+}
+
+// ── Early termination ─────────────────────────────────────────────────────────
+
+/// 40 files mentioning `config`, with term frequencies and lengths that give
+/// each a different score, plus 12 identical files that tie.
+fn early_termination_fixture() -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    for i in 0..40 {
+        let body = format!("{}{}\n", "config ".repeat(i % 7 + 1), "filler ".repeat(i));
+        fs::write(root.join(format!("f{:02}.txt", i)), body).unwrap();
+    }
+    for i in 0..12 {
+        fs::write(root.join(format!("t{:02}.txt", i)), "setting\n").unwrap();
+    }
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+    (tmp, root)
+}
+
+fn page(root: &Path, query: &str, opts: &SearchOptions) -> (Vec<String>, SearchStats) {
+    let (results, stats) = ns::searcher::query::execute_search(root, query, opts).unwrap();
+    (results.into_iter().map(|r| r.path).collect(), stats)
+}
+
+#[test]
+fn early_termination_returns_the_same_pages() {
+    let (_tmp, root) = early_termination_fixture();
+    for offset in [0, 5, 35] {
+        let full = SearchOptions { offset, ..opts(5) };
+        let early = SearchOptions {
+            early_termination: true,
+            offset,
+            ..opts(5)
+        };
+        let (full_page, full_stats) = page(&root, "config", &full);
+        let (early_page, early_stats) = page(&root, "config", &early);
+        assert_eq!(early_page, full_page, "offset {}", offset);
+        assert_eq!(full_stats.total_matches, 40);
+        assert!(!full_stats.total_is_lower_bound);
+
+        if offset == 35 {
+            // The heap holds every match, so nothing was cut.
+            assert_eq!(early_stats.total_matches, 40);
+            assert!(!early_stats.total_is_lower_bound);
+        } else {
+            assert!(early_stats.total_is_lower_bound);
+            assert!(early_stats.total_matches > offset + 5);
+            assert!(early_stats.total_matches <= 40);
+        }
+    }
+}
+
+#[test]
+fn early_termination_falls_back_on_a_tie_at_the_page_end() {
+    let (_tmp, root) = early_termination_fixture();
+    let early = SearchOptions {
+        early_termination: true,
+        ..opts(5)
+    };
+    let (early_page, stats) = page(&root, "setting", &early);
+    assert_eq!(early_page, vec!["t00.txt", "t01.txt", "t02.txt", "t03.txt", "t04.txt"]);
+    assert_eq!(stats.total_matches, 12);
+    assert!(!stats.total_is_lower_bound);
+}
+
+#[test]
+fn early_termination_is_skipped_with_weights() {
+    let (_tmp, root) = early_termination_fixture();
+    let early = SearchOptions {
+        early_termination: true,
+        weights: vec![WeightRule {
+            glob: "f0*".to_string(),
+            multiplier: 0.5,
+        }],
+        ..opts(5)
+    };
+    let (_, stats) = page(&root, "config", &early);
+    assert_eq!(stats.total_matches, 40);
+    assert!(!stats.total_is_lower_bound);
+}
+
+#[test]
+fn cli_early_termination_marks_total_as_lower_bound() {
+    let (_tmp, root) = early_termination_fixture();
+    let output = std::process::Command::new(ns_binary())
+        .args(["--early-termination", "--json", "-m", "3", "config"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(output.status.success());
+    let parsed: serde_json::Value = serde_json::from_slice(&output.stdout).expect("valid JSON");
+    assert_eq!(parsed["results"].as_array().unwrap().len(), 3);
+    assert_eq!(parsed["stats"]["total_is_lower_bound"], true);
+
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("3 of "), "summary: {}", stderr);
+    assert!(stderr.contains("+ results"), "summary: {}", stderr);
+}
+
+// ── Dedup ─────────────────────────────────────────────────────────────────────
+
+/// The fixture plus an exact copy of `src/server.go` and one with its
+/// package renamed.
+fn dedup_fixture() -> (tempfile::TempDir, std::path::PathBuf) {
+    let (tmp, root) = common::isolated_fixture();
+    let server = fs::read_to_string(root.join("src/server.go")).unwrap();
+    fs::create_dir_all(root.join("vendor")).unwrap();
+    fs::write(root.join("vendor/server.go"), &server).unwrap();
+    fs::create_dir_all(root.join("copy")).unwrap();
+    fs::write(root.join("copy/server.go"), server.replacen("package main", "package copied", 1)).unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+    (tmp, root)
+}
+
+#[test]
+fn dedup_folds_copies_into_one_result() {
+    let (_tmp, root) = dedup_fixture();
+    let (results, stats) = ns::searcher::query::execute_search(&root, "NewServer", &opts(10)).unwrap();
+    assert_eq!(results.len(), 3);
+    assert_eq!(stats.total_matches, 3);
+    assert!(results.iter().all(|r| r.duplicates.is_empty()));
+
+    let dedup = SearchOptions {
+        dedup: true,
+        ..opts(10)
+    };
+    let (results, stats) = ns::searcher::query::execute_search(&root, "NewServer", &dedup).unwrap();
+    assert_eq!(results.len(), 1);
+    assert_eq!(stats.total_matches, 1);
+    assert_eq!(results[0].path, "copy/server.go");
+    assert_eq!(results[0].duplicates, vec!["src/server.go", "vendor/server.go"]);
+}
+
+#[test]
+fn dedup_distance_zero_folds_only_exact_copies() {
+    let (_tmp, root) = dedup_fixture();
+    let exact = SearchOptions {
+        dedup: true,
+        dedup_distance: 0,
+        ..opts(10)
+    };
+    let (results, _) = ns::searcher::query::execute_search(&root, "NewServer", &exact).unwrap();
+    let groups: Vec<(&str, Vec<&str>)> = results
+        .iter()
+        .map(|r| (r.path.as_str(), r.duplicates.iter().map(String::as_str).collect()))
+        .collect();
+    assert_eq!(
+        groups,
+        vec![("copy/server.go", vec![]), ("src/server.go", vec!["vendor/server.go"])]
+    );
+}
+
+#[test]
+fn dedup_keeps_distinct_files_apart() {
+    let (_tmp, root) = dedup_fixture();
+    let dedup = SearchOptions {
+        dedup: true,
+        ..opts(20)
+    };
+    let (plain, _) = ns::searcher::query::execute_search(&root, "event", &opts(20)).unwrap();
+    let (deduped, _) = ns::searcher::query::execute_search(&root, "event", &dedup).unwrap();
+    let paths = |results: &[ns::searcher::query::SearchResult]| {
+        results.iter().map(|r| r.path.clone()).collect::<Vec<_>>()
+    };
+    assert!(!plain.is_empty());
+    assert_eq!(paths(&deduped), paths(&plain));
+}
+
+#[test]
+fn cli_dedup_lists_duplicate_paths() {
+    let (_tmp, root) = dedup_fixture();
+    let output = std::process::Command::new(ns_binary())
+        .args(["--dedup", "--json", "NewServer"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(output.status.success());
+    let parsed: serde_json::Value = serde_json::from_slice(&output.stdout).expect("valid JSON");
+    let results = parsed["results"].as_array().unwrap();
+    assert_eq!(results.len(), 1);
+    assert_eq!(
+        results[0]["duplicates"],
+        serde_json::json!(["src/server.go", "vendor/server.go"])
+    );
+}
+
+// ── Cancellation ────────────────────────────────────────────────────────────
+
+#[test]
+fn cancelled_search_returns_cancelled() {
+    let (_tmp, root) = common::indexed_fixture();
+    let token = ns::cancel::CancelToken::new();
+    let cancelled = SearchOptions {
+        cancel: token.clone(),
+        ..opts(10)
+    };
+    assert!(ns::searcher::query::execute_search(&root, "EventStore", &cancelled).is_ok());
+
+    token.cancel();
+    for (query, early_termination, regex) in
+        [("EventStore", false, false), ("EventStore", true, false), ("Event.*", false, true)]
+    {
+        let cancelled = SearchOptions {
+            early_termination,
+            regex,
+            cancel: token.clone(),
+            ..opts(10)
+        };
+        let err = ns::searcher::search(&root, query, OutputMode::Text, &cancelled).unwrap_err();
+        assert!(matches!(err, ns::error::NsError::Cancelled), "got {:?}", err);
+        assert!(err.is_cancellation());
+    }
+}
+
+#[test]
+fn expired_deadline_returns_deadline_exceeded() {
+    let (_tmp, root) = common::indexed_fixture();
+    let expired = SearchOptions {
+        cancel: ns::cancel::CancelToken::new().with_timeout(std::time::Duration::ZERO),
+        ..opts(10)
+    };
+    let err = ns::searcher::query::execute_search(&root, "EventStore", &expired).unwrap_err();
+    assert!(matches!(err, ns::error::NsError::DeadlineExceeded), "got {:?}", err);
+}
+
+// ── Explain ─────────────────────────────────────────────────────────────────
+
+fn explain_fixture() -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    fs::write(
+        root.join("stop.go"),
+        "// Stop performs a graceful shutdown of the server.\nfunc Stop(h *Handle) { h.server.Close() }\n",
+    )
+    .unwrap();
+    for name in ["a", "b", "c", "d", "e"] {
+        fs::write(
+            root.join(format!("{}.go", name)),
+            "// Serve starts the server.\nfunc Serve(h *Handle) { h.server.Run() }\n",
+        )
+        .unwrap();
+    }
+    fs::write(root.join("notes.txt"), "nothing relevant here\n").unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+    (tmp, root)
+}
+
+#[test]
+fn explain_lists_terms_weighted_by_rarity() {
+    let (_tmp, root) = explain_fixture();
+    let explain = SearchOptions {
+        explain: true,
+        ..opts(10)
+    };
+    let (results, _) =
+        ns::searcher::query::execute_search(&root, "graceful server shutdown", &explain).unwrap();
+    assert_eq!(results[0].path, "stop.go");
+
+    let terms = &results[0].terms;
+    let find = |term: &str| terms.iter().find(|t| t.field == "content" && t.term == term).unwrap();
+    let (graceful, server) = (find("graceful"), find("server"));
+    assert_eq!((graceful.tf, server.tf), (1, 2));
+    assert!(graceful.idf > server.idf * 5.0, "rare term should have a much higher idf");
+    assert!(graceful.score > server.score);
+
+    let total: f32 = terms.iter().map(|t| t.score).sum();
+    assert!((total - results[0].score).abs() < 1e-3 * results[0].score, "{} vs {}", total, results[0].score);
+
+    let (plain, _) = ns::searcher::query::execute_search(&root, "graceful server shutdown", &opts(10)).unwrap();
+    assert!(plain[0].terms.is_empty(), "terms are only filled with explain");
+}
+
+#[test]
+fn explain_one_file_matches_search() {
+    let (_tmp, root) = explain_fixture();
+    let query = "graceful server shutdown";
+    let explain = SearchOptions {
+        explain: true,
+        ..opts(10)
+    };
+    let (results, _) = ns::searcher::query::execute_search(&root, query, &explain).unwrap();
+    let explanation = ns::searcher::explain::explain(&root, query, "stop.go", &opts(10))
+        .unwrap()
+        .expect("stop.go is indexed");
+    assert_eq!(explanation.score, results[0].score);
+    assert_eq!(explanation.terms, results[0].terms);
+
+    let unmatched = ns::searcher::explain::explain(&root, query, "notes.txt", &opts(10))
+        .unwrap()
+        .expect("notes.txt is indexed");
+    assert_eq!(unmatched.score, 0.0);
+    assert!(unmatched.terms.is_empty());
+    assert!(ns::searcher::explain::explain(&root, query, "missing.go", &opts(10)).unwrap().is_none());
+}
+
+#[test]
+fn cli_explain_adds_terms_to_ranking_factors() {
+    let (_tmp, root) = explain_fixture();
+    let output = std::process::Command::new(ns_binary())
+        .args(["--explain", "--json", "graceful server"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(output.status.success());
+    let parsed: serde_json::Value = serde_json::from_slice(&output.stdout).expect("valid JSON");
+    let terms = parsed["results"][0]["ranking_factors"]["terms"].as_array().unwrap();
+    assert!(terms.iter().any(|t| t["field"] == "content" && t["term"] == "graceful" && t["tf"] == 1));
+
+    let text = std::process::Command::new(ns_binary())
+        .args(["--explain", "graceful"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    let stdout = String::from_utf8_lossy(&text.stdout);
+    assert!(stdout.contains("~ term content:graceful  tf: 1, idf: "), "got: {}", stdout);
+}
+
+// ── Tracked lines ───────────────────────────────────────────────────────────
+
+fn track_lines_fixture(track_lines: bool) -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    fs::write(root.join("crlf.txt"), "alpha\r\nwombat here\r\n\r\nbeta wombat\r\n").unwrap();
+    fs::write(root.join("mixed.txt"), "wombat\r\nplain\nwombats\r\n\nlast wombat").unwrap();
+    fs::write(root.join("other.txt"), "no marsupials\n").unwrap();
+    let opts = ns::indexer::IndexOptions {
+        track_lines,
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &opts).expect("indexing should succeed");
+    (tmp, root)
+}
+
+fn lines_by_path(root: &Path, query: &str) -> Vec<(String, Vec<usize>)> {
+    let (mut results, _) = ns::searcher::query::execute_search(root, query, &opts(10)).unwrap();
+    results.sort_by(|a, b| a.path.cmp(&b.path));
+    results.into_iter().map(|r| (r.path, r.lines)).collect()
+}
+
+#[test]
+fn tracked_lines_handle_crlf_mixed_endings_and_no_final_newline() {
+    let (_tmp, root) = track_lines_fixture(true);
+    assert_eq!(
+        lines_by_path(&root, "wombat"),
+        vec![("crlf.txt".to_string(), vec![2, 4]), ("mixed.txt".to_string(), vec![1, 5])]
+    );
+    // Lines come from the terms' own postings: `wombats` is another term.
+    assert_eq!(lines_by_path(&root, "wombats"), vec![("mixed.txt".to_string(), vec![3])]);
+
+    let meta = ns::indexer::writer::read_meta(&root).unwrap();
+    assert!(meta.track_lines);
+}
+
+#[test]
+fn untracked_index_has_no_lines() {
+    let (_tmp, root) = track_lines_fixture(false);
+    assert!(lines_by_path(&root, "wombat").iter().all(|(_, lines)| lines.is_empty()));
+}
+
+#[test]
+fn cli_grep_format_prints_path_line_text() {
+    let (_tmp, root) = track_lines_fixture(true);
+    let output = std::process::Command::new(ns_binary())
+        .args(["--format", "grep", "-C", "0", "wombat"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(output.status.success());
+    let stdout = String::from_utf8_lossy(&output.stdout);
+    let mut lines: Vec<&str> = stdout.lines().filter(|l| *l != "--").collect();
+    lines.sort();
+    assert_eq!(
+        lines,
+        vec!["crlf.txt:2:wombat here", "crlf.txt:4:beta wombat", "mixed.txt:1:wombat", "mixed.txt:5:last wombat"]
+    );
+
+    let json = std::process::Command::new(ns_binary())
+        .args(["--json", "wombats"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    let parsed: serde_json::Value = serde_json::from_slice(&json.stdout).expect("valid JSON");
+    assert_eq!(parsed["results"][0]["match_lines"], serde_json::json!([3]));
+}
+
+// ── Analyzers ───────────────────────────────────────────────────────────────
+
+fn analyzer_fixture(spec: &str) -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    fs::write(root.join("camel.txt"), "call readTimeout(now)\n").unwrap();
+    fs::write(root.join("pascal.txt"), "call ReadTimeout, then stop\n").unwrap();
+    fs::write(root.join("words.txt"), "read the timeout\n").unwrap();
+    let opts = ns::indexer::IndexOptions {
+        analyzer: Some(ns::indexer::analyzer::Analyzer::parse(spec).unwrap()),
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &opts).expect("indexing should succeed");
+    (tmp, root)
+}
+
+fn sorted_paths(root: &Path, query: &str) -> Vec<String> {
+    let (results, _) = ns::searcher::query::execute_search(root, query, &opts(10)).unwrap();
+    let mut paths: Vec<String> = results.into_iter().map(|r| r.path).collect();
+    paths.sort();
+    paths
+}
+
+#[test]
+fn custom_analyzer_applies_at_index_and_query_time() {
+    // Whole words with case kept: no identifier splitting, no lowercasing.
+    let (_tmp, root) = analyzer_fixture("simple");
+    assert_eq!(sorted_paths(&root, "readTimeout"), vec!["camel.txt"]);
+    assert_eq!(sorted_paths(&root, "ReadTimeout"), vec!["pascal.txt"]);
+    assert_eq!(sorted_paths(&root, "read"), vec!["words.txt"]);
+    // No stop word filter, so `the` is a term.
+    assert_eq!(sorted_paths(&root, "the"), vec!["words.txt"]);
+
+    fs::write(root.join("later.txt"), "readTimeout again\n").unwrap();
+    std::thread::sleep(std::time::Duration::from_secs(1));
+    ns::indexer::run_incremental_index(&root, 1_048_576).expect("incremental should succeed");
+    let meta = ns::indexer::writer::read_meta(&root).unwrap();
+    assert_eq!(meta.analyzer.as_deref(), Some("simple"));
+    assert_eq!(sorted_paths(&root, "readTimeout"), vec!["camel.txt", "later.txt"]);
+}
+
+#[test]
+fn default_build_records_default_analyzer() {
+    let (_tmp, root) = analyzer_fixture("code,length:1:39,lowercase,stop_words");
+    let meta = ns::indexer::writer::read_meta(&root).unwrap();
+    assert_eq!(meta.analyzer.as_deref(), Some("code,length:1:39,lowercase,stop_words"));
+    assert_eq!(sorted_paths(&root, "read"), vec!["camel.txt", "pascal.txt", "words.txt"]);
+    assert!(sorted_paths(&root, "the").is_empty());
+
+    let (_tmp, plain) = track_lines_fixture(false);
+    let meta = ns::indexer::writer::read_meta(&plain).unwrap();
+    assert_eq!(
+        meta.analyzer,
+        Some(ns::indexer::analyzer::Analyzer::default().spec())
+    );
+}
+
+#[test]
+fn unknown_analyzer_in_meta_is_an_error() {
+    let (_tmp, root) = analyzer_fixture("simple,lowercase");
+    let meta_path = root.join(".ns/meta.json");
+    let meta = fs::read_to_string(&meta_path).unwrap();
+    fs::write(&meta_path, meta.replace("simple,lowercase", "simple,soundex")).unwrap();
+
+    let err = ns::searcher::query::execute_search(&root, "read", &opts(10))
+        .expect_err("unknown analyzer should fail");
+    assert!(
+        matches!(err, ns::error::NsError::UnsupportedAnalyzer(ref spec) if spec == "simple,soundex"),
+        "got {:?}",
+        err
+    );
+}
+
+#[test]
+fn cli_rejects_malformed_analyzer() {
+    let tmp = tempfile::tempdir().unwrap();
+    let output = std::process::Command::new(ns_binary())
+        .args(["index", "--analyzer", "code,soundex"])
+        .current_dir(tmp.path())
+        .output()
+        .expect("should run ns binary");
+    assert!(!output.status.success());
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("is not an analyzer"), "got: {}", stderr);
+    assert!(!tmp.path().join(".ns").exists());
+}
+
+// ── Path matching ───────────────────────────────────────────────────────────
+
+fn match_paths_fixture(match_paths: bool) -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    fs::create_dir_all(root.join("internal/server")).unwrap();
+    fs::write(root.join("internal/server/server_test.go"), "func checkServe() {}\n").unwrap();
+    fs::write(root.join("internal/config.go"), "type Config struct{}\n").unwrap();
+    fs::write(root.join("main.go"), "func main() { serve() }\n").unwrap();
+    let opts = ns::indexer::IndexOptions {
+        match_paths,
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &opts).expect("indexing should succeed");
+    (tmp, root)
+}
+
+#[test]
+fn match_paths_lets_query_words_match_path_components() {
+    let (_tmp, root) = match_paths_fixture(true);
+    let under_internal = vec!["internal/config.go", "internal/server/server_test.go"];
+    assert_eq!(sorted_paths(&root, "internal"), under_internal);
+    assert_eq!(sorted_paths(&root, "path:internal"), under_internal);
+    // `server_test.go` contributes `server`, `test` and `go`.
+    assert_eq!(sorted_paths(&root, "test"), vec!["internal/server/server_test.go"]);
+    // Content matches still count, alongside path matches.
+    assert_eq!(sorted_paths(&root, "config"), vec!["internal/config.go"]);
+    assert!(ns::indexer::writer::read_meta(&root).unwrap().match_paths);
+
+    fs::write(root.join("internal/cache.go"), "package cache\n").unwrap();
+    std::thread::sleep(std::time::Duration::from_secs(1));
+    ns::indexer::run_incremental_index(&root, 1_048_576).expect("incremental should succeed");
+    assert!(ns::indexer::writer::read_meta(&root).unwrap().match_paths);
+    assert_eq!(sorted_paths(&root, "internal").len(), 3);
+}
+
+#[test]
+fn without_match_paths_path_words_only_rank() {
+    let (_tmp, root) = match_paths_fixture(false);
+    assert!(sorted_paths(&root, "internal").is_empty());
+    assert!(sorted_paths(&root, "test").is_empty());
+    assert_eq!(
+        sorted_paths(&root, "path:internal"),
+        vec!["internal/config.go", "internal/server/server_test.go"]
+    );
+    let meta_json = fs::read_to_string(root.join(".ns/meta.json")).unwrap();
+    assert!(!meta_json.contains("match_paths"));
+}
+
+// ── Token length ────────────────────────────────────────────────────────────
+
+#[test]
+fn min_token_length_drops_short_terms_at_index_and_query_time() {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path();
+    fs::write(root.join("loop.txt"), "xy total xy 日本語 語\n").unwrap();
+    let analyzer = ns::indexer::analyzer::Analyzer::default().with_length(Some(3), None);
+    let opts = ns::indexer::IndexOptions {
+        analyzer: Some(analyzer),
+        ..Default::default()
+    };
+    let stats = ns::indexer::run_full_index_with_options(root, &opts)
+        .unwrap()
+        .expect("loop.txt is indexable");
+    // `xy` twice and `語`: three characters are six bytes, one is too few.
+    assert_eq!(stats.filtered_terms, 2);
+    assert_eq!(sorted_paths(root, "total"), vec!["loop.txt"]);
+    assert_eq!(sorted_paths(root, "日本語"), vec!["loop.txt"]);
+    assert!(sorted_paths(root, "xy").is_empty());
+    let meta = ns::indexer::writer::read_meta(root).unwrap();
+    assert_eq!(meta.analyzer.as_deref(), Some("code,length:3:39,lowercase,stop_words"));
+}
+
+#[test]
+fn cli_applies_and_checks_token_lengths() {
+    let tmp = tempfile::tempdir().unwrap();
+    fs::write(tmp.path().join("a.txt"), "ab abc abcd\n").unwrap();
+    let index = |args: &[&str]| {
+        std::process::Command::new(ns_binary())
+            .arg("index")
+            .args(args)
+            .current_dir(tmp.path())
+            .output()
+            .expect("should run ns binary")
+    };
+
+    let output = index(&["--min-token-length", "5", "--max-token-length", "3"]);
+    assert!(!output.status.success());
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("minimum token length is over the maximum"), "got: {}", stderr);
+
+    let output = index(&["--stem", "--min-token-length", "3", "--max-token-length", "3"]);
+    assert!(output.status.success());
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("Left out 3 distinct terms by length"), "got: {}", stderr);
+    let meta = ns::indexer::writer::read_meta(tmp.path()).unwrap();
+    assert_eq!(meta.analyzer.as_deref(), Some("code,length:3:3,lowercase,stop_words,stem"));
+}
+
+// ── Cached searches ─────────────────────────────────────────────────────────
+
+fn cached_paths(searcher: &ns::searcher::cached::CachedSearcher, query: &str) -> Vec<String> {
+    let (results, _) = searcher.search(query, &opts(10)).unwrap();
+    results.into_iter().map(|r| r.path).collect()
+}
+
+#[test]
+fn cached_searcher_reuses_pages_until_the_index_changes() {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path();
+    fs::write(root.join("a.rs"), "fn parse_config() {}\n").unwrap();
+    ns::indexer::run_full_index(root, 1_048_576).unwrap();
+    let generation = ns::indexer::writer::read_meta(root).unwrap().generation;
+    assert!(generation > 0);
+
+    let opts = ns::searcher::cached::SearcherOptions {
+        cache_size: 8,
+        ..Default::default()
+    };
+    let searcher = ns::searcher::cached::CachedSearcher::open(root, opts).unwrap();
+    assert_eq!(cached_paths(&searcher, "parse_config"), vec!["a.rs"]);
+    assert_eq!(cached_paths(&searcher, "  parse_config "), vec!["a.rs"]);
+    let stats = searcher.cache_stats();
+    assert_eq!((stats.hits, stats.misses, stats.entries), (1, 1, 1));
+    assert_eq!(stats.generation, generation);
+
+    // An incremental update bumps the generation, emptying the cache.
+    fs::write(root.join("b.rs"), "fn parse_config_file() { parse_config() }\n").unwrap();
+    std::thread::sleep(std::time::Duration::from_secs(1));
+    ns::indexer::run_incremental_index(root, 1_048_576).unwrap();
+    assert_eq!(cached_paths(&searcher, "parse_config").len(), 2);
+    let stats = searcher.cache_stats();
+    assert_eq!((stats.hits, stats.misses, stats.entries), (1, 2, 1));
+    assert_eq!(stats.generation, generation + 1);
+
+    // So does a full rebuild, which replaces the index directory.
+    fs::remove_file(root.join("a.rs")).unwrap();
+    ns::indexer::run_full_index(root, 1_048_576).unwrap();
+    assert_eq!(cached_paths(&searcher, "parse_config"), vec!["b.rs"]);
+    assert_eq!(searcher.cache_stats().generation, generation + 2);
+}
+
+#[test]
+fn cached_searcher_is_shared_between_threads() {
+    let (_tmp, root) = analyzer_fixture("code,length:1:39,lowercase,stop_words");
+    let opts = ns::searcher::cached::SearcherOptions {
+        cache_size: 2,
+        ..Default::default()
+    };
+    let searcher = ns::searcher::cached::CachedSearcher::open(&root, opts).unwrap();
+    let expected = sorted_paths(&root, "read");
+    std::thread::scope(|scope| {
+        for _ in 0..4 {
+            scope.spawn(|| {
+                for _ in 0..10 {
+                    let mut paths = cached_paths(&searcher, "read");
+                    paths.sort();
+                    assert_eq!(paths, expected);
+                }
+            });
+        }
+    });
+    let stats = searcher.cache_stats();
+    assert_eq!(stats.hits + stats.misses, 40);
+    assert!(stats.hits >= 36, "at most one miss per thread: {:?}", stats);
+
+    let uncached = ns::searcher::cached::CachedSearcher::open(&root, Default::default()).unwrap();
+    cached_paths(&uncached, "read");
+    cached_paths(&uncached, "read");
+    assert_eq!(uncached.cache_stats().misses, 2);
+    assert_eq!(uncached.cache_stats().entries, 0);
+}
+
+#[test]
+fn batch_searches_answer_in_order_with_errors_in_their_slot() {
+    let (_tmp, root) = analyzer_fixture("code,length:1:39,lowercase,stop_words");
+    let searcher_opts = ns::searcher::cached::SearcherOptions {
+        cache_size: 8,
+        threads: 3,
+        ..Default::default()
+    };
+    let searcher = ns::searcher::cached::CachedSearcher::open(&root, searcher_opts).unwrap();
+    let queries = ["read", "read AND", "stop", "timeout", "nothing_here", "read"];
+    let pages = searcher.search_batch(&queries, &opts(10)).unwrap();
+    assert_eq!(pages.len(), queries.len());
+    for (query, page) in queries.iter().zip(&pages) {
+        match (*query, page) {
+            ("read AND", Err(ns::error::NsError::QueryParse(_))) => {}
+            (_, Ok((results, _))) => {
+                let mut paths: Vec<String> = results.iter().map(|r| r.path.clone()).collect();
+                paths.sort();
+                assert_eq!(paths, sorted_paths(&root, query), "query {:?}", query);
+            }
+            (_, other) => panic!("query {:?}: {:?}", query, other.as_ref().err()),
+        }
+    }
+    // Failed searches aren't cached; a repeated batch is all hits but the error.
+    assert_eq!(searcher.cache_stats().entries, 4);
+    let again = searcher.search_batch(&queries, &opts(10)).unwrap();
+    assert!(again[1].is_err());
+    let stats = searcher.cache_stats();
+    assert_eq!((stats.hits, stats.misses), (5, 7));
+}
+
+// ── Unicode normalization ───────────────────────────────────────────────────
+
+/// The same words written precomposed, decomposed (`e` + U+0301) and in
+/// ASCII, one file each, indexed with `spec`.
+fn unicode_fixture(spec: &str) -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    fs::write(root.join("composed.txt"), "menu: caf\u{e9} cr\u{e8}me\n").unwrap();
+    fs::write(root.join("decomposed.txt"), "menu: cafe\u{301} cre\u{300}me\n").unwrap();
+    fs::write(root.join("plain.txt"), "menu: cafe creme\n").unwrap();
+    let opts = ns::indexer::IndexOptions {
+        analyzer: Some(ns::indexer::analyzer::Analyzer::parse(spec).unwrap()),
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &opts).expect("indexing should succeed");
+    (tmp, root)
+}
+
+#[test]
+fn nfc_matches_composed_and_decomposed_text_alike() {
+    let (_tmp, root) = unicode_fixture("code,length:1:39,lowercase,stop_words");
+    assert_eq!(sorted_paths(&root, "caf\u{e9}"), vec!["composed.txt"]);
+    assert_eq!(sorted_paths(&root, "cafe\u{301}"), vec!["decomposed.txt"]);
+
+    let (_tmp, root) = unicode_fixture("code,nfc,length:1:39,lowercase,stop_words");
+    for query in ["caf\u{e9}", "cafe\u{301}", "\"CAFE\u{301} crème\""] {
+        assert_eq!(
+            sorted_paths(&root, query),
+            vec!["composed.txt", "decomposed.txt"],
+            "query {:?}",
+            query
+        );
+    }
+    assert_eq!(sorted_paths(&root, "cafe"), vec!["plain.txt"]);
+}
+
+#[test]
+fn fold_diacritics_matches_accented_words_without_accents() {
+    let (_tmp, root) = unicode_fixture("code,length:1:39,lowercase,fold_diacritics,stop_words");
+    let all = vec!["composed.txt", "decomposed.txt", "plain.txt"];
+    assert_eq!(sorted_paths(&root, "cafe"), all);
+    assert_eq!(sorted_paths(&root, "cafe\u{301}"), all);
+    assert_eq!(sorted_paths(&root, "\"café creme\""), all);
+}
+
+// ── Sort modes ──────────────────────────────────────────────────────────────
+
+/// Four files matching `config` with distinct scores, paths and mtimes,
+/// except that `c.rs` and `d.rs` tie on both score and mtime.
+fn sort_fixture(track_mtime: bool) -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    let epoch = std::time::UNIX_EPOCH;
+    let files = [("a.rs", 1, 3000), ("b.rs", 3, 1000), ("c.rs", 2, 2000), ("d.rs", 2, 2000)];
+    for (name, repeats, secs) in files {
+        let path = root.join(name);
+        fs::write(&path, "config\n".repeat(repeats)).unwrap();
+        let file = fs::File::options().write(true).open(&path).unwrap();
+        file.set_modified(epoch + std::time::Duration::from_secs(secs)).unwrap();
+    }
+    let opts = ns::indexer::IndexOptions {
+        track_mtime,
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &opts).expect("indexing should succeed");
+    (tmp, root)
+}
+
+fn sorted_by(root: &Path, sort_by: SortMode, offset: usize, max: usize) -> Vec<String> {
+    let opts = SearchOptions {
+        sort_by,
+        offset,
+        ..opts(max)
+    };
+    let (results, stats) = ns::searcher::query::execute_search(root, "config", &opts).unwrap();
+    assert_eq!(stats.total_matches, 4);
+    results.into_iter().map(|r| r.path).collect()
+}
+
+#[test]
+fn sort_modes_order_results_and_break_ties_by_path() {
+    let (_tmp, root) = sort_fixture(true);
+    assert_eq!(sorted_by(&root, SortMode::Relevance, 0, 10), ["b.rs", "c.rs", "d.rs", "a.rs"]);
+    assert_eq!(sorted_by(&root, SortMode::Path, 0, 10), ["a.rs", "b.rs", "c.rs", "d.rs"]);
+    assert_eq!(sorted_by(&root, SortMode::ModTime, 0, 10), ["a.rs", "c.rs", "d.rs", "b.rs"]);
+
+    // Pages of each order put together are the whole order.
+    for sort_by in [SortMode::Relevance, SortMode::Path, SortMode::ModTime] {
+        let pages: Vec<String> = (0..4).flat_map(|i| sorted_by(&root, sort_by, i, 1)).collect();
+        assert_eq!(pages, sorted_by(&root, sort_by, 0, 10), "{:?}", sort_by);
+    }
+    // Scores are still reported under other orders.
+    let opts = SearchOptions {
+        sort_by: SortMode::Path,
+        ..opts(10)
+    };
+    let (results, _) = ns::searcher::query::execute_search(&root, "config", &opts).unwrap();
+    assert!(results[1].score > results[0].score, "b.rs outscores a.rs");
+}
+
+#[test]
+fn sorting_by_mtime_needs_tracked_mtimes() {
+    let (_tmp, root) = sort_fixture(false);
+    assert_eq!(sorted_by(&root, SortMode::Path, 0, 10), ["a.rs", "b.rs", "c.rs", "d.rs"]);
+    let opts = SearchOptions {
+        sort_by: SortMode::ModTime,
+        ..opts(10)
+    };
+    let err = ns::searcher::query::execute_search(&root, "config", &opts).unwrap_err();
+    assert!(matches!(err, ns::error::NsError::NoMtimes));
+
+    let output = std::process::Command::new(ns_binary())
+        .args(["--sort", "mtime", "config"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(!output.status.success());
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("ns index --track-mtime"), "got: {}", stderr);
+}
+
+// ── Token classes ───────────────────────────────────────────────────────────
+
+/// `shutdown` in a Go comment, in Go code, in a Go string and in Markdown.
+fn token_class_fixture(token_classes: bool) -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    let files = [
+        ("server.go", "package main\n\n// graceful shutdown\nfunc serve() {}\n"),
+        ("worker.go", "package main\n\nfunc shutdown() {}\n"),
+        ("log.go", "package main\n\nfunc stop() { log.Print(\"shutdown\") }\n"),
+        ("notes.md", "Call shutdown before exiting.\n"),
+    ];
+    for (name, content) in files {
+        fs::write(root.join(name), content).unwrap();
+    }
+    let opts = ns::indexer::IndexOptions {
+        token_classes,
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &opts).expect("indexing should succeed");
+    (tmp, root)
+}
+
+fn class_paths(root: &Path, query: &str, classes: TokenClasses) -> Vec<String> {
+    let opts = SearchOptions {
+        token_classes: classes,
+        ..opts(10)
+    };
+    let (results, _) = ns::searcher::query::execute_search(root, query, &opts).unwrap();
+    let mut paths: Vec<String> = results.into_iter().map(|r| r.path).collect();
+    paths.sort();
+    paths
+}
+
+#[test]
+fn excluding_comments_skips_words_only_found_in_go_comments() {
+    let (_tmp, root) = token_class_fixture(true);
+    let all = TokenClasses::default();
+    assert_eq!(
+        class_paths(&root, "shutdown", all),
+        ["log.go", "notes.md", "server.go", "worker.go"]
+    );
+    let no_comments = all.without(TokenClass::Comment);
+    assert_eq!(
+        class_paths(&root, "shutdown", no_comments),
+        ["log.go", "notes.md", "worker.go"],
+        "other languages count as code"
+    );
+    let comments = TokenClasses::only(&[TokenClass::Comment]);
+    assert_eq!(class_paths(&root, "shutdown", comments), ["server.go"]);
+    let strings = TokenClasses::only(&[TokenClass::String]);
+    assert_eq!(class_paths(&root, "shutdown", strings), ["log.go"]);
+    assert_eq!(class_paths(&root, "graceful", no_comments), Vec::<String>::new());
+
+    let fuzzy = SearchOptions {
+        fuzzy: true,
+        token_classes: no_comments,
+        ..opts(10)
+    };
+    let (results, _) = ns::searcher::query::execute_search(&root, "shutdwn", &fuzzy).unwrap();
+    assert!(results.iter().all(|r| r.path != "server.go"));
+
+    let output = std::process::Command::new(ns_binary())
+        .args(["--exclude-comments", "shutdown"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    let stdout = String::from_utf8_lossy(&output.stdout);
+    assert!(stdout.contains("worker.go"), "got: {}", stdout);
+    assert!(!stdout.contains("server.go"), "got: {}", stdout);
+}
+
+#[test]
+fn token_class_search_needs_a_token_class_index() {
+    let (_tmp, root) = token_class_fixture(false);
+    let opts = SearchOptions {
+        token_classes: TokenClasses::only(&[TokenClass::Comment]),
+        ..opts(10)
+    };
+    let err = ns::searcher::query::execute_search(&root, "shutdown", &opts).unwrap_err();
+    assert!(matches!(err, ns::error::NsError::NoTokenClasses));
+
+    let output = std::process::Command::new(ns_binary())
+        .args(["--in", "comment", "shutdown"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(!output.status.success());
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("ns index --token-classes"), "got: {}", stderr);
+}
+
+// ── Declared fields ─────────────────────────────────────────────────────────
+
+/// Two docs: `install` in one's title only, and in the other's body.
+fn fields_fixture() -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    fs::write(root.join("guide.md"), "Run the setup script, then restart.\n").unwrap();
+    let body = "Notes on releases.\n".repeat(20) + "Users install it from the releases page.\n";
+    fs::write(root.join("faq.md"), body).unwrap();
+    let opts = ns::indexer::IndexOptions {
+        fields: vec![ns::indexer::fields::DocField {
+            name: "title".to_string(),
+            boost: 3.0,
+        }],
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &opts).expect("indexing should succeed");
+    let title = BTreeMap::from([("title".to_string(), "Install guide".to_string())]);
+    ns::indexer::index_fields(&root, "guide.md", &title, 1_048_576).unwrap();
+    (tmp, root)
+}
+
+#[test]
+fn declared_fields_are_searched_at_their_boost() {
+    let (_tmp, root) = fields_fixture();
+    assert_eq!(ranked_paths(&root, "install", &opts(10)), ["guide.md", "faq.md"]);
+    assert_eq!(ranked_paths(&root, "title:install", &opts(10)), ["guide.md"]);
+    let (results, _) = ns::searcher::query::execute_search(&root, "install", &opts(1)).unwrap();
+    assert!(results[0].matched_fields.contains(&"title".to_string()));
+
+    let unboosted = SearchOptions {
+        field_boosts: BTreeMap::from([("title".to_string(), 0.0)]),
+        ..opts(10)
+    };
+    assert_eq!(ranked_paths(&root, "install", &unboosted)[0], "faq.md");
+
+    let author = BTreeMap::from([("author".to_string(), "me".to_string())]);
+    let result = ns::indexer::index_fields(&root, "guide.md", &author, 1_048_576);
+    assert!(matches!(result, Err(ns::error::NsError::UnknownField(ref name)) if name == "author"));
+}
+
+#[test]
+fn field_values_survive_rebuilds_and_renames() {
+    let (_tmp, root) = fields_fixture();
+    let output = std::process::Command::new(ns_binary())
+        .args(["index", "--field", "title=3"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(output.status.success(), "{}", String::from_utf8_lossy(&output.stderr));
+    assert_eq!(ranked_paths(&root, "title:install", &opts(10)), ["guide.md"]);
+
+    fs::rename(root.join("guide.md"), root.join("start.md")).unwrap();
+    ns::indexer::run_incremental_index(&root, 1_048_576).unwrap();
+    assert_eq!(ranked_paths(&root, "title:install", &opts(10)), ["start.md"]);
+
+    let output = std::process::Command::new(ns_binary())
+        .args(["index", "--field", "content=2"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    assert!(!output.status.success());
+    let stderr = String::from_utf8_lossy(&output.stderr);
+    assert!(stderr.contains("cannot declare field 'content'"), "got: {}", stderr);
+}
+
+// ── Snippet ranking ─────────────────────────────────────────────────────────
+
+#[test]
+fn single_snippet_is_the_passage_covering_the_most_terms() {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    let text = "fn read_header() {}\n\n// filler\n// filler\n\nfn read_with_timeout() {}\n";
+    fs::write(root.join("io.rs"), text).unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let output = std::process::Command::new(ns_binary())
+        .args(["--max-snippets", "1", "-C", "0", "read timeout"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    let stdout = String::from_utf8_lossy(&output.stdout);
+    assert!(stdout.contains("read_with_timeout"), "got: {}", stdout);
+    assert!(!stdout.contains("read_header"), "got: {}", stdout);
+}
+
+// ── Read-only index ─────────────────────────────────────────────────────────
+
+#[test]
+fn read_only_index_serves_threads_and_refuses_updates() {
+    let (_tmp, root) = common::indexed_fixture();
+    let (expected, _) =
+        ns::searcher::query::execute_search(&root, "EventStore", &opts(10)).unwrap();
+    let expected: Vec<String> = expected.into_iter().map(|r| r.path).collect();
+
+    let index = ns::searcher::read_only::ReadOnlyIndex::open(&root).unwrap();
+    std::thread::scope(|scope| {
+        for _ in 0..4 {
+            scope.spawn(|| {
+                let (results, _) = index.search("EventStore", &opts(10)).unwrap();
+                let paths: Vec<String> = results.into_iter().map(|r| r.path).collect();
+                assert_eq!(paths, expected);
+            });
+        }
+    });
+
+    let err = ns::indexer::remove_file(&root, "src/utils.js").unwrap_err();
+    assert!(err.is_lock_error(), "got {:?}", err);
+    let (results, _) = index.search("debounce", &opts(10)).unwrap();
+    assert!(results.iter().any(|r| r.path == "src/utils.js"));
+
+    drop(index);
+    ns::indexer::remove_file(&root, "src/utils.js").expect("unlocked once dropped");
+}
+
+// ── Term positions ──────────────────────────────────────────────────────────
+
+#[test]
+fn positions_are_byte_offsets_of_each_matched_term() {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    let text = "// café naïve: connect\nlet 🦀 = connect(connect_timeout, \"ünïcode timeout\");\n";
+    fs::write(root.join("net.rs"), text).unwrap();
+    let index_opts = ns::indexer::IndexOptions {
+        track_offsets: true,
+        ..Default::default()
+    };
+    ns::indexer::run_full_index_with_options(&root, &index_opts).expect("indexing should succeed");
+
+    let (results, _) = ns::searcher::query::execute_search(&root, "connect", &opts(10)).unwrap();
+    assert!(results[0].positions.is_empty(), "positions are only read on request");
+
+    let search_opts = SearchOptions {
+        include_positions: true,
+        ..opts(10)
+    };
+    let (results, _) =
+        ns::searcher::query::execute_search(&root, "connect timeout", &search_opts).unwrap();
+    let positions = &results[0].positions;
+    let offsets_of =
+        |word: &str| -> Vec<usize> { text.match_indices(word).map(|(i, _)| i).collect() };
+    assert_eq!(positions.keys().collect::<Vec<_>>(), ["connect", "timeout"]);
+    assert_eq!(positions["connect"], offsets_of("connect"));
+    assert_eq!(positions["timeout"], offsets_of("timeout"));
+    for (term, offsets) in positions {
+        assert!(offsets.windows(2).all(|w| w[0] < w[1]), "{}: {:?}", term, offsets);
+        for &offset in offsets {
+            assert!(text[offset..].starts_with(term.as_str()));
+        }
+    }
+
+    let json = std::process::Command::new(ns_binary())
+        .args(["--json", "--positions", "timeout"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    let parsed: serde_json::Value = serde_json::from_slice(&json.stdout).expect("valid JSON");
+    assert_eq!(
+        parsed["results"][0]["positions"],
+        serde_json::json!({ "timeout": offsets_of("timeout") })
+    );
+}
+
+#[test]
+fn positions_need_an_index_with_offsets() {
+    let (_tmp, root) = common::indexed_fixture();
+    let search_opts = SearchOptions {
+        include_positions: true,
+        ..opts(10)
+    };
+    let err = ns::searcher::query::execute_search(&root, "EventStore", &search_opts).unwrap_err();
+    assert!(matches!(err, ns::error::NsError::NoOffsets), "got {:?}", err);
+}
+
+// ── Matches per file ────────────────────────────────────────────────────────
+
+#[test]
+fn max_per_file_caps_matching_lines_and_counts_the_rest() {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    let big: String = (0..50).map(|i| format!("let retry_{} = retry();\n", i)).collect();
+    fs::write(root.join("big.rs"), big).unwrap();
+    fs::write(root.join("small.rs"), "fn retry() {}\n").unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let output = std::process::Command::new(ns_binary())
+        .args(["--max-per-file", "3", "-C", "0", "--json", "retry"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    let parsed: serde_json::Value = serde_json::from_slice(&output.stdout).expect("valid JSON");
+    let results = parsed["results"].as_array().unwrap();
+    assert_eq!(results.len(), 2, "each file is listed once");
+    let big = results.iter().find(|r| r["path"] == "big.rs").unwrap();
+    assert_eq!(big["lines"].as_array().unwrap().len(), 3);
+    assert_eq!(big["more_matches"], 47);
+    let small = results.iter().find(|r| r["path"] == "small.rs").unwrap();
+    assert!(small.get("more_matches").is_none());
+
+    let text = std::process::Command::new(ns_binary())
+        .args(["--max-per-file", "3", "-C", "0", "retry"])
+        .current_dir(&root)
+        .output()
+        .expect("should run ns binary");
+    let stdout = String::from_utf8_lossy(&text.stdout);
+    assert!(stdout.contains("... (47 more matches)"), "got: {}", stdout);
+}
+
+// ── Synonyms ────────────────────────────────────────────────────────────────
+
+/// Files using `cfg`, `config` and neither, plus a `postgres` one.
+fn synonym_fixture() -> (tempfile::TempDir, std::path::PathBuf) {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    fs::write(root.join("short.go"), "package app\n\nvar cfg = load()\n").unwrap();
+    fs::write(root.join("long.go"), "package app\n\nvar config = load()\n").unwrap();
+    fs::write(root.join("other.go"), "package app\n\nvar timeout = 30\n").unwrap();
+    fs::write(root.join("store.go"), "package app\n\n// postgres pool\n").unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+    (tmp, root)
+}
+
+fn synonyms() -> ns::searcher::synonyms::SynonymMap {
+    let mut synonyms = ns::searcher::synonyms::SynonymMap::default();
+    synonyms.add_equivalent(&["config", "cfg"]);
+    synonyms.add_one_way("db", &["postgres"]);
+    synonyms
+}
+
+#[test]
+fn synonyms_match_files_holding_only_a_synonym_ranked_lower() {
+    let (_tmp, root) = synonym_fixture();
+    assert_eq!(sorted_paths(&root, "config"), vec!["long.go"]);
+
+    let search_opts = SearchOptions {
+        synonyms: synonyms(),
+        ..opts(10)
+    };
+    let search = |query: &str| {
+        let (results, _) =
+            ns::searcher::query::execute_search(&root, query, &search_opts).unwrap();
+        results
+    };
+    let results = search("config");
+    let paths: Vec<&str> = results.iter().map(|r| r.path.as_str()).collect();
+    assert_eq!(paths, vec!["long.go", "short.go"], "the written term ranks first");
+    assert!(results[1].score < results[0].score);
+    assert_eq!(search("cfg")[0].path, "short.go");
+    assert_eq!(search("cfg").len(), 2);
+
+    // One-way entries expand only from the word they were added for.
+    assert_eq!(search("db")[0].path, "store.go");
+    assert!(search("postgres").iter().all(|r| r.path == "store.go"));
+
+    let output = ns::searcher::search(&root, "config", OutputMode::Text, &search_opts).unwrap();
+    assert!(output.formatted.contains("var cfg = load()"), "got: {}", output.formatted);
+}
+
+#[test]
+fn cached_searcher_applies_its_synonyms() {
+    let (_tmp, root) = synonym_fixture();
+    let searcher_opts = ns::searcher::cached::SearcherOptions {
+        synonyms: synonyms(),
+        ..Default::default()
+    };
+    let searcher = ns::searcher::cached::CachedSearcher::open(&root, searcher_opts).unwrap();
+    assert_eq!(cached_paths(&searcher, "config"), vec!["long.go", "short.go"]);
+}
+
+// ── Search as you type ──────────────────────────────────────────────────────
+
+#[test]
+fn prefix_last_term_completes_only_the_last_word() {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    fs::write(root.join("server.go"), "// graceful shutdown of the server\n").unwrap();
+    fs::write(root.join("camera.go"), "// shutter speed, not graceful\n").unwrap();
+    fs::write(root.join("other.go"), "// shut the door\n").unwrap();
+    fs::write(root.join("words.go"), "// gracefully done\n").unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let typing = SearchOptions {
+        prefix_last_term: true,
+        ..opts(10)
+    };
+    let paths = |query: &str, search_opts: &SearchOptions| {
+        let (results, _) = ns::searcher::query::execute_search(&root, query, search_opts).unwrap();
+        let mut paths: Vec<String> = results.into_iter().map(|r| r.path).collect();
+        paths.sort();
+        paths
+    };
+    assert_eq!(paths("graceful AND shut", &opts(10)), Vec::<String>::new());
+    assert_eq!(paths("graceful AND shut", &typing), vec!["camera.go", "server.go"]);
+    assert_eq!(paths("shutd", &typing), vec!["server.go"]);
+    // Earlier words stay exact: `graceful` doesn't complete to `gracefully`.
+    assert_eq!(paths("graceful shutdown", &typing), vec!["camera.go", "server.go"]);
+    // A trailing space means the word is finished.
+    assert_eq!(paths("shut ", &typing), vec!["other.go"]);
+    assert_eq!(paths("\"the door\" shutt", &typing), vec!["camera.go", "other.go"]);
+}
+
+#[test]
+fn prefix_expansions_are_capped() {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    // `zetaaa`, `zetaab`, ...: one word each, all completions of `zeta`.
+    for i in 0..80u8 {
+        let word = format!("zeta{}{}", (b'a' + i / 26) as char, (b'a' + i % 26) as char);
+        fs::write(root.join(format!("{}.txt", word)), format!("{}\n", word)).unwrap();
+    }
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+    let typing = SearchOptions {
+        prefix_last_term: true,
+        ..opts(100)
+    };
+    let (results, _) = ns::searcher::query::execute_search(&root, "zeta", &typing).unwrap();
+    assert_eq!(results.len(), ns::searcher::suggest::MAX_PREFIX_EXPANSIONS);
+}
+
+// ── Substring search ────────────────────────────────────────────────────────
+
+#[test]
+fn substring_search_matches_inside_tokens_at_byte_offsets() {
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    fs::write(root.join("read.go"), "// ключ\nvar ReadTimeout = 5\n").unwrap();
+    fs::write(root.join("write.go"), "var WriteTimeout, IdleTimeout = 5, 9\n").unwrap();
+    fs::write(root.join("lower.go"), "var timeout = 1\n").unwrap();
+    fs::write(root.join("other.go"), "var Timer = 2\n").unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let search = |needle: &str, search_opts: &SearchOptions| {
+        let (matches, stats) =
+            ns::searcher::substring::search_substring(&root, needle, search_opts).unwrap();
+        assert_eq!(stats.total_results, matches.len());
+        matches
+            .into_iter()
+            .map(|m| (m.path, m.ranges))
+            .collect::<Vec<_>>()
+    };
+    // Most matches first; offsets count bytes, so `ключ` moves `read.go`'s by 8.
+    assert_eq!(
+        search("Timeout", &opts(10)),
+        vec![
+            ("write.go".to_string(), vec![9..16, 22..29]),
+            ("read.go".to_string(), vec![20..27]),
+        ]
+    );
+    assert_eq!(
+        search("eadTim", &opts(10)),
+        vec![("read.go".to_string(), vec![17..23])]
+    );
+    let ignore_case = SearchOptions {
+        ignore_case: true,
+        ..opts(10)
+    };
+    let paths: Vec<String> = search("TIMEOUT", &ignore_case).into_iter().map(|(p, _)| p).collect();
+    assert_eq!(paths, vec!["write.go", "lower.go", "read.go"]);
+    assert_eq!(search("Timeout, Idle", &opts(10)).len(), 1);
+    assert!(search("Timeouts", &opts(10)).is_empty());
+    assert!(search("", &opts(10)).is_empty());
+
+    // Too long to index whole, so only its parts are terms.
+    fs::write(
+        root.join("beans.java"),
+        "class AbstractSingletonProxyFactoryBeanProvider {}\n",
+    )
+    .unwrap();
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+    assert_eq!(
+        search("BeanProvider", &opts(10)),
+        vec![("beans.java".to_string(), vec![35..47])]
+    );
+    assert_eq!(search("yFactoryB", &opts(10)).len(), 1);
+}
+
+// ── Grouping by directory ───────────────────────────────────────────────────
+
+#[test]
+fn grouped_search_aggregates_files_per_directory() {
+    use ns::searcher::groups::{search_grouped, GroupOptions, GroupScore};
+
+    let tmp = tempfile::tempdir().unwrap();
+    let root = tmp.path().to_path_buf();
+    let files = [
+        ("lib/net/conn.go", "// graceful shutdown of the conn\n"),
+        ("lib/net/listener.go", "// graceful shutdown of the listener\n"),
+        ("lib/io/pipe.go", "// graceful shutdown of the pipe\n"),
+        ("cmd/main.go", "// shutdown shutdown shutdown shutdown\n"),
+        ("README.md", "graceful shutdown of everything\n"),
+        ("other/util.go", "// nothing to see here\n"),
+    ];
+    for (path, content) in files {
+        let path = root.join(path);
+        fs::create_dir_all(path.parent().unwrap()).unwrap();
+        fs::write(path, content).unwrap();
+    }
+    ns::indexer::run_full_index(&root, 1_048_576).expect("indexing should succeed");
+
+    let grouped = |search_opts: &SearchOptions, group_opts: &GroupOptions| {
+        search_grouped(&root, "shutdown", search_opts, group_opts).unwrap()
+    };
+    let summary = |groups: &[ns::searcher::groups::DirGroup]| {
+        groups
+            .iter()
+            .map(|g| (g.dir.clone(), g.hits))
+            .collect::<Vec<_>>()
+    };
+
+    // Summed, three files outweigh one strong one; the root is "".
+    let (groups, stats) = grouped(&opts(10), &GroupOptions::default());
+    assert_eq!(
+        summary(&groups),
+        vec![("lib".to_string(), 3), ("cmd".to_string(), 1), ("".to_string(), 1)]
+    );
+    assert_eq!((stats.total_results, stats.total_matches), (3, 3));
+    let lib = &groups[0];
+    let top: Vec<&str> = lib.top_files.iter().map(|r| r.path.as_str()).collect();
+    assert_eq!(top, vec!["lib/io/pipe.go", "lib/net/conn.go", "lib/net/listener.go"]);
+    let summed: f32 = lib.top_files.iter().map(|r| r.score).sum();
+    assert!((lib.score - summed).abs() < 1e-4);
+
+    let max = GroupOptions {
+        score: GroupScore::Max,
+        top_files: 1,
+        ..Default::default()
+    };
+    let (groups, _) = grouped(&opts(10), &max);
+    assert_eq!(groups[0].dir, "cmd");
+    assert_eq!(groups[0].score, groups[0].top_files[0].score);
+    assert!(groups.iter().all(|g| g.top_files.len() == 1));
+
+    let nested = GroupOptions {
+        depth: 2,
+        ..Default::default()
+    };
+    let page = SearchOptions {
+        offset: 1,
+        ..opts(1)
+    };
+    let (groups, stats) = grouped(&opts(10), &nested);
+    let dirs: Vec<&str> = groups.iter().map(|g| g.dir.as_str()).collect();
+    assert_eq!(dirs.len(), 4);
+    assert_eq!(dirs[0], "lib/net");
+    let (second, paged) = grouped(&page, &nested);
+    assert_eq!(summary(&second), summary(&groups[1..2]));
+    assert_eq!((paged.total_results, paged.total_matches), (1, stats.total_matches));
+}